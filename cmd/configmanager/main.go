@@ -1,7 +1,15 @@
 package main
 
-import "github.com/in4it/wireguard-server/pkg/configmanager"
+import (
+	"flag"
+
+	"github.com/in4it/wireguard-server/pkg/configmanager"
+)
 
 func main() {
-	configmanager.StartServer(8081)
+	var legacyHTTPPort int
+	flag.IntVar(&legacyHTTPPort, "legacy-http-port", 8081, "localhost port for the unauthenticated version check api (0 to disable)")
+	flag.Parse()
+
+	configmanager.StartServer(legacyHTTPPort)
 }
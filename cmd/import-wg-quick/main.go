@@ -0,0 +1,103 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	localstorage "github.com/in4it/go-devops-platform/storage/local"
+	"github.com/in4it/wireguard-server/pkg/commands"
+	"github.com/in4it/wireguard-server/pkg/wireguard"
+)
+
+func main() {
+	var (
+		appDir           string
+		serverConfig     string
+		clientConfigsDir string
+		userMappingFile  string
+		importRequest    wireguard.ImportRequest
+	)
+	flag.StringVar(&appDir, "vpn-dir", "/vpn", "directory where vpn files are located")
+	flag.StringVar(&serverConfig, "server-config", "/etc/wireguard/wg0.conf", "wg-quick config of the server")
+	flag.StringVar(&clientConfigsDir, "client-configs", "", "optional directory with wg-quick client configs (*.conf), to keep the private keys of the clients")
+	flag.StringVar(&userMappingFile, "user-mapping", "", "optional file with \"public key or peer comment = login\" lines. Without mapping, the peer comment is the login")
+	flag.BoolVar(&importRequest.ImportServer, "import-server", false, "import the private key and listen port of the server")
+	flag.BoolVar(&importRequest.DryRun, "dry-run", false, "only show what would be imported")
+	flag.Parse()
+
+	localstorage, err := localstorage.NewWithPath(appDir)
+	if err != nil {
+		fmt.Printf("Failed to intialize storage: %s\n", err)
+		os.Exit(1)
+	}
+
+	body, err := os.ReadFile(serverConfig)
+	if err != nil {
+		fmt.Printf("Could not read server config: %s\n", err)
+		os.Exit(1)
+	}
+	importRequest.ServerConfig = string(body)
+	if clientConfigsDir != "" {
+		filenames, err := filepath.Glob(filepath.Join(clientConfigsDir, "*.conf"))
+		if err != nil {
+			fmt.Printf("Could not list client configs: %s\n", err)
+			os.Exit(1)
+		}
+		for _, filename := range filenames {
+			body, err := os.ReadFile(filename)
+			if err != nil {
+				fmt.Printf("Could not read client config: %s\n", err)
+				os.Exit(1)
+			}
+			importRequest.ClientConfigs = append(importRequest.ClientConfigs, string(body))
+		}
+	}
+	if userMappingFile != "" {
+		body, err := os.ReadFile(userMappingFile)
+		if err != nil {
+			fmt.Printf("Could not read user mapping: %s\n", err)
+			os.Exit(1)
+		}
+		importRequest.UserMapping, err = commands.ParseUserMapping(string(body))
+		if err != nil {
+			fmt.Printf("Invalid user mapping: %s\n", err)
+			os.Exit(1)
+		}
+	}
+
+	report, err := commands.ImportWGQuickConfig(localstorage, importRequest)
+	if err != nil {
+		fmt.Printf("Import failed: %s\n", err)
+		os.Exit(1)
+	}
+	printReport(report)
+}
+
+func printReport(report wireguard.ImportReport) {
+	if report.DryRun {
+		fmt.Printf("Dry run: nothing was changed.\n\n")
+	}
+	if report.Server != nil {
+		fmt.Printf("Server key %s (public key: %s, port: %d)\n\n", report.Server.Status, report.Server.PublicKey, report.Server.Port)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PUBLIC KEY\tCOMMENT\tLOGIN\tCONNECTION\tADDRESS\tSTATUS\tREASON") //nolint:errcheck
+	for _, peer := range report.Peers {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", peer.PublicKey, peer.Comment, peer.Login, peer.ConnectionID, peer.Address, peer.Status, peer.Reason) //nolint:errcheck
+	}
+	w.Flush() //nolint:errcheck
+	fmt.Printf("\n%d peers imported, %d skipped\n", report.Imported, report.Skipped)
+	warnings := report.Warnings
+	for _, peer := range report.Peers {
+		for _, warning := range peer.Warnings {
+			warnings = append(warnings, fmt.Sprintf("%s: %s", peer.PublicKey, warning))
+		}
+	}
+	if len(warnings) > 0 {
+		fmt.Printf("\nWarnings:\n  %s\n", strings.Join(warnings, "\n  "))
+	}
+}
@@ -0,0 +1,40 @@
+package commands
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/in4it/go-devops-platform/storage"
+	"github.com/in4it/go-devops-platform/users"
+	"github.com/in4it/wireguard-server/pkg/wireguard"
+)
+
+func ImportWGQuickConfig(storage storage.Iface, importRequest wireguard.ImportRequest) (wireguard.ImportReport, error) {
+	userStore, err := users.NewUserStore(storage, -1)
+	if err != nil {
+		return wireguard.ImportReport{}, fmt.Errorf("userstore initialization error: %s", err)
+	}
+	return wireguard.ImportWGQuickConfig(storage, importRequest, userStore, "import-wg-quick")
+}
+
+// ParseUserMapping parses a mapping file with "public key or peer comment = login" lines. Lines starting with # are ignored.
+// Public keys end with =, so the login is everything after the last =.
+func ParseUserMapping(data string) (map[string]string, error) {
+	userMapping := make(map[string]string)
+	for lineNumber, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.LastIndex(line, "=")
+		if i == -1 {
+			return userMapping, fmt.Errorf("line %d: expected public key or comment = login", lineNumber+1)
+		}
+		key, login := strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:])
+		if key == "" || login == "" {
+			return userMapping, fmt.Errorf("line %d: expected public key or comment = login", lineNumber+1)
+		}
+		userMapping[key] = login
+	}
+	return userMapping, nil
+}
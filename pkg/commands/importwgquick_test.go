@@ -0,0 +1,30 @@
+package commands
+
+import (
+	"maps"
+	"testing"
+)
+
+func TestParseUserMapping(t *testing.T) {
+	userMapping, err := ParseUserMapping(`# mapping of the wg0 peers
+amMLo5A9I7EwF4Fce2ZoHeGAjdhZsl3EvTlKEWZH+jY= = alice
+laptop bob = bob@example.com
+
+`)
+	if err != nil {
+		t.Fatalf("ParseUserMapping error: %s", err)
+	}
+	expected := map[string]string{
+		"amMLo5A9I7EwF4Fce2ZoHeGAjdhZsl3EvTlKEWZH+jY=": "alice",
+		"laptop bob": "bob@example.com",
+	}
+	if !maps.Equal(userMapping, expected) {
+		t.Fatalf("unexpected mapping: %v", userMapping)
+	}
+	for _, invalidMapping := range []string{"alice", "= alice", "key ="} {
+		_, err = ParseUserMapping(invalidMapping)
+		if err == nil {
+			t.Fatalf("expected error for mapping: %s", invalidMapping)
+		}
+	}
+}
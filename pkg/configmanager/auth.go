@@ -0,0 +1,98 @@
+package configmanager
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"time"
+
+	configmanagerclient "github.com/in4it/wireguard-server/pkg/configmanager/client"
+	"github.com/in4it/wireguard-server/pkg/wireguard/fsutils"
+)
+
+const MAX_REQUEST_SIZE = 1024 * 1024
+
+type peerCredentialsKey struct{}
+
+type peerCredentials struct {
+	uid   int
+	known bool // false when the os doesn't support peer credentials
+	err   error
+}
+
+// authMiddleware only lets through signed requests. When the peer credentials of the socket are known, the uid needs to be allowed too.
+func (c *ConfigManager) authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		credentials, ok := r.Context().Value(peerCredentialsKey{}).(peerCredentials)
+		if ok && credentials.err != nil {
+			returnError(w, fmt.Errorf("could not get peer credentials: %s", credentials.err), http.StatusForbidden)
+			return
+		}
+		if ok && credentials.known && !slices.Contains(c.allowedUIDs, credentials.uid) {
+			returnError(w, fmt.Errorf("uid %d is not allowed to use the configmanager api", credentials.uid), http.StatusForbidden)
+			return
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, MAX_REQUEST_SIZE))
+		if err != nil {
+			returnError(w, fmt.Errorf("body read error: %s", err), http.StatusBadRequest)
+			return
+		}
+		err = configmanagerclient.Verify(r, body, c.secret, time.Now())
+		if err != nil {
+			returnError(w, fmt.Errorf("unauthorized: %s", err), http.StatusUnauthorized)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		next.ServeHTTP(w, r)
+	})
+}
+
+// peerCredentialsContext stores the uid of the process on the other side of the unix socket in the request context
+func peerCredentialsContext(ctx context.Context, conn net.Conn) context.Context {
+	uid, known, err := getPeerUID(conn)
+	return context.WithValue(ctx, peerCredentialsKey{}, peerCredentials{uid: uid, known: known, err: err})
+}
+
+// getAllowedUIDs returns root, the user of the configmanager and the vpn user (the rest-server)
+func getAllowedUIDs() []int {
+	allowedUIDs := []int{0, os.Getuid()}
+	vpnUserUid, _, err := fsutils.GetVPNUserUidandGid()
+	if err == nil {
+		allowedUIDs = append(allowedUIDs, vpnUserUid)
+	}
+	return allowedUIDs
+}
+
+// listenUnixSocket listens on the socket, which is only accessible for root and the vpn group
+func listenUnixSocket(socket string) (net.Listener, error) {
+	err := os.Remove(socket) // remove the socket of a previous run
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("could not remove existing socket: %s", err)
+	}
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		return nil, fmt.Errorf("listen error: %s", err)
+	}
+	err = os.Chmod(socket, 0660)
+	if err != nil {
+		l.Close() //nolint:errcheck
+		return nil, fmt.Errorf("could not set permissions of socket: %s", err)
+	}
+	if os.Getuid() == 0 {
+		_, vpnUserGid, err := fsutils.GetVPNUserUidandGid()
+		if err == nil {
+			err = os.Chown(socket, 0, vpnUserGid)
+			if err != nil {
+				l.Close() //nolint:errcheck
+				return nil, fmt.Errorf("could not set ownership of socket: %s", err)
+			}
+		}
+	}
+	return l, nil
+}
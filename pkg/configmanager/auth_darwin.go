@@ -0,0 +1,10 @@
+//go:build darwin
+
+package configmanager
+
+import "net"
+
+// peer credentials are not checked on darwin, the requests still need to be signed
+func getPeerUID(conn net.Conn) (int, bool, error) {
+	return 0, false, nil
+}
@@ -0,0 +1,35 @@
+//go:build linux
+
+package configmanager
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// getPeerUID returns the uid of the peer process using SO_PEERCRED
+func getPeerUID(conn net.Conn) (int, bool, error) {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return 0, false, nil
+	}
+	rawConn, err := unixConn.SyscallConn()
+	if err != nil {
+		return 0, false, fmt.Errorf("syscall conn error: %s", err)
+	}
+	var (
+		ucred    *syscall.Ucred
+		ucredErr error
+	)
+	err = rawConn.Control(func(fd uintptr) {
+		ucred, ucredErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil {
+		return 0, false, fmt.Errorf("control error: %s", err)
+	}
+	if ucredErr != nil {
+		return 0, false, fmt.Errorf("getsockopt error: %s", ucredErr)
+	}
+	return int(ucred.Uid), true, nil
+}
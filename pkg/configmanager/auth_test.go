@@ -0,0 +1,73 @@
+package configmanager
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"runtime"
+	"strings"
+	"testing"
+
+	configmanagerclient "github.com/in4it/wireguard-server/pkg/configmanager/client"
+)
+
+func TestAuthMiddleware(t *testing.T) {
+	socket := path.Join(t.TempDir(), "configmanager.sock")
+	l, err := listenUnixSocket(socket)
+	if err != nil {
+		t.Fatalf("listenUnixSocket error: %s", err)
+	}
+	fileInfo, err := os.Stat(socket)
+	if err != nil {
+		t.Fatalf("stat error: %s", err)
+	}
+	if fileInfo.Mode().Perm() != 0660 {
+		t.Fatalf("unexpected socket permissions: %s", fileInfo.Mode().Perm())
+	}
+
+	c := &ConfigManager{
+		secret:      []byte("secret"),
+		allowedUIDs: getAllowedUIDs(),
+		jobs:        newJobStore(),
+	}
+	ts := httptest.NewUnstartedServer(c.authMiddleware(c.getRouter()))
+	ts.Listener.Close() //nolint:errcheck
+	ts.Listener = l
+	ts.Config.ConnContext = peerCredentialsContext
+	ts.Start()
+	defer ts.Close() //nolint:errcheck
+
+	_, err = configmanagerclient.New(socket, []byte("secret")).Job(context.Background(), "0123456789abcdef0123456789abcdef")
+	if err == nil || !strings.Contains(err.Error(), "status code 404") { // authenticated, but the job doesn't exist
+		t.Fatalf("expected job not found, got: %v", err)
+	}
+
+	_, err = configmanagerclient.New(socket, []byte("wrong secret")).Job(context.Background(), "0123456789abcdef0123456789abcdef")
+	if err == nil || !strings.Contains(err.Error(), "status code 401") {
+		t.Fatalf("expected unauthorized, got: %v", err)
+	}
+
+	if runtime.GOOS == "linux" {
+		c.allowedUIDs = []int{-1}
+		_, err = configmanagerclient.New(socket, []byte("secret")).Job(context.Background(), "0123456789abcdef0123456789abcdef")
+		if err == nil || !strings.Contains(err.Error(), "status code 403") {
+			t.Fatalf("expected forbidden, got: %v", err)
+		}
+	}
+
+	// the legacy router doesn't serve the api
+	req := httptest.NewRequest(http.MethodPost, "/restart-vpn", nil)
+	w := httptest.NewRecorder()
+	c.getLegacyRouter().ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 from the legacy router, got: %d", w.Code)
+	}
+	req = httptest.NewRequest(http.MethodPost, "/upgrade", nil)
+	w = httptest.NewRecorder()
+	c.getLegacyRouter().ServeHTTP(w, req)
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected 405 for an upgrade on the legacy router, got: %d", w.Code)
+	}
+}
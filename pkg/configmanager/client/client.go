@@ -0,0 +1,230 @@
+package configmanagerclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const TIMEOUT = 10 * time.Second
+const DEFAULT_RETRIES = 3
+const DEFAULT_BACKOFF = 200 * time.Millisecond
+
+// API is the configmanager api, implemented by Client and Fake
+type API interface {
+	PubKey(ctx context.Context) (PubKeyExchange, error)
+	RefreshClients(ctx context.Context, refreshClientRequest RefreshClientRequest) (JobResponse, error)
+	PeerStats(ctx context.Context) ([]LivePeerStat, error)
+	RefreshServerConfig(ctx context.Context) (JobResponse, error)
+	UpgradeStatus(ctx context.Context) (UpgradeResponse, error)
+	Upgrade(ctx context.Context) (UpgradeStartResponse, error)
+	RestartVPN(ctx context.Context) (JobResponse, error)
+	RotateServerKey(ctx context.Context, rotateServerKeyRequest RotateServerKeyRequest) (RotateServerKeyResponse, error)
+	ReconcileStatus(ctx context.Context) (ReconcileStatus, error)
+	Reconcile(ctx context.Context) (JobResponse, error)
+	FirewallStatus(ctx context.Context) (FirewallStatus, error)
+	ApplyFirewall(ctx context.Context) (JobResponse, error)
+	Job(ctx context.Context, jobID string) (Job, error)
+	Version(ctx context.Context) (VersionResponse, error)
+}
+
+// Client talks to the configmanager over its unix socket. Every request is signed with the shared secret.
+type Client struct {
+	Retries    int           // retries when the configmanager can't be reached (e.g. during a restart)
+	Backoff    time.Duration // wait time before the first retry, doubled after every retry
+	secret     []byte
+	httpClient *http.Client
+}
+
+func New(socket string, secret []byte) *Client {
+	return &Client{
+		Retries: DEFAULT_RETRIES,
+		Backoff: DEFAULT_BACKOFF,
+		secret:  secret,
+		httpClient: &http.Client{
+			Timeout: TIMEOUT,
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var dialer net.Dialer
+					return dialer.DialContext(ctx, "unix", socket)
+				},
+				DisableKeepAlives: true,
+			},
+		},
+	}
+}
+
+func (c *Client) PubKey(ctx context.Context) (PubKeyExchange, error) {
+	var pubKeyExchange PubKeyExchange
+	_, err := c.do(ctx, http.MethodGet, "/pubkey", nil, http.StatusOK, &pubKeyExchange)
+	return pubKeyExchange, err
+}
+
+// RefreshClients notifies the configmanager of added, deleted or changed peer configs
+func (c *Client) RefreshClients(ctx context.Context, refreshClientRequest RefreshClientRequest) (JobResponse, error) {
+	return c.doJob(ctx, "/refresh-clients", refreshClientRequest)
+}
+
+func (c *Client) PeerStats(ctx context.Context) ([]LivePeerStat, error) {
+	var livePeerStats []LivePeerStat
+	_, err := c.do(ctx, http.MethodGet, "/peer-stats", nil, http.StatusOK, &livePeerStats)
+	return livePeerStats, err
+}
+
+// RefreshServerConfig lets the configmanager apply a changed vpn config
+func (c *Client) RefreshServerConfig(ctx context.Context) (JobResponse, error) {
+	return c.doJob(ctx, "/refresh-server-config", nil)
+}
+
+func (c *Client) UpgradeStatus(ctx context.Context) (UpgradeResponse, error) {
+	var upgradeResponse UpgradeResponse
+	_, err := c.do(ctx, http.MethodGet, "/upgrade", nil, http.StatusOK, &upgradeResponse)
+	return upgradeResponse, err
+}
+
+func (c *Client) Upgrade(ctx context.Context) (UpgradeStartResponse, error) {
+	var upgradeStartResponse UpgradeStartResponse
+	_, err := c.do(ctx, http.MethodPost, "/upgrade", nil, http.StatusOK, &upgradeStartResponse)
+	return upgradeStartResponse, err
+}
+
+func (c *Client) RestartVPN(ctx context.Context) (JobResponse, error) {
+	return c.doJob(ctx, "/restart-vpn", nil)
+}
+
+func (c *Client) RotateServerKey(ctx context.Context, rotateServerKeyRequest RotateServerKeyRequest) (RotateServerKeyResponse, error) {
+	var rotateServerKeyResponse RotateServerKeyResponse
+	_, err := c.do(ctx, http.MethodPost, "/rotate-server-key", rotateServerKeyRequest, http.StatusAccepted, &rotateServerKeyResponse)
+	return rotateServerKeyResponse, err
+}
+
+func (c *Client) ReconcileStatus(ctx context.Context) (ReconcileStatus, error) {
+	var reconcileStatus ReconcileStatus
+	_, err := c.do(ctx, http.MethodGet, "/reconcile", nil, http.StatusOK, &reconcileStatus)
+	return reconcileStatus, err
+}
+
+// Reconcile schedules an early reconcile pass
+func (c *Client) Reconcile(ctx context.Context) (JobResponse, error) {
+	return c.doJob(ctx, "/reconcile", nil)
+}
+
+func (c *Client) FirewallStatus(ctx context.Context) (FirewallStatus, error) {
+	var firewallStatus FirewallStatus
+	_, err := c.do(ctx, http.MethodGet, "/firewall", nil, http.StatusOK, &firewallStatus)
+	return firewallStatus, err
+}
+
+// ApplyFirewall re-applies the firewall rules when they're not in sync
+func (c *Client) ApplyFirewall(ctx context.Context) (JobResponse, error) {
+	return c.doJob(ctx, "/firewall", nil)
+}
+
+func (c *Client) Job(ctx context.Context, jobID string) (Job, error) {
+	var job Job
+	_, err := c.do(ctx, http.MethodGet, "/jobs/"+url.PathEscape(jobID), nil, http.StatusOK, &job)
+	return job, err
+}
+
+func (c *Client) Version(ctx context.Context) (VersionResponse, error) {
+	var versionResponse VersionResponse
+	_, err := c.do(ctx, http.MethodGet, "/version", nil, http.StatusOK, &versionResponse)
+	return versionResponse, err
+}
+
+// doJob sends a mutating request and returns the job that tracks it
+func (c *Client) doJob(ctx context.Context, requestURI string, request any) (JobResponse, error) {
+	var jobResponse JobResponse
+	_, err := c.do(ctx, http.MethodPost, requestURI, request, http.StatusAccepted, &jobResponse)
+	return jobResponse, err
+}
+
+// do sends a signed request and returns the body. The body is also decoded into response, when not nil.
+// The request is retried with backoff when the configmanager can't be reached.
+func (c *Client) do(ctx context.Context, method, requestURI string, request any, expectedStatusCode int, response any) ([]byte, error) {
+	var (
+		payload []byte
+		body    []byte
+		err     error
+	)
+	if request != nil {
+		payload, err = json.Marshal(request)
+		if err != nil {
+			return nil, fmt.Errorf("marshal error: %s", err)
+		}
+	}
+
+	backoff := c.Backoff
+	for attempt := 0; ; attempt++ {
+		body, err = c.send(ctx, method, requestURI, payload, expectedStatusCode)
+		if err == nil {
+			break
+		}
+		if attempt >= c.Retries || !isRetryable(ctx, method, err) {
+			return nil, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("%s (retry canceled: %s)", err, ctx.Err())
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+
+	if response != nil {
+		err = json.Unmarshal(body, response)
+		if err != nil {
+			return nil, fmt.Errorf("decode error: %s", err)
+		}
+	}
+	return body, nil
+}
+
+func (c *Client) send(ctx context.Context, method, requestURI string, payload []byte, expectedStatusCode int) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, "http://configmanager"+requestURI, bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("configmanager request error: %s", err)
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	Sign(req, payload, c.secret, time.Now())
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("configmanager request error: %w", err)
+	}
+	defer resp.Body.Close() //nolint:errcheck
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("configmanager response read error: %w", err)
+	}
+	if resp.StatusCode != expectedStatusCode {
+		return nil, decodeError(resp.StatusCode, body)
+	}
+	return body, nil
+}
+
+// isRetryable returns true when the configmanager couldn't be reached. Other errors are only retried for GET requests,
+// as a mutating request might have been executed already.
+func isRetryable(ctx context.Context, method string, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var apiError *Error
+	if errors.As(err, &apiError) {
+		return method == http.MethodGet && apiError.StatusCode >= http.StatusInternalServerError
+	}
+	var opError *net.OpError
+	if errors.As(err, &opError) && opError.Op == "dial" {
+		return true
+	}
+	return method == http.MethodGet
+}
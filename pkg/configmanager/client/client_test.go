@@ -0,0 +1,172 @@
+package configmanagerclient
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestServer(t *testing.T, handler http.HandlerFunc) (*httptest.Server, string) {
+	socket := path.Join(t.TempDir(), "configmanager.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewUnstartedServer(handler)
+	ts.Listener.Close() //nolint:errcheck
+	ts.Listener = l
+	ts.Start()
+	return ts, socket
+}
+
+func TestClientErrorDecoding(t *testing.T) {
+	ts, socket := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"error": "job not found"}`))
+	})
+	defer ts.Close() //nolint:errcheck
+
+	_, err := New(socket, []byte("secret")).Job(context.Background(), "0123456789abcdef0123456789abcdef")
+	var apiError *Error
+	if !errors.As(err, &apiError) {
+		t.Fatalf("expected api error, got: %v", err)
+	}
+	if apiError.StatusCode != http.StatusNotFound || apiError.Message != "job not found" || !IsNotFound(err) {
+		t.Fatalf("unexpected api error: %+v", apiError)
+	}
+
+	if decodeError(http.StatusBadRequest, []byte("not json\n")).Message != "not json" {
+		t.Fatalf("expected body as message when the body is not json")
+	}
+
+	tsJob, socketJob := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	})
+	defer tsJob.Close() //nolint:errcheck
+
+	_, err = New(socketJob, []byte("secret")).RestartVPN(context.Background())
+	if err == nil {
+		t.Fatalf("expected decode error when no job is returned")
+	}
+}
+
+func TestClientRetries(t *testing.T) {
+	var requests atomic.Int32
+	ts, socket := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if r.Method == http.MethodPost {
+			w.WriteHeader(http.StatusAccepted)
+			_, _ = w.Write([]byte(`{"jobId": "0123456789abcdef0123456789abcdef"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"version": "v1.2.3"}`))
+	})
+	defer ts.Close() //nolint:errcheck
+
+	client := New(socket, []byte("secret"))
+	client.Backoff = 1 * time.Millisecond
+
+	version, err := client.Version(context.Background())
+	if err != nil {
+		t.Fatalf("version error: %s", err)
+	}
+	if version.Version != "v1.2.3" || requests.Load() != 3 {
+		t.Fatalf("unexpected version %s after %d requests", version.Version, requests.Load())
+	}
+
+	// mutating requests that reached the configmanager are not retried
+	requests.Store(0)
+	_, err = client.RestartVPN(context.Background())
+	if err == nil || requests.Load() != 1 {
+		t.Fatalf("expected a single failed request, got %d requests (error: %v)", requests.Load(), err)
+	}
+}
+
+func TestClientRetryDial(t *testing.T) {
+	socket := path.Join(t.TempDir(), "configmanager.sock")
+	client := New(socket, []byte("secret"))
+	client.Retries = 5
+	client.Backoff = 20 * time.Millisecond
+
+	// the configmanager starts listening while the client is retrying
+	started := make(chan *httptest.Server)
+	go func() {
+		time.Sleep(30 * time.Millisecond)
+		l, err := net.Listen("unix", socket)
+		if err != nil {
+			t.Errorf("listen error: %s", err)
+			started <- nil
+			return
+		}
+		ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusAccepted)
+			_, _ = w.Write([]byte(`{"jobId": "0123456789abcdef0123456789abcdef"}`))
+		}))
+		ts.Listener.Close() //nolint:errcheck
+		ts.Listener = l
+		ts.Start()
+		started <- ts
+	}()
+
+	_, err := client.RestartVPN(context.Background())
+	ts := <-started
+	if ts != nil {
+		defer ts.Close() //nolint:errcheck
+	}
+	if err != nil {
+		t.Fatalf("restart error: %s", err)
+	}
+}
+
+func TestClientContext(t *testing.T) {
+	socket := path.Join(t.TempDir(), "configmanager.sock")
+	client := New(socket, []byte("secret"))
+	client.Backoff = 1 * time.Hour
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := client.PeerStats(ctx)
+	if err == nil {
+		t.Fatalf("expected error when the configmanager is not listening")
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Fatalf("retry didn't stop when the context was done")
+	}
+}
+
+func TestWaitForJob(t *testing.T) {
+	fake := NewFake()
+	jobResponse, err := fake.Reconcile(context.Background())
+	if err != nil {
+		t.Fatalf("reconcile error: %s", err)
+	}
+	job, err := WaitForJob(context.Background(), fake, jobResponse.JobID)
+	if err != nil {
+		t.Fatalf("WaitForJob error: %s", err)
+	}
+	if job.Action != "reconcile" || fake.CallCount("POST /reconcile") != 1 {
+		t.Fatalf("unexpected job: %+v", job)
+	}
+
+	fake.JobStatus = JOB_STATUS_RUNNING
+	jobResponse, err = fake.RestartVPN(context.Background())
+	if err != nil {
+		t.Fatalf("restart error: %s", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = WaitForJob(ctx, fake, jobResponse.JobID)
+	if err == nil {
+		t.Fatalf("expected timeout for a running job")
+	}
+}
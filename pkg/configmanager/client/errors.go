@@ -0,0 +1,35 @@
+package configmanagerclient
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Error is returned when the configmanager responds with an unexpected status code
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("configmanager returned status code %d: %s", e.StatusCode, e.Message)
+}
+
+// IsNotFound returns true when the configmanager responded with 404 (e.g. an unknown or expired job)
+func IsNotFound(err error) bool {
+	var apiError *Error
+	return errors.As(err, &apiError) && apiError.StatusCode == http.StatusNotFound
+}
+
+// decodeError reads the message from the {"error": ...} body. Bodies in another format are returned as is.
+func decodeError(statusCode int, body []byte) *Error {
+	var errorResponse ErrorResponse
+	err := json.Unmarshal(body, &errorResponse)
+	if err != nil || errorResponse.Error == "" {
+		return &Error{StatusCode: statusCode, Message: strings.TrimSpace(string(body))}
+	}
+	return &Error{StatusCode: statusCode, Message: errorResponse.Error}
+}
@@ -0,0 +1,186 @@
+package configmanagerclient
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Fake is an in-memory configmanager for tests. Calls are recorded, and mutating calls create a job that is already finished.
+type Fake struct {
+	// responses
+	PubKeyExchange          PubKeyExchange
+	LivePeerStats           []LivePeerStat
+	UpgradeResponse         UpgradeResponse
+	RotateServerKeyResponse RotateServerKeyResponse
+	LastReconcileStatus     ReconcileStatus
+	CurrentFirewallStatus   FirewallStatus
+	VersionResponse         VersionResponse
+	JobStatus               string // status of the created jobs (JOB_STATUS_SUCCEEDED by default)
+	JobError                string // error of the created jobs, when JobStatus is JOB_STATUS_FAILED
+	Err                     error  // returned by every call when set
+
+	mutex                   sync.Mutex
+	calls                   map[string]int
+	refreshClientRequests   []RefreshClientRequest
+	rotateServerKeyRequests []RotateServerKeyRequest
+	jobs                    map[string]Job
+}
+
+func NewFake() *Fake {
+	return &Fake{
+		LivePeerStats: []LivePeerStat{},
+		JobStatus:     JOB_STATUS_SUCCEEDED,
+		calls:         make(map[string]int),
+		jobs:          make(map[string]Job),
+	}
+}
+
+// CallCount returns how many times a route was called, e.g. CallCount("POST /restart-vpn")
+func (f *Fake) CallCount(call string) int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.calls[call]
+}
+
+func (f *Fake) RefreshClientRequests() []RefreshClientRequest {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return slices.Clone(f.refreshClientRequests)
+}
+
+func (f *Fake) RotateServerKeyRequests() []RotateServerKeyRequest {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return slices.Clone(f.rotateServerKeyRequests)
+}
+
+func (f *Fake) PubKey(ctx context.Context) (PubKeyExchange, error) {
+	return f.PubKeyExchange, f.call(http.MethodGet, "/pubkey")
+}
+
+func (f *Fake) RefreshClients(ctx context.Context, refreshClientRequest RefreshClientRequest) (JobResponse, error) {
+	err := f.call(http.MethodPost, "/refresh-clients")
+	if err != nil {
+		return JobResponse{}, err
+	}
+	f.mutex.Lock()
+	f.refreshClientRequests = append(f.refreshClientRequests, refreshClientRequest)
+	f.mutex.Unlock()
+	return f.newJob("refresh-clients"), nil
+}
+
+func (f *Fake) PeerStats(ctx context.Context) ([]LivePeerStat, error) {
+	return f.LivePeerStats, f.call(http.MethodGet, "/peer-stats")
+}
+
+func (f *Fake) RefreshServerConfig(ctx context.Context) (JobResponse, error) {
+	return f.callJob(http.MethodPost, "/refresh-server-config", "refresh-server-config")
+}
+
+func (f *Fake) UpgradeStatus(ctx context.Context) (UpgradeResponse, error) {
+	return f.UpgradeResponse, f.call(http.MethodGet, "/upgrade")
+}
+
+func (f *Fake) Upgrade(ctx context.Context) (UpgradeStartResponse, error) {
+	jobResponse, err := f.callJob(http.MethodPost, "/upgrade", "upgrade")
+	if err != nil {
+		return UpgradeStartResponse{}, err
+	}
+	return UpgradeStartResponse{Upgrade: "starting", JobID: jobResponse.JobID}, nil
+}
+
+func (f *Fake) RestartVPN(ctx context.Context) (JobResponse, error) {
+	return f.callJob(http.MethodPost, "/restart-vpn", "restart-vpn")
+}
+
+func (f *Fake) RotateServerKey(ctx context.Context, rotateServerKeyRequest RotateServerKeyRequest) (RotateServerKeyResponse, error) {
+	err := f.call(http.MethodPost, "/rotate-server-key")
+	if err != nil {
+		return RotateServerKeyResponse{}, err
+	}
+	f.mutex.Lock()
+	f.rotateServerKeyRequests = append(f.rotateServerKeyRequests, rotateServerKeyRequest)
+	f.mutex.Unlock()
+	rotateServerKeyResponse := f.RotateServerKeyResponse
+	rotateServerKeyResponse.JobID = f.newJob("rotate-server-key").JobID
+	return rotateServerKeyResponse, nil
+}
+
+func (f *Fake) ReconcileStatus(ctx context.Context) (ReconcileStatus, error) {
+	return f.LastReconcileStatus, f.call(http.MethodGet, "/reconcile")
+}
+
+func (f *Fake) Reconcile(ctx context.Context) (JobResponse, error) {
+	return f.callJob(http.MethodPost, "/reconcile", "reconcile")
+}
+
+func (f *Fake) FirewallStatus(ctx context.Context) (FirewallStatus, error) {
+	return f.CurrentFirewallStatus, f.call(http.MethodGet, "/firewall")
+}
+
+func (f *Fake) ApplyFirewall(ctx context.Context) (JobResponse, error) {
+	return f.callJob(http.MethodPost, "/firewall", "firewall")
+}
+
+func (f *Fake) Job(ctx context.Context, jobID string) (Job, error) {
+	err := f.call(http.MethodGet, "/jobs/{id}")
+	if err != nil {
+		return Job{}, err
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	job, ok := f.jobs[jobID]
+	if !ok {
+		return Job{}, &Error{StatusCode: http.StatusNotFound, Message: "job not found"}
+	}
+	return job, nil
+}
+
+func (f *Fake) Version(ctx context.Context) (VersionResponse, error) {
+	return f.VersionResponse, f.call(http.MethodGet, "/version")
+}
+
+func (f *Fake) call(method, route string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.calls[method+" "+route]++
+	return f.Err
+}
+
+func (f *Fake) callJob(method, route, action string) (JobResponse, error) {
+	err := f.call(method, route)
+	if err != nil {
+		return JobResponse{}, err
+	}
+	return f.newJob(action), nil
+}
+
+func (f *Fake) newJob(action string) JobResponse {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	now := time.Now()
+	job := Job{
+		ID:        fmt.Sprintf("%032x", len(f.jobs)+1),
+		Action:    action,
+		Status:    f.JobStatus,
+		CreatedAt: now,
+		StartedAt: now,
+	}
+	switch job.Status {
+	case JOB_STATUS_SUCCEEDED:
+		job.Progress = 100
+		job.FinishedAt = now
+	case JOB_STATUS_FAILED:
+		job.Error = f.JobError
+		job.FinishedAt = now
+	}
+	f.jobs[job.ID] = job
+	return JobResponse{JobID: job.ID}
+}
+
+var _ API = &Client{}
+var _ API = &Fake{}
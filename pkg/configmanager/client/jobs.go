@@ -0,0 +1,30 @@
+package configmanagerclient
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const JOB_POLL_INTERVAL = 100 * time.Millisecond
+
+// WaitForJob polls the job until it's finished. An error is returned when the job failed or the context is done before the job finished.
+func WaitForJob(ctx context.Context, api API, jobID string) (Job, error) {
+	for {
+		job, err := api.Job(ctx, jobID)
+		if err != nil {
+			return job, err
+		}
+		switch job.Status {
+		case JOB_STATUS_SUCCEEDED:
+			return job, nil
+		case JOB_STATUS_FAILED:
+			return job, fmt.Errorf("job %s (%s) failed: %s", job.ID, job.Action, job.Error)
+		}
+		select {
+		case <-ctx.Done():
+			return job, fmt.Errorf("job %s (%s) didn't finish in time (status: %s)", job.ID, job.Action, job.Status)
+		case <-time.After(JOB_POLL_INTERVAL):
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package configmanagerclient
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const TIMESTAMP_HEADER = "X-Configmanager-Timestamp"
+const SIGNATURE_HEADER = "X-Configmanager-Signature"
+const SIGNATURE_MAX_AGE = 5 * time.Minute
+
+// Sign adds a timestamp and a HMAC-SHA256 signature of the method, request uri, timestamp and body to the request
+func Sign(r *http.Request, body []byte, secret []byte, now time.Time) {
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	r.Header.Set(TIMESTAMP_HEADER, timestamp)
+	r.Header.Set(SIGNATURE_HEADER, signature(secret, r.Method, r.URL.RequestURI(), timestamp, body))
+}
+
+// Verify checks the signature of a request signed with Sign. Requests with a timestamp more than SIGNATURE_MAX_AGE away from now are rejected.
+func Verify(r *http.Request, body []byte, secret []byte, now time.Time) error {
+	timestamp := r.Header.Get(TIMESTAMP_HEADER)
+	if timestamp == "" {
+		return fmt.Errorf("request is not signed")
+	}
+	unixTimestamp, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid timestamp")
+	}
+	age := now.Sub(time.Unix(unixTimestamp, 0))
+	if age > SIGNATURE_MAX_AGE || age < -SIGNATURE_MAX_AGE {
+		return fmt.Errorf("request expired")
+	}
+	expected := signature(secret, r.Method, r.URL.RequestURI(), timestamp, body)
+	if !hmac.Equal([]byte(expected), []byte(r.Header.Get(SIGNATURE_HEADER))) {
+		return fmt.Errorf("invalid signature")
+	}
+	return nil
+}
+
+func signature(secret []byte, method, requestURI, timestamp string, body []byte) string {
+	bodyHash := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(method + "\n" + requestURI + "\n" + timestamp + "\n" + hex.EncodeToString(bodyHash[:])))
+	return hex.EncodeToString(mac.Sum(nil))
+}
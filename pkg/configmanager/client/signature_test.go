@@ -0,0 +1,49 @@
+package configmanagerclient
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignVerify(t *testing.T) {
+	secret := []byte("secret")
+	body := []byte(`{"action":"add","filenames":["1-2-3-4-0.json"]}`)
+	now := time.Now()
+
+	req, err := http.NewRequest(http.MethodPost, "http://configmanager/refresh-clients", nil)
+	if err != nil {
+		t.Fatalf("new request error: %s", err)
+	}
+	Sign(req, body, secret, now)
+
+	err = Verify(req, body, secret, now.Add(1*time.Minute))
+	if err != nil {
+		t.Fatalf("verify error: %s", err)
+	}
+	err = Verify(req, []byte(`{"action":"delete","filenames":["1-2-3-4-0.json"]}`), secret, now)
+	if err == nil || err.Error() != "invalid signature" {
+		t.Fatalf("expected invalid signature for a changed body, got: %v", err)
+	}
+	err = Verify(req, body, []byte("other secret"), now)
+	if err == nil || err.Error() != "invalid signature" {
+		t.Fatalf("expected invalid signature for a different secret, got: %v", err)
+	}
+	err = Verify(req, body, secret, now.Add(SIGNATURE_MAX_AGE+1*time.Second))
+	if err == nil || err.Error() != "request expired" {
+		t.Fatalf("expected expired request, got: %v", err)
+	}
+
+	req.URL.Path = "/restart-vpn"
+	err = Verify(req, body, secret, now)
+	if err == nil || err.Error() != "invalid signature" {
+		t.Fatalf("expected invalid signature for a different path, got: %v", err)
+	}
+
+	req.Header.Del(TIMESTAMP_HEADER)
+	err = Verify(req, body, secret, now)
+	if err == nil || !strings.Contains(err.Error(), "not signed") {
+		t.Fatalf("expected unsigned request error, got: %v", err)
+	}
+}
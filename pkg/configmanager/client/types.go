@@ -0,0 +1,132 @@
+package configmanagerclient
+
+import "time"
+
+// job status
+const JOB_STATUS_PENDING = "pending"
+const JOB_STATUS_RUNNING = "running"
+const JOB_STATUS_SUCCEEDED = "succeeded"
+const JOB_STATUS_FAILED = "failed"
+
+type RefreshClientRequest struct {
+	Action    string
+	Filenames []string `json:"filenames"`
+}
+
+type RotateServerKeyRequest struct {
+	SwitchAt  time.Time `json:"switchAt"`
+	Emergency bool      `json:"emergency"`
+}
+type RotateServerKeyResponse struct {
+	PublicKey          string    `json:"publicKey"`
+	PendingPublicKey   string    `json:"pendingPublicKey"`
+	PendingKeySwitchAt time.Time `json:"pendingKeySwitchAt"`
+	JobID              string    `json:"jobId,omitempty"`
+}
+
+// live peer stats, as reported by the configmanager
+type LivePeerStat struct {
+	PublicKey         string    `json:"publicKey"`
+	Endpoint          string    `json:"endpoint"`
+	LastHandshakeTime time.Time `json:"lastHandshakeTime"`
+	ReceiveBytes      int64     `json:"receiveBytes"`
+	TransmitBytes     int64     `json:"transmitBytes"`
+}
+
+// changes made by a reconcile pass of the configmanager
+type ReconcileDrift struct {
+	PeersAdded       int `json:"peersAdded"`
+	PeersUpdated     int `json:"peersUpdated"`
+	PeersRemoved     int `json:"peersRemoved"`
+	RoutesAdded      int `json:"routesAdded"`
+	RoutesRemoved    int `json:"routesRemoved"`
+	ServerKeyUpdated int `json:"serverKeyUpdated"`
+	PeerErrors       int `json:"peerErrors"` // peers that were skipped because their peer config is invalid
+}
+
+// reconcile status, as reported by the configmanager
+type ReconcileStatus struct {
+	LastRun           time.Time      `json:"lastRun"`
+	LastSuccess       time.Time      `json:"lastSuccess"`
+	LastTrigger       string         `json:"lastTrigger"`
+	LastDurationMs    int64          `json:"lastDurationMs"`
+	LastError         string         `json:"lastError,omitempty"`
+	LastDrift         ReconcileDrift `json:"lastDrift"`
+	TotalDrift        ReconcileDrift `json:"totalDrift"`
+	Runs              int            `json:"runs"`
+	Errors            int            `json:"errors"`
+	ConsecutiveErrors int            `json:"consecutiveErrors"`
+}
+
+// job of the configmanager, created for every mutating action
+type Job struct {
+	ID         string    `json:"id"`
+	Action     string    `json:"action"`
+	Status     string    `json:"status"`
+	Progress   int       `json:"progress"`
+	Message    string    `json:"message,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	CreatedAt  time.Time `json:"createdAt"`
+	StartedAt  time.Time `json:"startedAt,omitzero"`
+	FinishedAt time.Time `json:"finishedAt,omitzero"`
+	DurationMs int64     `json:"durationMs"`
+}
+
+type JobResponse struct {
+	JobID string `json:"jobId"`
+}
+
+type PubKeyExchange struct {
+	PubKey string `json:"pubKey"`
+}
+
+type UpgradeResponse struct {
+	NewVersionAvailable bool   `json:"newVersionAvailable"`
+	NewVersion          string `json:"newVersion"`
+	CurrentVersion      string `json:"currentVersion"`
+}
+type UpgradeStartResponse struct {
+	Upgrade string `json:"upgrade"`
+	JobID   string `json:"jobId"`
+}
+
+type VersionResponse struct {
+	Version string `json:"version"`
+}
+
+// firewall rules are identified by their comment. The comment contains a hash of the rule, so a changed rule is detected as drift
+type FirewallRule struct {
+	Comment string `json:"comment"`
+	Expr    string `json:"expr,omitempty"`
+}
+
+type FirewallChain struct {
+	Name     string         `json:"name"`
+	Type     string         `json:"type"`
+	Hook     string         `json:"hook"`
+	Priority int            `json:"priority"`
+	Policy   string         `json:"policy"`
+	Rules    []FirewallRule `json:"rules"`
+}
+
+type FirewallStatus struct {
+	Table           string                  `json:"table"`
+	Installed       bool                    `json:"installed"`
+	InSync          bool                    `json:"inSync"`
+	Desired         []FirewallChain         `json:"desired"`
+	Current         []FirewallChain         `json:"current"`
+	IPTablesForward []IPTablesForwardStatus `json:"iptablesForward"`
+}
+
+// the FORWARD chain of iptables. When it drops packets (e.g. docker or ufw), the vpn traffic is also accepted in this chain
+type IPTablesForwardStatus struct {
+	Binary         string `json:"binary"`         // iptables or ip6tables
+	DropPolicy     bool   `json:"dropPolicy"`     // the chain drops packets that are not accepted
+	Required       bool   `json:"required"`       // the accept rules need to be installed
+	RulesInstalled int    `json:"rulesInstalled"` // number of accept rules that are installed
+}
+
+// body of an error returned by the configmanager
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
@@ -0,0 +1,297 @@
+package configmanager
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/in4it/go-devops-platform/storage"
+	configmanagerclient "github.com/in4it/wireguard-server/pkg/configmanager/client"
+	"github.com/in4it/wireguard-server/pkg/wireguard"
+)
+
+const FIREWALL_TABLE_FAMILY = "inet" // inet covers both ipv4 and ipv6
+const FIREWALL_TABLE_NAME = "vpn-server"
+const FIREWALL_RECONCILE_INTERVAL = 1 * time.Minute
+const IPTABLES_FORWARD_COMMENT = "vpn-server"
+
+type firewallRule = configmanagerclient.FirewallRule
+type firewallChain = configmanagerclient.FirewallChain
+type FirewallStatus = configmanagerclient.FirewallStatus
+type IPTablesForwardStatus = configmanagerclient.IPTablesForwardStatus
+
+func newFirewallRule(name, expr string) firewallRule {
+	hash := sha256.Sum256([]byte(expr))
+	return firewallRule{
+		Comment: fmt.Sprintf("%s %x", name, hash[:4]),
+		Expr:    expr,
+	}
+}
+
+// getFirewallChains returns the chains and rules that should be installed in the vpn-server table. No chains means the table should not exist.
+func getFirewallChains(vpnConfig wireguard.VPNConfig) ([]firewallChain, error) {
+	if vpnConfig.DisableNAT {
+		return nil, nil
+	}
+	if vpnConfig.ExternalInterface != "" && !wireguard.IsValidInterfaceName(vpnConfig.ExternalInterface) {
+		return nil, fmt.Errorf("invalid external interface: %s", vpnConfig.ExternalInterface)
+	}
+
+	forward := firewallChain{Name: "forward", Type: "filter", Hook: "forward", Priority: 0, Policy: "accept"}
+	forward.Rules = []firewallRule{
+		newFirewallRule("forward-in", fmt.Sprintf("iifname %q accept", wireguard.VPN_INTERFACE_NAME)),
+		newFirewallRule("forward-out", fmt.Sprintf("oifname %q accept", wireguard.VPN_INTERFACE_NAME)),
+	}
+
+	// only traffic of the vpn clients is masqueraded. Without an external interface, that's all their traffic that doesn't leave through the vpn interface
+	if !vpnConfig.AddressRange.IsValid() {
+		return nil, fmt.Errorf("vpn address range is not set")
+	}
+	outputInterface := fmt.Sprintf("oifname != %q", wireguard.VPN_INTERFACE_NAME)
+	if vpnConfig.ExternalInterface != "" {
+		outputInterface = fmt.Sprintf("oifname %q", vpnConfig.ExternalInterface)
+	}
+	postrouting := firewallChain{Name: "postrouting", Type: "nat", Hook: "postrouting", Priority: 100, Policy: "accept"}
+	postrouting.Rules = []firewallRule{
+		newFirewallRule("masquerade-ipv4", fmt.Sprintf("ip saddr %s %s masquerade", vpnConfig.AddressRange.Masked(), outputInterface)),
+	}
+	if vpnConfig.AddressRangeIPv6.IsValid() {
+		postrouting.Rules = append(postrouting.Rules, newFirewallRule("masquerade-ipv6", fmt.Sprintf("ip6 saddr %s %s masquerade", vpnConfig.AddressRangeIPv6.Masked(), outputInterface)))
+	}
+
+	return []firewallChain{forward, postrouting}, nil
+}
+
+// getFirewallScript returns an nft script that atomically replaces the vpn-server table. The table is created first, so the delete never fails.
+func getFirewallScript(chains []firewallChain) string {
+	table := FIREWALL_TABLE_FAMILY + " " + FIREWALL_TABLE_NAME
+	script := "table " + table + "\n"
+	script += "delete table " + table + "\n"
+	if len(chains) == 0 {
+		return script
+	}
+	script += "table " + table + " {\n"
+	for _, chain := range chains {
+		script += "\tchain " + chain.Name + " {\n"
+		script += fmt.Sprintf("\t\ttype %s hook %s priority %d; policy %s;\n", chain.Type, chain.Hook, chain.Priority, chain.Policy)
+		for _, rule := range chain.Rules {
+			script += fmt.Sprintf("\t\t%s comment %q\n", rule.Expr, rule.Comment)
+		}
+		script += "\t}\n"
+	}
+	script += "}\n"
+	return script
+}
+
+// parseFirewallTable parses the output of nft -j list table
+func parseFirewallTable(out []byte) ([]firewallChain, error) {
+	var listing struct {
+		Nftables []struct {
+			Chain *struct {
+				Name   string `json:"name"`
+				Type   string `json:"type"`
+				Hook   string `json:"hook"`
+				Prio   int    `json:"prio"`
+				Policy string `json:"policy"`
+			} `json:"chain"`
+			Rule *struct {
+				Chain   string `json:"chain"`
+				Comment string `json:"comment"`
+			} `json:"rule"`
+		} `json:"nftables"`
+	}
+	err := json.Unmarshal(out, &listing)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse nft output: %s", err)
+	}
+	chains := []firewallChain{}
+	for _, item := range listing.Nftables {
+		if item.Chain != nil {
+			chains = append(chains, firewallChain{
+				Name:     item.Chain.Name,
+				Type:     item.Chain.Type,
+				Hook:     item.Chain.Hook,
+				Priority: item.Chain.Prio,
+				Policy:   item.Chain.Policy,
+				Rules:    []firewallRule{},
+			})
+		}
+		if item.Rule != nil {
+			index := slices.IndexFunc(chains, func(chain firewallChain) bool { return chain.Name == item.Rule.Chain })
+			if index == -1 {
+				return nil, fmt.Errorf("rule found for unknown chain: %s", item.Rule.Chain)
+			}
+			chains[index].Rules = append(chains[index].Rules, firewallRule{Comment: item.Rule.Comment})
+		}
+	}
+	return chains, nil
+}
+
+// firewallInSync compares the installed chains with the desired chains. Rules are compared by comment.
+func firewallInSync(desired []firewallChain, installed []firewallChain, tableExists bool) bool {
+	if len(desired) == 0 {
+		return !tableExists
+	}
+	if !tableExists || len(desired) != len(installed) {
+		return false
+	}
+	for k := range desired {
+		if desired[k].Name != installed[k].Name || desired[k].Type != installed[k].Type || desired[k].Hook != installed[k].Hook ||
+			desired[k].Priority != installed[k].Priority || desired[k].Policy != installed[k].Policy {
+			return false
+		}
+		if !slices.EqualFunc(desired[k].Rules, installed[k].Rules, func(a, b firewallRule) bool { return a.Comment == b.Comment }) {
+			return false
+		}
+	}
+	return true
+}
+
+// getIPTablesForwardRules returns the rules that are inserted in the iptables FORWARD chain when that chain drops packets.
+// An accept in the vpn-server table only ends that chain, so a drop in another chain on the forward hook still applies.
+func getIPTablesForwardRules() [][]string {
+	return [][]string{
+		{"-i", wireguard.VPN_INTERFACE_NAME, "-m", "comment", "--comment", IPTABLES_FORWARD_COMMENT, "-j", "ACCEPT"},
+		{"-o", wireguard.VPN_INTERFACE_NAME, "-m", "comment", "--comment", IPTABLES_FORWARD_COMMENT, "-j", "ACCEPT"},
+	}
+}
+
+// parseIPTablesForward parses the output of iptables -S FORWARD. The chain drops packets when its policy is drop
+// or when it ends with a rule that drops or rejects everything.
+func parseIPTablesForward(binary string, out string) IPTablesForwardStatus {
+	forward := IPTablesForwardStatus{Binary: binary}
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	for _, line := range lines {
+		if line == "-P FORWARD DROP" || line == "-A FORWARD -j DROP" || strings.HasPrefix(line, "-A FORWARD -j REJECT") {
+			forward.DropPolicy = true
+		}
+	}
+	for _, rule := range getIPTablesForwardRules() {
+		if slices.Contains(lines, "-A FORWARD "+strings.Join(rule, " ")) {
+			forward.RulesInstalled++
+		}
+	}
+	return forward
+}
+
+func iptablesForwardInSync(forward IPTablesForwardStatus) bool {
+	if forward.Required {
+		return forward.RulesInstalled == len(getIPTablesForwardRules())
+	}
+	return forward.RulesInstalled == 0
+}
+
+// getIPTablesForwardStatus returns the status of the iptables FORWARD chains. Binaries that are not installed are skipped.
+func getIPTablesForwardStatus(vpnConfig wireguard.VPNConfig, desired []firewallChain) ([]IPTablesForwardStatus, error) {
+	status := []IPTablesForwardStatus{}
+	for _, binary := range []string{"iptables", "ip6tables"} {
+		if !iptablesAvailable(binary) {
+			continue
+		}
+		out, err := runIPTables(binary, "-S", "FORWARD")
+		if err != nil {
+			return status, err
+		}
+		forward := parseIPTablesForward(binary, out)
+		forward.Required = forward.DropPolicy && len(desired) > 0 && (binary == "iptables" || vpnConfig.AddressRangeIPv6.IsValid())
+		status = append(status, forward)
+	}
+	return status, nil
+}
+
+// reconcileIPTablesForward inserts or removes the accept rules in the iptables FORWARD chains
+func reconcileIPTablesForward(status []IPTablesForwardStatus) error {
+	for _, forward := range status {
+		if iptablesForwardInSync(forward) {
+			continue
+		}
+		out, err := runIPTables(forward.Binary, "-S", "FORWARD")
+		if err != nil {
+			return err
+		}
+		lines := strings.Split(strings.TrimSpace(out), "\n")
+		for _, rule := range getIPTablesForwardRules() {
+			installed := slices.Contains(lines, "-A FORWARD "+strings.Join(rule, " "))
+			if forward.Required && !installed {
+				_, err = runIPTables(forward.Binary, append([]string{"-I", "FORWARD"}, rule...)...)
+			} else if !forward.Required && installed {
+				_, err = runIPTables(forward.Binary, append([]string{"-D", "FORWARD"}, rule...)...)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func getFirewallStatus(storage storage.Iface) (FirewallStatus, error) {
+	status := FirewallStatus{Table: FIREWALL_TABLE_FAMILY + " " + FIREWALL_TABLE_NAME}
+	vpnConfig, err := wireguard.GetVPNConfig(storage)
+	if err != nil {
+		return status, fmt.Errorf("failed to get vpn config: %s", err)
+	}
+	status.Desired, err = getFirewallChains(vpnConfig)
+	if err != nil {
+		return status, fmt.Errorf("could not get firewall rules: %s", err)
+	}
+	status.Current, status.Installed, err = getInstalledFirewall()
+	if err != nil {
+		return status, fmt.Errorf("could not get installed firewall rules: %s", err)
+	}
+	status.IPTablesForward, err = getIPTablesForwardStatus(vpnConfig, status.Desired)
+	if err != nil {
+		return status, fmt.Errorf("could not get iptables forward chain: %s", err)
+	}
+	status.InSync = firewallInSync(status.Desired, status.Current, status.Installed) && !slices.ContainsFunc(status.IPTablesForward, func(forward IPTablesForwardStatus) bool {
+		return !iptablesForwardInSync(forward)
+	})
+	return status, nil
+}
+
+// reconcileFirewall installs the firewall rules when the installed rules differ from the vpn config. Returns true when the rules were (re)applied.
+func reconcileFirewall(storage storage.Iface) (bool, error) {
+	status, err := getFirewallStatus(storage)
+	if err != nil {
+		return false, err
+	}
+	if status.InSync {
+		return false, nil
+	}
+	if !firewallInSync(status.Desired, status.Current, status.Installed) {
+		err = applyFirewall(getFirewallScript(status.Desired))
+		if err != nil {
+			return false, fmt.Errorf("could not apply firewall rules: %s", err)
+		}
+	}
+	err = reconcileIPTablesForward(status.IPTablesForward)
+	if err != nil {
+		return false, fmt.Errorf("could not apply iptables forward rules: %s", err)
+	}
+	return true, nil
+}
+
+func startFirewall(storage storage.Iface) {
+	go func() {
+		lastError := ""
+		for {
+			applied, err := reconcileFirewall(storage)
+			if err != nil {
+				if err.Error() != lastError { // don't repeat the same error every interval
+					log.Printf("firewall error: %s", err)
+				}
+				lastError = err.Error()
+			} else {
+				lastError = ""
+			}
+			if applied {
+				log.Printf("Firewall rules (table %s %s) applied", FIREWALL_TABLE_FAMILY, FIREWALL_TABLE_NAME)
+			}
+			time.Sleep(FIREWALL_RECONCILE_INTERVAL)
+		}
+	}()
+}
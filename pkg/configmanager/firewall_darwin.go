@@ -0,0 +1,21 @@
+//go:build darwin
+
+package configmanager
+
+import "fmt"
+
+func getInstalledFirewall() ([]firewallChain, bool, error) {
+	return nil, false, fmt.Errorf("firewall is not implemented in darwin")
+}
+
+func applyFirewall(script string) error {
+	return fmt.Errorf("firewall is not implemented in darwin")
+}
+
+func iptablesAvailable(binary string) bool {
+	return false
+}
+
+func runIPTables(binary string, args ...string) (string, error) {
+	return "", fmt.Errorf("iptables is not implemented in darwin")
+}
@@ -0,0 +1,49 @@
+//go:build linux
+
+package configmanager
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// getInstalledFirewall reads back the vpn-server table. Returns false when the table doesn't exist.
+func getInstalledFirewall() ([]firewallChain, bool, error) {
+	out, err := exec.Command("nft", "-j", "list", "table", FIREWALL_TABLE_FAMILY, FIREWALL_TABLE_NAME).CombinedOutput()
+	if err != nil {
+		if strings.Contains(string(out), "No such file or directory") {
+			return nil, false, nil
+		}
+		return nil, false, fmt.Errorf("nft list table error: %s (output: %s)", err, strings.TrimSpace(string(out)))
+	}
+	chains, err := parseFirewallTable(out)
+	if err != nil {
+		return nil, false, err
+	}
+	return chains, true, nil
+}
+
+// applyFirewall runs the nft script in a single transaction
+func applyFirewall(script string) error {
+	cmd := exec.Command("nft", "-f", "-")
+	cmd.Stdin = strings.NewReader(script)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("nft error: %s (output: %s)", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func iptablesAvailable(binary string) bool {
+	_, err := exec.LookPath(binary)
+	return err == nil
+}
+
+func runIPTables(binary string, args ...string) (string, error) {
+	out, err := exec.Command(binary, append([]string{"-w"}, args...)...).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("%s %s error: %s (output: %s)", binary, strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return string(out), nil
+}
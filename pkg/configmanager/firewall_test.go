@@ -0,0 +1,138 @@
+package configmanager
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/in4it/wireguard-server/pkg/wireguard"
+)
+
+func TestGetFirewallChains(t *testing.T) {
+	addressRange := netip.MustParsePrefix(wireguard.DEFAULT_VPN_PREFIX)
+	chains, err := getFirewallChains(wireguard.VPNConfig{DisableNAT: true, ExternalInterface: "eth0"})
+	if err != nil {
+		t.Fatalf("getFirewallChains error: %s", err)
+	}
+	if len(chains) != 0 {
+		t.Fatalf("expected no chains when nat is disabled, got: %+v", chains)
+	}
+
+	_, err = getFirewallChains(wireguard.VPNConfig{ExternalInterface: `eth0" accept`, AddressRange: addressRange})
+	if err == nil {
+		t.Fatalf("expected error for invalid external interface")
+	}
+	_, err = getFirewallChains(wireguard.VPNConfig{ExternalInterface: "eth0"})
+	if err == nil {
+		t.Fatalf("expected error without an address range")
+	}
+
+	chains, err = getFirewallChains(wireguard.VPNConfig{ExternalInterface: "eth0", AddressRange: addressRange})
+	if err != nil {
+		t.Fatalf("getFirewallChains error: %s", err)
+	}
+	script := getFirewallScript(chains)
+	if !strings.Contains(script, "\t\tip saddr 10.189.184.0/21 oifname \"eth0\" masquerade comment \"masquerade-ipv4 ") {
+		t.Fatalf("ipv4 masquerade rule not found: %s", script)
+	}
+	if strings.Contains(script, "ipv6") {
+		t.Fatalf("didn't expect ipv6 rules without an ipv6 address range: %s", script)
+	}
+
+	// without an external interface, only the traffic of the vpn clients is masqueraded
+	chainsIPv6, err := getFirewallChains(wireguard.VPNConfig{AddressRange: addressRange, AddressRangeIPv6: netip.MustParsePrefix("fd00::1/64")})
+	if err != nil {
+		t.Fatalf("getFirewallChains error: %s", err)
+	}
+	script = getFirewallScript(chainsIPv6)
+	if !strings.Contains(script, "\t\tip saddr 10.189.184.0/21 oifname != \"vpn\" masquerade comment \"masquerade-ipv4 ") {
+		t.Fatalf("ipv4 masquerade rule not found: %s", script)
+	}
+	if !strings.Contains(script, "\t\tip6 saddr fd00::/64 oifname != \"vpn\" masquerade comment \"masquerade-ipv6 ") {
+		t.Fatalf("ipv6 masquerade rule not found: %s", script)
+	}
+	if chains[1].Rules[0].Comment == chainsIPv6[1].Rules[0].Comment {
+		t.Fatalf("expected different comment for a different rule: %s", chains[1].Rules[0].Comment)
+	}
+}
+
+func TestGetFirewallScriptDelete(t *testing.T) {
+	script := getFirewallScript(nil)
+	if script != "table inet vpn-server\ndelete table inet vpn-server\n" {
+		t.Fatalf("unexpected script: %s", script)
+	}
+}
+
+func TestFirewallInSync(t *testing.T) {
+	addressRange := netip.MustParsePrefix(wireguard.DEFAULT_VPN_PREFIX)
+	desired, err := getFirewallChains(wireguard.VPNConfig{ExternalInterface: "eth0", AddressRange: addressRange})
+	if err != nil {
+		t.Fatalf("getFirewallChains error: %s", err)
+	}
+	installedJSON := `{"nftables": [{"metainfo": {"version": "1.0.6", "release_name": "Lester Gooch #5", "json_schema_version": 1}}, {"table": {"family": "inet", "name": "vpn-server", "handle": 12}}, {"chain": {"family": "inet", "table": "vpn-server", "name": "forward", "handle": 1, "type": "filter", "hook": "forward", "prio": 0, "policy": "accept"}}, {"chain": {"family": "inet", "table": "vpn-server", "name": "postrouting", "handle": 2, "type": "nat", "hook": "postrouting", "prio": 100, "policy": "accept"}}, {"rule": {"family": "inet", "table": "vpn-server", "chain": "forward", "handle": 3, "comment": "` + desired[0].Rules[0].Comment + `", "expr": [{"match": {"op": "==", "left": {"meta": {"key": "iifname"}}, "right": "vpn"}}, {"accept": null}]}}, {"rule": {"family": "inet", "table": "vpn-server", "chain": "forward", "handle": 4, "comment": "` + desired[0].Rules[1].Comment + `", "expr": [{"match": {"op": "==", "left": {"meta": {"key": "oifname"}}, "right": "vpn"}}, {"accept": null}]}}, {"rule": {"family": "inet", "table": "vpn-server", "chain": "postrouting", "handle": 5, "comment": "` + desired[1].Rules[0].Comment + `", "expr": [{"match": {"op": "==", "left": {"meta": {"key": "nfproto"}}, "right": "ipv4"}}, {"match": {"op": "==", "left": {"meta": {"key": "oifname"}}, "right": "eth0"}}, {"masquerade": null}]}}]}`
+
+	installed, err := parseFirewallTable([]byte(installedJSON))
+	if err != nil {
+		t.Fatalf("parseFirewallTable error: %s", err)
+	}
+	if !firewallInSync(desired, installed, true) {
+		t.Fatalf("expected firewall to be in sync: %+v", installed)
+	}
+	if firewallInSync(desired, installed, false) {
+		t.Fatalf("expected firewall to be out of sync when the table doesn't exist")
+	}
+	if !firewallInSync(nil, nil, false) {
+		t.Fatalf("expected firewall to be in sync when nat is disabled and the table doesn't exist")
+	}
+
+	// external interface changed
+	desired, err = getFirewallChains(wireguard.VPNConfig{ExternalInterface: "ens5", AddressRange: addressRange})
+	if err != nil {
+		t.Fatalf("getFirewallChains error: %s", err)
+	}
+	if firewallInSync(desired, installed, true) {
+		t.Fatalf("expected firewall to be out of sync after the external interface changed")
+	}
+
+	// rule removed
+	desired, err = getFirewallChains(wireguard.VPNConfig{ExternalInterface: "eth0", AddressRange: addressRange})
+	if err != nil {
+		t.Fatalf("getFirewallChains error: %s", err)
+	}
+	installed[0].Rules = installed[0].Rules[:1]
+	if firewallInSync(desired, installed, true) {
+		t.Fatalf("expected firewall to be out of sync after a rule was removed")
+	}
+}
+
+func TestParseIPTablesForward(t *testing.T) {
+	forward := parseIPTablesForward("iptables", "-P FORWARD DROP\n-A FORWARD -j DOCKER-USER\n-A FORWARD -j DOCKER-FORWARD\n")
+	if !forward.DropPolicy || forward.RulesInstalled != 0 {
+		t.Fatalf("unexpected forward status: %+v", forward)
+	}
+	forward.Required = true
+	if iptablesForwardInSync(forward) {
+		t.Fatalf("expected forward chain to be out of sync without the accept rules")
+	}
+
+	forward = parseIPTablesForward("iptables", "-P FORWARD DROP\n-A FORWARD -i vpn -m comment --comment vpn-server -j ACCEPT\n-A FORWARD -o vpn -m comment --comment vpn-server -j ACCEPT\n-A FORWARD -j ufw-before-forward\n")
+	forward.Required = true
+	if forward.RulesInstalled != 2 || !iptablesForwardInSync(forward) {
+		t.Fatalf("expected forward chain to be in sync: %+v", forward)
+	}
+	forward.Required = false // e.g. nat was disabled, so the rules need to be removed
+	if iptablesForwardInSync(forward) {
+		t.Fatalf("expected forward chain to be out of sync when the rules are not required")
+	}
+
+	for out, dropPolicy := range map[string]bool{
+		"-P FORWARD ACCEPT\n":                   false,
+		"-P FORWARD ACCEPT\n-A FORWARD -j DROP": true,
+		"-P FORWARD ACCEPT\n-A FORWARD -j REJECT --reject-with icmp-host-prohibited": true,
+		"-P FORWARD ACCEPT\n-A FORWARD -i eth1 -j DROP":                              false,
+	} {
+		if forward := parseIPTablesForward("iptables", out); forward.DropPolicy != dropPolicy {
+			t.Fatalf("unexpected drop policy for %q: %v", out, forward.DropPolicy)
+		}
+	}
+}
@@ -6,12 +6,18 @@ import (
 	"net/http"
 	"strings"
 
+	configmanagerclient "github.com/in4it/wireguard-server/pkg/configmanager/client"
 	"github.com/in4it/wireguard-server/pkg/wireguard"
 )
 
 func (c *ConfigManager) getPubKey(w http.ResponseWriter, r *http.Request) {
+	vpnConfig, err := wireguard.GetVPNConfig(c.Storage) // read on every request: the server key changes when it's rotated
+	if err != nil {
+		returnError(w, fmt.Errorf("failed to get vpn config: %s", err), http.StatusBadRequest)
+		return
+	}
 	var pubKeyExchange wireguard.PubKeyExchange
-	pubKeyExchange.PubKey = c.PublicKey
+	pubKeyExchange.PubKey = vpnConfig.PublicKey
 	out, err := json.Marshal(pubKeyExchange)
 	if err != nil {
 		returnError(w, fmt.Errorf("pub exchange marshal error: %s", err), http.StatusBadRequest)
@@ -34,12 +40,7 @@ func (c *ConfigManager) refreshClients(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		if payload.Action == wireguard.ACTION_CLEANUP {
-			err = cleanupClients(c.Storage)
-			if err != nil {
-				returnError(w, fmt.Errorf("cleanup clients error: %s", err), http.StatusBadRequest)
-				return
-			}
-			w.WriteHeader(http.StatusAccepted)
+			c.triggerReconcileJob(w, JOB_ACTION_REFRESH_CLIENTS)
 			return // no further actions needed
 		}
 
@@ -61,44 +62,68 @@ func (c *ConfigManager) refreshClients(w http.ResponseWriter, r *http.Request) {
 				returnError(w, fmt.Errorf("filename in wrong format"), http.StatusBadRequest)
 				return
 			}
-			switch payload.Action {
-			case wireguard.ACTION_ADD:
-				err = syncClient(c.Storage, filename, c.ClientCache)
-				if err != nil {
-					returnError(w, fmt.Errorf("syncClient error: %s", err), http.StatusBadRequest)
-					return
-				}
-			case wireguard.ACTION_DELETE:
-				err = deleteClient(c.Storage, filename, c.ClientCache)
-				if err != nil {
-					returnError(w, fmt.Errorf("deleteClient error: %s", err), http.StatusBadRequest)
-					return
-				}
+			err = updateClientCache(c.Storage, filename, c.ClientCache)
+			if err != nil {
+				returnError(w, fmt.Errorf("update client cache error: %s", err), http.StatusBadRequest)
+				return
 			}
 		}
-		w.WriteHeader(http.StatusAccepted)
+		c.triggerReconcileJob(w, JOB_ACTION_REFRESH_CLIENTS) // the peers are applied by the reconciler
 	default:
 		returnError(w, fmt.Errorf("method not supported"), http.StatusBadRequest)
 	}
 }
-func (c *ConfigManager) refreshServerConfig(w http.ResponseWriter, r *http.Request) {
+func (c *ConfigManager) peerStats(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
-	case http.MethodPost:
-		vpnConfig, err := wireguard.GetVPNConfig(c.Storage)
+	case http.MethodGet:
+		livePeerStats, err := getLivePeerStats(c.backend)
 		if err != nil {
-			returnError(w, fmt.Errorf("get vpn config error: %s", err), http.StatusBadRequest)
+			returnError(w, fmt.Errorf("get live peer stats error: %s", err), http.StatusBadRequest)
 			return
 		}
-		startPacketLogger := false
-		if vpnConfig.EnablePacketLogs && !c.VPNConfig.EnablePacketLogs {
-			startPacketLogger = true
+		out, err := json.Marshal(livePeerStats)
+		if err != nil {
+			returnError(w, fmt.Errorf("live peer stats marshal error: %s", err), http.StatusBadRequest)
+			return
 		}
-		c.VPNConfig.EnablePacketLogs = vpnConfig.EnablePacketLogs
-		c.VPNConfig.PacketLogsTypes = vpnConfig.PacketLogsTypes
-		if startPacketLogger {
-			go wireguard.RunPacketLogger(c.Storage, c.ClientCache, c.VPNConfig)
+		_, err = w.Write(out)
+		if err != nil {
+			returnError(w, fmt.Errorf("write error: %s", err), http.StatusBadRequest)
+			return
 		}
-		w.WriteHeader(http.StatusAccepted)
+	default:
+		returnError(w, fmt.Errorf("method not supported"), http.StatusBadRequest)
+	}
+}
+func (c *ConfigManager) refreshServerConfig(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		job, err := c.jobs.runAsync(JOB_ACTION_REFRESH_SERVER_CONFIG, func(progress progressFunc) error {
+			vpnConfig, err := wireguard.GetVPNConfig(c.Storage)
+			if err != nil {
+				return fmt.Errorf("get vpn config error: %s", err)
+			}
+			startPacketLogger := false
+			if vpnConfig.EnablePacketLogs && !c.VPNConfig.EnablePacketLogs {
+				startPacketLogger = true
+			}
+			c.VPNConfig.EnablePacketLogs = vpnConfig.EnablePacketLogs
+			c.VPNConfig.PacketLogsTypes = vpnConfig.PacketLogsTypes
+			if startPacketLogger {
+				go wireguard.RunPacketLogger(c.Storage, c.backend, c.ClientCache, c.VPNConfig)
+			}
+			progress(50, "applying firewall rules")
+			_, err = reconcileFirewall(c.Storage) // nat settings might have changed
+			if err != nil {
+				return fmt.Errorf("firewall error: %s", err)
+			}
+			return nil
+		})
+		if err != nil {
+			returnError(w, err, http.StatusBadRequest)
+			return
+		}
+		writeJobResponse(w, job)
 	default:
 		returnError(w, fmt.Errorf("method not supported"), http.StatusBadRequest)
 	}
@@ -123,14 +148,26 @@ func (c *ConfigManager) upgrade(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	case http.MethodPost:
-		_, err := w.Write([]byte(`{"upgrade": "starting"}`))
+		job, err := c.jobs.runAsync(JOB_ACTION_UPGRADE, func(progress progressFunc) error {
+			err := upgrade(progress)
+			if err != nil {
+				fmt.Printf("upgrade failed: %s\n", err)
+			}
+			return err
+		})
 		if err != nil {
-			returnError(w, fmt.Errorf("write error: %s", err), http.StatusBadRequest)
+			returnError(w, fmt.Errorf("upgrade error: %s", err), http.StatusBadRequest)
+			return
+		}
+		out, err := json.Marshal(configmanagerclient.UpgradeStartResponse{Upgrade: "starting", JobID: job.ID})
+		if err != nil {
+			returnError(w, fmt.Errorf("upgrade response marshal error: %s", err), http.StatusBadRequest)
 			return
 		}
-		err = upgrade()
+		_, err = w.Write(out)
 		if err != nil {
-			fmt.Printf("upgrade failed: %s\n", err)
+			returnError(w, fmt.Errorf("write error: %s", err), http.StatusBadRequest)
+			return
 		}
 	default:
 		returnError(w, fmt.Errorf("method not supported"), http.StatusBadRequest)
@@ -140,7 +177,7 @@ func (c *ConfigManager) upgrade(w http.ResponseWriter, r *http.Request) {
 func (c *ConfigManager) version(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodGet:
-		out, err := json.Marshal(map[string]string{"version": getVersion()})
+		out, err := json.Marshal(configmanagerclient.VersionResponse{Version: getVersion()})
 		if err != nil {
 			returnError(w, fmt.Errorf("version marshal error: %s", err), http.StatusBadRequest)
 			return
@@ -158,34 +195,212 @@ func (c *ConfigManager) version(w http.ResponseWriter, r *http.Request) {
 func (c *ConfigManager) restartVpn(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodPost:
-		err := stopVPN()
-		if err != nil { // don't exit, as the VPN might be down already.
-			fmt.Println("========= Warning =========")
-			fmt.Printf("Warning: vpn stop error: %s\n", err)
-			fmt.Println("=========================")
+		job, err := c.jobs.runAsync(JOB_ACTION_RESTART_VPN, func(progress progressFunc) error {
+			progress(0, "stopping vpn")
+			err := stopVPN(c.Storage, c.backend)
+			if err != nil { // don't exit, as the VPN might be down already.
+				fmt.Println("========= Warning =========")
+				fmt.Printf("Warning: vpn stop error: %s\n", err)
+				fmt.Println("=========================")
+			}
+			progress(25, "starting vpn")
+			err = startVPN(c.Storage, c.backend)
+			if err != nil {
+				return fmt.Errorf("vpn start error: %s", err)
+			}
+			progress(50, "refreshing clients")
+			err = c.reconciler.run(RECONCILE_TRIGGER_RESTART)
+			if err != nil {
+				return fmt.Errorf("could not refresh all clients: %s", err)
+			}
+			progress(75, "applying firewall rules")
+			_, err = reconcileFirewall(c.Storage)
+			if err != nil { // the vpn is up, the firewall reconcile loop will retry
+				return fmt.Errorf("vpn restarted, but the firewall rules could not be applied: %s", err)
+			}
+			return nil
+		})
+		if err != nil {
+			returnError(w, err, http.StatusBadRequest)
+			return
+		}
+		writeJobResponse(w, job)
+	default:
+		returnError(w, fmt.Errorf("method not supported"), http.StatusBadRequest)
+	}
+}
+
+func (c *ConfigManager) reconcile(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		out, err := json.Marshal(c.reconciler.getStatus())
+		if err != nil {
+			returnError(w, fmt.Errorf("reconcile status marshal error: %s", err), http.StatusBadRequest)
+			return
+		}
+		_, err = w.Write(out)
+		if err != nil {
+			returnError(w, fmt.Errorf("write error: %s", err), http.StatusBadRequest)
+			return
+		}
+	case http.MethodPost:
+		c.triggerReconcileJob(w, JOB_ACTION_RECONCILE)
+	default:
+		returnError(w, fmt.Errorf("method not supported"), http.StatusBadRequest)
+	}
+}
+
+func (c *ConfigManager) firewall(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		status, err := getFirewallStatus(c.Storage)
+		if err != nil {
+			returnError(w, fmt.Errorf("firewall status error: %s", err), http.StatusBadRequest)
+			return
+		}
+		out, err := json.Marshal(status)
+		if err != nil {
+			returnError(w, fmt.Errorf("firewall status marshal error: %s", err), http.StatusBadRequest)
+			return
+		}
+		_, err = w.Write(out)
+		if err != nil {
+			returnError(w, fmt.Errorf("write error: %s", err), http.StatusBadRequest)
+			return
+		}
+	case http.MethodPost:
+		job, err := c.jobs.runAsync(JOB_ACTION_FIREWALL, func(progress progressFunc) error {
+			applied, err := reconcileFirewall(c.Storage)
+			if err != nil {
+				return fmt.Errorf("firewall error: %s", err)
+			}
+			if applied {
+				progress(100, "firewall rules applied")
+			}
+			return nil
+		})
+		if err != nil {
+			returnError(w, err, http.StatusBadRequest)
+			return
 		}
-		err = startVPN(c.Storage)
+		writeJobResponse(w, job)
+	default:
+		returnError(w, fmt.Errorf("method not supported"), http.StatusBadRequest)
+	}
+}
+
+func (c *ConfigManager) rotateServerKey(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		var (
+			payload   wireguard.RotateServerKeyRequest
+			vpnConfig wireguard.VPNConfig
+		)
+		decoder := json.NewDecoder(r.Body)
+		err := decoder.Decode(&payload)
+		if err != nil {
+			returnError(w, fmt.Errorf("wrong payload (expected rotate server key request)"), http.StatusBadRequest)
+			return
+		}
+		job, err := c.jobs.run(JOB_ACTION_ROTATE_SERVER_KEY, func(progress progressFunc) error {
+			if payload.Emergency {
+				vpnConfig, err = wireguard.EmergencyRotateServerKey(c.Storage)
+				if err != nil {
+					return fmt.Errorf("emergency server key rotation error: %s", err)
+				}
+				err = applyServerKey(c.Storage, c.reconciler)
+				if err != nil {
+					return fmt.Errorf("could not apply new server key: %s", err)
+				}
+				return nil
+			}
+			vpnConfig, err = wireguard.RotateServerKey(c.Storage, payload.SwitchAt)
+			if err != nil {
+				return fmt.Errorf("server key rotation error: %s", err)
+			}
+			vpnConfig, err = activatePendingServerKey(c.Storage, c.reconciler)
+			if err != nil {
+				return fmt.Errorf("could not activate server key: %s", err)
+			}
+			return nil
+		})
 		if err != nil {
-			returnError(w, fmt.Errorf("vpn start error: %s", err), http.StatusBadRequest)
+			returnError(w, err, http.StatusBadRequest)
 			return
 		}
-		err = refreshAllClientsAndServer(c.Storage, c.ClientCache)
+		out, err := json.Marshal(wireguard.RotateServerKeyResponse{PublicKey: vpnConfig.PublicKey, PendingPublicKey: vpnConfig.PendingPublicKey, PendingKeySwitchAt: vpnConfig.PendingKeySwitchAt, JobID: job.ID})
 		if err != nil {
-			returnError(w, fmt.Errorf("could not refresh all clients: %s", err), http.StatusBadRequest)
+			returnError(w, fmt.Errorf("rotate server key response marshal error: %s", err), http.StatusBadRequest)
 			return
 		}
 		w.WriteHeader(http.StatusAccepted)
+		_, err = w.Write(out)
+		if err != nil {
+			returnError(w, fmt.Errorf("write error: %s", err), http.StatusBadRequest)
+			return
+		}
 	default:
 		returnError(w, fmt.Errorf("method not supported"), http.StatusBadRequest)
 	}
 }
 
+func (c *ConfigManager) getJob(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		job, ok := c.jobs.get(r.PathValue("id"))
+		if !ok {
+			returnError(w, fmt.Errorf("job not found"), http.StatusNotFound)
+			return
+		}
+		out, err := json.Marshal(job)
+		if err != nil {
+			returnError(w, fmt.Errorf("job marshal error: %s", err), http.StatusBadRequest)
+			return
+		}
+		_, err = w.Write(out)
+		if err != nil {
+			returnError(w, fmt.Errorf("write error: %s", err), http.StatusBadRequest)
+			return
+		}
+	default:
+		returnError(w, fmt.Errorf("method not supported"), http.StatusBadRequest)
+	}
+}
+
+// triggerReconcileJob schedules an early reconcile pass and returns the job that finishes with the pass
+func (c *ConfigManager) triggerReconcileJob(w http.ResponseWriter, action string) {
+	job, err := c.jobs.newJob(action)
+	if err != nil {
+		returnError(w, fmt.Errorf("new job error: %s", err), http.StatusBadRequest)
+		return
+	}
+	c.reconciler.trigger(job.ID)
+	writeJobResponse(w, job)
+}
+
+func writeJobResponse(w http.ResponseWriter, job wireguard.Job) {
+	out, err := json.Marshal(wireguard.JobResponse{JobID: job.ID})
+	if err != nil {
+		returnError(w, fmt.Errorf("job response marshal error: %s", err), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+	_, err = w.Write(out)
+	if err != nil {
+		fmt.Printf("write error: %s\n", err)
+	}
+}
+
 func returnError(w http.ResponseWriter, err error, statusCode int) {
 	fmt.Println("========= ERROR =========")
 	fmt.Printf("Error: %s\n", err)
 	fmt.Println("=========================")
+	out, marshalErr := json.Marshal(configmanagerclient.ErrorResponse{Error: err.Error()})
+	if marshalErr != nil {
+		out = []byte(`{"error": "unknown error"}`)
+	}
 	w.WriteHeader(statusCode)
-	_, err = w.Write([]byte(`{"error": "` + err.Error() + `"}`))
+	_, err = w.Write(out)
 	if err != nil {
 		fmt.Printf("write error: %s", err)
 		return
@@ -0,0 +1,41 @@
+package configmanager
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	memorystorage "github.com/in4it/go-devops-platform/storage/memory"
+	"github.com/in4it/wireguard-server/pkg/wireguard"
+)
+
+func TestGetPubKeyAfterRotation(t *testing.T) {
+	storage := &memorystorage.MockMemoryStorage{}
+	_, err := wireguard.CreateNewVPNConfig(storage)
+	if err != nil {
+		t.Fatalf("CreateNewVPNConfig error: %s", err)
+	}
+	vpnConfig, err := wireguard.RotateServerKey(storage, time.Now())
+	if err != nil {
+		t.Fatalf("RotateServerKey error: %s", err)
+	}
+	_, err = wireguard.ActivatePendingServerKey(storage, time.Now())
+	if err != nil {
+		t.Fatalf("ActivatePendingServerKey error: %s", err)
+	}
+
+	c := &ConfigManager{Storage: storage}
+	req := httptest.NewRequest(http.MethodGet, "/pubkey", nil)
+	w := httptest.NewRecorder()
+	c.getPubKey(w, req)
+	var pubKeyExchange wireguard.PubKeyExchange
+	err = json.NewDecoder(w.Body).Decode(&pubKeyExchange)
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+	if pubKeyExchange.PubKey != vpnConfig.PendingPublicKey {
+		t.Fatalf("expected the rotated server key, got: %s", pubKeyExchange.PubKey)
+	}
+}
@@ -0,0 +1,170 @@
+package configmanager
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/in4it/wireguard-server/pkg/wireguard"
+)
+
+const JOB_RETENTION = 1 * time.Hour
+const JOB_TIMEOUT = 1 * time.Hour // pending and running jobs fail after the timeout
+const MAX_JOBS = 1000
+
+// job actions
+const JOB_ACTION_REFRESH_CLIENTS = "refresh-clients"
+const JOB_ACTION_REFRESH_SERVER_CONFIG = "refresh-server-config"
+const JOB_ACTION_UPGRADE = "upgrade"
+const JOB_ACTION_RESTART_VPN = "restart-vpn"
+const JOB_ACTION_ROTATE_SERVER_KEY = "rotate-server-key"
+const JOB_ACTION_RECONCILE = "reconcile"
+const JOB_ACTION_FIREWALL = "firewall"
+
+type progressFunc func(progress int, message string)
+
+// jobStore keeps the status of the jobs in memory. Finished jobs are removed after JOB_RETENTION, or when there are more than MAX_JOBS jobs.
+type jobStore struct {
+	mutex sync.Mutex
+	jobs  map[string]*wireguard.Job
+	order []string
+}
+
+func newJobStore() *jobStore {
+	return &jobStore{
+		jobs: make(map[string]*wireguard.Job),
+	}
+}
+
+func (j *jobStore) newJob(action string) (wireguard.Job, error) {
+	buf := make([]byte, 16)
+	_, err := io.ReadFull(rand.Reader, buf)
+	if err != nil {
+		return wireguard.Job{}, fmt.Errorf("crypto/rand Reader error: %s", err)
+	}
+	job := &wireguard.Job{
+		ID:        hex.EncodeToString(buf),
+		Action:    action,
+		Status:    wireguard.JOB_STATUS_PENDING,
+		CreatedAt: time.Now(),
+	}
+
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	j.prune(time.Now())
+	j.jobs[job.ID] = job
+	j.order = append(j.order, job.ID)
+	return *job, nil
+}
+
+// prune fails jobs that are pending or running for longer than JOB_TIMEOUT, removes finished jobs after the
+// retention period, and removes the oldest finished jobs when there are too many
+func (j *jobStore) prune(now time.Time) {
+	for _, id := range j.order {
+		job := j.jobs[id]
+		if job.FinishedAt.IsZero() && now.Sub(job.CreatedAt) > JOB_TIMEOUT {
+			job.Status = wireguard.JOB_STATUS_FAILED
+			job.Error = fmt.Sprintf("job didn't finish within %s", JOB_TIMEOUT)
+			job.FinishedAt = now
+		}
+	}
+	j.order = slices.DeleteFunc(j.order, func(id string) bool {
+		if !j.jobs[id].FinishedAt.IsZero() && now.Sub(j.jobs[id].FinishedAt) > JOB_RETENTION {
+			delete(j.jobs, id)
+			return true
+		}
+		return false
+	})
+	// make room for a new job, starting with the oldest job
+	toRemove := len(j.jobs) - MAX_JOBS + 1
+	j.order = slices.DeleteFunc(j.order, func(id string) bool {
+		if toRemove > 0 && !j.jobs[id].FinishedAt.IsZero() {
+			delete(j.jobs, id)
+			toRemove--
+			return true
+		}
+		return false
+	})
+}
+
+func (j *jobStore) start(id string) {
+	j.update(id, func(job *wireguard.Job) {
+		job.Status = wireguard.JOB_STATUS_RUNNING
+		job.StartedAt = time.Now()
+	})
+}
+
+func (j *jobStore) progress(id string, progress int, message string) {
+	j.update(id, func(job *wireguard.Job) {
+		job.Progress = progress
+		job.Message = message
+	})
+}
+
+func (j *jobStore) finish(id string, err error) {
+	j.update(id, func(job *wireguard.Job) {
+		job.FinishedAt = time.Now()
+		if job.StartedAt.IsZero() {
+			job.StartedAt = job.FinishedAt
+		}
+		job.DurationMs = job.FinishedAt.Sub(job.StartedAt).Milliseconds()
+		if err != nil {
+			job.Status = wireguard.JOB_STATUS_FAILED
+			job.Error = err.Error()
+			return
+		}
+		job.Status = wireguard.JOB_STATUS_SUCCEEDED
+		job.Progress = 100
+	})
+}
+
+func (j *jobStore) update(id string, f func(job *wireguard.Job)) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	job, ok := j.jobs[id]
+	if !ok {
+		return
+	}
+	f(job)
+}
+
+func (j *jobStore) get(id string) (wireguard.Job, bool) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	job, ok := j.jobs[id]
+	if !ok {
+		return wireguard.Job{}, false
+	}
+	return *job, true
+}
+
+// run executes the action as a job and waits for it to finish
+func (j *jobStore) run(action string, f func(progress progressFunc) error) (wireguard.Job, error) {
+	job, err := j.newJob(action)
+	if err != nil {
+		return job, err
+	}
+	j.start(job.ID)
+	err = f(func(progress int, message string) { j.progress(job.ID, progress, message) })
+	j.finish(job.ID, err)
+	job, _ = j.get(job.ID)
+	return job, err
+}
+
+// runAsync executes the action as a job in the background
+func (j *jobStore) runAsync(action string, f func(progress progressFunc) error) (wireguard.Job, error) {
+	job, err := j.newJob(action)
+	if err != nil {
+		return job, err
+	}
+	go func() {
+		j.start(job.ID)
+		err := f(func(progress int, message string) { j.progress(job.ID, progress, message) })
+		j.finish(job.ID, err)
+	}()
+	return job, nil
+}
@@ -0,0 +1,104 @@
+package configmanager
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/in4it/wireguard-server/pkg/wireguard"
+)
+
+func TestJobStore(t *testing.T) {
+	jobs := newJobStore()
+	job, err := jobs.run(JOB_ACTION_RESTART_VPN, func(progress progressFunc) error {
+		progress(50, "halfway")
+		job, _ := jobs.get(jobs.order[0])
+		if job.Status != wireguard.JOB_STATUS_RUNNING || job.Progress != 50 || job.Message != "halfway" {
+			return fmt.Errorf("unexpected job while running: %+v", job)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("run error: %s", err)
+	}
+	if !wireguard.IsValidJobID(job.ID) {
+		t.Fatalf("invalid job id: %s", job.ID)
+	}
+	if job.Status != wireguard.JOB_STATUS_SUCCEEDED || job.Progress != 100 || job.FinishedAt.IsZero() {
+		t.Fatalf("unexpected job: %+v", job)
+	}
+
+	job, err = jobs.run(JOB_ACTION_FIREWALL, func(progress progressFunc) error {
+		return fmt.Errorf("nft not found")
+	})
+	if err == nil {
+		t.Fatalf("expected error")
+	}
+	if job.Status != wireguard.JOB_STATUS_FAILED || job.Error != "nft not found" {
+		t.Fatalf("unexpected job: %+v", job)
+	}
+
+	// background jobs return right away, a failure is recorded in the job
+	job, err = jobs.runAsync(JOB_ACTION_RESTART_VPN, func(progress progressFunc) error {
+		return fmt.Errorf("vpn start error")
+	})
+	if err != nil {
+		t.Fatalf("runAsync error: %s", err)
+	}
+	for range 100 {
+		job, _ = jobs.get(job.ID)
+		if !job.FinishedAt.IsZero() {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if job.Status != wireguard.JOB_STATUS_FAILED || job.Error != "vpn start error" {
+		t.Fatalf("unexpected job: %+v", job)
+	}
+
+	pending, err := jobs.newJob(JOB_ACTION_REFRESH_CLIENTS)
+	if err != nil {
+		t.Fatalf("newJob error: %s", err)
+	}
+	jobs.prune(time.Now().Add(JOB_RETENTION + time.Minute))
+	if len(jobs.jobs) != 1 || len(jobs.order) != 1 {
+		t.Fatalf("expected only the pending job after prune, got: %d jobs", len(jobs.jobs))
+	}
+	job, ok := jobs.get(pending.ID)
+	if !ok {
+		t.Fatalf("pending job was pruned")
+	}
+	if job.Status != wireguard.JOB_STATUS_FAILED || job.FinishedAt.IsZero() {
+		t.Fatalf("expected pending job to fail after the timeout: %+v", job)
+	}
+}
+
+func TestJobStoreMaxJobs(t *testing.T) {
+	jobs := newJobStore()
+	running, err := jobs.newJob(JOB_ACTION_UPGRADE)
+	if err != nil {
+		t.Fatalf("newJob error: %s", err)
+	}
+	jobs.start(running.ID)
+	finished := []string{}
+	for range MAX_JOBS {
+		job, err := jobs.run(JOB_ACTION_REFRESH_CLIENTS, func(progress progressFunc) error { return nil })
+		if err != nil {
+			t.Fatalf("run error: %s", err)
+		}
+		finished = append(finished, job.ID)
+	}
+	if len(jobs.jobs) != MAX_JOBS || len(jobs.order) != MAX_JOBS {
+		t.Fatalf("expected %d jobs, got: %d", MAX_JOBS, len(jobs.jobs))
+	}
+	if _, ok := jobs.get(running.ID); !ok {
+		t.Fatalf("running job was pruned")
+	}
+	// only the oldest finished job makes room for the last job
+	if _, ok := jobs.get(finished[0]); ok {
+		t.Fatalf("expected oldest finished job to be pruned")
+	}
+	if _, ok := jobs.get(finished[1]); !ok {
+		t.Fatalf("expected finished job to be kept")
+	}
+}
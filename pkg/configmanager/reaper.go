@@ -0,0 +1,42 @@
+package configmanager
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/in4it/go-devops-platform/storage"
+	"github.com/in4it/wireguard-server/pkg/wireguard"
+)
+
+const CLIENT_REAPER_INTERVAL = 1 * time.Minute
+
+// reapClients disables expired peers and enables peers that became valid. The peers are applied by an early reconcile pass.
+func reapClients(storage storage.Iface, reconciler *reconciler) error {
+	toAdd, toDelete, err := wireguard.ReapClientConfigs(storage, time.Now())
+	if err != nil {
+		return fmt.Errorf("reap client configs error: %s", err)
+	}
+	for _, filename := range toDelete {
+		log.Printf("Disabling connection outside of its validity window: %s", filename)
+	}
+	for _, filename := range toAdd {
+		log.Printf("Enabling connection that became valid: %s", filename)
+	}
+	if len(toAdd) > 0 || len(toDelete) > 0 {
+		reconciler.trigger()
+	}
+	return nil
+}
+
+func startClientReaper(storage storage.Iface, reconciler *reconciler) {
+	go func() {
+		for {
+			err := reapClients(storage, reconciler)
+			if err != nil {
+				log.Printf("client reaper error: %s", err)
+			}
+			time.Sleep(CLIENT_REAPER_INTERVAL)
+		}
+	}()
+}
@@ -0,0 +1,181 @@
+package configmanager
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/in4it/go-devops-platform/storage"
+	"github.com/in4it/wireguard-server/pkg/wireguard"
+	wireguardbackend "github.com/in4it/wireguard-server/pkg/wireguard/backend"
+)
+
+const RECONCILE_INTERVAL = 1 * time.Minute
+
+// reconcile triggers
+const RECONCILE_TRIGGER_STARTUP = "startup"
+const RECONCILE_TRIGGER_INTERVAL = "interval"
+const RECONCILE_TRIGGER_REFRESH = "refresh"
+const RECONCILE_TRIGGER_RESTART = "restart-vpn"
+const RECONCILE_TRIGGER_SERVER_KEY = "server-key"
+
+// reconciler periodically brings the vpn interface in line with the stored peer configs
+type reconciler struct {
+	storage     storage.Iface
+	backend     wireguardbackend.Backend
+	clientCache *wireguard.ClientCache
+	jobs        *jobStore
+	triggerChan chan struct{}
+	runMutex    sync.Mutex
+	statusMutex sync.Mutex
+	status      wireguard.ReconcileStatus
+	pendingJobs []string
+}
+
+func newReconciler(storage storage.Iface, backend wireguardbackend.Backend, clientCache *wireguard.ClientCache, jobs *jobStore) *reconciler {
+	return &reconciler{
+		storage:     storage,
+		backend:     backend,
+		clientCache: clientCache,
+		jobs:        jobs,
+		triggerChan: make(chan struct{}, 1),
+	}
+}
+
+// trigger schedules an early reconcile pass. Multiple triggers before the pass starts result in a single pass.
+// The jobs are finished when the pass is done.
+func (r *reconciler) trigger(jobIDs ...string) {
+	r.statusMutex.Lock()
+	r.pendingJobs = append(r.pendingJobs, jobIDs...)
+	r.statusMutex.Unlock()
+	select {
+	case r.triggerChan <- struct{}{}:
+	default: // a pass is already scheduled
+	}
+}
+
+// run executes a reconcile pass and records the result
+func (r *reconciler) run(trigger string) error {
+	r.runMutex.Lock()
+	defer r.runMutex.Unlock()
+
+	r.statusMutex.Lock()
+	jobIDs := r.pendingJobs
+	r.pendingJobs = nil
+	r.statusMutex.Unlock()
+	for _, jobID := range jobIDs {
+		r.jobs.start(jobID)
+	}
+
+	start := time.Now()
+	drift, err := r.reconcile()
+	for _, jobID := range jobIDs {
+		r.jobs.finish(jobID, err)
+	}
+
+	r.statusMutex.Lock()
+	defer r.statusMutex.Unlock()
+	r.status.LastRun = start
+	r.status.LastTrigger = trigger
+	r.status.LastDurationMs = time.Since(start).Milliseconds()
+	r.status.LastDrift = drift
+	r.status.TotalDrift = addReconcileDrift(r.status.TotalDrift, drift)
+	r.status.Runs++
+	if err != nil {
+		r.status.LastError = err.Error()
+		r.status.Errors++
+		r.status.ConsecutiveErrors++
+		return err
+	}
+	r.status.LastError = ""
+	r.status.LastSuccess = start
+	r.status.ConsecutiveErrors = 0
+	return nil
+}
+
+func (r *reconciler) getStatus() wireguard.ReconcileStatus {
+	r.statusMutex.Lock()
+	defer r.statusMutex.Unlock()
+	return r.status
+}
+
+func (r *reconciler) reconcile() (wireguard.ReconcileDrift, error) {
+	peerConfigs, err := getAllPeerConfigs(r.storage)
+	if err != nil {
+		return wireguard.ReconcileDrift{}, err
+	}
+	for _, peerConfig := range peerConfigs {
+		err = wireguard.UpdateClientCache(peerConfig, r.clientCache)
+		if err != nil {
+			return wireguard.ReconcileDrift{}, fmt.Errorf("update client cache error: %s", err)
+		}
+	}
+	drift, err := reconcilePeers(r.storage, r.backend, peerConfigs)
+	if err != nil {
+		return drift, err
+	}
+	// the peers are also written to the server config, so wg-quick can restore the interface without the configmanager
+	err = writeServerConfig(r.storage)
+	if err != nil {
+		return drift, fmt.Errorf("could not write server config: %s", err)
+	}
+	return drift, nil
+}
+
+func (r *reconciler) start() {
+	go func() {
+		lastError := ""
+		for {
+			trigger := RECONCILE_TRIGGER_INTERVAL
+			select {
+			case <-r.triggerChan:
+				trigger = RECONCILE_TRIGGER_REFRESH
+			case <-time.After(RECONCILE_INTERVAL):
+			}
+			err := r.run(trigger)
+			if err != nil {
+				if err.Error() != lastError { // don't repeat the same error every interval
+					log.Printf("reconcile error: %s", err)
+				}
+				lastError = err.Error()
+			} else {
+				lastError = ""
+			}
+		}
+	}()
+}
+
+func getAllPeerConfigs(storage storage.Iface) ([]wireguard.PeerConfig, error) {
+	if _, err := os.Stat(storage.ConfigPath(wireguard.VPN_CLIENTS_DIR)); errors.Is(err, os.ErrNotExist) {
+		return []wireguard.PeerConfig{}, nil // directory doesn't exist, so no configs to be read
+	}
+	return wireguard.GetAllPeerConfigs(storage)
+}
+
+// updateClientCache updates the client cache of a single peer, so the packet logger knows about the peer before the next reconcile pass
+func updateClientCache(storage storage.Iface, filename string, clientCache *wireguard.ClientCache) error {
+	peerConfig, err := wireguard.GetPeerConfigByFilename(storage, filename)
+	if err != nil {
+		return fmt.Errorf("getClientFile error: %s", err)
+	}
+	err = wireguard.UpdateClientCache(peerConfig, clientCache)
+	if err != nil {
+		return fmt.Errorf("update client cache error: %s", err)
+	}
+	return nil
+}
+
+func addReconcileDrift(a, b wireguard.ReconcileDrift) wireguard.ReconcileDrift {
+	return wireguard.ReconcileDrift{
+		PeersAdded:       a.PeersAdded + b.PeersAdded,
+		PeersUpdated:     a.PeersUpdated + b.PeersUpdated,
+		PeersRemoved:     a.PeersRemoved + b.PeersRemoved,
+		RoutesAdded:      a.RoutesAdded + b.RoutesAdded,
+		RoutesRemoved:    a.RoutesRemoved + b.RoutesRemoved,
+		ServerKeyUpdated: a.ServerKeyUpdated + b.ServerKeyUpdated,
+		PeerErrors:       a.PeerErrors + b.PeerErrors,
+	}
+}
@@ -0,0 +1,48 @@
+package configmanager
+
+import (
+	"testing"
+
+	memorystorage "github.com/in4it/go-devops-platform/storage/memory"
+	"github.com/in4it/wireguard-server/pkg/wireguard"
+	wireguardbackend "github.com/in4it/wireguard-server/pkg/wireguard/backend"
+)
+
+func TestReconcilerTrigger(t *testing.T) {
+	jobs := newJobStore()
+	r := newReconciler(&memorystorage.MockMemoryStorage{}, wireguardbackend.NewMemory(), &wireguard.ClientCache{}, jobs)
+	job1, err := jobs.newJob(JOB_ACTION_REFRESH_CLIENTS)
+	if err != nil {
+		t.Fatalf("newJob error: %s", err)
+	}
+	job2, err := jobs.newJob(JOB_ACTION_RECONCILE)
+	if err != nil {
+		t.Fatalf("newJob error: %s", err)
+	}
+	r.trigger(job1.ID)
+	r.trigger(job2.ID) // doesn't block when a pass is already scheduled
+	if len(r.triggerChan) != 1 {
+		t.Fatalf("expected a single scheduled pass, got: %d", len(r.triggerChan))
+	}
+
+	_ = r.run(RECONCILE_TRIGGER_REFRESH) // fails without a vpn interface
+	for _, id := range []string{job1.ID, job2.ID} {
+		job, ok := jobs.get(id)
+		if !ok {
+			t.Fatalf("job %s not found", id)
+		}
+		if job.Status != wireguard.JOB_STATUS_SUCCEEDED && job.Status != wireguard.JOB_STATUS_FAILED {
+			t.Fatalf("expected job to be finished, got status: %s", job.Status)
+		}
+	}
+	if r.getStatus().Runs != 1 {
+		t.Fatalf("expected 1 run, got: %d", r.getStatus().Runs)
+	}
+}
+
+func TestAddReconcileDrift(t *testing.T) {
+	total := addReconcileDrift(wireguard.ReconcileDrift{PeersAdded: 2, RoutesRemoved: 1}, wireguard.ReconcileDrift{PeersAdded: 1, PeersRemoved: 3, ServerKeyUpdated: 1})
+	if total != (wireguard.ReconcileDrift{PeersAdded: 3, PeersRemoved: 3, RoutesRemoved: 1, ServerKeyUpdated: 1}) {
+		t.Fatalf("unexpected total drift: %+v", total)
+	}
+}
@@ -0,0 +1,33 @@
+package configmanager
+
+import (
+	"fmt"
+
+	"github.com/in4it/go-devops-platform/storage"
+	"github.com/in4it/wireguard-server/pkg/wireguard"
+	wireguardbackend "github.com/in4it/wireguard-server/pkg/wireguard/backend"
+	"github.com/in4it/wireguard-server/pkg/wireguard/linux/stats"
+	syncclients "github.com/in4it/wireguard-server/pkg/wireguard/linux/syncclients"
+)
+
+func reconcilePeers(storage storage.Iface, backend wireguardbackend.Backend, peerConfigs []wireguard.PeerConfig) (wireguard.ReconcileDrift, error) {
+	return syncclients.Reconcile(storage, backend, peerConfigs)
+}
+
+func getLivePeerStats(backend wireguardbackend.Backend) ([]wireguard.LivePeerStat, error) {
+	peerStats, err := stats.GetStats(backend)
+	if err != nil {
+		return nil, fmt.Errorf("could not get WireGuard stats: %s", err)
+	}
+	livePeerStats := make([]wireguard.LivePeerStat, len(peerStats))
+	for k, peerStat := range peerStats {
+		livePeerStats[k] = wireguard.LivePeerStat{
+			PublicKey:         peerStat.PublicKey,
+			Endpoint:          peerStat.Endpoint,
+			LastHandshakeTime: peerStat.LastHandshakeTime,
+			ReceiveBytes:      peerStat.ReceiveBytes,
+			TransmitBytes:     peerStat.TransmitBytes,
+		}
+	}
+	return livePeerStats, nil
+}
@@ -7,10 +7,26 @@ func (c *ConfigManager) getRouter() *http.ServeMux {
 
 	mux.Handle("/pubkey", http.HandlerFunc(c.getPubKey))
 	mux.Handle("/refresh-clients", http.HandlerFunc(c.refreshClients))
+	mux.Handle("/peer-stats", http.HandlerFunc(c.peerStats))
 	mux.Handle("/refresh-server-config", http.HandlerFunc(c.refreshServerConfig))
 	mux.Handle("/upgrade", http.HandlerFunc(c.upgrade))
 	mux.Handle("/restart-vpn", http.HandlerFunc(c.restartVpn))
+	mux.Handle("/rotate-server-key", http.HandlerFunc(c.rotateServerKey))
+	mux.Handle("/reconcile", http.HandlerFunc(c.reconcile))
+	mux.Handle("/firewall", http.HandlerFunc(c.firewall))
+	mux.Handle("/jobs/{id}", http.HandlerFunc(c.getJob))
 	mux.Handle("/version", http.HandlerFunc(c.version))
 
 	return mux
 }
+
+// getLegacyRouter returns the routes that are served on localhost without authentication. Only the version checks
+// are served; starting an upgrade needs the authenticated socket.
+func (c *ConfigManager) getLegacyRouter() *http.ServeMux {
+	mux := http.NewServeMux()
+
+	mux.Handle("GET /upgrade", http.HandlerFunc(c.upgrade))
+	mux.Handle("GET /version", http.HandlerFunc(c.version))
+
+	return mux
+}
@@ -8,9 +8,11 @@ import (
 	"github.com/in4it/go-devops-platform/storage"
 	localstorage "github.com/in4it/go-devops-platform/storage/local"
 	"github.com/in4it/wireguard-server/pkg/wireguard"
+	wireguardbackend "github.com/in4it/wireguard-server/pkg/wireguard/backend"
 )
 
-func StartServer(port int) {
+// StartServer serves the configmanager api on a unix socket. When legacyPort is set, the upgrade api is also served on localhost at that port.
+func StartServer(legacyPort int) {
 	localStorage, err := localstorage.New()
 	if err != nil {
 		log.Fatalf("couldn't initialize storage: %s", err)
@@ -27,34 +29,62 @@ func StartServer(port int) {
 	}
 	if !isRunning {
 		log.Printf("VPN is not running. Starting...")
-		err = startVPN(localStorage)
-		if err != nil {
-			log.Fatalf("couldn't start vpn: %s", err)
-		}
+	}
+	err = startVPN(localStorage, c.backend) // also corrects the interface when it is already running
+	if err != nil {
+		log.Fatalf("couldn't start vpn: %s", err)
+	}
+	if !isRunning {
 		log.Printf("VPN Server started\n")
 	}
 
 	// refresh all clients
-	err = refreshAllClientsAndServer(localStorage, c.ClientCache)
+	err = wireguard.MigratePeerConfigs(localStorage)
+	if err != nil {
+		log.Fatalf("could not migrate peer configs: %s", err)
+	}
+	err = c.reconciler.run(RECONCILE_TRIGGER_STARTUP)
 	if err != nil {
 		log.Fatalf("could not refresh all clients: %s", err)
 	}
 
 	// start goroutines
-	startStats(localStorage)                                    // start gathering of wireguard stats
-	startPacketLogger(localStorage, c.ClientCache, c.VPNConfig) // start packet logger (optional)
+	startFirewall(localStorage)                                            // install the nat and forward rules, and re-apply them when they drift
+	startStats(localStorage, c.backend)                                    // start gathering of wireguard stats
+	startPacketLogger(localStorage, c.backend, c.ClientCache, c.VPNConfig) // start packet logger (optional)
+	startServerKeyRotation(localStorage, c.reconciler)                     // switch to a pending server key when scheduled
+	startClientReaper(localStorage, c.reconciler)                          // disable expired connections
+	c.reconciler.start()                                                   // keep the peers of the vpn interface in sync with the peer configs
+
+	if legacyPort > 0 {
+		go func() {
+			log.Printf("Starting localhost http server for the upgrade api at port %d\n", legacyPort)
+			log.Fatal(http.ListenAndServe(fmt.Sprintf("127.0.0.1:%d", legacyPort), c.getLegacyRouter()))
+		}()
+	}
 
-	log.Printf("Starting localhost http server at port %d\n", port)
-	log.Fatal(http.ListenAndServe(fmt.Sprintf("127.0.0.1:%d", port), c.getRouter()))
+	l, err := listenUnixSocket(wireguard.CONFIGMANAGER_SOCKET)
+	if err != nil {
+		log.Fatalf("couldn't listen on %s: %s", wireguard.CONFIGMANAGER_SOCKET, err)
+	}
+	server := &http.Server{
+		Handler:     c.authMiddleware(c.getRouter()),
+		ConnContext: peerCredentialsContext,
+	}
+	log.Printf("Starting http server at %s\n", wireguard.CONFIGMANAGER_SOCKET)
+	log.Fatal(server.Serve(l))
 }
 
 func initConfigManager(storage storage.Iface) (*ConfigManager, error) {
 	c := &ConfigManager{
 		Storage: storage,
+		backend: wireguardbackend.New(),
 		ClientCache: &wireguard.ClientCache{
 			Addresses: []wireguard.ClientCacheAddresses{},
 		},
 	}
+	c.jobs = newJobStore()
+	c.reconciler = newReconciler(storage, c.backend, c.ClientCache, c.jobs)
 
 	vpnConfig, err := wireguard.GetVPNConfig(storage)
 	if err != nil {
@@ -72,6 +102,17 @@ func initConfigManager(storage storage.Iface) (*ConfigManager, error) {
 		}
 	}
 
+	err = wireguard.EnsureClientKeysEncryptionKey(storage)
+	if err != nil {
+		return c, fmt.Errorf("failed to ensure client keys encryption key: %s", err)
+	}
+
+	c.secret, err = wireguard.GetConfigManagerSecret(storage)
+	if err != nil {
+		return c, fmt.Errorf("failed to get configmanager secret: %s", err)
+	}
+	c.allowedUIDs = getAllowedUIDs()
+
 	c.VPNConfig = &vpnConfig
 
 	return c, nil
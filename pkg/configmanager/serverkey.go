@@ -0,0 +1,48 @@
+package configmanager
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/in4it/go-devops-platform/storage"
+	"github.com/in4it/wireguard-server/pkg/wireguard"
+)
+
+const SERVER_KEY_ROTATION_CHECK_INTERVAL = 1 * time.Minute
+
+// activatePendingServerKey switches to the pending server key when its switch time has passed
+func activatePendingServerKey(storage storage.Iface, reconciler *reconciler) (wireguard.VPNConfig, error) {
+	activated, err := wireguard.ActivatePendingServerKey(storage, time.Now())
+	if err != nil {
+		return wireguard.VPNConfig{}, fmt.Errorf("activate pending server key error: %s", err)
+	}
+	if activated {
+		err = applyServerKey(storage, reconciler)
+		if err != nil {
+			return wireguard.VPNConfig{}, fmt.Errorf("could not apply new server key: %s", err)
+		}
+	}
+	return wireguard.GetVPNConfig(storage)
+}
+
+// applyServerKey rewrites the server config and resyncs the server key and all peers
+func applyServerKey(storage storage.Iface, reconciler *reconciler) error {
+	err := writeServerConfig(storage)
+	if err != nil {
+		return fmt.Errorf("could not write server config: %s", err)
+	}
+	return reconciler.run(RECONCILE_TRIGGER_SERVER_KEY)
+}
+
+func startServerKeyRotation(storage storage.Iface, reconciler *reconciler) {
+	go func() {
+		for {
+			_, err := activatePendingServerKey(storage, reconciler)
+			if err != nil {
+				log.Printf("server key rotation error: %s", err)
+			}
+			time.Sleep(SERVER_KEY_ROTATION_CHECK_INTERVAL)
+		}
+	}()
+}
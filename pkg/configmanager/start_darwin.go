@@ -3,28 +3,30 @@
 package configmanager
 
 import (
-	"fmt"
-
 	"github.com/in4it/go-devops-platform/storage"
 	"github.com/in4it/wireguard-server/pkg/wireguard"
+	wireguardbackend "github.com/in4it/wireguard-server/pkg/wireguard/backend"
 )
 
-func startVPN(storage storage.Iface) error {
-	fmt.Printf("Warning: startVPN is not implemented in darwin\n")
-	return nil
+// startVPN starts the simulated vpn interface of the memory backend
+func startVPN(storage storage.Iface, backend wireguardbackend.Backend) error {
+	return wireguard.StartVPN(storage, backend)
+}
+
+func writeServerConfig(storage storage.Iface) error {
+	return nil // no /etc/wireguard on darwin
 }
 
-func stopVPN() error {
-	fmt.Printf("Warning: startVPN is not implemented in darwin\n")
-	return nil
+func stopVPN(storage storage.Iface, backend wireguardbackend.Backend) error {
+	return wireguard.StopVPN(storage, backend)
 }
 
-func startStats(storage storage.Iface) {
-	fmt.Printf("Warning: startStats is not implemented in darwin\n")
+func startStats(storage storage.Iface, backend wireguardbackend.Backend) {
+	go wireguard.RunStats(storage, backend)
 }
 
-func startPacketLogger(storage storage.Iface, clientCache *wireguard.ClientCache, vpnConfig *wireguard.VPNConfig) {
-	go wireguard.RunPacketLogger(storage, clientCache, vpnConfig)
+func startPacketLogger(storage storage.Iface, backend wireguardbackend.Backend, clientCache *wireguard.ClientCache, vpnConfig *wireguard.VPNConfig) {
+	go wireguard.RunPacketLogger(storage, backend, clientCache, vpnConfig)
 	// run cleanup
 	go wireguard.PacketLoggerLogRotation(storage)
 }
@@ -3,33 +3,38 @@
 package configmanager
 
 import (
-	"log"
+	"fmt"
 
 	"github.com/in4it/go-devops-platform/storage"
 	"github.com/in4it/wireguard-server/pkg/wireguard"
+	wireguardbackend "github.com/in4it/wireguard-server/pkg/wireguard/backend"
 )
 
-func startVPN(storage storage.Iface) error {
+func startVPN(storage storage.Iface, backend wireguardbackend.Backend) error {
 	err := wireguard.WriteWireGuardServerConfig(storage)
 	if err != nil {
-		log.Fatalf("WriteWireGuardServerConfig error: %s", err)
+		return fmt.Errorf("WriteWireGuardServerConfig error: %s", err)
 	}
 
-	return wireguard.StartVPN()
+	return wireguard.StartVPN(storage, backend)
 }
 
-func stopVPN() error {
-	return wireguard.StopVPN()
+func writeServerConfig(storage storage.Iface) error {
+	return wireguard.WriteWireGuardServerConfig(storage)
 }
 
-func startStats(storage storage.Iface) {
+func stopVPN(storage storage.Iface, backend wireguardbackend.Backend) error {
+	return wireguard.StopVPN(storage, backend)
+}
+
+func startStats(storage storage.Iface, backend wireguardbackend.Backend) {
 	// run statistics go routine
-	go wireguard.RunStats(storage)
+	go wireguard.RunStats(storage, backend)
 }
 
-func startPacketLogger(storage storage.Iface, clientCache *wireguard.ClientCache, vpnConfig *wireguard.VPNConfig) {
+func startPacketLogger(storage storage.Iface, backend wireguardbackend.Backend, clientCache *wireguard.ClientCache, vpnConfig *wireguard.VPNConfig) {
 	// run statistics go routine
-	go wireguard.RunPacketLogger(storage, clientCache, vpnConfig)
+	go wireguard.RunPacketLogger(storage, backend, clientCache, vpnConfig)
 	// run cleanup
 	go wireguard.PacketLoggerLogRotation(storage)
 }
@@ -2,19 +2,21 @@ package configmanager
 
 import (
 	"github.com/in4it/go-devops-platform/storage"
+	configmanagerclient "github.com/in4it/wireguard-server/pkg/configmanager/client"
 	"github.com/in4it/wireguard-server/pkg/wireguard"
+	wireguardbackend "github.com/in4it/wireguard-server/pkg/wireguard/backend"
 )
 
 type ConfigManager struct {
 	PrivateKey  string
-	PublicKey   string
 	Storage     storage.Iface
 	ClientCache *wireguard.ClientCache
 	VPNConfig   *wireguard.VPNConfig
+	backend     wireguardbackend.Backend
+	reconciler  *reconciler
+	jobs        *jobStore
+	secret      []byte
+	allowedUIDs []int
 }
 
-type UpgradeResponse struct {
-	NewVersionAvailable bool   `json:"newVersionAvailable"`
-	NewVersion          string `json:"newVersion"`
-	CurrentVersion      string `json:"currentVersion"`
-}
+type UpgradeResponse = configmanagerclient.UpgradeResponse
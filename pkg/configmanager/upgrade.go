@@ -58,7 +58,8 @@ func getBinaries() map[string]string {
 	}
 }
 
-func upgrade() error {
+// upgrade downloads and installs the latest binaries. The configmanager restarts itself at the end, so the job status is lost after the restart.
+func upgrade(progress progressFunc) error {
 	pwd, err := os.Executable()
 	if err != nil {
 		return fmt.Errorf("upgrade: user current dir error: %s", err)
@@ -67,11 +68,13 @@ func upgrade() error {
 
 	binaries := getBinaries()
 
+	progress(10, "downloading binaries")
 	err = downloadFilesForUpgrade(pwdDir, binaries)
 	if err != nil {
 		return fmt.Errorf("upgrade error: %s", err)
 	}
 	// delete current file, move downloaded file, set permissions
+	progress(60, "installing binaries")
 	for filename, downloadedFile := range binaries {
 		err := os.Remove(filename)
 		if err != nil {
@@ -112,6 +115,7 @@ func upgrade() error {
 
 	// execute systemctl restart
 	if runtime.GOOS == "linux" {
+		progress(90, "restarting services")
 		for _, service := range []string{"vpn-configmanager", "vpn-rest-server"} {
 			cmd := exec.Command("systemctl", "restart", service)
 			err = cmd.Start()
@@ -0,0 +1,442 @@
+package vpn
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/in4it/go-devops-platform/rest"
+	"github.com/in4it/go-devops-platform/users"
+	configmanagerclient "github.com/in4it/wireguard-server/pkg/configmanager/client"
+	"github.com/in4it/wireguard-server/pkg/wireguard"
+)
+
+func (v *VPN) adminUserConnectionsHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := v.UserStore.GetUserByID(r.PathValue("userID"))
+	if err != nil {
+		v.returnError(w, fmt.Errorf("user not found: %s", err), http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		metadataFilter := newMetadataFilter(r)
+		peerConfigs, err := wireguard.GetAllPeerConfigs(v.Storage)
+		if err != nil {
+			v.returnError(w, fmt.Errorf("could not get peer configs: %s", err), http.StatusBadRequest)
+			return
+		}
+		connections := []Connection{}
+		for _, peerConfig := range peerConfigs {
+			if wireguard.HasClientUserID(peerConfig.ID, user.ID) && metadataFilter(peerConfig, user.Login) {
+				connections = append(connections, newConnection(peerConfig))
+			}
+		}
+		out, err := json.Marshal(connections)
+		if err != nil {
+			v.returnError(w, fmt.Errorf("could not marshal list connection response: %s", err), http.StatusBadRequest)
+			return
+		}
+		v.write(w, out)
+	case http.MethodPost: // admins can create connections when the policy only allows admins, but the limits still apply
+		if user.Suspended {
+			v.returnError(w, fmt.Errorf("user is suspended"), http.StatusBadRequest)
+			return
+		}
+		newClientConfigOptions, err := decodeNewConnectionRequest(r)
+		if err != nil {
+			v.returnError(w, err, http.StatusBadRequest)
+			return
+		}
+		newClientConfigOptions.CreatedByAdmin = true
+		peerConfig, err := wireguard.NewClientConfig(v.Storage, user.ID, newClientConfigOptions)
+		if err != nil {
+			v.returnError(w, fmt.Errorf("could not generate client vpn config: %s", err), newConnectionErrorStatus(err))
+			return
+		}
+		out, err := json.Marshal(newConnection(peerConfig))
+		if err != nil {
+			v.returnError(w, fmt.Errorf("could not marshal connection: %s", err), http.StatusBadRequest)
+			return
+		}
+		v.write(w, out)
+	default:
+		v.returnError(w, fmt.Errorf("method not supported"), http.StatusBadRequest)
+	}
+}
+
+func (v *VPN) adminConnectionHandler(w http.ResponseWriter, r *http.Request) {
+	if strings.Contains(r.PathValue("id"), ".") || strings.Contains(r.PathValue("id"), "/") {
+		v.returnError(w, fmt.Errorf("connection id contains invalid characters"), http.StatusBadRequest)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		peerConfig, err := wireguard.GetPeerConfigByFilename(v.Storage, fmt.Sprintf("%s.json", r.PathValue("id")))
+		if err != nil {
+			v.returnError(w, fmt.Errorf("could not get connection: %s", err), http.StatusNotFound)
+			return
+		}
+		out, err := json.Marshal(newConnection(peerConfig))
+		if err != nil {
+			v.returnError(w, fmt.Errorf("could not marshal connection: %s", err), http.StatusBadRequest)
+			return
+		}
+		v.write(w, out)
+	case http.MethodPatch:
+		v.setConnectionMetadata(w, r, r.Context().Value(rest.CustomValue("user")).(users.User))
+	case http.MethodDelete:
+		user := r.Context().Value(rest.CustomValue("user")).(users.User)
+		err := wireguard.RevokeClientConfig(v.Storage, r.PathValue("id"), user.Login)
+		if err != nil {
+			v.returnError(w, fmt.Errorf("RevokeClientConfig error: %s", err), http.StatusBadRequest)
+			return
+		}
+		v.write(w, []byte(`{"deleted": "`+r.PathValue("id")+`"}`))
+	default:
+		v.returnError(w, fmt.Errorf("method not supported"), http.StatusBadRequest)
+	}
+}
+
+func (v *VPN) adminConnectionDisableHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		v.returnError(w, fmt.Errorf("method not supported"), http.StatusBadRequest)
+		return
+	}
+	if strings.Contains(r.PathValue("id"), ".") || strings.Contains(r.PathValue("id"), "/") {
+		v.returnError(w, fmt.Errorf("connection id contains invalid characters"), http.StatusBadRequest)
+		return
+	}
+	user := r.Context().Value(rest.CustomValue("user")).(users.User)
+	peerConfig, err := wireguard.DisableClientConfig(v.Storage, r.PathValue("id"), user.Login)
+	if err != nil {
+		v.returnError(w, fmt.Errorf("DisableClientConfig error: %s", err), http.StatusBadRequest)
+		return
+	}
+	out, err := json.Marshal(newConnection(peerConfig))
+	if err != nil {
+		v.returnError(w, fmt.Errorf("could not marshal connection: %s", err), http.StatusBadRequest)
+		return
+	}
+	v.write(w, out)
+}
+
+func (v *VPN) adminConnectionEnableHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		v.returnError(w, fmt.Errorf("method not supported"), http.StatusBadRequest)
+		return
+	}
+	if strings.Contains(r.PathValue("id"), ".") || strings.Contains(r.PathValue("id"), "/") {
+		v.returnError(w, fmt.Errorf("connection id contains invalid characters"), http.StatusBadRequest)
+		return
+	}
+	user := r.Context().Value(rest.CustomValue("user")).(users.User)
+	peerConfig, err := wireguard.EnableClientConfig(v.Storage, r.PathValue("id"), user.Login)
+	if err != nil {
+		v.returnError(w, fmt.Errorf("EnableClientConfig error: %s", err), http.StatusBadRequest)
+		return
+	}
+	out, err := json.Marshal(newConnection(peerConfig))
+	if err != nil {
+		v.returnError(w, fmt.Errorf("could not marshal connection: %s", err), http.StatusBadRequest)
+		return
+	}
+	v.write(w, out)
+}
+
+func (v *VPN) adminConnectionValidityHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPut:
+		if strings.Contains(r.PathValue("id"), ".") || strings.Contains(r.PathValue("id"), "/") {
+			v.returnError(w, fmt.Errorf("connection id contains invalid characters"), http.StatusBadRequest)
+			return
+		}
+		var validityRequest ConnectionValidityRequest
+		err := json.NewDecoder(r.Body).Decode(&validityRequest)
+		if err != nil {
+			v.returnError(w, fmt.Errorf("validity request decode error: %s", err), http.StatusBadRequest)
+			return
+		}
+		notBefore, err := parseTimestamp(validityRequest.NotBefore)
+		if err != nil {
+			v.returnError(w, fmt.Errorf("notBefore is not a valid RFC3339 timestamp: %s", err), http.StatusBadRequest)
+			return
+		}
+		expiresAt, err := parseTimestamp(validityRequest.ExpiresAt)
+		if err != nil {
+			v.returnError(w, fmt.Errorf("expiresAt is not a valid RFC3339 timestamp: %s", err), http.StatusBadRequest)
+			return
+		}
+		user := r.Context().Value(rest.CustomValue("user")).(users.User)
+		peerConfig, err := wireguard.SetClientValidity(v.Storage, r.PathValue("id"), notBefore, expiresAt, user.Login)
+		if err != nil {
+			v.returnError(w, fmt.Errorf("SetClientValidity error: %s", err), http.StatusBadRequest)
+			return
+		}
+		out, err := json.Marshal(newConnection(peerConfig))
+		if err != nil {
+			v.returnError(w, fmt.Errorf("could not marshal connection: %s", err), http.StatusBadRequest)
+			return
+		}
+		v.write(w, out)
+	default:
+		v.returnError(w, fmt.Errorf("method not supported"), http.StatusBadRequest)
+	}
+}
+
+func (v *VPN) adminConnectionAuditHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		v.returnError(w, fmt.Errorf("method not supported"), http.StatusBadRequest)
+		return
+	}
+	entries, err := wireguard.GetConnectionAuditLog(v.Storage, r.PathValue("id"))
+	if err != nil {
+		v.returnError(w, fmt.Errorf("could not get audit log: %s", err), http.StatusBadRequest)
+		return
+	}
+	out, err := json.Marshal(entries)
+	if err != nil {
+		v.returnError(w, fmt.Errorf("could not marshal audit log: %s", err), http.StatusBadRequest)
+		return
+	}
+	v.write(w, out)
+}
+
+func (v *VPN) adminReconcileHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		v.returnError(w, fmt.Errorf("method not supported"), http.StatusBadRequest)
+		return
+	}
+	reconcileStatus, err := wireguard.GetReconcileStatus(r.Context(), v.Storage)
+	if err != nil {
+		v.returnError(w, fmt.Errorf("could not get reconcile status: %s", err), http.StatusBadRequest)
+		return
+	}
+	out, err := json.Marshal(reconcileStatus)
+	if err != nil {
+		v.returnError(w, fmt.Errorf("could not marshal reconcile status: %s", err), http.StatusBadRequest)
+		return
+	}
+	v.write(w, out)
+}
+
+func (v *VPN) jobHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		v.returnError(w, fmt.Errorf("method not supported"), http.StatusBadRequest)
+		return
+	}
+	if !wireguard.IsValidJobID(r.PathValue("id")) {
+		v.returnError(w, fmt.Errorf("job id in wrong format"), http.StatusBadRequest)
+		return
+	}
+	job, err := wireguard.GetJob(r.Context(), v.Storage, r.PathValue("id"))
+	if configmanagerclient.IsNotFound(err) {
+		v.returnError(w, fmt.Errorf("job not found"), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		v.returnError(w, fmt.Errorf("could not get job: %s", err), http.StatusBadRequest)
+		return
+	}
+	out, err := json.Marshal(job)
+	if err != nil {
+		v.returnError(w, fmt.Errorf("could not marshal job: %s", err), http.StatusBadRequest)
+		return
+	}
+	v.write(w, out)
+}
+
+func (v *VPN) adminConnectionsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		v.returnError(w, fmt.Errorf("method not supported"), http.StatusBadRequest)
+		return
+	}
+	page, err := getQueryInt(r, "page", 1)
+	if err != nil || page < 1 {
+		v.returnError(w, fmt.Errorf("page is not a valid number"), http.StatusBadRequest)
+		return
+	}
+	limit, err := getQueryInt(r, "limit", DEFAULT_INVENTORY_LIMIT)
+	if err != nil || limit < 1 || limit > MAX_INVENTORY_LIMIT {
+		v.returnError(w, fmt.Errorf("limit needs to be a number between 1 and %d", MAX_INVENTORY_LIMIT), http.StatusBadRequest)
+		return
+	}
+	status := r.URL.Query().Get("status")
+	if status != "" && status != CONNECTION_STATUS_ACTIVE && status != CONNECTION_STATUS_INACTIVE && status != CONNECTION_STATUS_DISABLED {
+		v.returnError(w, fmt.Errorf("status needs to be one of: %s, %s, %s", CONNECTION_STATUS_ACTIVE, CONNECTION_STATUS_INACTIVE, CONNECTION_STATUS_DISABLED), http.StatusBadRequest)
+		return
+	}
+	addressFilter, err := newAddressFilter(r.URL.Query().Get("address"))
+	if err != nil {
+		v.returnError(w, fmt.Errorf("address filter error: %s", err), http.StatusBadRequest)
+		return
+	}
+	userFilter := r.URL.Query().Get("user")
+	metadataFilter := newMetadataFilter(r)
+
+	peerConfigs, err := wireguard.GetAllPeerConfigs(v.Storage)
+	if err != nil {
+		v.returnError(w, fmt.Errorf("could not get peer configs: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	response := ConnectionInventoryResponse{
+		Connections:       []ConnectionInventoryItem{},
+		Page:              page,
+		Limit:             limit,
+		LiveDataAvailable: true,
+	}
+	livePeerStats, err := wireguard.GetLivePeerStats(r.Context(), v.Storage)
+	if err != nil { // still return the inventory without live data
+		fmt.Printf("Warning: could not get live peer stats: %s\n", err)
+		response.LiveDataAvailable = false
+	}
+	livePeerStatsByPublicKey := make(map[string]wireguard.LivePeerStat, len(livePeerStats))
+	for _, livePeerStat := range livePeerStats {
+		livePeerStatsByPublicKey[livePeerStat.PublicKey] = livePeerStat
+	}
+	logins := make(map[string]string)
+	for _, user := range v.UserStore.ListUsers() {
+		logins[user.ID] = user.Login
+	}
+
+	for _, peerConfig := range peerConfigs {
+		userID, err := wireguard.GetUserIDFromConnectionID(peerConfig.ID)
+		if err != nil {
+			continue
+		}
+		item := ConnectionInventoryItem{
+			Connection:  newConnection(peerConfig),
+			UserID:      userID,
+			Login:       logins[userID],
+			Address:     peerConfig.Address,
+			AddressIPv6: peerConfig.AddressIPv6,
+			Profile:     peerConfig.Profile,
+			Type:        peerConfig.Type,
+			Networks:    peerConfig.Networks,
+			PublicKey:   peerConfig.PublicKey,
+			Status:      CONNECTION_STATUS_INACTIVE,
+		}
+		if livePeerStat, ok := livePeerStatsByPublicKey[peerConfig.PublicKey]; ok && peerConfig.PublicKey != "" {
+			item.Endpoint = livePeerStat.Endpoint
+			item.ReceiveBytes = livePeerStat.ReceiveBytes
+			item.TransmitBytes = livePeerStat.TransmitBytes
+			if !livePeerStat.LastHandshakeTime.IsZero() {
+				item.LastHandshake = livePeerStat.LastHandshakeTime.UTC().Format(time.RFC3339)
+				if time.Since(livePeerStat.LastHandshakeTime) < ACTIVE_HANDSHAKE_THRESHOLD {
+					item.Status = CONNECTION_STATUS_ACTIVE
+				}
+			}
+		}
+		if peerConfig.Disabled {
+			item.Status = CONNECTION_STATUS_DISABLED
+		}
+
+		if userFilter != "" && userFilter != item.UserID && userFilter != item.Login {
+			continue
+		}
+		if status != "" && status != item.Status {
+			continue
+		}
+		if !addressFilter(peerConfig.Address) && (peerConfig.AddressIPv6 == "" || !addressFilter(peerConfig.AddressIPv6)) {
+			continue
+		}
+		if !metadataFilter(peerConfig, item.Login) {
+			continue
+		}
+		response.Connections = append(response.Connections, item)
+	}
+
+	order := r.URL.Query().Get("order")
+	if order != "" && order != "asc" && order != "desc" {
+		v.returnError(w, fmt.Errorf("order needs to be one of: asc, desc"), http.StatusBadRequest)
+		return
+	}
+	switch r.URL.Query().Get("sort") {
+	case "", "id":
+		slices.SortFunc(response.Connections, func(a, b ConnectionInventoryItem) int {
+			return strings.Compare(a.ID, b.ID)
+		})
+		if order == "desc" {
+			slices.Reverse(response.Connections)
+		}
+	case "lastSeen": // RFC3339 timestamps in UTC sort lexicographically. Most recently seen first, unless asc is requested
+		slices.SortStableFunc(response.Connections, func(a, b ConnectionInventoryItem) int {
+			return strings.Compare(b.LastHandshake, a.LastHandshake)
+		})
+		if order == "asc" {
+			slices.Reverse(response.Connections)
+		}
+	default:
+		v.returnError(w, fmt.Errorf("sort needs to be one of: id, lastSeen"), http.StatusBadRequest)
+		return
+	}
+
+	response.Total = len(response.Connections)
+	start := min((page-1)*limit, response.Total)
+	end := min(start+limit, response.Total)
+	response.Connections = response.Connections[start:end]
+
+	out, err := json.Marshal(response)
+	if err != nil {
+		v.returnError(w, fmt.Errorf("could not marshal connection inventory: %s", err), http.StatusBadRequest)
+		return
+	}
+	v.write(w, out)
+}
+
+// newAddressFilter returns a filter that matches an address against an ip, a network in CIDR notation or an address prefix
+func newAddressFilter(filter string) (func(address string) bool, error) {
+	if filter == "" {
+		return func(address string) bool { return true }, nil
+	}
+	if strings.Contains(filter, "/") {
+		prefix, err := netip.ParsePrefix(filter)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network: %s", err)
+		}
+		return func(address string) bool {
+			addressPrefix, err := netip.ParsePrefix(address)
+			if err != nil {
+				return false
+			}
+			return prefix.Contains(addressPrefix.Addr())
+		}, nil
+	}
+	return func(address string) bool {
+		return strings.HasPrefix(address, filter)
+	}, nil
+}
+
+// newMetadataFilter returns a filter on the platform, label and search query parameters. The search matches case-insensitive
+// on the id, name, description, labels and login of a connection.
+func newMetadataFilter(r *http.Request) func(peerConfig wireguard.PeerConfig, login string) bool {
+	platform := r.URL.Query().Get("platform")
+	label := r.URL.Query().Get("label")
+	search := strings.ToLower(r.URL.Query().Get("search"))
+	return func(peerConfig wireguard.PeerConfig, login string) bool {
+		if platform != "" && platform != peerConfig.Platform {
+			return false
+		}
+		if label != "" && !slices.ContainsFunc(peerConfig.Labels, func(l string) bool { return strings.EqualFold(l, label) }) {
+			return false
+		}
+		if search == "" {
+			return true
+		}
+		fields := append([]string{peerConfig.ID, peerConfig.Name, peerConfig.Description, login}, peerConfig.Labels...)
+		return slices.ContainsFunc(fields, func(field string) bool { return strings.Contains(strings.ToLower(field), search) })
+	}
+}
+
+func getQueryInt(r *http.Request, key string, defaultValue int) (int, error) {
+	value := r.URL.Query().Get(key)
+	if value == "" {
+		return defaultValue, nil
+	}
+	return strconv.Atoi(value)
+}
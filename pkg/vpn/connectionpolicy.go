@@ -0,0 +1,64 @@
+package vpn
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+
+	"github.com/in4it/wireguard-server/pkg/wireguard"
+)
+
+func (v *VPN) adminConnectionPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		connectionPolicy, err := wireguard.GetConnectionPolicy(v.Storage)
+		if err != nil {
+			v.returnError(w, fmt.Errorf("GetConnectionPolicy error: %s", err), http.StatusBadRequest)
+			return
+		}
+		out, err := json.Marshal(connectionPolicy)
+		if err != nil {
+			v.returnError(w, fmt.Errorf("could not marshal connection policy: %s", err), http.StatusBadRequest)
+			return
+		}
+		v.write(w, out)
+	case http.MethodPut:
+		var connectionPolicy wireguard.ConnectionPolicy
+		err := json.NewDecoder(r.Body).Decode(&connectionPolicy)
+		if err != nil {
+			v.returnError(w, fmt.Errorf("connection policy decode error: %s", err), http.StatusBadRequest)
+			return
+		}
+		err = v.validateUserIDs(slices.Collect(maps.Keys(connectionPolicy.UserLimits)))
+		if err != nil {
+			v.returnError(w, err, http.StatusBadRequest)
+			return
+		}
+		profilesConfig, err := wireguard.GetProfiles(v.Storage)
+		if err != nil {
+			v.returnError(w, fmt.Errorf("GetProfiles error: %s", err), http.StatusBadRequest)
+			return
+		}
+		for group := range connectionPolicy.GroupLimits {
+			if _, ok := profilesConfig.Groups[group]; !ok {
+				v.returnError(w, fmt.Errorf("group %s not found", group), http.StatusBadRequest)
+				return
+			}
+		}
+		connectionPolicy, err = wireguard.SetConnectionPolicy(v.Storage, connectionPolicy)
+		if err != nil {
+			v.returnError(w, fmt.Errorf("SetConnectionPolicy error: %s", err), http.StatusBadRequest)
+			return
+		}
+		out, err := json.Marshal(connectionPolicy)
+		if err != nil {
+			v.returnError(w, fmt.Errorf("could not marshal connection policy: %s", err), http.StatusBadRequest)
+			return
+		}
+		v.write(w, out)
+	default:
+		v.returnError(w, fmt.Errorf("method not supported"), http.StatusBadRequest)
+	}
+}
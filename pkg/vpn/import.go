@@ -0,0 +1,37 @@
+package vpn
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/in4it/go-devops-platform/rest"
+	"github.com/in4it/go-devops-platform/users"
+	"github.com/in4it/wireguard-server/pkg/wireguard"
+)
+
+// adminImportHandler imports the peers of an existing wg-quick server config. With dryRun, only the report is returned.
+func (v *VPN) adminImportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		v.returnError(w, fmt.Errorf("method not supported"), http.StatusBadRequest)
+		return
+	}
+	var importRequest wireguard.ImportRequest
+	err := json.NewDecoder(r.Body).Decode(&importRequest)
+	if err != nil {
+		v.returnError(w, fmt.Errorf("import request decode error: %s", err), http.StatusBadRequest)
+		return
+	}
+	user := r.Context().Value(rest.CustomValue("user")).(users.User)
+	report, err := wireguard.ImportWGQuickConfig(v.Storage, importRequest, v.UserStore, user.Login)
+	if err != nil {
+		v.returnError(w, fmt.Errorf("ImportWGQuickConfig error: %s", err), http.StatusBadRequest)
+		return
+	}
+	out, err := json.Marshal(report)
+	if err != nil {
+		v.returnError(w, fmt.Errorf("could not marshal import report: %s", err), http.StatusBadRequest)
+		return
+	}
+	v.write(w, out)
+}
@@ -0,0 +1,96 @@
+package vpn
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/in4it/wireguard-server/pkg/wireguard"
+)
+
+func (v *VPN) adminIPAMHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		ipam, err := wireguard.GetIPAM(v.Storage)
+		if err != nil {
+			v.returnError(w, fmt.Errorf("GetIPAM error: %s", err), http.StatusBadRequest)
+			return
+		}
+		conflicts, err := wireguard.GetIPConflicts(v.Storage)
+		if err != nil {
+			v.returnError(w, fmt.Errorf("GetIPConflicts error: %s", err), http.StatusBadRequest)
+			return
+		}
+		ipamResponse := IPAMResponse{
+			AddressRange:  ipam.IPv4.AddressRange.String(),
+			AddressPrefix: ipam.IPv4.AddressPrefix,
+			Allocations:   ipam.IPv4.Allocations,
+			Reservations:  ipam.Reservations,
+			Conflicts:     conflicts,
+		}
+		if ipam.IPv6 != nil {
+			ipamResponse.AddressRangeIPv6 = ipam.IPv6.AddressRange.String()
+			ipamResponse.AddressPrefixIPv6 = ipam.IPv6.AddressPrefix
+			ipamResponse.AllocationsIPv6 = ipam.IPv6.Allocations
+		}
+		out, err := json.Marshal(ipamResponse)
+		if err != nil {
+			v.returnError(w, fmt.Errorf("could not marshal ipam response: %s", err), http.StatusBadRequest)
+			return
+		}
+		v.write(w, out)
+	default:
+		v.returnError(w, fmt.Errorf("method not supported"), http.StatusBadRequest)
+	}
+}
+
+func (v *VPN) adminIPReservationsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		ipam, err := wireguard.GetIPAM(v.Storage)
+		if err != nil {
+			v.returnError(w, fmt.Errorf("GetIPAM error: %s", err), http.StatusBadRequest)
+			return
+		}
+		out, err := json.Marshal(ipam.Reservations)
+		if err != nil {
+			v.returnError(w, fmt.Errorf("could not marshal reservations: %s", err), http.StatusBadRequest)
+			return
+		}
+		v.write(w, out)
+	case http.MethodPost:
+		var reservation wireguard.IPReservation
+		err := json.NewDecoder(r.Body).Decode(&reservation)
+		if err != nil {
+			v.returnError(w, fmt.Errorf("reservation decode error: %s", err), http.StatusBadRequest)
+			return
+		}
+		reservation, err = wireguard.AddIPReservation(v.Storage, reservation)
+		if err != nil {
+			v.returnError(w, fmt.Errorf("AddIPReservation error: %s", err), http.StatusBadRequest)
+			return
+		}
+		out, err := json.Marshal(reservation)
+		if err != nil {
+			v.returnError(w, fmt.Errorf("could not marshal reservation: %s", err), http.StatusBadRequest)
+			return
+		}
+		v.write(w, out)
+	default:
+		v.returnError(w, fmt.Errorf("method not supported"), http.StatusBadRequest)
+	}
+}
+
+func (v *VPN) adminIPReservationHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodDelete:
+		err := wireguard.DeleteIPReservation(v.Storage, r.PathValue("address"))
+		if err != nil {
+			v.returnError(w, fmt.Errorf("DeleteIPReservation error: %s", err), http.StatusBadRequest)
+			return
+		}
+		v.write(w, []byte(`{"deleted": "`+r.PathValue("address")+`"}`))
+	default:
+		v.returnError(w, fmt.Errorf("method not supported"), http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,146 @@
+package vpn
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/in4it/wireguard-server/pkg/wireguard"
+)
+
+func (v *VPN) adminNetworkPeersHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		peerConfigs, err := wireguard.GetNetworkPeerConfigs(v.Storage)
+		if err != nil {
+			v.returnError(w, fmt.Errorf("GetNetworkPeerConfigs error: %s", err), http.StatusBadRequest)
+			return
+		}
+		networkPeers := make([]NetworkPeer, len(peerConfigs))
+		for k, peerConfig := range peerConfigs {
+			networkPeers[k] = newNetworkPeer(peerConfig)
+		}
+		out, err := json.Marshal(networkPeers)
+		if err != nil {
+			v.returnError(w, fmt.Errorf("could not marshal network peers: %s", err), http.StatusBadRequest)
+			return
+		}
+		v.write(w, out)
+	case http.MethodPost:
+		var networkPeerOptions wireguard.NetworkPeerOptions
+		err := json.NewDecoder(r.Body).Decode(&networkPeerOptions)
+		if err != nil {
+			v.returnError(w, fmt.Errorf("network peer decode error: %s", err), http.StatusBadRequest)
+			return
+		}
+		peerConfig, err := wireguard.NewNetworkPeerConfig(v.Storage, networkPeerOptions)
+		if err != nil {
+			v.returnError(w, fmt.Errorf("NewNetworkPeerConfig error: %s", err), http.StatusBadRequest)
+			return
+		}
+		if peerConfig.RouteToClients { // the other clients need a route to the new networks
+			err = wireguard.UpdateClientsConfig(v.Storage)
+			if err != nil {
+				v.returnError(w, fmt.Errorf("could not update client vpn configs: %s", err), http.StatusBadRequest)
+				return
+			}
+		}
+		out, err := json.Marshal(newNetworkPeer(peerConfig))
+		if err != nil {
+			v.returnError(w, fmt.Errorf("could not marshal network peer: %s", err), http.StatusBadRequest)
+			return
+		}
+		v.write(w, out)
+	default:
+		v.returnError(w, fmt.Errorf("method not supported"), http.StatusBadRequest)
+	}
+}
+
+func (v *VPN) adminNetworkPeerHandler(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.PathValue("id"), wireguard.NETWORK_PEER_USER_ID+"-") {
+		v.returnError(w, fmt.Errorf("connection id is not a network peer"), http.StatusBadRequest)
+		return
+	}
+	if strings.Contains(r.PathValue("id"), ".") || strings.Contains(r.PathValue("id"), "/") {
+		v.returnError(w, fmt.Errorf("connection id contains invalid characters"), http.StatusBadRequest)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = wireguard.CLIENT_CONFIG_FORMAT_CONF
+		}
+		contentType, extension, err := wireguard.GetClientConfigFormat(format)
+		if err != nil {
+			v.returnError(w, err, http.StatusBadRequest)
+			return
+		}
+		out, err := wireguard.GenerateNewClientConfigWithOptions(v.Storage, r.PathValue("id"), wireguard.NETWORK_PEER_USER_ID, wireguard.ClientConfigOptions{Format: format, WaitForSync: r.URL.Query().Get("wait") == "true"})
+		if err != nil {
+			v.returnError(w, fmt.Errorf("GetClientConfig error: %s", err), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", contentType)
+		if r.URL.Query().Has("format") {
+			w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, r.PathValue("id"), extension))
+		}
+		v.write(w, out)
+	case http.MethodPut:
+		var networkPeerOptions wireguard.NetworkPeerOptions
+		err := json.NewDecoder(r.Body).Decode(&networkPeerOptions)
+		if err != nil {
+			v.returnError(w, fmt.Errorf("network peer decode error: %s", err), http.StatusBadRequest)
+			return
+		}
+		peerConfig, err := wireguard.UpdateNetworkPeerConfig(v.Storage, r.PathValue("id"), networkPeerOptions)
+		if err != nil {
+			v.returnError(w, fmt.Errorf("UpdateNetworkPeerConfig error: %s", err), http.StatusBadRequest)
+			return
+		}
+		err = wireguard.UpdateClientsConfig(v.Storage)
+		if err != nil {
+			v.returnError(w, fmt.Errorf("could not update client vpn configs: %s", err), http.StatusBadRequest)
+			return
+		}
+		out, err := json.Marshal(newNetworkPeer(peerConfig))
+		if err != nil {
+			v.returnError(w, fmt.Errorf("could not marshal network peer: %s", err), http.StatusBadRequest)
+			return
+		}
+		v.write(w, out)
+	case http.MethodDelete:
+		peerConfig, err := wireguard.GetNetworkPeerConfig(v.Storage, r.PathValue("id"))
+		if err != nil {
+			v.returnError(w, fmt.Errorf("GetNetworkPeerConfig error: %s", err), http.StatusNotFound)
+			return
+		}
+		err = wireguard.DeleteClientConfig(v.Storage, r.PathValue("id"), wireguard.NETWORK_PEER_USER_ID)
+		if err != nil {
+			v.returnError(w, fmt.Errorf("DeleteClientConfig error: %s", err), http.StatusBadRequest)
+			return
+		}
+		if peerConfig.RouteToClients { // remove the networks from the other clients
+			err = wireguard.UpdateClientsConfig(v.Storage)
+			if err != nil {
+				v.returnError(w, fmt.Errorf("could not update client vpn configs: %s", err), http.StatusBadRequest)
+				return
+			}
+		}
+		v.write(w, []byte(`{"deleted": "`+r.PathValue("id")+`"}`))
+	default:
+		v.returnError(w, fmt.Errorf("method not supported"), http.StatusBadRequest)
+	}
+}
+
+func newNetworkPeer(peerConfig wireguard.PeerConfig) NetworkPeer {
+	return NetworkPeer{
+		Connection:     newConnection(peerConfig),
+		Address:        peerConfig.Address,
+		AddressIPv6:    peerConfig.AddressIPv6,
+		PublicKey:      peerConfig.PublicKey,
+		Networks:       peerConfig.Networks,
+		RouteToClients: peerConfig.RouteToClients,
+	}
+}
@@ -0,0 +1,201 @@
+package vpn
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/in4it/wireguard-server/pkg/wireguard"
+)
+
+func (v *VPN) adminProfilesHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		profilesConfig, err := wireguard.GetProfiles(v.Storage)
+		if err != nil {
+			v.returnError(w, fmt.Errorf("GetProfiles error: %s", err), http.StatusBadRequest)
+			return
+		}
+		out, err := json.Marshal(profilesConfig.Profiles)
+		if err != nil {
+			v.returnError(w, fmt.Errorf("could not marshal profiles: %s", err), http.StatusBadRequest)
+			return
+		}
+		v.write(w, out)
+	case http.MethodPost:
+		var profile wireguard.Profile
+		err := json.NewDecoder(r.Body).Decode(&profile)
+		if err != nil {
+			v.returnError(w, fmt.Errorf("profile decode error: %s", err), http.StatusBadRequest)
+			return
+		}
+		err = v.validateUserIDs(profile.Users)
+		if err != nil {
+			v.returnError(w, err, http.StatusBadRequest)
+			return
+		}
+		profile, err = wireguard.AddProfile(v.Storage, profile)
+		if err != nil {
+			v.returnError(w, fmt.Errorf("AddProfile error: %s", err), http.StatusBadRequest)
+			return
+		}
+		err = wireguard.UpdateClientsConfig(v.Storage)
+		if err != nil {
+			v.returnError(w, fmt.Errorf("could not update client vpn configs: %s", err), http.StatusBadRequest)
+			return
+		}
+		out, err := json.Marshal(profile)
+		if err != nil {
+			v.returnError(w, fmt.Errorf("could not marshal profile: %s", err), http.StatusBadRequest)
+			return
+		}
+		v.write(w, out)
+	default:
+		v.returnError(w, fmt.Errorf("method not supported"), http.StatusBadRequest)
+	}
+}
+
+func (v *VPN) adminProfileHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		profilesConfig, err := wireguard.GetProfiles(v.Storage)
+		if err != nil {
+			v.returnError(w, fmt.Errorf("GetProfiles error: %s", err), http.StatusBadRequest)
+			return
+		}
+		k := slices.IndexFunc(profilesConfig.Profiles, func(profile wireguard.Profile) bool { return profile.Name == r.PathValue("name") })
+		if k == -1 {
+			v.returnError(w, fmt.Errorf("profile not found"), http.StatusNotFound)
+			return
+		}
+		out, err := json.Marshal(profilesConfig.Profiles[k])
+		if err != nil {
+			v.returnError(w, fmt.Errorf("could not marshal profile: %s", err), http.StatusBadRequest)
+			return
+		}
+		v.write(w, out)
+	case http.MethodPut:
+		var profile wireguard.Profile
+		err := json.NewDecoder(r.Body).Decode(&profile)
+		if err != nil {
+			v.returnError(w, fmt.Errorf("profile decode error: %s", err), http.StatusBadRequest)
+			return
+		}
+		if profile.Name == "" {
+			profile.Name = r.PathValue("name")
+		}
+		err = v.validateUserIDs(profile.Users)
+		if err != nil {
+			v.returnError(w, err, http.StatusBadRequest)
+			return
+		}
+		profile, err = wireguard.UpdateProfile(v.Storage, r.PathValue("name"), profile)
+		if err != nil {
+			v.returnError(w, fmt.Errorf("UpdateProfile error: %s", err), http.StatusBadRequest)
+			return
+		}
+		err = wireguard.UpdateClientsConfig(v.Storage)
+		if err != nil {
+			v.returnError(w, fmt.Errorf("could not update client vpn configs: %s", err), http.StatusBadRequest)
+			return
+		}
+		out, err := json.Marshal(profile)
+		if err != nil {
+			v.returnError(w, fmt.Errorf("could not marshal profile: %s", err), http.StatusBadRequest)
+			return
+		}
+		v.write(w, out)
+	case http.MethodDelete:
+		err := wireguard.DeleteProfile(v.Storage, r.PathValue("name"))
+		if err != nil {
+			v.returnError(w, fmt.Errorf("DeleteProfile error: %s", err), http.StatusBadRequest)
+			return
+		}
+		err = wireguard.UpdateClientsConfig(v.Storage)
+		if err != nil {
+			v.returnError(w, fmt.Errorf("could not update client vpn configs: %s", err), http.StatusBadRequest)
+			return
+		}
+		v.write(w, []byte(`{"deleted": "`+r.PathValue("name")+`"}`))
+	default:
+		v.returnError(w, fmt.Errorf("method not supported"), http.StatusBadRequest)
+	}
+}
+
+func (v *VPN) adminGroupsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		profilesConfig, err := wireguard.GetProfiles(v.Storage)
+		if err != nil {
+			v.returnError(w, fmt.Errorf("GetProfiles error: %s", err), http.StatusBadRequest)
+			return
+		}
+		out, err := json.Marshal(profilesConfig.Groups)
+		if err != nil {
+			v.returnError(w, fmt.Errorf("could not marshal groups: %s", err), http.StatusBadRequest)
+			return
+		}
+		v.write(w, out)
+	default:
+		v.returnError(w, fmt.Errorf("method not supported"), http.StatusBadRequest)
+	}
+}
+
+func (v *VPN) adminGroupHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPut:
+		var groupRequest GroupRequest
+		err := json.NewDecoder(r.Body).Decode(&groupRequest)
+		if err != nil {
+			v.returnError(w, fmt.Errorf("group request decode error: %s", err), http.StatusBadRequest)
+			return
+		}
+		err = v.validateUserIDs(groupRequest.Users)
+		if err != nil {
+			v.returnError(w, err, http.StatusBadRequest)
+			return
+		}
+		err = wireguard.SetGroupMembers(v.Storage, r.PathValue("name"), groupRequest.Users)
+		if err != nil {
+			v.returnError(w, fmt.Errorf("SetGroupMembers error: %s", err), http.StatusBadRequest)
+			return
+		}
+		err = wireguard.UpdateClientsConfig(v.Storage)
+		if err != nil {
+			v.returnError(w, fmt.Errorf("could not update client vpn configs: %s", err), http.StatusBadRequest)
+			return
+		}
+		out, err := json.Marshal(groupRequest)
+		if err != nil {
+			v.returnError(w, fmt.Errorf("could not marshal group: %s", err), http.StatusBadRequest)
+			return
+		}
+		v.write(w, out)
+	case http.MethodDelete:
+		err := wireguard.SetGroupMembers(v.Storage, r.PathValue("name"), []string{})
+		if err != nil {
+			v.returnError(w, fmt.Errorf("SetGroupMembers error: %s", err), http.StatusBadRequest)
+			return
+		}
+		err = wireguard.UpdateClientsConfig(v.Storage)
+		if err != nil {
+			v.returnError(w, fmt.Errorf("could not update client vpn configs: %s", err), http.StatusBadRequest)
+			return
+		}
+		v.write(w, []byte(`{"deleted": "`+r.PathValue("name")+`"}`))
+	default:
+		v.returnError(w, fmt.Errorf("method not supported"), http.StatusBadRequest)
+	}
+}
+
+// validateUserIDs checks whether the users of a profile or group exist in the user store
+func (v *VPN) validateUserIDs(userIDs []string) error {
+	for _, userID := range userIDs {
+		_, err := v.UserStore.GetUserByID(userID)
+		if err != nil {
+			return fmt.Errorf("user %s not found: %s", userID, err)
+		}
+	}
+	return nil
+}
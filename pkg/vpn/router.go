@@ -11,14 +11,38 @@ func (v *VPN) GetRouter() *http.ServeMux {
 
 	mux.Handle("/api/vpn/connections", http.HandlerFunc(v.connectionsHandler))
 	mux.Handle("/api/vpn/connection/{id}", http.HandlerFunc(v.connectionsElementHandler))
+	mux.Handle("/api/vpn/connection/{id}/rotate-key", http.HandlerFunc(v.connectionRotateKeyHandler))
 	mux.Handle("/api/vpn/connectionlicense", http.HandlerFunc(v.connectionLicenseHandler))
 
+	mux.Handle("/api/vpn/admin/connections", rest.IsAdminMiddleware(http.HandlerFunc(v.adminConnectionsHandler)))
+	mux.Handle("/api/vpn/admin/user/{userID}/connections", rest.IsAdminMiddleware(http.HandlerFunc(v.adminUserConnectionsHandler)))
+	mux.Handle("/api/vpn/admin/connection/{id}", rest.IsAdminMiddleware(http.HandlerFunc(v.adminConnectionHandler)))
+	mux.Handle("/api/vpn/admin/connection/{id}/disable", rest.IsAdminMiddleware(http.HandlerFunc(v.adminConnectionDisableHandler)))
+	mux.Handle("/api/vpn/admin/connection/{id}/enable", rest.IsAdminMiddleware(http.HandlerFunc(v.adminConnectionEnableHandler)))
+	mux.Handle("/api/vpn/admin/connection/{id}/validity", rest.IsAdminMiddleware(http.HandlerFunc(v.adminConnectionValidityHandler)))
+	mux.Handle("/api/vpn/admin/connection/{id}/audit", rest.IsAdminMiddleware(http.HandlerFunc(v.adminConnectionAuditHandler)))
+	mux.Handle("/api/vpn/admin/reconcile", rest.IsAdminMiddleware(http.HandlerFunc(v.adminReconcileHandler)))
+	mux.Handle("/api/vpn/admin/ipam", rest.IsAdminMiddleware(http.HandlerFunc(v.adminIPAMHandler)))
+	mux.Handle("/api/vpn/admin/ipam/reservations", rest.IsAdminMiddleware(http.HandlerFunc(v.adminIPReservationsHandler)))
+	mux.Handle("/api/vpn/admin/ipam/reservation/{address}", rest.IsAdminMiddleware(http.HandlerFunc(v.adminIPReservationHandler)))
+	mux.Handle("/api/vpn/admin/profiles", rest.IsAdminMiddleware(http.HandlerFunc(v.adminProfilesHandler)))
+	mux.Handle("/api/vpn/admin/profile/{name}", rest.IsAdminMiddleware(http.HandlerFunc(v.adminProfileHandler)))
+	mux.Handle("/api/vpn/admin/groups", rest.IsAdminMiddleware(http.HandlerFunc(v.adminGroupsHandler)))
+	mux.Handle("/api/vpn/admin/group/{name}", rest.IsAdminMiddleware(http.HandlerFunc(v.adminGroupHandler)))
+	mux.Handle("/api/vpn/admin/networkpeers", rest.IsAdminMiddleware(http.HandlerFunc(v.adminNetworkPeersHandler)))
+	mux.Handle("/api/vpn/admin/networkpeer/{id}", rest.IsAdminMiddleware(http.HandlerFunc(v.adminNetworkPeerHandler)))
+	mux.Handle("/api/vpn/admin/connectionpolicy", rest.IsAdminMiddleware(http.HandlerFunc(v.adminConnectionPolicyHandler)))
+	mux.Handle("/api/vpn/admin/import", rest.IsAdminMiddleware(http.HandlerFunc(v.adminImportHandler)))
+
 	mux.Handle("/api/vpn/stats/user/{date}", rest.IsAdminMiddleware(http.HandlerFunc(v.userStatsHandler)))
 	mux.Handle("/api/vpn/stats/packetlogs/{user}/{date}", rest.IsAdminMiddleware(http.HandlerFunc(v.packetLogsHandler)))
 
 	mux.Handle("/api/vpn/setup/vpn", rest.IsAdminMiddleware(http.HandlerFunc(v.vpnSetupHandler)))
 	mux.Handle("/api/vpn/setup/templates", rest.IsAdminMiddleware(http.HandlerFunc(v.templateSetupHandler)))
 	mux.Handle("/api/vpn/setup/restart-vpn", rest.IsAdminMiddleware(http.HandlerFunc(v.restartVPNHandler)))
+	mux.Handle("/api/vpn/setup/rotate-server-key", rest.IsAdminMiddleware(http.HandlerFunc(v.rotateServerKeyHandler)))
+
+	mux.Handle("/api/vpn/jobs/{id}", rest.IsAdminMiddleware(http.HandlerFunc(v.jobHandler)))
 
 	mux.Handle("/api/vpn/version", http.HandlerFunc(v.version))
 
@@ -3,7 +3,6 @@ package vpn
 import (
 	"encoding/json"
 	"fmt"
-	"io"
 	"net"
 	"net/http"
 	"net/netip"
@@ -34,18 +33,37 @@ func (v *VPN) vpnSetupHandler(w http.ResponseWriter, r *http.Request) {
 		if vpnConfig.PacketLogsRetention == 0 {
 			vpnConfig.PacketLogsRetention = 7
 		}
+		addressRangeIPv6 := ""
+		if vpnConfig.AddressRangeIPv6.IsValid() {
+			addressRangeIPv6 = vpnConfig.AddressRangeIPv6.String()
+		}
+		clientAllowedIPs, err := wireguard.GetClientAllowedIPs(v.Storage, vpnConfig)
+		if err != nil {
+			v.returnError(w, fmt.Errorf("could not determine client allowed ips: %s", err), http.StatusBadRequest)
+			return
+		}
 		setupRequest := VPNSetupRequest{
-			Routes:              strings.Join(vpnConfig.ClientRoutes, ", "),
-			VPNEndpoint:         vpnConfig.Endpoint,
-			AddressRange:        vpnConfig.AddressRange.String(),
-			ClientAddressPrefix: vpnConfig.ClientAddressPrefix,
-			Port:                strconv.Itoa(vpnConfig.Port),
-			ExternalInterface:   vpnConfig.ExternalInterface,
-			Nameservers:         strings.Join(vpnConfig.Nameservers, ","),
-			DisableNAT:          vpnConfig.DisableNAT,
-			EnablePacketLogs:    vpnConfig.EnablePacketLogs,
-			PacketLogsTypes:     packetLogTypes,
-			PacketLogsRetention: strconv.Itoa(vpnConfig.PacketLogsRetention),
+			Routes:                  strings.Join(vpnConfig.ClientRoutes, ", "),
+			ExcludedRoutes:          strings.Join(vpnConfig.ClientExcludedRoutes, ", "),
+			ClientAllowedIPs:        clientAllowedIPs,
+			VPNEndpoint:             vpnConfig.Endpoint,
+			AddressRange:            vpnConfig.AddressRange.String(),
+			AddressRangeIPv6:        addressRangeIPv6,
+			ClientAddressPrefix:     vpnConfig.ClientAddressPrefix,
+			ClientAddressPrefixIPv6: vpnConfig.ClientAddressPrefixIPv6,
+			Port:                    strconv.Itoa(vpnConfig.Port),
+			ExternalInterface:       vpnConfig.ExternalInterface,
+			Nameservers:             strings.Join(vpnConfig.Nameservers, ","),
+			DisableNAT:              vpnConfig.DisableNAT,
+			EnablePacketLogs:        vpnConfig.EnablePacketLogs,
+			PacketLogsTypes:         packetLogTypes,
+			PacketLogsRetention:     strconv.Itoa(vpnConfig.PacketLogsRetention),
+			RequireClientPublicKey:  vpnConfig.RequireClientPublicKey,
+			OneTimeConfigReveal:     vpnConfig.OneTimeConfigReveal,
+			InterfaceMode:           vpnConfig.InterfaceMode,
+		}
+		if vpnConfig.MTU != 0 {
+			setupRequest.MTU = strconv.Itoa(vpnConfig.MTU)
 		}
 		out, err := json.Marshal(setupRequest)
 		if err != nil {
@@ -84,6 +102,23 @@ func (v *VPN) vpnSetupHandler(w http.ResponseWriter, r *http.Request) {
 			writeVPNConfig = true
 			rewriteClientConfigs = true
 		}
+		if strings.Join(vpnConfig.ClientExcludedRoutes, ", ") != setupRequest.ExcludedRoutes {
+			validatedNetworks := []string{}
+			for _, network := range strings.Split(setupRequest.ExcludedRoutes, ",") {
+				if strings.TrimSpace(network) == "" {
+					continue
+				}
+				_, ipnet, err := net.ParseCIDR(strings.TrimSpace(network))
+				if err != nil {
+					v.returnError(w, fmt.Errorf("excluded route %s in wrong format: %s", strings.TrimSpace(network), err), http.StatusBadRequest)
+					return
+				}
+				validatedNetworks = append(validatedNetworks, ipnet.String())
+			}
+			vpnConfig.ClientExcludedRoutes = validatedNetworks
+			writeVPNConfig = true
+			rewriteClientConfigs = true
+		}
 		if vpnConfig.Endpoint != setupRequest.VPNEndpoint {
 			vpnConfig.Endpoint = setupRequest.VPNEndpoint
 			writeVPNConfig = true
@@ -104,6 +139,27 @@ func (v *VPN) vpnSetupHandler(w http.ResponseWriter, r *http.Request) {
 			writeVPNConfig = true
 			rewriteClientConfigs = true
 		}
+		addressRangeIPv6Parsed := netip.Prefix{}
+		if setupRequest.AddressRangeIPv6 != "" { // ipv6 is optional
+			addressRangeIPv6Parsed, err = netip.ParsePrefix(setupRequest.AddressRangeIPv6)
+			if err != nil || !addressRangeIPv6Parsed.Addr().Is6() || addressRangeIPv6Parsed.Addr().Is4In6() {
+				v.returnError(w, fmt.Errorf("AddressRangeIPv6 in wrong format: needs to be an IPv6 network with the server address (e.g. fd00::1/64)"), http.StatusBadRequest)
+				return
+			}
+			if setupRequest.ClientAddressPrefixIPv6 == "" {
+				setupRequest.ClientAddressPrefixIPv6 = wireguard.DEFAULT_CLIENT_ADDRESS_PREFIX_IPV6
+			}
+		}
+		if addressRangeIPv6Parsed != vpnConfig.AddressRangeIPv6 {
+			vpnConfig.AddressRangeIPv6 = addressRangeIPv6Parsed
+			writeVPNConfig = true
+			rewriteClientConfigs = true
+		}
+		if setupRequest.ClientAddressPrefixIPv6 != vpnConfig.ClientAddressPrefixIPv6 {
+			vpnConfig.ClientAddressPrefixIPv6 = setupRequest.ClientAddressPrefixIPv6
+			writeVPNConfig = true
+			rewriteClientConfigs = true
+		}
 		port, err := strconv.Atoi(setupRequest.Port)
 		if err != nil {
 			v.returnError(w, fmt.Errorf("port in wrong format: %s", err), http.StatusBadRequest)
@@ -124,6 +180,10 @@ func (v *VPN) vpnSetupHandler(w http.ResponseWriter, r *http.Request) {
 			writeVPNConfig = true
 			rewriteClientConfigs = true
 		}
+		if setupRequest.ExternalInterface != "" && !wireguard.IsValidInterfaceName(setupRequest.ExternalInterface) {
+			v.returnError(w, fmt.Errorf("external interface in wrong format: not a valid interface name"), http.StatusBadRequest)
+			return
+		}
 		if setupRequest.ExternalInterface != vpnConfig.ExternalInterface { // don't rewrite client config
 			vpnConfig.ExternalInterface = setupRequest.ExternalInterface
 			writeVPNConfig = true
@@ -132,6 +192,34 @@ func (v *VPN) vpnSetupHandler(w http.ResponseWriter, r *http.Request) {
 			vpnConfig.DisableNAT = setupRequest.DisableNAT
 			writeVPNConfig = true
 		}
+		if setupRequest.RequireClientPublicKey != vpnConfig.RequireClientPublicKey { // only enforced when a config is downloaded
+			vpnConfig.RequireClientPublicKey = setupRequest.RequireClientPublicKey
+			writeVPNConfig = true
+		}
+		if setupRequest.OneTimeConfigReveal != vpnConfig.OneTimeConfigReveal { // only applies to configs downloaded from now on
+			vpnConfig.OneTimeConfigReveal = setupRequest.OneTimeConfigReveal
+			writeVPNConfig = true
+		}
+		mtu := 0 // default mtu
+		if setupRequest.MTU != "" {
+			mtu, err = strconv.Atoi(setupRequest.MTU)
+			if err != nil || mtu < 1280 || mtu > 9000 {
+				v.returnError(w, fmt.Errorf("mtu in wrong format: needs to be a number between 1280 and 9000"), http.StatusBadRequest)
+				return
+			}
+		}
+		if mtu != vpnConfig.MTU { // applied when the vpn is restarted
+			vpnConfig.MTU = mtu
+			writeVPNConfig = true
+		}
+		if setupRequest.InterfaceMode != "" && setupRequest.InterfaceMode != wireguard.INTERFACE_MODE_NATIVE && setupRequest.InterfaceMode != wireguard.INTERFACE_MODE_WG_QUICK {
+			v.returnError(w, fmt.Errorf("interface mode needs to be %s or %s", wireguard.INTERFACE_MODE_NATIVE, wireguard.INTERFACE_MODE_WG_QUICK), http.StatusBadRequest)
+			return
+		}
+		if setupRequest.InterfaceMode != vpnConfig.InterfaceMode { // applied when the vpn is restarted
+			vpnConfig.InterfaceMode = setupRequest.InterfaceMode
+			writeVPNConfig = true
+		}
 		if setupRequest.EnablePacketLogs != vpnConfig.EnablePacketLogs {
 			vpnConfig.EnablePacketLogs = setupRequest.EnablePacketLogs
 			writeVPNConfig = true
@@ -172,7 +260,7 @@ func (v *VPN) vpnSetupHandler(w http.ResponseWriter, r *http.Request) {
 				v.returnError(w, fmt.Errorf("could write vpn config: %s", err), http.StatusBadRequest)
 				return
 			}
-			err = wireguard.ReloadVPNServerConfig()
+			err = wireguard.ReloadVPNServerConfig(r.Context(), v.Storage)
 			if err != nil {
 				v.returnError(w, fmt.Errorf("unable to reload server config: %s", err), http.StatusBadRequest)
 				return
@@ -186,6 +274,11 @@ func (v *VPN) vpnSetupHandler(w http.ResponseWriter, r *http.Request) {
 				return
 			}
 		}
+		setupRequest.ClientAllowedIPs, err = wireguard.GetClientAllowedIPs(v.Storage, vpnConfig)
+		if err != nil {
+			v.returnError(w, fmt.Errorf("could not determine client allowed ips: %s", err), http.StatusBadRequest)
+			return
+		}
 		out, err := json.Marshal(setupRequest)
 		if err != nil {
 			v.returnError(w, fmt.Errorf("could not marshal SetupRequest: %s", err), http.StatusBadRequest)
@@ -274,35 +367,83 @@ func (v *VPN) restartVPNHandler(w http.ResponseWriter, r *http.Request) {
 		v.returnError(w, fmt.Errorf("unsupported method"), http.StatusBadRequest)
 		return
 	}
-	client := http.Client{
-		Timeout: 10 * time.Second,
-	}
-	req, err := http.NewRequest(r.Method, "http://"+wireguard.CONFIGMANAGER_URI+"/restart-vpn", nil)
+	jobResponse, err := wireguard.RestartVPN(r.Context(), v.Storage)
 	if err != nil {
-		v.returnError(w, fmt.Errorf("restart request error: %s", err), http.StatusBadRequest)
+		v.returnError(w, fmt.Errorf("restart error: %s", err), http.StatusBadRequest)
 		return
 	}
-	resp, err := client.Do(req)
+	out, err := json.Marshal(jobResponse)
 	if err != nil {
-		v.returnError(w, fmt.Errorf("restart error: %s", err), http.StatusBadRequest)
+		v.returnError(w, fmt.Errorf("restart response marshal error: %s", err), http.StatusBadRequest)
 		return
 	}
-	if resp.StatusCode != http.StatusAccepted {
-		bodyBytes, err := io.ReadAll(resp.Body)
+	v.write(w, out)
+}
+
+func (v *VPN) rotateServerKeyHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		vpnConfig, err := wireguard.GetVPNConfig(v.Storage)
 		if err != nil {
-			v.returnError(w, fmt.Errorf("restart error: got status code: %d. Response: %s", resp.StatusCode, bodyBytes), http.StatusBadRequest)
+			v.returnError(w, fmt.Errorf("could not get vpn config: %s", err), http.StatusBadRequest)
 			return
 		}
-		v.returnError(w, fmt.Errorf("restart error: got status code: %d. Couldn't get response", resp.StatusCode), http.StatusBadRequest)
-		return
+		v.writeServerKeyRotationResponse(w, wireguard.RotateServerKeyResponse{PublicKey: vpnConfig.PublicKey, PendingPublicKey: vpnConfig.PendingPublicKey, PendingKeySwitchAt: vpnConfig.PendingKeySwitchAt})
+	case http.MethodPost:
+		var serverKeyRotationRequest ServerKeyRotationRequest
+		decoder := json.NewDecoder(r.Body)
+		err := decoder.Decode(&serverKeyRotationRequest)
+		if err != nil {
+			v.returnError(w, fmt.Errorf("server key rotation request decode error: %s", err), http.StatusBadRequest)
+			return
+		}
+		rotateServerKeyRequest := wireguard.RotateServerKeyRequest{
+			Emergency: serverKeyRotationRequest.Emergency,
+		}
+		if serverKeyRotationRequest.SwitchAt != "" {
+			if serverKeyRotationRequest.Emergency {
+				v.returnError(w, fmt.Errorf("an emergency server key rotation cannot be scheduled"), http.StatusBadRequest)
+				return
+			}
+			rotateServerKeyRequest.SwitchAt, err = time.Parse(time.RFC3339, serverKeyRotationRequest.SwitchAt)
+			if err != nil {
+				v.returnError(w, fmt.Errorf("switchAt is not a valid RFC3339 timestamp: %s", err), http.StatusBadRequest)
+				return
+			}
+		}
+		rotateServerKeyResponse, err := wireguard.RequestServerKeyRotation(r.Context(), v.Storage, rotateServerKeyRequest)
+		if err != nil {
+			v.returnError(w, fmt.Errorf("server key rotation error: %s", err), http.StatusBadRequest)
+			return
+		}
+		v.writeServerKeyRotationResponse(w, rotateServerKeyResponse)
+	default:
+		v.returnError(w, fmt.Errorf("method not supported"), http.StatusBadRequest)
 	}
+}
 
-	defer resp.Body.Close() //nolint:errcheck
-	bodyBytes, err := io.ReadAll(resp.Body)
+func (v *VPN) writeServerKeyRotationResponse(w http.ResponseWriter, rotateServerKeyResponse wireguard.RotateServerKeyResponse) {
+	peerConfigs, err := wireguard.GetAllPeerConfigs(v.Storage)
 	if err != nil {
-		v.returnError(w, fmt.Errorf("body read error: %s", err), http.StatusBadRequest)
+		v.returnError(w, fmt.Errorf("could not get peer configs: %s", err), http.StatusBadRequest)
 		return
 	}
-
-	v.write(w, bodyBytes)
+	serverKeyRotationResponse := ServerKeyRotationResponse{
+		PublicKey:        rotateServerKeyResponse.PublicKey,
+		PendingPublicKey: rotateServerKeyResponse.PendingPublicKey,
+	}
+	if rotateServerKeyResponse.PendingPublicKey != "" {
+		serverKeyRotationResponse.PendingKeySwitchAt = rotateServerKeyResponse.PendingKeySwitchAt.Format(time.RFC3339)
+	}
+	for _, peerConfig := range peerConfigs {
+		if peerConfig.ConfigRefreshRequired {
+			serverKeyRotationResponse.ConnectionsNeedingRefresh++
+		}
+	}
+	out, err := json.Marshal(serverKeyRotationResponse)
+	if err != nil {
+		v.returnError(w, fmt.Errorf("could not marshal server key rotation response: %s", err), http.StatusBadRequest)
+		return
+	}
+	v.write(w, out)
 }
@@ -1,10 +1,21 @@
 package vpn
 
 import (
+	"time"
+
 	"github.com/in4it/go-devops-platform/storage"
 	"github.com/in4it/go-devops-platform/users"
+	"github.com/in4it/wireguard-server/pkg/wireguard"
 )
 
+const DEFAULT_INVENTORY_LIMIT = 50
+const MAX_INVENTORY_LIMIT = 1000
+const ACTIVE_HANDSHAKE_THRESHOLD = 3 * time.Minute
+
+const CONNECTION_STATUS_ACTIVE = "active"
+const CONNECTION_STATUS_INACTIVE = "inactive"
+const CONNECTION_STATUS_DISABLED = "disabled"
+
 type VPN struct {
 	Storage   storage.Iface
 	UserStore *users.UserStore
@@ -12,12 +23,73 @@ type VPN struct {
 	Protocol  string
 }
 
+type NewConnectionRequest struct {
+	PublicKey string `json:"publicKey"`
+	NotBefore string `json:"notBefore"`
+	ExpiresAt string `json:"expiresAt"`
+}
 type NewConnectionResponse struct {
 	Name string `json:"name"`
 }
 type Connection struct {
-	ID   string `json:"id"`
-	Name string `json:"name"`
+	ID                    string   `json:"id"`
+	Name                  string   `json:"name"`
+	ClientGeneratedKey    bool     `json:"clientGeneratedKey"`
+	ConfigRevealed        bool     `json:"configRevealed"`
+	ConfigRefreshRequired bool     `json:"configRefreshRequired"`
+	Disabled              bool     `json:"disabled"`
+	DisabledReason        string   `json:"disabledReason,omitempty"`
+	DisabledBy            string   `json:"disabledBy,omitempty"`
+	DisabledAt            string   `json:"disabledAt,omitempty"`
+	NotBefore             string   `json:"notBefore,omitempty"`
+	ExpiresAt             string   `json:"expiresAt,omitempty"`
+	Description           string   `json:"description,omitempty"`
+	Platform              string   `json:"platform,omitempty"`
+	Labels                []string `json:"labels,omitempty"`
+}
+type ConnectionPublicKeyRequest struct {
+	PublicKey string `json:"publicKey"`
+}
+type ConnectionValidityRequest struct {
+	NotBefore string `json:"notBefore"`
+	ExpiresAt string `json:"expiresAt"`
+}
+
+type ConnectionInventoryResponse struct {
+	Connections       []ConnectionInventoryItem `json:"connections"`
+	Total             int                       `json:"total"`
+	Page              int                       `json:"page"`
+	Limit             int                       `json:"limit"`
+	LiveDataAvailable bool                      `json:"liveDataAvailable"`
+}
+type ConnectionInventoryItem struct {
+	Connection
+	UserID        string   `json:"userID"`
+	Login         string   `json:"login"`
+	Address       string   `json:"address"`
+	AddressIPv6   string   `json:"addressIPv6,omitempty"`
+	Profile       string   `json:"profile,omitempty"`
+	Type          string   `json:"type,omitempty"`
+	Networks      []string `json:"networks,omitempty"`
+	PublicKey     string   `json:"publicKey"`
+	Status        string   `json:"status"`
+	LastHandshake string   `json:"lastHandshake,omitempty"`
+	Endpoint      string   `json:"endpoint,omitempty"`
+	ReceiveBytes  int64    `json:"receiveBytes"`
+	TransmitBytes int64    `json:"transmitBytes"`
+}
+
+type NetworkPeer struct {
+	Connection
+	Address        string   `json:"address"`
+	AddressIPv6    string   `json:"addressIPv6,omitempty"`
+	PublicKey      string   `json:"publicKey"`
+	Networks       []string `json:"networks"`
+	RouteToClients bool     `json:"routeToClients"`
+}
+
+type GroupRequest struct {
+	Users []string `json:"users"`
 }
 
 type UserStatsResponse struct {
@@ -65,17 +137,36 @@ type LogRow struct {
 }
 
 type VPNSetupRequest struct {
-	Routes              string   `json:"routes"`
-	VPNEndpoint         string   `json:"vpnEndpoint"`
-	AddressRange        string   `json:"addressRange"`
-	ClientAddressPrefix string   `json:"clientAddressPrefix"`
-	Port                string   `json:"port"`
-	ExternalInterface   string   `json:"externalInterface"`
-	Nameservers         string   `json:"nameservers"`
-	DisableNAT          bool     `json:"disableNAT"`
-	EnablePacketLogs    bool     `json:"enablePacketLogs"`
-	PacketLogsTypes     []string `json:"packetLogsTypes"`
-	PacketLogsRetention string   `json:"packetLogsRetention"`
+	Routes                  string   `json:"routes"`
+	ExcludedRoutes          string   `json:"excludedRoutes"`
+	ClientAllowedIPs        []string `json:"clientAllowedIPs,omitempty"` // computed from the routes, read only
+	VPNEndpoint             string   `json:"vpnEndpoint"`
+	AddressRange            string   `json:"addressRange"`
+	ClientAddressPrefix     string   `json:"clientAddressPrefix"`
+	AddressRangeIPv6        string   `json:"addressRangeIPv6"`
+	ClientAddressPrefixIPv6 string   `json:"clientAddressPrefixIPv6"`
+	Port                    string   `json:"port"`
+	ExternalInterface       string   `json:"externalInterface"`
+	Nameservers             string   `json:"nameservers"`
+	DisableNAT              bool     `json:"disableNAT"`
+	EnablePacketLogs        bool     `json:"enablePacketLogs"`
+	PacketLogsTypes         []string `json:"packetLogsTypes"`
+	PacketLogsRetention     string   `json:"packetLogsRetention"`
+	RequireClientPublicKey  bool     `json:"requireClientPublicKey"`
+	OneTimeConfigReveal     bool     `json:"oneTimeConfigReveal"`
+	MTU                     string   `json:"mtu"`
+	InterfaceMode           string   `json:"interfaceMode"`
+}
+
+type ServerKeyRotationRequest struct {
+	SwitchAt  string `json:"switchAt"`
+	Emergency bool   `json:"emergency"`
+}
+type ServerKeyRotationResponse struct {
+	PublicKey                 string `json:"publicKey"`
+	PendingPublicKey          string `json:"pendingPublicKey"`
+	PendingKeySwitchAt        string `json:"pendingKeySwitchAt"`
+	ConnectionsNeedingRefresh int    `json:"connectionsNeedingRefresh"`
 }
 
 type TemplateSetupRequest struct {
@@ -84,6 +175,19 @@ type TemplateSetupRequest struct {
 }
 
 type ConnectionLicenseResponse struct {
-	LicenseUserCount int `json:"licenseUserCount"`
-	ConnectionCount  int `json:"connectionCount"`
+	LicenseUserCount     int  `json:"licenseUserCount"`
+	ConnectionCount      int  `json:"connectionCount"`
+	ConnectionLimit      int  `json:"connectionLimit"` // 0 is unlimited
+	CanCreateConnections bool `json:"canCreateConnections"`
+}
+
+type IPAMResponse struct {
+	AddressRange      string                    `json:"addressRange"`
+	AddressPrefix     string                    `json:"addressPrefix"`
+	Allocations       map[string]string         `json:"allocations"`
+	AddressRangeIPv6  string                    `json:"addressRangeIPv6,omitempty"`
+	AddressPrefixIPv6 string                    `json:"addressPrefixIPv6,omitempty"`
+	AllocationsIPv6   map[string]string         `json:"allocationsIPv6,omitempty"`
+	Reservations      []wireguard.IPReservation `json:"reservations"`
+	Conflicts         []wireguard.IPConflict    `json:"conflicts"`
 }
@@ -2,11 +2,14 @@ package vpn
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"path"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/in4it/go-devops-platform/rest"
 	"github.com/in4it/go-devops-platform/users"
@@ -50,10 +53,7 @@ func (v *VPN) connectionsHandler(w http.ResponseWriter, r *http.Request) {
 		}
 		connections := make([]Connection, len(peerConfigs))
 		for k := range peerConfigs {
-			connections[k] = Connection{
-				ID:   peerConfigs[k].ID,
-				Name: peerConfigs[k].Name,
-			}
+			connections[k] = newConnection(peerConfigs[k])
 		}
 		out, err := json.Marshal(connections)
 		if err != nil {
@@ -65,9 +65,15 @@ func (v *VPN) connectionsHandler(w http.ResponseWriter, r *http.Request) {
 		muClientDownload.Lock()
 		defer muClientDownload.Unlock()
 		user := r.Context().Value(rest.CustomValue("user")).(users.User)
-		peerConfig, err := wireguard.NewEmptyClientConfig(v.Storage, user.ID)
+		newClientConfigOptions, err := decodeNewConnectionRequest(r)
+		if err != nil {
+			v.returnError(w, err, http.StatusBadRequest)
+			return
+		}
+		newClientConfigOptions.CreatedByAdmin = user.Role == "admin"
+		peerConfig, err := wireguard.NewClientConfig(v.Storage, user.ID, newClientConfigOptions)
 		if err != nil {
-			v.returnError(w, fmt.Errorf("could not generate client vpn config: %s", err), http.StatusBadRequest)
+			v.returnError(w, fmt.Errorf("could not generate client vpn config: %s", err), newConnectionErrorStatus(err))
 			return
 		}
 		newConnectionResponse := NewConnectionResponse{Name: peerConfig.Name}
@@ -93,12 +99,63 @@ func (v *VPN) connectionsElementHandler(w http.ResponseWriter, r *http.Request)
 			v.returnError(w, fmt.Errorf("connection id contains invalid characters"), http.StatusBadRequest)
 			return
 		}
-		out, err := wireguard.GenerateNewClientConfig(v.Storage, r.PathValue("id"), user.ID)
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = wireguard.CLIENT_CONFIG_FORMAT_CONF
+		}
+		contentType, extension, err := wireguard.GetClientConfigFormat(format)
+		if err != nil {
+			v.returnError(w, err, http.StatusBadRequest)
+			return
+		}
+		out, err := wireguard.GenerateNewClientConfigWithOptions(v.Storage, r.PathValue("id"), user.ID, wireguard.ClientConfigOptions{Format: format, WaitForSync: r.URL.Query().Get("wait") == "true"})
 		if err != nil {
 			v.returnError(w, fmt.Errorf("GetClientConfig error: %s", err), http.StatusBadRequest)
 			return
 		}
+		w.Header().Set("Content-Type", contentType)
+		if r.URL.Query().Has("format") {
+			w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, r.PathValue("id"), extension))
+		}
+		v.write(w, out)
+	case http.MethodPut:
+		user := r.Context().Value(rest.CustomValue("user")).(users.User)
+		if !strings.HasPrefix(r.PathValue("id"), user.ID) {
+			v.returnError(w, fmt.Errorf("connection id is in invalid format (needs to contain user id)"), http.StatusBadRequest)
+			return
+		}
+		if strings.Contains(r.PathValue("id"), ".") || strings.Contains(r.PathValue("id"), "/") {
+			v.returnError(w, fmt.Errorf("connection id contains invalid characters"), http.StatusBadRequest)
+			return
+		}
+		var publicKeyRequest ConnectionPublicKeyRequest
+		err := json.NewDecoder(r.Body).Decode(&publicKeyRequest)
+		if err != nil {
+			v.returnError(w, fmt.Errorf("public key request decode error: %s", err), http.StatusBadRequest)
+			return
+		}
+		peerConfig, err := wireguard.SetClientPublicKey(v.Storage, r.PathValue("id"), publicKeyRequest.PublicKey)
+		if err != nil {
+			v.returnError(w, fmt.Errorf("SetClientPublicKey error: %s", err), http.StatusBadRequest)
+			return
+		}
+		out, err := json.Marshal(newConnection(peerConfig))
+		if err != nil {
+			v.returnError(w, fmt.Errorf("could not marshal connection: %s", err), http.StatusBadRequest)
+			return
+		}
 		v.write(w, out)
+	case http.MethodPatch:
+		user := r.Context().Value(rest.CustomValue("user")).(users.User)
+		if !strings.HasPrefix(r.PathValue("id"), user.ID) {
+			v.returnError(w, fmt.Errorf("connection id is in invalid format (needs to contain user id)"), http.StatusBadRequest)
+			return
+		}
+		if strings.Contains(r.PathValue("id"), ".") || strings.Contains(r.PathValue("id"), "/") {
+			v.returnError(w, fmt.Errorf("connection id contains invalid characters"), http.StatusBadRequest)
+			return
+		}
+		v.setConnectionMetadata(w, r, user)
 	case http.MethodDelete:
 		user := r.Context().Value(rest.CustomValue("user")).(users.User)
 		if !strings.HasPrefix(r.PathValue("id"), user.ID) {
@@ -121,6 +178,54 @@ func (v *VPN) connectionsElementHandler(w http.ResponseWriter, r *http.Request)
 	}
 }
 
+func (v *VPN) setConnectionMetadata(w http.ResponseWriter, r *http.Request, user users.User) {
+	var metadata wireguard.ConnectionMetadata
+	err := json.NewDecoder(r.Body).Decode(&metadata)
+	if err != nil {
+		v.returnError(w, fmt.Errorf("connection metadata decode error: %s", err), http.StatusBadRequest)
+		return
+	}
+	peerConfig, err := wireguard.SetClientMetadata(v.Storage, r.PathValue("id"), metadata, user.Login)
+	if err != nil {
+		v.returnError(w, fmt.Errorf("SetClientMetadata error: %s", err), http.StatusBadRequest)
+		return
+	}
+	out, err := json.Marshal(newConnection(peerConfig))
+	if err != nil {
+		v.returnError(w, fmt.Errorf("could not marshal connection: %s", err), http.StatusBadRequest)
+		return
+	}
+	v.write(w, out)
+}
+
+func (v *VPN) connectionRotateKeyHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		user := r.Context().Value(rest.CustomValue("user")).(users.User)
+		if !strings.HasPrefix(r.PathValue("id"), user.ID) {
+			v.returnError(w, fmt.Errorf("connection id is in invalid format (needs to contain user id)"), http.StatusBadRequest)
+			return
+		}
+		if strings.Contains(r.PathValue("id"), ".") || strings.Contains(r.PathValue("id"), "/") {
+			v.returnError(w, fmt.Errorf("connection id contains invalid characters"), http.StatusBadRequest)
+			return
+		}
+		peerConfig, err := wireguard.RotateClientKey(v.Storage, r.PathValue("id"))
+		if err != nil {
+			v.returnError(w, fmt.Errorf("RotateClientKey error: %s", err), http.StatusBadRequest)
+			return
+		}
+		out, err := json.Marshal(newConnection(peerConfig))
+		if err != nil {
+			v.returnError(w, fmt.Errorf("could not marshal connection: %s", err), http.StatusBadRequest)
+			return
+		}
+		v.write(w, out)
+	default:
+		v.returnError(w, fmt.Errorf("method not supported"), http.StatusBadRequest)
+	}
+}
+
 func (v *VPN) connectionLicenseHandler(w http.ResponseWriter, r *http.Request) {
 	user := r.Context().Value(rest.CustomValue("user")).(users.User)
 	licenseUserCount := r.Context().Value(rest.CustomValue("licenseUserCount")).(int)
@@ -130,10 +235,91 @@ func (v *VPN) connectionLicenseHandler(w http.ResponseWriter, r *http.Request) {
 		return
 
 	}
-	out, err := json.Marshal(ConnectionLicenseResponse{LicenseUserCount: licenseUserCount, ConnectionCount: len(totalConnections)})
+	connectionLimit, err := wireguard.GetConnectionLimit(v.Storage, user.ID)
+	if err != nil {
+		v.returnError(w, fmt.Errorf("can't determine connection limit: %s", err), http.StatusBadRequest)
+		return
+	}
+	connectionPolicy, err := wireguard.GetConnectionPolicy(v.Storage)
+	if err != nil {
+		v.returnError(w, fmt.Errorf("can't get connection policy: %s", err), http.StatusBadRequest)
+		return
+	}
+	connectionLicenseResponse := ConnectionLicenseResponse{
+		LicenseUserCount:     licenseUserCount,
+		ConnectionCount:      len(totalConnections),
+		ConnectionLimit:      connectionLimit,
+		CanCreateConnections: (!connectionPolicy.AdminOnly || user.Role == "admin") && (connectionLimit == 0 || len(totalConnections) < connectionLimit),
+	}
+	out, err := json.Marshal(connectionLicenseResponse)
 	if err != nil {
 		v.returnError(w, fmt.Errorf("oidcProviders marshal error"), http.StatusBadRequest)
 		return
 	}
 	v.write(w, out)
 }
+
+// decodeNewConnectionRequest returns the options of a new connection. An empty body is allowed.
+func decodeNewConnectionRequest(r *http.Request) (wireguard.NewClientConfigOptions, error) {
+	var newConnectionRequest NewConnectionRequest
+	err := json.NewDecoder(r.Body).Decode(&newConnectionRequest)
+	if err != nil && err != io.EOF {
+		return wireguard.NewClientConfigOptions{}, fmt.Errorf("new connection request decode error: %s", err)
+	}
+	newClientConfigOptions := wireguard.NewClientConfigOptions{PublicKey: newConnectionRequest.PublicKey}
+	newClientConfigOptions.NotBefore, err = parseTimestamp(newConnectionRequest.NotBefore)
+	if err != nil {
+		return newClientConfigOptions, fmt.Errorf("notBefore is not a valid RFC3339 timestamp: %s", err)
+	}
+	newClientConfigOptions.ExpiresAt, err = parseTimestamp(newConnectionRequest.ExpiresAt)
+	if err != nil {
+		return newClientConfigOptions, fmt.Errorf("expiresAt is not a valid RFC3339 timestamp: %s", err)
+	}
+	return newClientConfigOptions, nil
+}
+
+// newConnectionErrorStatus returns 403 when the policy doesn't allow the user to create connections
+// and 409 when the user reached the connection limit
+func newConnectionErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, wireguard.ErrConnectionCreationNotAllowed):
+		return http.StatusForbidden
+	case errors.Is(err, wireguard.ErrConnectionLimitReached):
+		return http.StatusConflict
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+func newConnection(peerConfig wireguard.PeerConfig) Connection {
+	connection := Connection{
+		ID:                    peerConfig.ID,
+		Name:                  peerConfig.Name,
+		ClientGeneratedKey:    peerConfig.ClientGeneratedKey,
+		ConfigRevealed:        peerConfig.ConfigRevealed,
+		ConfigRefreshRequired: peerConfig.ConfigRefreshRequired,
+		Disabled:              peerConfig.Disabled,
+		DisabledReason:        peerConfig.DisabledReason,
+		DisabledBy:            peerConfig.DisabledBy,
+		Description:           peerConfig.Description,
+		Platform:              peerConfig.Platform,
+		Labels:                peerConfig.Labels,
+	}
+	if !peerConfig.DisabledAt.IsZero() {
+		connection.DisabledAt = peerConfig.DisabledAt.Format(time.RFC3339)
+	}
+	if !peerConfig.NotBefore.IsZero() {
+		connection.NotBefore = peerConfig.NotBefore.Format(time.RFC3339)
+	}
+	if !peerConfig.ExpiresAt.IsZero() {
+		connection.ExpiresAt = peerConfig.ExpiresAt.Format(time.RFC3339)
+	}
+	return connection
+}
+
+func parseTimestamp(timestamp string) (time.Time, error) {
+	if timestamp == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, timestamp)
+}
@@ -6,17 +6,18 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"net"
 	"net/http"
 	"net/http/httptest"
 	"path"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/in4it/go-devops-platform/auth/provisioning/scim"
 	"github.com/in4it/go-devops-platform/rest"
 	memorystorage "github.com/in4it/go-devops-platform/storage/memory"
 	"github.com/in4it/go-devops-platform/users"
+	configmanagerclient "github.com/in4it/wireguard-server/pkg/configmanager/client"
 	"github.com/in4it/wireguard-server/pkg/wireguard"
 )
 
@@ -38,41 +39,8 @@ func TestSCIMCreateUserConnectionDeleteUserFlow(t *testing.T) {
 	}
 	s := scim.New(storage, userStore, "token")
 
-	l, err := net.Listen("tcp", wireguard.CONFIGMANAGER_URI)
-	if err != nil {
-		t.Fatal(err)
-	}
-
-	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		switch r.Method {
-		case http.MethodPost:
-			if r.RequestURI == "/refresh-clients" {
-				w.WriteHeader(http.StatusAccepted)
-				_, err := w.Write([]byte("OK"))
-				if err != nil {
-					t.Fatalf("write error: %s", err)
-				}
-				return
-			}
-			if r.RequestURI == "/refresh-server-config" {
-				w.WriteHeader(http.StatusAccepted)
-				_, err := w.Write([]byte("OK"))
-				if err != nil {
-					t.Fatalf("write error: %s", err)
-				}
-				return
-			}
-			w.WriteHeader(http.StatusBadRequest)
-		default:
-			w.WriteHeader(http.StatusBadRequest)
-		}
-	}))
-
-	ts.Listener.Close() //nolint:errcheck
-	ts.Listener = l
-	ts.Start()
-	defer ts.Close() //nolint:errcheck
-	defer l.Close()  //nolint:errcheck
+	wireguard.UseConfigManagerClient(configmanagerclient.NewFake())
+	defer wireguard.UseConfigManagerClient(nil)
 
 	// create a user
 	payload := scim.PostUserRequest{
@@ -169,41 +137,8 @@ func TestCreateUserConnectionSuspendUserFlow(t *testing.T) {
 	}
 	s := scim.New(storage, userStore, "token")
 
-	l, err := net.Listen("tcp", wireguard.CONFIGMANAGER_URI)
-	if err != nil {
-		t.Fatal(err)
-	}
-
-	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		switch r.Method {
-		case http.MethodPost:
-			if r.RequestURI == "/refresh-clients" {
-				w.WriteHeader(http.StatusAccepted)
-				_, err := w.Write([]byte("OK"))
-				if err != nil {
-					t.Fatalf("write error: %s", err)
-				}
-				return
-			}
-			if r.RequestURI == "/refresh-server-config" {
-				w.WriteHeader(http.StatusAccepted)
-				_, err := w.Write([]byte("OK"))
-				if err != nil {
-					t.Fatalf("write error: %s", err)
-				}
-				return
-			}
-			w.WriteHeader(http.StatusBadRequest)
-		default:
-			w.WriteHeader(http.StatusBadRequest)
-		}
-	}))
-
-	ts.Listener.Close() //nolint:errcheck
-	ts.Listener = l
-	ts.Start()
-	defer ts.Close() //nolint:errcheck
-	defer l.Close()  //nolint:errcheck
+	wireguard.UseConfigManagerClient(configmanagerclient.NewFake())
+	defer wireguard.UseConfigManagerClient(nil)
 
 	// create a user
 	payload := scim.PostUserRequest{
@@ -303,41 +238,8 @@ func TestCreateUserConnectionSuspendUserFlow(t *testing.T) {
 }
 
 func TestCreateUserConnectionDeleteUserFlow(t *testing.T) {
-	l, err := net.Listen("tcp", wireguard.CONFIGMANAGER_URI)
-	if err != nil {
-		t.Fatal(err)
-	}
-
-	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		switch r.Method {
-		case http.MethodPost:
-			if r.RequestURI == "/refresh-clients" {
-				w.WriteHeader(http.StatusAccepted)
-				_, err := w.Write([]byte("OK"))
-				if err != nil {
-					t.Fatalf("write error: %s", err)
-				}
-				return
-			}
-			if r.RequestURI == "/refresh-server-config" {
-				w.WriteHeader(http.StatusAccepted)
-				_, err := w.Write([]byte("OK"))
-				if err != nil {
-					t.Fatalf("write error: %s", err)
-				}
-				return
-			}
-			w.WriteHeader(http.StatusBadRequest)
-		default:
-			w.WriteHeader(http.StatusBadRequest)
-		}
-	}))
-
-	ts.Listener.Close() //nolint:errcheck
-	ts.Listener = l
-	ts.Start()
-	defer ts.Close() //nolint:errcheck
-	defer l.Close()  //nolint:errcheck
+	wireguard.UseConfigManagerClient(configmanagerclient.NewFake())
+	defer wireguard.UseConfigManagerClient(nil)
 
 	// first create a new user
 	storage := &memorystorage.MockMemoryStorage{}
@@ -438,3 +340,348 @@ func TestCreateUserConnectionDeleteUserFlow(t *testing.T) {
 		t.Fatalf("could read user config file, expected not to")
 	}
 }
+
+func TestAdminConnectionInventory(t *testing.T) {
+	storage := &memorystorage.MockMemoryStorage{}
+	userStore, err := users.NewUserStore(storage, USERSTORE_MAX_USERS)
+	if err != nil {
+		t.Fatalf("cannot create new user store: %s", err)
+	}
+	err = userStore.Empty()
+	if err != nil {
+		t.Fatalf("cannot empty user store")
+	}
+	user1, err := userStore.AddUser(users.User{Login: "john@domain.inv"})
+	if err != nil {
+		t.Fatalf("cannot add user: %s", err)
+	}
+	user2, err := userStore.AddUser(users.User{Login: "jane@domain.inv"})
+	if err != nil {
+		t.Fatalf("cannot add user: %s", err)
+	}
+
+	fake := configmanagerclient.NewFake()
+	wireguard.UseConfigManagerClient(fake)
+	defer wireguard.UseConfigManagerClient(nil)
+
+	_, err = wireguard.CreateNewVPNConfig(storage)
+	if err != nil {
+		t.Fatalf("Cannot create vpn config: %s", err)
+	}
+	peerConfig1, err := wireguard.NewEmptyClientConfig(storage, user1.ID)
+	if err != nil {
+		t.Fatalf("NewEmptyClientConfig error: %s", err)
+	}
+	_, err = wireguard.NewEmptyClientConfig(storage, user1.ID)
+	if err != nil {
+		t.Fatalf("NewEmptyClientConfig error: %s", err)
+	}
+	peerConfig3, err := wireguard.NewEmptyClientConfig(storage, user2.ID)
+	if err != nil {
+		t.Fatalf("NewEmptyClientConfig error: %s", err)
+	}
+	fake.LivePeerStats = append(fake.LivePeerStats, wireguard.LivePeerStat{
+		PublicKey:         peerConfig3.PublicKey,
+		Endpoint:          "192.0.2.1:51820",
+		LastHandshakeTime: time.Now(),
+		ReceiveBytes:      10,
+		TransmitBytes:     20,
+	})
+
+	v := &VPN{Storage: storage, UserStore: userStore}
+
+	getInventory := func(query string) ConnectionInventoryResponse {
+		req := httptest.NewRequest("GET", "http://example.com/api/vpn/admin/connections?"+query, nil)
+		req = req.WithContext(context.WithValue(context.Background(), rest.CustomValue("user"), users.User{Login: "admin", Role: "admin"}))
+		w := httptest.NewRecorder()
+		v.adminConnectionsHandler(w, req)
+		resp := w.Result()
+		defer resp.Body.Close() //nolint:errcheck
+		if resp.StatusCode != http.StatusOK {
+			body, _ := io.ReadAll(resp.Body)
+			t.Fatalf("status code is not 200: %d: %s", resp.StatusCode, body)
+		}
+		var inventory ConnectionInventoryResponse
+		err := json.NewDecoder(resp.Body).Decode(&inventory)
+		if err != nil {
+			t.Fatalf("Could not decode output: %s", err)
+		}
+		return inventory
+	}
+
+	inventory := getInventory("sort=lastSeen")
+	if inventory.Total != 3 || !inventory.LiveDataAvailable {
+		t.Fatalf("unexpected inventory: %+v", inventory)
+	}
+	if inventory.Connections[0].ID != peerConfig3.ID || inventory.Connections[0].Status != CONNECTION_STATUS_ACTIVE || inventory.Connections[0].Login != user2.Login || inventory.Connections[0].TransmitBytes != 20 {
+		t.Fatalf("expected active connection to be first: %+v", inventory.Connections[0])
+	}
+
+	inventory = getInventory("user=" + user1.Login + "&limit=1&page=2")
+	if inventory.Total != 2 || len(inventory.Connections) != 1 || inventory.Connections[0].UserID != user1.ID {
+		t.Fatalf("unexpected filtered inventory: %+v", inventory)
+	}
+
+	inventory = getInventory("status=active")
+	if inventory.Total != 1 {
+		t.Fatalf("unexpected filtered inventory: %+v", inventory)
+	}
+
+	inventory = getInventory("address=" + strings.Split(peerConfig1.Address, "/")[0] + "/32")
+	if inventory.Total != 1 || inventory.Connections[0].ID != peerConfig1.ID {
+		t.Fatalf("unexpected filtered inventory: %+v", inventory)
+	}
+}
+
+func TestRestartVPNAndJobHandler(t *testing.T) {
+	storage := &memorystorage.MockMemoryStorage{}
+	fake := configmanagerclient.NewFake()
+	wireguard.UseConfigManagerClient(fake)
+	defer wireguard.UseConfigManagerClient(nil)
+
+	v := &VPN{Storage: storage}
+
+	req := httptest.NewRequest("POST", "http://example.com/api/vpn/setup/restart-vpn", nil)
+	w := httptest.NewRecorder()
+	v.restartVPNHandler(w, req)
+	resp := w.Result()
+	defer resp.Body.Close() //nolint:errcheck
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status code is not 200: %d", resp.StatusCode)
+	}
+	var jobResponse wireguard.JobResponse
+	err := json.NewDecoder(resp.Body).Decode(&jobResponse)
+	if err != nil {
+		t.Fatalf("Could not decode output: %s", err)
+	}
+	if fake.CallCount("POST /restart-vpn") != 1 {
+		t.Fatalf("expected restart call to the configmanager")
+	}
+
+	req = httptest.NewRequest("GET", "http://example.com/api/vpn/jobs/"+jobResponse.JobID, nil)
+	req.SetPathValue("id", jobResponse.JobID)
+	w = httptest.NewRecorder()
+	v.jobHandler(w, req)
+	resp = w.Result()
+	defer resp.Body.Close() //nolint:errcheck
+	var job wireguard.Job
+	err = json.NewDecoder(resp.Body).Decode(&job)
+	if err != nil {
+		t.Fatalf("Could not decode output: %s", err)
+	}
+	if job.Status != wireguard.JOB_STATUS_SUCCEEDED || job.Action != "restart-vpn" {
+		t.Fatalf("unexpected job: %+v", job)
+	}
+
+	unknownJobID := strings.Repeat("f", 32)
+	req = httptest.NewRequest("GET", "http://example.com/api/vpn/jobs/"+unknownJobID, nil)
+	req.SetPathValue("id", unknownJobID)
+	w = httptest.NewRecorder()
+	v.jobHandler(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for unknown job, got: %d", w.Code)
+	}
+
+	fake.Err = fmt.Errorf("configmanager unavailable")
+	w = httptest.NewRecorder()
+	v.restartVPNHandler(w, httptest.NewRequest("POST", "http://example.com/api/vpn/setup/restart-vpn", nil))
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "configmanager unavailable") {
+		t.Fatalf("expected restart error, got: %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestConnectionMetadata(t *testing.T) {
+	storage := &memorystorage.MockMemoryStorage{}
+	userStore, err := users.NewUserStore(storage, USERSTORE_MAX_USERS)
+	if err != nil {
+		t.Fatalf("cannot create new user store: %s", err)
+	}
+	user, err := userStore.AddUser(users.User{Login: "john@domain.inv"})
+	if err != nil {
+		t.Fatalf("cannot add user: %s", err)
+	}
+	wireguard.UseConfigManagerClient(configmanagerclient.NewFake())
+	defer wireguard.UseConfigManagerClient(nil)
+
+	_, err = wireguard.CreateNewVPNConfig(storage)
+	if err != nil {
+		t.Fatalf("Cannot create vpn config: %s", err)
+	}
+	peerConfig1, err := wireguard.NewEmptyClientConfig(storage, user.ID)
+	if err != nil {
+		t.Fatalf("NewEmptyClientConfig error: %s", err)
+	}
+	_, err = wireguard.NewEmptyClientConfig(storage, user.ID)
+	if err != nil {
+		t.Fatalf("NewEmptyClientConfig error: %s", err)
+	}
+
+	v := &VPN{Storage: storage, UserStore: userStore}
+	patch := func(id, body string) *http.Response {
+		req := httptest.NewRequest("PATCH", "http://example.com/api/vpn/connection/"+id, strings.NewReader(body))
+		req.SetPathValue("id", id)
+		req = req.WithContext(context.WithValue(context.Background(), rest.CustomValue("user"), user))
+		w := httptest.NewRecorder()
+		v.connectionsElementHandler(w, req)
+		return w.Result()
+	}
+
+	resp := patch(peerConfig1.ID, `{"name": "work laptop", "description": "Thinkpad", "platform": "linux", "labels": ["work", "work", " office "]}`)
+	defer resp.Body.Close() //nolint:errcheck
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		t.Fatalf("status code is not 200: %d: %s", resp.StatusCode, body)
+	}
+	var connection Connection
+	err = json.NewDecoder(resp.Body).Decode(&connection)
+	if err != nil {
+		t.Fatalf("Could not decode output: %s", err)
+	}
+	if connection.Name != "work laptop" || connection.Description != "Thinkpad" || connection.Platform != wireguard.PLATFORM_LINUX || strings.Join(connection.Labels, ",") != "work,office" {
+		t.Fatalf("unexpected connection: %+v", connection)
+	}
+
+	// fields that are not set are left unchanged
+	resp = patch(peerConfig1.ID, `{"description": ""}`)
+	defer resp.Body.Close() //nolint:errcheck
+	connection = Connection{}
+	err = json.NewDecoder(resp.Body).Decode(&connection)
+	if err != nil {
+		t.Fatalf("Could not decode output: %s", err)
+	}
+	if connection.Name != "work laptop" || connection.Description != "" || connection.Platform != wireguard.PLATFORM_LINUX {
+		t.Fatalf("unexpected connection: %+v", connection)
+	}
+
+	for _, body := range []string{`{"platform": "beos"}`, `{"name": ""}`, `{"name": "a\nPostUp = id"}`} {
+		resp = patch(peerConfig1.ID, body)
+		defer resp.Body.Close() //nolint:errcheck
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("expected bad request for %s, got: %d", body, resp.StatusCode)
+		}
+	}
+	resp = patch("other-user-1", `{"name": "mine"}`)
+	defer resp.Body.Close() //nolint:errcheck
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected bad request for a connection of another user, got: %d", resp.StatusCode)
+	}
+
+	for query, expectedTotal := range map[string]int{"platform=linux": 1, "platform=ios": 0, "label=OFFICE": 1, "search=laptop": 1, "search=connection": 1, "search=john": 2} {
+		req := httptest.NewRequest("GET", "http://example.com/api/vpn/admin/connections?"+query, nil)
+		req = req.WithContext(context.WithValue(context.Background(), rest.CustomValue("user"), users.User{Login: "admin", Role: "admin"}))
+		w := httptest.NewRecorder()
+		v.adminConnectionsHandler(w, req)
+		var inventory ConnectionInventoryResponse
+		err = json.NewDecoder(w.Result().Body).Decode(&inventory)
+		if err != nil {
+			t.Fatalf("Could not decode output: %s", err)
+		}
+		if inventory.Total != expectedTotal {
+			t.Fatalf("expected %d connections for %s, got: %d", expectedTotal, query, inventory.Total)
+		}
+	}
+}
+
+func TestConnectionPolicy(t *testing.T) {
+	storage := &memorystorage.MockMemoryStorage{}
+	userStore, err := users.NewUserStore(storage, USERSTORE_MAX_USERS)
+	if err != nil {
+		t.Fatalf("cannot create new user store: %s", err)
+	}
+	user, err := userStore.AddUser(users.User{Login: "john@domain.inv"})
+	if err != nil {
+		t.Fatalf("cannot add user: %s", err)
+	}
+	wireguard.UseConfigManagerClient(configmanagerclient.NewFake())
+	defer wireguard.UseConfigManagerClient(nil)
+
+	_, err = wireguard.CreateNewVPNConfig(storage)
+	if err != nil {
+		t.Fatalf("Cannot create vpn config: %s", err)
+	}
+
+	v := &VPN{Storage: storage, UserStore: userStore}
+	adminUser := users.User{ID: "admin-id", Login: "admin", Role: "admin"}
+	adminRequest := func(method, url, body string) *http.Request {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		return req.WithContext(context.WithValue(context.Background(), rest.CustomValue("user"), adminUser))
+	}
+	setPolicy := func(body string) int {
+		w := httptest.NewRecorder()
+		v.adminConnectionPolicyHandler(w, adminRequest("PUT", "http://example.com/api/vpn/admin/connectionpolicy", body))
+		return w.Result().StatusCode
+	}
+	newConnection := func() int {
+		req := httptest.NewRequest("POST", "http://example.com/api/vpn/connections", nil)
+		req = req.WithContext(context.WithValue(context.Background(), rest.CustomValue("user"), user))
+		w := httptest.NewRecorder()
+		v.connectionsHandler(w, req)
+		return w.Result().StatusCode
+	}
+	connectionLicense := func() ConnectionLicenseResponse {
+		ctx := context.WithValue(context.Background(), rest.CustomValue("user"), user)
+		ctx = context.WithValue(ctx, rest.CustomValue("licenseUserCount"), 3)
+		req := httptest.NewRequest("GET", "http://example.com/api/vpn/connectionlicense", nil).WithContext(ctx)
+		w := httptest.NewRecorder()
+		v.connectionLicenseHandler(w, req)
+		var connectionLicenseResponse ConnectionLicenseResponse
+		err := json.NewDecoder(w.Result().Body).Decode(&connectionLicenseResponse)
+		if err != nil {
+			t.Fatalf("Could not decode output: %s", err)
+		}
+		return connectionLicenseResponse
+	}
+
+	if status := setPolicy(`{"userLimits": {"unknown-user": 1}}`); status != http.StatusBadRequest {
+		t.Fatalf("expected bad request for unknown user, got: %d", status)
+	}
+	if status := setPolicy(`{"groupLimits": {"unknown-group": 1}}`); status != http.StatusBadRequest {
+		t.Fatalf("expected bad request for unknown group, got: %d", status)
+	}
+	if status := setPolicy(`{"groupLimits": {"": 1}}`); status != http.StatusBadRequest {
+		t.Fatalf("expected bad request for an empty group name, got: %d", status)
+	}
+	if status := setPolicy(fmt.Sprintf(`{"maxConnectionsPerUser": 5, "userLimits": {"%s": 1}}`, user.ID)); status != http.StatusOK {
+		t.Fatalf("status code is not 200: %d", status)
+	}
+	if connectionLicense := connectionLicense(); connectionLicense.ConnectionLimit != 1 || !connectionLicense.CanCreateConnections || connectionLicense.LicenseUserCount != 3 {
+		t.Fatalf("unexpected connection license: %+v", connectionLicense)
+	}
+	if status := newConnection(); status != http.StatusOK {
+		t.Fatalf("status code is not 200: %d", status)
+	}
+	if status := newConnection(); status != http.StatusConflict {
+		t.Fatalf("expected conflict when the limit is reached, got: %d", status)
+	}
+	if connectionLicense := connectionLicense(); connectionLicense.ConnectionCount != 1 || connectionLicense.CanCreateConnections {
+		t.Fatalf("unexpected connection license: %+v", connectionLicense)
+	}
+
+	if status := setPolicy(`{"adminOnly": true}`); status != http.StatusOK {
+		t.Fatalf("status code is not 200: %d", status)
+	}
+	if status := newConnection(); status != http.StatusForbidden {
+		t.Fatalf("expected forbidden when only admins can create connections, got: %d", status)
+	}
+	if connectionLicense := connectionLicense(); connectionLicense.ConnectionLimit != 0 || connectionLicense.CanCreateConnections {
+		t.Fatalf("unexpected connection license: %+v", connectionLicense)
+	}
+	req := adminRequest("POST", "http://example.com/api/vpn/admin/user/"+user.ID+"/connections", "")
+	req.SetPathValue("userID", user.ID)
+	w := httptest.NewRecorder()
+	v.adminUserConnectionsHandler(w, req)
+	resp := w.Result()
+	defer resp.Body.Close() //nolint:errcheck
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		t.Fatalf("status code is not 200: %d: %s", resp.StatusCode, body)
+	}
+	var connection Connection
+	err = json.NewDecoder(resp.Body).Decode(&connection)
+	if err != nil {
+		t.Fatalf("Could not decode output: %s", err)
+	}
+	if connection.ID != user.ID+"-2" {
+		t.Fatalf("unexpected connection: %+v", connection)
+	}
+}
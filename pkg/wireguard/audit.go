@@ -0,0 +1,77 @@
+package wireguard
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/user"
+	"path"
+	"time"
+
+	"github.com/in4it/go-devops-platform/storage"
+)
+
+func writeConnectionAuditEntry(storage storage.Iface, connectionID, action, actor string) error {
+	entry := ConnectionAuditEntry{
+		Timestamp:    time.Now().UTC(),
+		ConnectionID: connectionID,
+		Action:       action,
+		Actor:        actor,
+	}
+	out, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("audit entry marshal error: %s", err)
+	}
+	auditDir := storage.ConfigPath(VPN_AUDIT_DIR)
+	err = storage.EnsurePath(auditDir)
+	if err != nil {
+		return fmt.Errorf("could not ensure path exists %s: %s", VPN_AUDIT_DIR, err)
+	}
+	filename := storage.ConfigPath(path.Join(VPN_AUDIT_DIR, VPN_CONNECTIONS_AUDIT_LOG))
+	err = storage.AppendFile(filename, append(out, '\n'))
+	if err != nil {
+		return fmt.Errorf("could not write audit entry: %s", err)
+	}
+	currentUser, err := user.Current()
+	if err != nil {
+		return fmt.Errorf("could not get current user: %s", err)
+	}
+	if currentUser.Username != VPN_USER { // the audit log is created by the first writer, and needs to stay writable for the rest-server
+		for _, name := range []string{auditDir, filename} {
+			err = storage.EnsureOwnership(name, VPN_USER)
+			if err != nil {
+				return fmt.Errorf("could not ensure ownership of %s: %s", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// GetConnectionAuditLog returns the audit entries of a connection, or of all connections when connectionID is empty
+func GetConnectionAuditLog(storage storage.Iface, connectionID string) ([]ConnectionAuditEntry, error) {
+	entries := []ConnectionAuditEntry{}
+	filename := storage.ConfigPath(path.Join(VPN_AUDIT_DIR, VPN_CONNECTIONS_AUDIT_LOG))
+	if !storage.FileExists(filename) {
+		return entries, nil
+	}
+	data, err := storage.ReadFile(filename)
+	if err != nil {
+		return entries, fmt.Errorf("could not read audit log: %s", err)
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		var entry ConnectionAuditEntry
+		err = json.Unmarshal(scanner.Bytes(), &entry)
+		if err != nil {
+			return entries, fmt.Errorf("could not unmarshal audit entry: %s", err)
+		}
+		if connectionID == "" || entry.ConnectionID == connectionID {
+			entries = append(entries, entry)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return entries, fmt.Errorf("audit log scan error: %s", err)
+	}
+	return entries, nil
+}
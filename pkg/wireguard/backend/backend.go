@@ -0,0 +1,50 @@
+package wireguardbackend
+
+import (
+	"net/netip"
+
+	"github.com/gopacket/gopacket"
+	wireguardlinux "github.com/in4it/wireguard-server/pkg/wireguard/linux"
+)
+
+type Key = wireguardlinux.Key
+type Device = wireguardlinux.Device
+type Peer = wireguardlinux.Peer
+type PeerConfig = wireguardlinux.PeerConfig
+
+// InterfaceConfig is the desired state of the vpn interface
+type InterfaceConfig struct {
+	PrivateKey Key
+	ListenPort int
+	MTU        int
+	Addresses  []netip.Prefix
+}
+
+// Backend manages a WireGuard interface. Netlink configures the kernel module, Memory simulates the interface.
+type Backend interface {
+	// Device returns the device and its peers. The error wraps os.ErrNotExist when the interface doesn't exist.
+	Device(name string) (*Device, error)
+	// ApplyPeers adds, updates or removes (PeerConfig.Remove) peers of the device
+	ApplyPeers(name string, peers []PeerConfig) error
+	SetPrivateKey(name string, privateKey Key) error
+	// StartInterface creates the interface when it doesn't exist, and corrects the parts of the configuration that differ.
+	// It returns true when the interface was created.
+	StartInterface(name string, config InterfaceConfig) (bool, error)
+	StopInterface(name string) error
+	// PeerRoutes returns the routes to the networks behind network peers
+	PeerRoutes(name string) ([]netip.Prefix, error)
+	AddPeerRoute(name string, route netip.Prefix) error
+	DeletePeerRoute(name string, route netip.Prefix) error
+	PacketCapture
+}
+
+// PacketCapture opens a packet capture on an interface, for the packet logger
+type PacketCapture interface {
+	OpenCapture(name string) (PacketSource, error)
+}
+
+// PacketSource returns the captured packets. *pcap.Handle is a PacketSource.
+type PacketSource interface {
+	ReadPacketData() ([]byte, gopacket.CaptureInfo, error)
+	Close()
+}
@@ -0,0 +1,295 @@
+package wireguardbackend
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gopacket/gopacket"
+)
+
+const MEMORY_CAPTURE_BUFFER = 100
+
+// Memory is a WireGuard backend that keeps the interfaces in memory. Handshakes, traffic and captured packets
+// are simulated with Handshake, Transfer and InjectPacket.
+type Memory struct {
+	Now func() time.Time // clock of the simulated handshakes and packets
+
+	mutex      sync.Mutex
+	interfaces map[string]*memoryInterface
+}
+
+type memoryInterface struct {
+	device   Device
+	config   InterfaceConfig
+	routes   []netip.Prefix
+	captures []*memorySource
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		Now:        time.Now,
+		interfaces: make(map[string]*memoryInterface),
+	}
+}
+
+func (m *Memory) Device(name string) (*Device, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	iface, err := m.getInterface(name)
+	if err != nil {
+		return nil, err
+	}
+	device := iface.device
+	device.Peers = make([]Peer, len(iface.device.Peers))
+	for k, peer := range iface.device.Peers {
+		device.Peers[k] = peer
+		device.Peers[k].AllowedIPs = slices.Clone(peer.AllowedIPs)
+	}
+	return &device, nil
+}
+
+// ApplyPeers changes the peers like the kernel module does: an allowed ip moves to the last peer it was configured for
+func (m *Memory) ApplyPeers(name string, peers []PeerConfig) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	iface, err := m.getInterface(name)
+	if err != nil {
+		return err
+	}
+	for _, peerConfig := range peers {
+		index := slices.IndexFunc(iface.device.Peers, func(peer Peer) bool { return peer.PublicKey == peerConfig.PublicKey })
+		if peerConfig.Remove {
+			if index != -1 {
+				iface.device.Peers = slices.Delete(iface.device.Peers, index, index+1)
+			}
+			continue
+		}
+		if index == -1 {
+			if peerConfig.UpdateOnly {
+				continue
+			}
+			iface.device.Peers = append(iface.device.Peers, Peer{PublicKey: peerConfig.PublicKey})
+			index = len(iface.device.Peers) - 1
+		}
+		peer := &iface.device.Peers[index]
+		if peerConfig.PresharedKey != nil {
+			peer.PresharedKey = *peerConfig.PresharedKey
+		}
+		if peerConfig.Endpoint != nil {
+			peer.Endpoint = peerConfig.Endpoint
+		}
+		if peerConfig.PersistentKeepaliveInterval != nil {
+			peer.PersistentKeepaliveInterval = *peerConfig.PersistentKeepaliveInterval
+		}
+		if peerConfig.ReplaceAllowedIPs {
+			peer.AllowedIPs = nil
+		}
+		for _, allowedIP := range peerConfig.AllowedIPs {
+			for k := range iface.device.Peers {
+				iface.device.Peers[k].AllowedIPs = slices.DeleteFunc(iface.device.Peers[k].AllowedIPs, func(ipNet net.IPNet) bool {
+					return ipNet.String() == allowedIP.String()
+				})
+			}
+			peer.AllowedIPs = append(peer.AllowedIPs, allowedIP)
+		}
+	}
+	return nil
+}
+
+func (m *Memory) SetPrivateKey(name string, privateKey Key) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	iface, err := m.getInterface(name)
+	if err != nil {
+		return err
+	}
+	iface.device.PrivateKey = privateKey
+	iface.device.PublicKey = privateKey.PublicKey()
+	return nil
+}
+
+func (m *Memory) StartInterface(name string, config InterfaceConfig) (bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	iface, exists := m.interfaces[name]
+	if !exists {
+		iface = &memoryInterface{device: Device{Name: name, Peers: []Peer{}}}
+		m.interfaces[name] = iface
+	}
+	iface.config = config
+	iface.config.Addresses = slices.Clone(config.Addresses)
+	iface.device.PrivateKey = config.PrivateKey
+	iface.device.PublicKey = config.PrivateKey.PublicKey()
+	iface.device.ListenPort = config.ListenPort
+	return !exists, nil
+}
+
+// StopInterface removes the interface, together with its peers and routes. Open captures return io.EOF.
+func (m *Memory) StopInterface(name string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	iface, exists := m.interfaces[name]
+	if !exists {
+		return nil
+	}
+	for _, capture := range iface.captures {
+		capture.Close()
+	}
+	delete(m.interfaces, name)
+	return nil
+}
+
+// InterfaceConfig returns the configuration the interface was started with
+func (m *Memory) InterfaceConfig(name string) (InterfaceConfig, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	iface, err := m.getInterface(name)
+	if err != nil {
+		return InterfaceConfig{}, err
+	}
+	return iface.config, nil
+}
+
+func (m *Memory) PeerRoutes(name string) ([]netip.Prefix, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	iface, err := m.getInterface(name)
+	if err != nil {
+		return nil, err
+	}
+	return slices.Clone(iface.routes), nil
+}
+
+func (m *Memory) AddPeerRoute(name string, route netip.Prefix) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	iface, err := m.getInterface(name)
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(iface.routes, route) {
+		iface.routes = append(iface.routes, route)
+	}
+	return nil
+}
+
+func (m *Memory) DeletePeerRoute(name string, route netip.Prefix) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	iface, err := m.getInterface(name)
+	if err != nil {
+		return err
+	}
+	iface.routes = slices.DeleteFunc(iface.routes, func(installedRoute netip.Prefix) bool { return installedRoute == route })
+	return nil
+}
+
+func (m *Memory) OpenCapture(name string) (PacketSource, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	iface, err := m.getInterface(name)
+	if err != nil {
+		return nil, err
+	}
+	capture := &memorySource{packets: make(chan memoryPacket, MEMORY_CAPTURE_BUFFER), closed: make(chan struct{})}
+	iface.captures = append(iface.captures, capture)
+	return capture, nil
+}
+
+// Handshake simulates a handshake of a peer from an endpoint
+func (m *Memory) Handshake(name string, publicKey Key, endpoint *net.UDPAddr) error {
+	return m.updatePeer(name, publicKey, func(peer *Peer) {
+		peer.LastHandshakeTime = m.Now()
+		peer.Endpoint = endpoint
+	})
+}
+
+// Transfer simulates traffic of a peer by adding to its byte counters
+func (m *Memory) Transfer(name string, publicKey Key, receiveBytes, transmitBytes int64) error {
+	return m.updatePeer(name, publicKey, func(peer *Peer) {
+		peer.ReceiveBytes += receiveBytes
+		peer.TransmitBytes += transmitBytes
+	})
+}
+
+// InjectPacket passes a packet to the open captures of the interface. The packet is dropped when a capture isn't read fast enough.
+func (m *Memory) InjectPacket(name string, data []byte) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	iface, err := m.getInterface(name)
+	if err != nil {
+		return err
+	}
+	packet := memoryPacket{
+		data: slices.Clone(data),
+		captureInfo: gopacket.CaptureInfo{
+			Timestamp:     m.Now(),
+			CaptureLength: len(data),
+			Length:        len(data),
+		},
+	}
+	for _, capture := range iface.captures {
+		select {
+		case capture.packets <- packet:
+		default:
+		}
+	}
+	return nil
+}
+
+func (m *Memory) updatePeer(name string, publicKey Key, update func(peer *Peer)) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	iface, err := m.getInterface(name)
+	if err != nil {
+		return err
+	}
+	index := slices.IndexFunc(iface.device.Peers, func(peer Peer) bool { return peer.PublicKey == publicKey })
+	if index == -1 {
+		return fmt.Errorf("peer %s not found on %s", publicKey, name)
+	}
+	update(&iface.device.Peers[index])
+	return nil
+}
+
+func (m *Memory) getInterface(name string) (*memoryInterface, error) {
+	iface, exists := m.interfaces[name]
+	if !exists {
+		return nil, fmt.Errorf("interface %s not found: %w", name, os.ErrNotExist)
+	}
+	return iface, nil
+}
+
+type memoryPacket struct {
+	data        []byte
+	captureInfo gopacket.CaptureInfo
+}
+
+type memorySource struct {
+	packets   chan memoryPacket
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (s *memorySource) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	select {
+	case packet := <-s.packets:
+		return packet.data, packet.captureInfo, nil
+	case <-s.closed:
+		return nil, gopacket.CaptureInfo{}, io.EOF
+	}
+}
+
+func (s *memorySource) Close() {
+	s.closeOnce.Do(func() {
+		close(s.closed)
+	})
+}
+
+var _ Backend = &Memory{}
@@ -0,0 +1,136 @@
+package wireguardbackend
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestMemoryApplyPeers(t *testing.T) {
+	m := NewMemory()
+	_, err := m.Device("vpn")
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected not exist error, got: %v", err)
+	}
+
+	created, err := m.StartInterface("vpn", InterfaceConfig{PrivateKey: Key{1}, ListenPort: 51820, MTU: 1420, Addresses: []netip.Prefix{netip.MustParsePrefix("10.189.184.1/21")}})
+	if err != nil {
+		t.Fatalf("StartInterface error: %s", err)
+	}
+	if !created {
+		t.Fatalf("expected interface to be created")
+	}
+	created, err = m.StartInterface("vpn", InterfaceConfig{PrivateKey: Key{1}, ListenPort: 51821})
+	if err != nil || created {
+		t.Fatalf("expected existing interface to be updated (created: %v, error: %v)", created, err)
+	}
+
+	_, ipNet1, _ := net.ParseCIDR("10.189.184.2/32")
+	_, ipNet2, _ := net.ParseCIDR("10.189.184.3/32")
+	presharedKey := Key{9}
+	err = m.ApplyPeers("vpn", []PeerConfig{
+		{PublicKey: Key{2}, PresharedKey: &presharedKey, AllowedIPs: []net.IPNet{*ipNet1}},
+		{PublicKey: Key{3}, AllowedIPs: []net.IPNet{*ipNet2}},
+		{PublicKey: Key{4}, UpdateOnly: true},
+	})
+	if err != nil {
+		t.Fatalf("ApplyPeers error: %s", err)
+	}
+	// the allowed ip moves from peer 3 to peer 2, and peer 3 is removed
+	err = m.ApplyPeers("vpn", []PeerConfig{
+		{PublicKey: Key{2}, AllowedIPs: []net.IPNet{*ipNet2}},
+		{PublicKey: Key{3}, Remove: true},
+	})
+	if err != nil {
+		t.Fatalf("ApplyPeers error: %s", err)
+	}
+
+	device, err := m.Device("vpn")
+	if err != nil {
+		t.Fatalf("Device error: %s", err)
+	}
+	if device.ListenPort != 51821 || device.PublicKey != (Key{1}).PublicKey() {
+		t.Fatalf("unexpected device: %+v", device)
+	}
+	if len(device.Peers) != 1 {
+		t.Fatalf("expected 1 peer, got: %d", len(device.Peers))
+	}
+	if device.Peers[0].PresharedKey != presharedKey || len(device.Peers[0].AllowedIPs) != 2 {
+		t.Fatalf("unexpected peer: %+v", device.Peers[0])
+	}
+	device.Peers[0].AllowedIPs[0] = *ipNet2 // the returned device is a copy
+	device, _ = m.Device("vpn")
+	if device.Peers[0].AllowedIPs[0].String() != ipNet1.String() {
+		t.Fatalf("device was modified through a returned copy")
+	}
+}
+
+func TestMemorySimulation(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	m := NewMemory()
+	m.Now = func() time.Time { return now }
+	_, err := m.StartInterface("vpn", InterfaceConfig{PrivateKey: Key{1}})
+	if err != nil {
+		t.Fatalf("StartInterface error: %s", err)
+	}
+	err = m.ApplyPeers("vpn", []PeerConfig{{PublicKey: Key{2}}})
+	if err != nil {
+		t.Fatalf("ApplyPeers error: %s", err)
+	}
+
+	endpoint := &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 12345}
+	err = m.Handshake("vpn", Key{2}, endpoint)
+	if err != nil {
+		t.Fatalf("Handshake error: %s", err)
+	}
+	for range 2 {
+		err = m.Transfer("vpn", Key{2}, 100, 200)
+		if err != nil {
+			t.Fatalf("Transfer error: %s", err)
+		}
+	}
+	if m.Transfer("vpn", Key{3}, 1, 1) == nil {
+		t.Fatalf("expected error for unknown peer")
+	}
+	device, err := m.Device("vpn")
+	if err != nil {
+		t.Fatalf("Device error: %s", err)
+	}
+	peer := device.Peers[0]
+	if !peer.LastHandshakeTime.Equal(now) || peer.Endpoint.String() != "192.0.2.1:12345" || peer.ReceiveBytes != 200 || peer.TransmitBytes != 400 {
+		t.Fatalf("unexpected peer: %+v", peer)
+	}
+
+	capture, err := m.OpenCapture("vpn")
+	if err != nil {
+		t.Fatalf("OpenCapture error: %s", err)
+	}
+	err = m.InjectPacket("vpn", []byte{1, 2, 3})
+	if err != nil {
+		t.Fatalf("InjectPacket error: %s", err)
+	}
+	data, captureInfo, err := capture.ReadPacketData()
+	if err != nil {
+		t.Fatalf("ReadPacketData error: %s", err)
+	}
+	if len(data) != 3 || captureInfo.Length != 3 || !captureInfo.Timestamp.Equal(now) {
+		t.Fatalf("unexpected packet: %v (%+v)", data, captureInfo)
+	}
+
+	err = m.StopInterface("vpn")
+	if err != nil {
+		t.Fatalf("StopInterface error: %s", err)
+	}
+	_, _, err = capture.ReadPacketData()
+	if err != io.EOF {
+		t.Fatalf("expected EOF after the interface is removed, got: %v", err)
+	}
+	_, err = m.Device("vpn")
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected not exist error, got: %v", err)
+	}
+}
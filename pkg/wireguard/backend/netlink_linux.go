@@ -0,0 +1,170 @@
+//go:build linux
+
+package wireguardbackend
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+
+	wireguardlinux "github.com/in4it/wireguard-server/pkg/wireguard/linux"
+	pcap "github.com/packetcap/go-pcap"
+)
+
+// routes to the networks behind network peers are installed with a dedicated protocol number, so they can be told apart from other routes
+const peerRouteProtocol = 167
+
+// Netlink configures the WireGuard kernel module through generic netlink, and the interface through rtnetlink
+type Netlink struct{}
+
+func New() Backend {
+	return &Netlink{}
+}
+
+func (n *Netlink) Device(name string) (*Device, error) {
+	c, err := newClient()
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close() //nolint:errcheck
+	device, err := c.Device(name)
+	if err != nil {
+		return nil, fmt.Errorf("wireguard linux device '%s' not found: %w", name, err)
+	}
+	return device, nil
+}
+
+func (n *Netlink) ApplyPeers(name string, peers []PeerConfig) error {
+	return n.configureDevice(name, wireguardlinux.Config{Peers: peers})
+}
+
+func (n *Netlink) SetPrivateKey(name string, privateKey Key) error {
+	return n.configureDevice(name, wireguardlinux.Config{PrivateKey: &privateKey})
+}
+
+func (n *Netlink) StartInterface(name string, config InterfaceConfig) (bool, error) {
+	rtnl, err := wireguardlinux.NewRTNL()
+	if err != nil {
+		return false, err
+	}
+	defer rtnl.Close() //nolint:errcheck
+
+	index, created, err := rtnl.EnsureLink(name)
+	if err != nil {
+		return created, err
+	}
+	err = rtnl.SetLinkMTU(index, config.MTU)
+	if err != nil {
+		return created, err
+	}
+	err = syncInterfaceAddresses(rtnl, index, config.Addresses)
+	if err != nil {
+		return created, err
+	}
+	err = n.configureDevice(name, wireguardlinux.Config{PrivateKey: &config.PrivateKey, ListenPort: &config.ListenPort})
+	if err != nil {
+		return created, &wireguardlinux.InterfaceError{Op: "configure device", Interface: name, Err: err}
+	}
+	return created, rtnl.SetLinkUp(index)
+}
+
+func (n *Netlink) StopInterface(name string) error {
+	rtnl, err := wireguardlinux.NewRTNL()
+	if err != nil {
+		return err
+	}
+	defer rtnl.Close() //nolint:errcheck
+	return rtnl.DeleteLink(name)
+}
+
+func (n *Netlink) PeerRoutes(name string) ([]netip.Prefix, error) {
+	rtnl, index, err := newRTNL(name)
+	if err != nil {
+		return nil, err
+	}
+	defer rtnl.Close() //nolint:errcheck
+	return rtnl.Routes(index, peerRouteProtocol)
+}
+
+func (n *Netlink) AddPeerRoute(name string, route netip.Prefix) error {
+	rtnl, index, err := newRTNL(name)
+	if err != nil {
+		return err
+	}
+	defer rtnl.Close() //nolint:errcheck
+	return rtnl.ReplaceRoute(index, route, peerRouteProtocol)
+}
+
+func (n *Netlink) DeletePeerRoute(name string, route netip.Prefix) error {
+	rtnl, index, err := newRTNL(name)
+	if err != nil {
+		return err
+	}
+	defer rtnl.Close() //nolint:errcheck
+	return rtnl.DeleteRoute(index, route, peerRouteProtocol)
+}
+
+func (n *Netlink) OpenCapture(name string) (PacketSource, error) {
+	return pcap.OpenLive(context.Background(), name, 1600, false, 0, false)
+}
+
+func (n *Netlink) configureDevice(name string, config wireguardlinux.Config) error {
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+	defer c.Close() //nolint:errcheck
+	return c.ConfigureDevice(name, config)
+}
+
+func newClient() (*wireguardlinux.Client, error) {
+	c, available, err := wireguardlinux.New()
+	if err != nil {
+		return nil, fmt.Errorf("cannot start wireguardlinux client: %s", err)
+	}
+	if !available {
+		return nil, fmt.Errorf("wireguard linux client not available")
+	}
+	return c, nil
+}
+
+func newRTNL(name string) (*wireguardlinux.RTNLClient, int, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, 0, fmt.Errorf("interface %s not found: %s", name, err)
+	}
+	rtnl, err := wireguardlinux.NewRTNL()
+	if err != nil {
+		return nil, 0, err
+	}
+	return rtnl, iface.Index, nil
+}
+
+// syncInterfaceAddresses adds the missing addresses, and removes the addresses that are not in the vpn config anymore
+func syncInterfaceAddresses(rtnl *wireguardlinux.RTNLClient, index int, addresses []netip.Prefix) error {
+	currentAddresses, err := rtnl.Addresses(index)
+	if err != nil {
+		return err
+	}
+	for _, address := range addresses {
+		if !slices.Contains(currentAddresses, address) {
+			err = rtnl.AddAddress(index, address)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	for _, currentAddress := range currentAddresses {
+		if !slices.Contains(addresses, currentAddress) && !currentAddress.Addr().IsLinkLocalUnicast() {
+			err = rtnl.DeleteAddress(index, currentAddress)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+var _ Backend = &Netlink{}
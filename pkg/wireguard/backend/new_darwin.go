@@ -0,0 +1,8 @@
+//go:build darwin
+
+package wireguardbackend
+
+// New returns a simulated interface, as darwin has no WireGuard kernel module
+func New() Backend {
+	return NewMemory()
+}
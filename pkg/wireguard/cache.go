@@ -6,15 +6,26 @@ import (
 )
 
 func UpdateClientCache(peerConfig PeerConfig, clientCache *ClientCache) error {
-	_, peerConfigAddressParsed, err := net.ParseCIDR(peerConfig.Address)
+	for _, address := range getPeerConfigAddresses(peerConfig) {
+		err := updateClientCacheAddress(peerConfig, address, clientCache)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func updateClientCacheAddress(peerConfig PeerConfig, address string, clientCache *ClientCache) error {
+	_, peerConfigAddressParsed, err := net.ParseCIDR(address)
 	if err != nil {
 		return fmt.Errorf("cannot parse peerConfig's address: %s", err)
 	}
 	found := false
 	for k, addressesItem := range clientCache.Addresses {
-		if addressesItem.ClientID == peerConfig.ID {
+		sameFamily := (addressesItem.Address.IP.To4() == nil) == (peerConfigAddressParsed.IP.To4() == nil)
+		if addressesItem.ClientID == peerConfig.ID && sameFamily {
 			found = true
-			if addressesItem.Address.String() != peerConfig.Address {
+			if addressesItem.Address.String() != address {
 				clientCache.Addresses[k].Address = *peerConfigAddressParsed
 				return nil
 			}
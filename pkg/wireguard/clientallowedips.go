@@ -3,40 +3,63 @@ package wireguard
 import (
 	"fmt"
 	"net"
+	"net/netip"
+	"slices"
 )
 
-func getClientAllowedIPs(addressRange string, clientRoutes, nameservers []string) ([]string, error) {
+// getClientAllowedIPs returns the AllowedIPs of a client: the client routes without the excluded routes,
+// the vpn address ranges and the nameservers
+func getClientAllowedIPs(addressRanges []string, clientRoutes, excludedRoutes, nameservers []string) ([]string, error) {
 	clientAllowedIPs := []string{}
 
-	_, clientAddressRange, err := net.ParseCIDR(addressRange)
-	if err != nil {
-		return clientAllowedIPs, fmt.Errorf("could not parse client address range (%s): %s", addressRange, err)
+	if len(excludedRoutes) > 0 {
+		var err error
+		clientRoutes, err = excludeRoutes(clientRoutes, excludedRoutes)
+		if err != nil {
+			return clientAllowedIPs, err
+		}
+	}
+
+	clientAddressRanges := []*net.IPNet{}
+	for _, addressRange := range addressRanges {
+		_, clientAddressRange, err := net.ParseCIDR(addressRange)
+		if err != nil {
+			return clientAllowedIPs, fmt.Errorf("could not parse client address range (%s): %s", addressRange, err)
+		}
+		clientAddressRanges = append(clientAddressRanges, clientAddressRange)
 	}
 
-	clientAddressRangeIntersects := false
 	if len(clientRoutes) > 0 {
-		for _, network := range clientRoutes {
-			//networkIntersects
-			_, ipnet, err := net.ParseCIDR(network)
-			if err == nil {
-				if networkIntersects(ipnet, clientAddressRange) {
-					clientAddressRangeIntersects = true
+		clientAllowedIPs = append(clientAllowedIPs, clientRoutes...)
+		for _, clientAddressRange := range clientAddressRanges {
+			clientAddressRangeIntersects := false
+			for _, network := range clientRoutes {
+				//networkIntersects
+				_, ipnet, err := net.ParseCIDR(network)
+				if err == nil {
+					if networkIntersects(ipnet, clientAddressRange) {
+						clientAddressRangeIntersects = true
+					}
 				}
 			}
+			if !clientAddressRangeIntersects {
+				clientAllowedIPs = append(clientAllowedIPs, clientAddressRange.String())
+			}
 		}
-		if clientAddressRangeIntersects {
-			clientAllowedIPs = clientRoutes
-		} else {
-			clientAllowedIPs = append(clientAllowedIPs, clientRoutes...)
+	} else {
+		for _, clientAddressRange := range clientAddressRanges {
 			clientAllowedIPs = append(clientAllowedIPs, clientAddressRange.String())
 		}
-	} else {
-		clientAllowedIPs = []string{clientAddressRange.String()}
 	}
 	// add nameserver
 	for _, nameserver := range nameservers {
 		interSects := false
-		_, nameserverIPNet, err := net.ParseCIDR(nameserver + "/32")
+		nameserverAddr, err := netip.ParseAddr(nameserver)
+		if err != nil {
+			continue
+		}
+		nameserverPrefix := netip.PrefixFrom(nameserverAddr, nameserverAddr.BitLen()).String() // /32 or /128
+		_, nameserverIPNet, err := net.ParseCIDR(nameserverPrefix)
 		if err == nil {
 			for _, clientAllowedIPString := range clientAllowedIPs {
 				_, clientAllowedIP, err2 := net.ParseCIDR(clientAllowedIPString)
@@ -48,8 +71,122 @@ func getClientAllowedIPs(addressRange string, clientRoutes, nameservers []string
 			}
 		}
 		if !interSects {
-			clientAllowedIPs = append(clientAllowedIPs, nameserver+"/32")
+			clientAllowedIPs = append(clientAllowedIPs, nameserverPrefix)
 		}
 	}
 	return clientAllowedIPs, nil
 }
+
+// getClientAddressRanges returns the address of the vpn server with the client address prefix, for every address family
+func getClientAddressRanges(vpnConfig VPNConfig) []string {
+	addressRanges := []string{vpnConfig.AddressRange.Addr().String() + vpnConfig.ClientAddressPrefix}
+	if vpnConfig.AddressRangeIPv6.IsValid() {
+		addressRanges = append(addressRanges, vpnConfig.AddressRangeIPv6.Addr().String()+vpnConfig.ClientAddressPrefixIPv6)
+	}
+	return addressRanges
+}
+
+// appendUncoveredRoutes adds the routes to the allowed ips, unless the route is already covered by one of the allowed ips
+func appendUncoveredRoutes(allowedIPs, routes []string) []string {
+	out := slices.Clone(allowedIPs)
+	for _, route := range routes {
+		prefix, err := netip.ParsePrefix(route)
+		if err != nil {
+			continue
+		}
+		covered := false
+		for _, allowedIP := range out {
+			allowedIPPrefix, err := netip.ParsePrefix(allowedIP)
+			if err == nil && allowedIPPrefix.Bits() <= prefix.Bits() && allowedIPPrefix.Masked().Contains(prefix.Addr()) {
+				covered = true
+			}
+		}
+		if !covered {
+			out = append(out, prefix.Masked().String())
+		}
+	}
+	return out
+}
+
+// excludeRoutes returns the minimal list of networks that covers the routes, without the excluded routes
+func excludeRoutes(routes, excludedRoutes []string) ([]string, error) {
+	prefixes := make([]netip.Prefix, len(routes))
+	for k, route := range routes {
+		prefix, err := netip.ParsePrefix(route)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse client route (%s): %s", route, err)
+		}
+		prefixes[k] = prefix.Masked()
+	}
+	excludes := make([]netip.Prefix, len(excludedRoutes))
+	for k, excludedRoute := range excludedRoutes {
+		prefix, err := netip.ParsePrefix(excludedRoute)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse excluded route (%s): %s", excludedRoute, err)
+		}
+		excludes[k] = prefix.Masked()
+	}
+	remaining := prefixes
+	for _, exclude := range excludes {
+		next := []netip.Prefix{}
+		for _, prefix := range remaining {
+			next = append(next, subtractPrefix(prefix, exclude)...)
+		}
+		remaining = next
+	}
+	out := []string{}
+	for _, prefix := range aggregatePrefixes(remaining) {
+		out = append(out, prefix.String())
+	}
+	return out, nil
+}
+
+// subtractPrefix splits the prefix in halves until the halves don't overlap with the excluded prefix anymore
+func subtractPrefix(prefix, exclude netip.Prefix) []netip.Prefix {
+	if !prefix.Overlaps(exclude) {
+		return []netip.Prefix{prefix}
+	}
+	if exclude.Bits() <= prefix.Bits() { // prefix is excluded completely
+		return []netip.Prefix{}
+	}
+	lower := netip.PrefixFrom(prefix.Addr(), prefix.Bits()+1)
+	upper := netip.PrefixFrom(setAddrBit(prefix.Addr(), prefix.Bits()), prefix.Bits()+1)
+	return append(subtractPrefix(lower, exclude), subtractPrefix(upper, exclude)...)
+}
+
+// aggregatePrefixes sorts the prefixes, removes prefixes that are covered by another prefix, and merges adjacent halves
+func aggregatePrefixes(prefixes []netip.Prefix) []netip.Prefix {
+	sorted := slices.Clone(prefixes)
+	slices.SortFunc(sorted, func(a, b netip.Prefix) int {
+		if c := a.Addr().Compare(b.Addr()); c != 0 {
+			return c
+		}
+		return a.Bits() - b.Bits()
+	})
+	out := []netip.Prefix{}
+	for _, prefix := range sorted {
+		if len(out) > 0 && out[len(out)-1].Contains(prefix.Addr()) && out[len(out)-1].Bits() <= prefix.Bits() {
+			continue
+		}
+		out = append(out, prefix)
+		for len(out) > 1 {
+			lower, upper := out[len(out)-2], out[len(out)-1]
+			if lower.Bits() != upper.Bits() || lower.Bits() == 0 {
+				break
+			}
+			parent := netip.PrefixFrom(lower.Addr(), lower.Bits()-1).Masked()
+			if parent.Addr() != lower.Addr() || setAddrBit(parent.Addr(), parent.Bits()) != upper.Addr() {
+				break
+			}
+			out = append(out[:len(out)-2], parent)
+		}
+	}
+	return out
+}
+
+func setAddrBit(addr netip.Addr, bit int) netip.Addr {
+	b := addr.AsSlice()
+	b[bit/8] |= 0x80 >> (bit % 8)
+	out, _ := netip.AddrFromSlice(b)
+	return out
+}
@@ -0,0 +1,253 @@
+package wireguard
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"text/template"
+
+	"github.com/in4it/go-devops-platform/storage"
+	"github.com/in4it/wireguard-server/pkg/wireguard/qrcode"
+)
+
+type clientConfigFormat struct {
+	ContentType string
+	Extension   string
+}
+
+var clientConfigFormats = map[string]clientConfigFormat{
+	CLIENT_CONFIG_FORMAT_CONF:         {ContentType: "text/plain", Extension: "conf"},
+	CLIENT_CONFIG_FORMAT_PNG:          {ContentType: "image/png", Extension: "png"},
+	CLIENT_CONFIG_FORMAT_SVG:          {ContentType: "image/svg+xml", Extension: "svg"},
+	CLIENT_CONFIG_FORMAT_MOBILECONFIG: {ContentType: "application/x-apple-aspen-config", Extension: "mobileconfig"},
+	CLIENT_CONFIG_FORMAT_NMCONNECTION: {ContentType: "text/plain", Extension: "nmconnection"},
+	CLIENT_CONFIG_FORMAT_JSON:         {ContentType: "application/json", Extension: "json"},
+}
+
+// GetClientConfigFormat returns the content type and file extension of a client config format
+func GetClientConfigFormat(format string) (string, string, error) {
+	clientConfigFormat, ok := clientConfigFormats[format]
+	if !ok {
+		return "", "", fmt.Errorf("unsupported client config format: %s", format)
+	}
+	return clientConfigFormat.ContentType, clientConfigFormat.Extension, nil
+}
+
+func renderClientConfig(storage storage.Iface, vpnClientData VPNClientData, format string) ([]byte, error) {
+	switch format {
+	case CLIENT_CONFIG_FORMAT_CONF:
+		return renderClientTemplate(storage, vpnClientData)
+	case CLIENT_CONFIG_FORMAT_PNG, CLIENT_CONFIG_FORMAT_SVG:
+		config, err := renderClientTemplate(storage, vpnClientData)
+		if err != nil {
+			return nil, err
+		}
+		qr, err := qrcode.Encode(config)
+		if err != nil {
+			return nil, fmt.Errorf("could not generate qr code: %s", err)
+		}
+		if format == CLIENT_CONFIG_FORMAT_SVG {
+			return qr.SVG(), nil
+		}
+		return qr.PNG(QR_CODE_PNG_SCALE)
+	case CLIENT_CONFIG_FORMAT_MOBILECONFIG:
+		config, err := renderClientTemplate(storage, vpnClientData)
+		if err != nil {
+			return nil, err
+		}
+		return renderAppleConfigurationProfile(vpnClientData, config)
+	case CLIENT_CONFIG_FORMAT_NMCONNECTION:
+		return renderNetworkManagerKeyfile(vpnClientData)
+	case CLIENT_CONFIG_FORMAT_JSON:
+		out, err := json.MarshalIndent(vpnClientData, "", "  ")
+		if err != nil {
+			return nil, fmt.Errorf("could not marshal client config: %s", err)
+		}
+		return out, nil
+	default:
+		return nil, fmt.Errorf("unsupported client config format: %s", format)
+	}
+}
+
+// renderClientTemplate renders the (customizable) wg-quick client template
+func renderClientTemplate(storage storage.Iface, vpnClientData VPNClientData) ([]byte, error) {
+	templatefileContents, err := GetClientTemplate(storage)
+	if err != nil {
+		return nil, fmt.Errorf("could not get client template: %s", err)
+	}
+
+	tmpl, err := template.New("client.tmpl").Funcs(template.FuncMap{"StringsJoin": strings.Join}).Parse(string(templatefileContents))
+	if err != nil {
+		return nil, fmt.Errorf("could not parse client template: %s", err)
+	}
+	out := bytes.NewBuffer([]byte{})
+	err = tmpl.Execute(out, vpnClientData)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse client template (execute parsing): %s", err)
+	}
+	return out.Bytes(), nil
+}
+
+// renderAppleConfigurationProfile wraps the wg-quick config in a configuration profile for the WireGuard app on macOS
+func renderAppleConfigurationProfile(vpnClientData VPNClientData, config []byte) ([]byte, error) {
+	remoteAddress, _, err := net.SplitHostPort(vpnClientData.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse endpoint: %s", err)
+	}
+	identifier := APPLE_PROFILE_IDENTIFIER_PREFIX + "." + vpnClientData.ID
+	values := []any{
+		xmlEscape(vpnClientData.Name),
+		xmlEscape(identifier),
+		nameBasedUUID(identifier + "." + vpnClientData.ServerPublicKey),
+		xmlEscape(vpnClientData.Name),
+		xmlEscape(identifier + ".vpn"),
+		nameBasedUUID(identifier + ".vpn." + vpnClientData.ServerPublicKey),
+		xmlEscape(vpnClientData.Name),
+		xmlEscape(string(config)),
+		xmlEscape(remoteAddress),
+	}
+	return fmt.Appendf(nil, `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>PayloadDisplayName</key>
+	<string>%s</string>
+	<key>PayloadType</key>
+	<string>Configuration</string>
+	<key>PayloadVersion</key>
+	<integer>1</integer>
+	<key>PayloadIdentifier</key>
+	<string>%s</string>
+	<key>PayloadUUID</key>
+	<string>%s</string>
+	<key>PayloadContent</key>
+	<array>
+		<dict>
+			<key>PayloadDisplayName</key>
+			<string>%s</string>
+			<key>PayloadType</key>
+			<string>com.apple.vpn.managed</string>
+			<key>PayloadVersion</key>
+			<integer>1</integer>
+			<key>PayloadIdentifier</key>
+			<string>%s</string>
+			<key>PayloadUUID</key>
+			<string>%s</string>
+			<key>UserDefinedName</key>
+			<string>%s</string>
+			<key>VPNType</key>
+			<string>VPN</string>
+			<key>VPNSubType</key>
+			<string>com.wireguard.macos</string>
+			<key>VendorConfig</key>
+			<dict>
+				<key>WgQuickConfig</key>
+				<string>%s</string>
+			</dict>
+			<key>VPN</key>
+			<dict>
+				<key>RemoteAddress</key>
+				<string>%s</string>
+				<key>AuthenticationMethod</key>
+				<string>Password</string>
+			</dict>
+		</dict>
+	</array>
+</dict>
+</plist>
+`, values...), nil
+}
+
+// renderNetworkManagerKeyfile renders a NetworkManager connection profile (/etc/NetworkManager/system-connections/*.nmconnection)
+func renderNetworkManagerKeyfile(vpnClientData VPNClientData) ([]byte, error) {
+	out := bytes.NewBuffer([]byte{})
+	fmt.Fprintf(out, "[connection]\nid=%s\nuuid=%s\ntype=wireguard\ninterface-name=%s\n\n", vpnClientData.Name, nameBasedUUID(vpnClientData.ID+"."+vpnClientData.ServerPublicKey), NETWORKMANAGER_INTERFACE_NAME)
+
+	out.WriteString("[wireguard]\n")
+	if vpnClientData.PrivateKey == CLIENT_PRIVATE_KEY_PLACEHOLDER {
+		out.WriteString("private-key-flags=1\n\n") // the private key is only known on the device: ask for it
+	} else {
+		fmt.Fprintf(out, "private-key=%s\n\n", vpnClientData.PrivateKey)
+	}
+
+	fmt.Fprintf(out, "[wireguard-peer.%s]\nendpoint=%s\n", vpnClientData.ServerPublicKey, vpnClientData.Endpoint)
+	if vpnClientData.PresharedKey != "" {
+		fmt.Fprintf(out, "preshared-key=%s\npreshared-key-flags=0\n", vpnClientData.PresharedKey)
+	}
+	fmt.Fprintf(out, "allowed-ips=%s;\npersistent-keepalive=%d\n\n", strings.Join(vpnClientData.AllowedIPs, ";"), vpnClientData.PersistentKeepalive)
+
+	ipv4Addresses, ipv6Addresses, err := splitAddressFamilies(vpnClientData.Address)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse address: %s", err)
+	}
+	ipv4DNS, ipv6DNS := []string{}, []string{}
+	for _, nameserver := range strings.Split(vpnClientData.DNS, ",") {
+		addr, err := netip.ParseAddr(strings.TrimSpace(nameserver))
+		if err != nil {
+			continue
+		}
+		if addr.Is4() {
+			ipv4DNS = append(ipv4DNS, addr.String())
+		} else {
+			ipv6DNS = append(ipv6DNS, addr.String())
+		}
+	}
+	writeNetworkManagerIPSection(out, "ipv4", ipv4Addresses, ipv4DNS)
+	writeNetworkManagerIPSection(out, "ipv6", ipv6Addresses, ipv6DNS)
+
+	return out.Bytes(), nil
+}
+
+func writeNetworkManagerIPSection(out *bytes.Buffer, section string, addresses, nameservers []string) {
+	fmt.Fprintf(out, "[%s]\n", section)
+	if len(addresses) == 0 {
+		out.WriteString("method=disabled\n\n")
+		return
+	}
+	for k, address := range addresses {
+		fmt.Fprintf(out, "address%d=%s\n", k+1, address)
+	}
+	if len(nameservers) > 0 {
+		fmt.Fprintf(out, "dns=%s;\n", strings.Join(nameservers, ";"))
+	}
+	out.WriteString("method=manual\n\n")
+}
+
+// splitAddressFamilies splits a comma separated list of addresses in ipv4 and ipv6 prefixes
+func splitAddressFamilies(addresses string) ([]string, []string, error) {
+	ipv4, ipv6 := []string{}, []string{}
+	for _, address := range strings.Split(addresses, ",") {
+		if strings.TrimSpace(address) == "" {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(strings.TrimSpace(address))
+		if err != nil {
+			return ipv4, ipv6, err
+		}
+		if prefix.Addr().Is4() {
+			ipv4 = append(ipv4, prefix.String())
+		} else {
+			ipv6 = append(ipv6, prefix.String())
+		}
+	}
+	return ipv4, ipv6, nil
+}
+
+// nameBasedUUID returns a stable uuid for a name, so a re-downloaded profile replaces the existing one
+func nameBasedUUID(name string) string {
+	hash := sha1.Sum([]byte(name))
+	hash[6] = (hash[6] & 0x0f) | 0x50 // version 5
+	hash[8] = (hash[8] & 0x3f) | 0x80 // variant
+	return fmt.Sprintf("%X-%X-%X-%X-%X", hash[0:4], hash[4:6], hash[6:8], hash[8:10], hash[10:16])
+}
+
+func xmlEscape(s string) string {
+	out := bytes.NewBuffer([]byte{})
+	_ = xml.EscapeText(out, []byte(s)) // writing to a bytes.Buffer doesn't return errors
+	return out.String()
+}
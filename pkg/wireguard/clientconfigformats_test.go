@@ -0,0 +1,91 @@
+package wireguard
+
+import (
+	"bytes"
+	"encoding/json"
+	"image/png"
+	"strings"
+	"testing"
+
+	memorystorage "github.com/in4it/go-devops-platform/storage/memory"
+	configmanagerclient "github.com/in4it/wireguard-server/pkg/configmanager/client"
+)
+
+func TestGenerateNewClientConfigWithFormat(t *testing.T) {
+	var err error
+	UseConfigManagerClient(configmanagerclient.NewFake())
+	defer UseConfigManagerClient(nil)
+
+	storage := &memorystorage.MockMemoryStorage{}
+
+	vpnConfig, err := CreateNewVPNConfig(storage)
+	if err != nil {
+		t.Fatalf("CreateNewVPNConfig error: %s", err)
+	}
+	vpnConfig.Endpoint = "vpn.example.com"
+	err = WriteVPNConfig(storage, vpnConfig)
+	if err != nil {
+		t.Fatalf("WriteVPNConfig error: %s", err)
+	}
+
+	peerConfig, err := NewEmptyClientConfig(storage, "2-2-2-2")
+	if err != nil {
+		t.Fatalf("NewEmptyClientConfig error: %s", err)
+	}
+
+	conf, err := GenerateNewClientConfigWithFormat(storage, peerConfig.ID, "2-2-2-2", CLIENT_CONFIG_FORMAT_CONF)
+	if err != nil {
+		t.Fatalf("GenerateNewClientConfigWithFormat error: %s", err)
+	}
+
+	// the json export contains the same key as the wg-quick config
+	out, err := GenerateNewClientConfigWithFormat(storage, peerConfig.ID, "2-2-2-2", CLIENT_CONFIG_FORMAT_JSON)
+	if err != nil {
+		t.Fatalf("GenerateNewClientConfigWithFormat error: %s", err)
+	}
+	var vpnClientData VPNClientData
+	err = json.Unmarshal(out, &vpnClientData)
+	if err != nil {
+		t.Fatalf("could not unmarshal json config: %s", err)
+	}
+	if vpnClientData.ID != peerConfig.ID || vpnClientData.Address != peerConfig.Address || vpnClientData.Endpoint != "vpn.example.com:51820" {
+		t.Fatalf("unexpected json config: %+v", vpnClientData)
+	}
+	if !strings.Contains(string(conf), "PrivateKey = "+vpnClientData.PrivateKey) {
+		t.Fatalf("private key differs between formats")
+	}
+
+	out, err = GenerateNewClientConfigWithFormat(storage, peerConfig.ID, "2-2-2-2", CLIENT_CONFIG_FORMAT_NMCONNECTION)
+	if err != nil {
+		t.Fatalf("GenerateNewClientConfigWithFormat error: %s", err)
+	}
+	for _, expected := range []string{"type=wireguard", "private-key=" + vpnClientData.PrivateKey, "[wireguard-peer." + vpnConfig.PublicKey + "]", "preshared-key=" + peerConfig.PresharedKey, "address1=" + peerConfig.Address} {
+		if !strings.Contains(string(out), expected) {
+			t.Fatalf("expected %s in nmconnection. Got: %s", expected, out)
+		}
+	}
+
+	out, err = GenerateNewClientConfigWithFormat(storage, peerConfig.ID, "2-2-2-2", CLIENT_CONFIG_FORMAT_MOBILECONFIG)
+	if err != nil {
+		t.Fatalf("GenerateNewClientConfigWithFormat error: %s", err)
+	}
+	for _, expected := range []string{"com.wireguard.macos", "<string>vpn.example.com</string>", "PrivateKey = " + vpnClientData.PrivateKey} {
+		if !strings.Contains(string(out), expected) {
+			t.Fatalf("expected %s in mobileconfig. Got: %s", expected, out)
+		}
+	}
+
+	out, err = GenerateNewClientConfigWithFormat(storage, peerConfig.ID, "2-2-2-2", CLIENT_CONFIG_FORMAT_PNG)
+	if err != nil {
+		t.Fatalf("GenerateNewClientConfigWithFormat error: %s", err)
+	}
+	_, err = png.Decode(bytes.NewReader(out))
+	if err != nil {
+		t.Fatalf("could not decode qr code: %s", err)
+	}
+
+	_, err = GenerateNewClientConfigWithFormat(storage, peerConfig.ID, "2-2-2-2", "exe")
+	if err == nil {
+		t.Fatalf("expected error for unsupported format")
+	}
+}
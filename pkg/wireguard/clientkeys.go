@@ -0,0 +1,114 @@
+package wireguard
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os/user"
+	"path"
+	"strings"
+
+	"github.com/in4it/go-devops-platform/storage"
+)
+
+// EnsureClientKeysEncryptionKey creates the key used to encrypt client private keys at rest, if it doesn't exist yet
+func EnsureClientKeysEncryptionKey(storage storage.Iface) error {
+	_, err := getClientKeysEncryptionKey(storage)
+	return err
+}
+
+func getClientKeysEncryptionKey(storage storage.Iface) ([]byte, error) {
+	return getSecret(storage, CLIENT_KEYS_ENCRYPTION_KEY_FILENAME)
+}
+
+// getSecret returns a random key stored in the secrets directory. The key is generated when it doesn't exist yet.
+func getSecret(storage storage.Iface, name string) ([]byte, error) {
+	filename := path.Join(VPN_SERVER_SECRETS_PATH, name)
+	if !storage.FileExists(filename) {
+		key := make([]byte, keyLength)
+		if _, err := io.ReadFull(rand.Reader, key); err != nil {
+			return nil, fmt.Errorf("could not generate key: %s", err)
+		}
+		err := storage.EnsurePath(VPN_SERVER_SECRETS_PATH)
+		if err != nil {
+			return nil, fmt.Errorf("could not ensure path exists %s: %s", VPN_SERVER_SECRETS_PATH, err)
+		}
+		err = storage.WriteFile(filename, []byte(base64.StdEncoding.EncodeToString(key)))
+		if err != nil {
+			return nil, fmt.Errorf("could not write key to %s: %s", filename, err)
+		}
+		currentUser, err := user.Current()
+		if err != nil {
+			return nil, fmt.Errorf("could not get current user: %s", err)
+		}
+		if currentUser.Username != VPN_USER { // the rest-server needs to be able to read the key
+			err = storage.EnsureOwnership(filename, VPN_USER)
+			if err != nil {
+				return nil, fmt.Errorf("could not ensure ownership of %s: %s", filename, err)
+			}
+		}
+		return key, nil
+	}
+	keyEncoded, err := storage.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("could not read key %s: %s", filename, err)
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(keyEncoded)))
+	if err != nil {
+		return nil, fmt.Errorf("could not decode key %s: %s", filename, err)
+	}
+	if len(key) != keyLength {
+		return nil, fmt.Errorf("key %s has wrong length: %d", filename, len(key))
+	}
+	return key, nil
+}
+
+func encryptClientPrivateKey(storage storage.Iface, privateKey string) (string, error) {
+	gcm, err := getClientKeysCipher(storage)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", fmt.Errorf("could not generate nonce: %s", err)
+	}
+	return base64.StdEncoding.EncodeToString(gcm.Seal(nonce, nonce, []byte(privateKey), nil)), nil
+}
+
+func decryptClientPrivateKey(storage storage.Iface, privateKeyEncrypted string) (string, error) {
+	gcm, err := getClientKeysCipher(storage)
+	if err != nil {
+		return "", err
+	}
+	data, err := base64.StdEncoding.DecodeString(privateKeyEncrypted)
+	if err != nil {
+		return "", fmt.Errorf("could not decode encrypted private key: %s", err)
+	}
+	if len(data) < gcm.NonceSize() {
+		return "", fmt.Errorf("encrypted private key is too short")
+	}
+	privateKey, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", fmt.Errorf("could not decrypt private key: %s", err)
+	}
+	return string(privateKey), nil
+}
+
+func getClientKeysCipher(storage storage.Iface) (cipher.AEAD, error) {
+	key, err := getClientKeysEncryptionKey(storage)
+	if err != nil {
+		return nil, fmt.Errorf("could not get encryption key: %s", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("could not create cipher: %s", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("could not create gcm cipher: %s", err)
+	}
+	return gcm, nil
+}
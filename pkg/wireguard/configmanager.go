@@ -0,0 +1,96 @@
+package wireguard
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/in4it/go-devops-platform/storage"
+	configmanagerclient "github.com/in4it/wireguard-server/pkg/configmanager/client"
+)
+
+const CONFIGMANAGER_SOCKET = "/vpn/configmanager.sock"
+
+// configManagerClient replaces the configmanager client when set, see UseConfigManagerClient
+var configManagerClient configmanagerclient.API
+
+// UseConfigManagerClient sends all configmanager calls to the given client (e.g. a configmanagerclient.Fake in tests). Nil restores the default client.
+func UseConfigManagerClient(client configmanagerclient.API) {
+	configManagerClient = client
+}
+
+// GetConfigManagerSecret returns the secret used to sign configmanager requests. The secret is created when it doesn't exist yet.
+func GetConfigManagerSecret(storage storage.Iface) ([]byte, error) {
+	return getSecret(storage, CONFIGMANAGER_SECRET_FILENAME)
+}
+
+func NewConfigManagerClient(storage storage.Iface) (configmanagerclient.API, error) {
+	if configManagerClient != nil {
+		return configManagerClient, nil
+	}
+	secret, err := GetConfigManagerSecret(storage)
+	if err != nil {
+		return nil, fmt.Errorf("could not get configmanager secret: %s", err)
+	}
+	return configmanagerclient.New(CONFIGMANAGER_SOCKET, secret), nil
+}
+
+func ReloadVPNServerConfig(ctx context.Context, storage storage.Iface) error {
+	client, err := NewConfigManagerClient(storage)
+	if err != nil {
+		return err
+	}
+	_, err = client.RefreshServerConfig(ctx)
+	return err
+}
+
+func RestartVPN(ctx context.Context, storage storage.Iface) (JobResponse, error) {
+	client, err := NewConfigManagerClient(storage)
+	if err != nil {
+		return JobResponse{}, err
+	}
+	return client.RestartVPN(ctx)
+}
+
+func RequestServerKeyRotation(ctx context.Context, storage storage.Iface, rotateServerKeyRequest RotateServerKeyRequest) (RotateServerKeyResponse, error) {
+	client, err := NewConfigManagerClient(storage)
+	if err != nil {
+		return RotateServerKeyResponse{}, err
+	}
+	return client.RotateServerKey(ctx, rotateServerKeyRequest)
+}
+
+// GetLivePeerStats returns the live peer data (handshake, endpoint, transfer) from the configmanager
+func GetLivePeerStats(ctx context.Context, storage storage.Iface) ([]LivePeerStat, error) {
+	client, err := NewConfigManagerClient(storage)
+	if err != nil {
+		return nil, err
+	}
+	return client.PeerStats(ctx)
+}
+
+// GetReconcileStatus returns the result of the last reconcile pass between the peer configs and the vpn interface from the configmanager
+func GetReconcileStatus(ctx context.Context, storage storage.Iface) (ReconcileStatus, error) {
+	client, err := NewConfigManagerClient(storage)
+	if err != nil {
+		return ReconcileStatus{}, err
+	}
+	return client.ReconcileStatus(ctx)
+}
+
+func refreshClients(storage storage.Iface, action string, filenames []string) error {
+	_, err := refreshClientsWithJob(storage, action, filenames)
+	return err
+}
+
+// refreshClientsWithJob notifies the configmanager and returns the id of the job that applies the change
+func refreshClientsWithJob(storage storage.Iface, action string, filenames []string) (string, error) {
+	client, err := NewConfigManagerClient(storage)
+	if err != nil {
+		return "", err
+	}
+	jobResponse, err := client.RefreshClients(context.Background(), RefreshClientRequest{Action: action, Filenames: filenames})
+	if err != nil {
+		return "", err
+	}
+	return jobResponse.JobID, nil
+}
@@ -0,0 +1,127 @@
+package wireguard
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	memorystorage "github.com/in4it/go-devops-platform/storage/memory"
+	configmanagerclient "github.com/in4it/wireguard-server/pkg/configmanager/client"
+)
+
+func TestConfigManagerClientSignature(t *testing.T) {
+	storage := &memorystorage.MockMemoryStorage{}
+	secret, err := GetConfigManagerSecret(storage)
+	if err != nil {
+		t.Fatalf("GetConfigManagerSecret error: %s", err)
+	}
+
+	// the real client signs requests, so this test uses it against a server on a temporary socket instead of the fake
+	socket := path.Join(t.TempDir(), "configmanager.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	UseConfigManagerClient(configmanagerclient.New(socket, secret))
+	defer UseConfigManagerClient(nil)
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Fatalf("read error: %s", err)
+		}
+		err = configmanagerclient.Verify(r, body, secret, time.Now())
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"error": "` + err.Error() + `"}`))
+			return
+		}
+		if r.Method == http.MethodPost && r.RequestURI == "/restart-vpn" && len(body) == 0 {
+			w.WriteHeader(http.StatusAccepted)
+			_, _ = w.Write([]byte(`{"jobId": "0123456789abcdef0123456789abcdef"}`))
+			return
+		}
+		if r.Method == http.MethodPost && r.RequestURI == "/refresh-clients" && bytes.Contains(body, []byte("1-2-3-4-0.json")) {
+			w.WriteHeader(http.StatusAccepted)
+			_, _ = w.Write([]byte(`{"jobId": "0123456789abcdef0123456789abcdef"}`))
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	ts.Listener.Close() //nolint:errcheck
+	ts.Listener = l
+	ts.Start()
+	defer ts.Close() //nolint:errcheck
+
+	jobResponse, err := RestartVPN(context.Background(), storage)
+	if err != nil {
+		t.Fatalf("RestartVPN error: %s", err)
+	}
+	if jobResponse.JobID != "0123456789abcdef0123456789abcdef" {
+		t.Fatalf("unexpected job id: %s", jobResponse.JobID)
+	}
+	err = refreshClients(storage, ACTION_ADD, []string{"1-2-3-4-0.json"})
+	if err != nil {
+		t.Fatalf("refreshClients error: %s", err)
+	}
+
+	// a client with a different secret is rejected
+	_, err = configmanagerclient.New(socket, []byte("wrong secret")).RestartVPN(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "status code 401") {
+		t.Fatalf("expected unauthorized error, got: %v", err)
+	}
+}
+
+func TestConfigManagerFake(t *testing.T) {
+	storage := &memorystorage.MockMemoryStorage{}
+	fake := configmanagerclient.NewFake()
+	UseConfigManagerClient(fake)
+	defer UseConfigManagerClient(nil)
+
+	_, err := CreateNewVPNConfig(storage)
+	if err != nil {
+		t.Fatalf("CreateNewVPNConfig error: %s", err)
+	}
+	peerConfig, err := NewEmptyClientConfig(storage, "2-2-2-2")
+	if err != nil {
+		t.Fatalf("NewEmptyClientConfig error: %s", err)
+	}
+	err = DeleteClientConfig(storage, peerConfig.ID, "2-2-2-2")
+	if err != nil {
+		t.Fatalf("DeleteClientConfig error: %s", err)
+	}
+	refreshClientRequests := fake.RefreshClientRequests()
+	if len(refreshClientRequests) == 0 {
+		t.Fatalf("expected refresh client requests")
+	}
+	lastRequest := refreshClientRequests[len(refreshClientRequests)-1]
+	if lastRequest.Action != ACTION_CLEANUP {
+		t.Fatalf("unexpected refresh client request: %+v", lastRequest)
+	}
+
+	jobID, err := refreshClientsWithJob(storage, ACTION_CLEANUP, []string{})
+	if err != nil {
+		t.Fatalf("refreshClientsWithJob error: %s", err)
+	}
+	_, err = WaitForJob(storage, jobID, 1*time.Second)
+	if err != nil {
+		t.Fatalf("WaitForJob error: %s", err)
+	}
+
+	fake.JobStatus = JOB_STATUS_FAILED
+	fake.JobError = "could not configure device"
+	jobID, err = refreshClientsWithJob(storage, ACTION_CLEANUP, []string{})
+	if err != nil {
+		t.Fatalf("refreshClientsWithJob error: %s", err)
+	}
+	_, err = WaitForJob(storage, jobID, 1*time.Second)
+	if err == nil || !strings.Contains(err.Error(), "could not configure device") {
+		t.Fatalf("expected failed job, got: %v", err)
+	}
+}
@@ -0,0 +1,137 @@
+package wireguard
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/user"
+
+	"github.com/in4it/go-devops-platform/storage"
+)
+
+var ErrConnectionLimitReached = errors.New("connection limit reached")
+var ErrConnectionCreationNotAllowed = errors.New("only admins can create connections")
+
+// GetConnectionPolicy returns the connection policy. Without a policy, connections are unlimited.
+func GetConnectionPolicy(storage storage.Iface) (ConnectionPolicy, error) {
+	connectionPolicy := ConnectionPolicy{UserLimits: make(map[string]int), GroupLimits: make(map[string]int)}
+	filename := storage.ConfigPath(CONNECTION_POLICY_CONFIG_NAME)
+	if !storage.FileExists(filename) {
+		return connectionPolicy, nil
+	}
+	body, err := storage.ReadFile(filename)
+	if err != nil {
+		return connectionPolicy, fmt.Errorf("cannot read connection policy: %s", err)
+	}
+	err = json.Unmarshal(body, &connectionPolicy)
+	if err != nil {
+		return connectionPolicy, fmt.Errorf("cannot unmarshal connection policy: %s", err)
+	}
+	if connectionPolicy.UserLimits == nil {
+		connectionPolicy.UserLimits = make(map[string]int)
+	}
+	if connectionPolicy.GroupLimits == nil {
+		connectionPolicy.GroupLimits = make(map[string]int)
+	}
+	return connectionPolicy, nil
+}
+
+// SetConnectionPolicy validates and stores the connection policy. Existing connections above the limit are kept.
+func SetConnectionPolicy(storage storage.Iface, connectionPolicy ConnectionPolicy) (ConnectionPolicy, error) {
+	if connectionPolicy.MaxConnectionsPerUser < 0 {
+		return connectionPolicy, fmt.Errorf("max connections per user can't be negative")
+	}
+	if connectionPolicy.UserLimits == nil {
+		connectionPolicy.UserLimits = make(map[string]int)
+	}
+	if connectionPolicy.GroupLimits == nil {
+		connectionPolicy.GroupLimits = make(map[string]int)
+	}
+	for userID, limit := range connectionPolicy.UserLimits {
+		if userID == "" || limit < 0 {
+			return connectionPolicy, fmt.Errorf("invalid limit for user %s: %d", userID, limit)
+		}
+	}
+	for group, limit := range connectionPolicy.GroupLimits {
+		if group == "" || limit < 0 {
+			return connectionPolicy, fmt.Errorf("invalid limit for group %s: %d", group, limit)
+		}
+	}
+
+	out, err := json.Marshal(connectionPolicy)
+	if err != nil {
+		return connectionPolicy, fmt.Errorf("connection policy marshal error: %s", err)
+	}
+	filename := storage.ConfigPath(CONNECTION_POLICY_CONFIG_NAME)
+	err = storage.WriteFile(filename, out)
+	if err != nil {
+		return connectionPolicy, fmt.Errorf("connection policy write error: %s", err)
+	}
+	currentUser, err := user.Current()
+	if err != nil {
+		return connectionPolicy, fmt.Errorf("could not get current user: %s", err)
+	}
+	if currentUser.Username != VPN_USER {
+		err = storage.EnsureOwnership(filename, VPN_USER)
+		if err != nil {
+			return connectionPolicy, fmt.Errorf("could not ensure ownership of %s: %s", filename, err)
+		}
+	}
+	return connectionPolicy, nil
+}
+
+// getUserLimit returns the max connections of a user, given the groups of the user
+func (c ConnectionPolicy) getUserLimit(userID string, userGroups []string) int {
+	if limit, ok := c.UserLimits[userID]; ok {
+		return limit
+	}
+	groupLimit, inGroup := 0, false
+	for _, group := range userGroups {
+		limit, ok := c.GroupLimits[group]
+		if !ok {
+			continue
+		}
+		if !inGroup || limit == 0 || (groupLimit != 0 && limit > groupLimit) {
+			groupLimit = limit
+		}
+		inGroup = true
+	}
+	if inGroup {
+		return groupLimit
+	}
+	return c.MaxConnectionsPerUser
+}
+
+// GetConnectionLimit returns the max connections of a user. 0 means unlimited.
+func GetConnectionLimit(storage storage.Iface, userID string) (int, error) {
+	connectionPolicy, err := GetConnectionPolicy(storage)
+	if err != nil {
+		return 0, err
+	}
+	profilesConfig, err := GetProfiles(storage)
+	if err != nil {
+		return 0, fmt.Errorf("could not get profiles: %s", err)
+	}
+	return connectionPolicy.getUserLimit(userID, profilesConfig.getUserGroups(userID)), nil
+}
+
+// checkConnectionPolicy returns an error wrapping ErrConnectionCreationNotAllowed or ErrConnectionLimitReached
+// when the user can't have another connection
+func checkConnectionPolicy(storage storage.Iface, userID string, connectionCount int, createdByAdmin bool) error {
+	connectionPolicy, err := GetConnectionPolicy(storage)
+	if err != nil {
+		return err
+	}
+	if connectionPolicy.AdminOnly && !createdByAdmin {
+		return ErrConnectionCreationNotAllowed
+	}
+	profilesConfig, err := GetProfiles(storage)
+	if err != nil {
+		return fmt.Errorf("could not get profiles: %s", err)
+	}
+	limit := connectionPolicy.getUserLimit(userID, profilesConfig.getUserGroups(userID))
+	if limit > 0 && connectionCount >= limit {
+		return fmt.Errorf("%w: %d of %d connections in use", ErrConnectionLimitReached, connectionCount, limit)
+	}
+	return nil
+}
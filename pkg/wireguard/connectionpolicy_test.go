@@ -0,0 +1,89 @@
+package wireguard
+
+import (
+	"errors"
+	"testing"
+
+	memorystorage "github.com/in4it/go-devops-platform/storage/memory"
+	configmanagerclient "github.com/in4it/wireguard-server/pkg/configmanager/client"
+)
+
+func TestGetUserLimit(t *testing.T) {
+	connectionPolicy := ConnectionPolicy{
+		MaxConnectionsPerUser: 2,
+		UserLimits:            map[string]int{"1-1-1-1": 5},
+		GroupLimits:           map[string]int{"developers": 3, "contractors": 1, "admins": 0},
+	}
+	profilesConfig := ProfilesConfig{Groups: map[string][]string{
+		"developers":  {"1-1-1-1", "2-2-2-2", "3-3-3-3"},
+		"contractors": {"2-2-2-2", "4-4-4-4"},
+		"admins":      {"3-3-3-3"},
+		"other":       {"5-5-5-5"},
+	}}
+	expected := map[string]int{
+		"1-1-1-1": 5, // user limit takes precedence
+		"2-2-2-2": 3, // most generous group limit
+		"3-3-3-3": 0, // unlimited group
+		"4-4-4-4": 1,
+		"5-5-5-5": 2, // group without limit, so the default applies
+	}
+	for userID, limit := range expected {
+		if got := connectionPolicy.getUserLimit(userID, profilesConfig.getUserGroups(userID)); got != limit {
+			t.Fatalf("unexpected limit for %s: %d (expected %d)", userID, got, limit)
+		}
+	}
+}
+
+func TestConnectionPolicy(t *testing.T) {
+	storage := &memorystorage.MockMemoryStorage{}
+	UseConfigManagerClient(configmanagerclient.NewFake())
+	defer UseConfigManagerClient(nil)
+
+	_, err := CreateNewVPNConfig(storage)
+	if err != nil {
+		t.Fatalf("CreateNewVPNConfig error: %s", err)
+	}
+	_, err = SetConnectionPolicy(storage, ConnectionPolicy{MaxConnectionsPerUser: -1})
+	if err == nil {
+		t.Fatalf("expected error for negative limit")
+	}
+	_, err = SetConnectionPolicy(storage, ConnectionPolicy{MaxConnectionsPerUser: 1, UserLimits: map[string]int{"3-3-3-3": 2}})
+	if err != nil {
+		t.Fatalf("SetConnectionPolicy error: %s", err)
+	}
+
+	_, err = NewEmptyClientConfig(storage, "2-2-2-2")
+	if err != nil {
+		t.Fatalf("NewEmptyClientConfig error: %s", err)
+	}
+	_, err = NewEmptyClientConfig(storage, "2-2-2-2")
+	if !errors.Is(err, ErrConnectionLimitReached) {
+		t.Fatalf("expected connection limit error, got: %v", err)
+	}
+	for range 2 {
+		_, err = NewEmptyClientConfig(storage, "3-3-3-3")
+		if err != nil {
+			t.Fatalf("NewEmptyClientConfig error: %s", err)
+		}
+	}
+	limit, err := GetConnectionLimit(storage, "3-3-3-3")
+	if err != nil {
+		t.Fatalf("GetConnectionLimit error: %s", err)
+	}
+	if limit != 2 {
+		t.Fatalf("unexpected limit: %d", limit)
+	}
+
+	_, err = SetConnectionPolicy(storage, ConnectionPolicy{AdminOnly: true})
+	if err != nil {
+		t.Fatalf("SetConnectionPolicy error: %s", err)
+	}
+	_, err = NewEmptyClientConfig(storage, "4-4-4-4")
+	if !errors.Is(err, ErrConnectionCreationNotAllowed) {
+		t.Fatalf("expected creation not allowed error, got: %v", err)
+	}
+	_, err = NewClientConfig(storage, "4-4-4-4", NewClientConfigOptions{CreatedByAdmin: true})
+	if err != nil {
+		t.Fatalf("NewClientConfig error: %s", err)
+	}
+}
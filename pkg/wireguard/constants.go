@@ -1,26 +1,37 @@
 package wireguard
 
-const CONFIGMANAGER_URI = "127.0.0.1:8081"
+import configmanagerclient "github.com/in4it/wireguard-server/pkg/configmanager/client"
+
 const VPN_USER = "vpn"
 const VPN_INTERFACE_NAME = "vpn"
 const DEFAULT_VPN_PREFIX = "10.189.184.1/21"
+const DEFAULT_CLIENT_ADDRESS_PREFIX_IPV6 = "/128"
+const DEFAULT_MTU = 1420
 const VPN_CONFIG_NAME = "vpn-config.json"
-const IP_LIST_PATH = "config/iplist.json"
+const IP_LIST_NAME = "iplist.json"
+const PROFILES_CONFIG_NAME = "profiles.json"
+const CONNECTION_POLICY_CONFIG_NAME = "connection-policy.json"
 const VPN_CLIENTS_DIR = "clients"
 const VPN_STATS_DIR = "stats"
 const VPN_PACKETLOGGER_DIR = "packetlogs"
+const VPN_AUDIT_DIR = "audit"
+const VPN_CONNECTIONS_AUDIT_LOG = "connections.log"
 const VPN_PACKETLOGGER_TMP_DIR = "tmp"
 const VPN_SERVER_SECRETS_PATH = "secrets"
 const VPN_PRIVATE_KEY_FILENAME = "priv.key"
+const VPN_PENDING_PRIVATE_KEY_FILENAME = "priv.key.pending"
 const PRESHARED_KEY_FILENAME = "preshared.key"
+const CLIENT_KEYS_ENCRYPTION_KEY_FILENAME = "client-keys.key"
+const CONFIGMANAGER_SECRET_FILENAME = "configmanager.key"
 const WIREGUARD_TEMPLATE_DIR = "templates"
 const WIREGUARD_TEMPLATE_SERVER = "server.tmpl"
 const WIREGUARD_CONFIG = "/etc/wireguard/vpn.conf"
+const CLIENT_PRIVATE_KEY_PLACEHOLDER = "<private key generated on your device>"
 const DEFAULT_CLIENT_TEMPLATE = `# default wireguard client template
 [Interface]
 Address = {{ .Address }}
 PrivateKey = {{ .PrivateKey }}
-DNS = {{ .DNS }}
+{{if .DNS }}DNS = {{ .DNS }}{{end}}
 
 [Peer]
 PublicKey = {{ .ServerPublicKey }}
@@ -28,24 +39,122 @@ PresharedKey = {{ .PresharedKey }}
 Endpoint = {{ .Endpoint }}
 AllowedIPs = {{StringsJoin .AllowedIPs "," }}
 
-PersistentKeepalive = 25
+PersistentKeepalive = {{ .PersistentKeepalive }}
 `
+const DEFAULT_PERSISTENT_KEEPALIVE = 25
 
 const DEFAULT_SERVER_TEMPLATE = `# default wireguard server template
 [Interface]
 Address = {{ .Address }}
 PrivateKey = {{ .PrivateKey }}
 ListenPort = {{ .Port }}
+{{range .Clients }}
+[Peer]
+PublicKey = {{ .PublicKey }}
+PresharedKey = {{ .PresharedKey }}
+AllowedIPs = {{ .AllowedIPs }}
+{{end}}`
+
+// previous default server template, without the peers
+const LEGACY_SERVER_TEMPLATE_WITHOUT_PEERS = `# default wireguard server template
+[Interface]
+Address = {{ .Address }}
+PrivateKey = {{ .PrivateKey }}
+ListenPort = {{ .Port }}
+`
+
+// previous default server templates, which did nat with iptables. NAT is now managed by the configmanager firewall (nftables).
+const LEGACY_SERVER_TEMPLATE_IPTABLES = `# default wireguard server template
+[Interface]
+Address = {{ .Address }}
+PrivateKey = {{ .PrivateKey }}
+ListenPort = {{ .Port }}
+{{if not .DisableNAT }}
+PostUp = iptables -A FORWARD -i %i -j ACCEPT; iptables -A FORWARD -o %i -j ACCEPT; iptables -t nat -A POSTROUTING -o {{ .ExternalInterface }} -j MASQUERADE
+PostDown = iptables -D FORWARD -i %i -j ACCEPT; iptables -D FORWARD -o %i -j ACCEPT; iptables -t nat -D POSTROUTING -o {{ .ExternalInterface }} -j MASQUERADE
+{{end}}
+`
+const LEGACY_SERVER_TEMPLATE_IPTABLES_IPV6 = `# default wireguard server template
+[Interface]
+Address = {{ .Address }}
+PrivateKey = {{ .PrivateKey }}
+ListenPort = {{ .Port }}
 {{if not .DisableNAT }}
 PostUp = iptables -A FORWARD -i %i -j ACCEPT; iptables -A FORWARD -o %i -j ACCEPT; iptables -t nat -A POSTROUTING -o {{ .ExternalInterface }} -j MASQUERADE
 PostDown = iptables -D FORWARD -i %i -j ACCEPT; iptables -D FORWARD -o %i -j ACCEPT; iptables -t nat -D POSTROUTING -o {{ .ExternalInterface }} -j MASQUERADE
+{{if .AddressIPv6 }}
+PostUp = ip6tables -A FORWARD -i %i -j ACCEPT; ip6tables -A FORWARD -o %i -j ACCEPT; ip6tables -t nat -A POSTROUTING -o {{ .ExternalInterface }} -j MASQUERADE
+PostDown = ip6tables -D FORWARD -i %i -j ACCEPT; ip6tables -D FORWARD -o %i -j ACCEPT; ip6tables -t nat -D POSTROUTING -o {{ .ExternalInterface }} -j MASQUERADE
+{{end}}
 {{end}}
 `
 
+// interface modes
+const INTERFACE_MODE_NATIVE = "native"     // the configmanager manages the interface through rtnetlink
+const INTERFACE_MODE_WG_QUICK = "wg-quick" // legacy: wg-quick brings the interface up and down
+
+// peer types
+const PEER_TYPE_NETWORK = "network"
+const NETWORK_PEER_USER_ID = "network" // network peers are not owned by a user
+
+// configmanager job status
+const JOB_STATUS_PENDING = configmanagerclient.JOB_STATUS_PENDING
+const JOB_STATUS_RUNNING = configmanagerclient.JOB_STATUS_RUNNING
+const JOB_STATUS_SUCCEEDED = configmanagerclient.JOB_STATUS_SUCCEEDED
+const JOB_STATUS_FAILED = configmanagerclient.JOB_STATUS_FAILED
+
 // config notify actions
 const ACTION_ADD = "add"
 const ACTION_DELETE = "delete"
 const ACTION_CLEANUP = "cleanup"
 
+// disabled reasons
+const DISABLED_REASON_SUSPENDED = "user suspended"
+const DISABLED_REASON_ADMIN = "disabled by admin"
+const DISABLED_REASON_EXPIRED = "expired"
+const DISABLED_REASON_NOT_YET_VALID = "not yet valid"
+
+// audit actions
+const AUDIT_ACTION_DISABLE = "disable"
+const AUDIT_ACTION_ENABLE = "enable"
+const AUDIT_ACTION_DELETE = "delete"
+const AUDIT_ACTION_SET_VALIDITY = "set validity"
+const AUDIT_ACTION_IMPORT = "import"
+const AUDIT_ACTION_SET_METADATA = "set metadata"
+
+// connection platforms
+const PLATFORM_WINDOWS = "windows"
+const PLATFORM_MACOS = "macos"
+const PLATFORM_LINUX = "linux"
+const PLATFORM_IOS = "ios"
+const PLATFORM_ANDROID = "android"
+const PLATFORM_OTHER = "other"
+
+// connection metadata limits
+const MAX_CONNECTION_NAME_LENGTH = 64
+const MAX_CONNECTION_DESCRIPTION_LENGTH = 256
+const MAX_CONNECTION_LABELS = 20
+const MAX_CONNECTION_LABEL_LENGTH = 32
+
+// wg-quick import status
+const IMPORT_STATUS_IMPORTED = "imported" // in a dry run: would be imported
+const IMPORT_STATUS_SKIPPED = "skipped"
+const IMPORT_STATUS_UNCHANGED = "unchanged"
+
+// IMPORT_EMPTY_PRESHARED_KEY is used for imported peers without a preshared key. An all-zero preshared key is the same as no preshared key
+// in WireGuard, while an empty preshared key in a peer config means the server preshared key.
+const IMPORT_EMPTY_PRESHARED_KEY = "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA="
+
+// client config formats
+const CLIENT_CONFIG_FORMAT_CONF = "conf"
+const CLIENT_CONFIG_FORMAT_PNG = "png"
+const CLIENT_CONFIG_FORMAT_SVG = "svg"
+const CLIENT_CONFIG_FORMAT_MOBILECONFIG = "mobileconfig"
+const CLIENT_CONFIG_FORMAT_NMCONNECTION = "nmconnection"
+const CLIENT_CONFIG_FORMAT_JSON = "json"
+const QR_CODE_PNG_SCALE = 8
+const APPLE_PROFILE_IDENTIFIER_PREFIX = "com.in4it.vpn"
+const NETWORKMANAGER_INTERFACE_NAME = "vpn"
+
 // stats
 const TIMESTAMP_FORMAT = "2006-01-02T15:04:05"
@@ -0,0 +1,119 @@
+package wireguard
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/in4it/go-devops-platform/storage"
+)
+
+func validateClientValidity(notBefore, expiresAt time.Time) error {
+	if !notBefore.IsZero() && !expiresAt.IsZero() && !expiresAt.After(notBefore) {
+		return fmt.Errorf("expiry needs to be after the not-before time")
+	}
+	return nil
+}
+
+// updateClientValidity disables a peer outside of its validity window and enables it again once it becomes valid.
+// Returns the config notify action when the peer needs to be added to or removed from the vpn.
+func updateClientValidity(peerConfig *PeerConfig, now time.Time) string {
+	wasDisabled := peerConfig.Disabled
+	expired := !peerConfig.ExpiresAt.IsZero() && !now.Before(peerConfig.ExpiresAt)
+	notYetValid := !peerConfig.NotBefore.IsZero() && now.Before(peerConfig.NotBefore)
+
+	switch {
+	case peerConfig.DisabledReason == DISABLED_REASON_SUSPENDED || peerConfig.DisabledReason == DISABLED_REASON_ADMIN:
+		return "" // suspended peers stay disabled until the user or connection is reactivated
+	case expired:
+		peerConfig.Disabled = true
+		peerConfig.DisabledReason = DISABLED_REASON_EXPIRED
+	case notYetValid:
+		peerConfig.Disabled = true
+		peerConfig.DisabledReason = DISABLED_REASON_NOT_YET_VALID
+	case peerConfig.DisabledReason == DISABLED_REASON_EXPIRED || peerConfig.DisabledReason == DISABLED_REASON_NOT_YET_VALID:
+		peerConfig.DisabledReason = ""
+		peerConfig.Disabled = peerConfig.DisabledUntilConfigRefresh
+	}
+
+	if !wasDisabled && peerConfig.Disabled {
+		return ACTION_DELETE
+	}
+	if wasDisabled && !peerConfig.Disabled {
+		return ACTION_ADD
+	}
+	return ""
+}
+
+// SetClientValidity sets the not-before and expiry time of a connection. A zero time clears the limit.
+func SetClientValidity(storage storage.Iface, connectionID string, notBefore, expiresAt time.Time, actor string) (PeerConfig, error) {
+	err := validateClientValidity(notBefore, expiresAt)
+	if err != nil {
+		return PeerConfig{}, err
+	}
+
+	clientConfigMutex.Lock()
+	defer clientConfigMutex.Unlock()
+
+	peerConfig, err := getPeerConfig(storage, connectionID)
+	if err != nil {
+		return peerConfig, fmt.Errorf("could not get peer config: %s", err)
+	}
+	peerConfig.NotBefore = notBefore
+	peerConfig.ExpiresAt = expiresAt
+	action := updateClientValidity(&peerConfig, time.Now())
+
+	err = writePeerConfig(storage, peerConfig)
+	if err != nil {
+		return peerConfig, err
+	}
+	err = writeConnectionAuditEntry(storage, peerConfig.ID, AUDIT_ACTION_SET_VALIDITY, actor)
+	if err != nil {
+		return peerConfig, err
+	}
+
+	// notify configmanager
+	if action != "" && peerConfig.PublicKey != "" {
+		err = refreshClients(storage, action, []string{fmt.Sprintf("%s.json", peerConfig.ID)})
+		if err != nil {
+			return peerConfig, err
+		}
+	}
+
+	return peerConfig, nil
+}
+
+// ReapClientConfigs disables expired peers and enables peers that became valid.
+// Returns the filenames of the peers that need to be added to and removed from the vpn.
+func ReapClientConfigs(storage storage.Iface, now time.Time) ([]string, []string, error) {
+	clientConfigMutex.Lock()
+	defer clientConfigMutex.Unlock()
+
+	toAdd := []string{}
+	toDelete := []string{}
+
+	peerConfigs, err := GetAllPeerConfigs(storage)
+	if err != nil {
+		return toAdd, toDelete, fmt.Errorf("could not get peer configs: %s", err)
+	}
+	for _, peerConfig := range peerConfigs {
+		disabledReason := peerConfig.DisabledReason
+		action := updateClientValidity(&peerConfig, now)
+		if action == "" && disabledReason == peerConfig.DisabledReason {
+			continue
+		}
+		err = writePeerConfig(storage, peerConfig)
+		if err != nil {
+			return toAdd, toDelete, fmt.Errorf("could not write peer config (%s): %s", peerConfig.ID, err)
+		}
+		if peerConfig.PublicKey == "" {
+			continue
+		}
+		switch action {
+		case ACTION_ADD:
+			toAdd = append(toAdd, fmt.Sprintf("%s.json", peerConfig.ID))
+		case ACTION_DELETE:
+			toDelete = append(toDelete, fmt.Sprintf("%s.json", peerConfig.ID))
+		}
+	}
+	return toAdd, toDelete, nil
+}
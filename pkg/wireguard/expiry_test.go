@@ -0,0 +1,111 @@
+package wireguard
+
+import (
+	"testing"
+	"time"
+
+	memorystorage "github.com/in4it/go-devops-platform/storage/memory"
+	"github.com/in4it/go-devops-platform/users"
+	configmanagerclient "github.com/in4it/wireguard-server/pkg/configmanager/client"
+)
+
+func TestReapClientConfigs(t *testing.T) {
+	var err error
+	UseConfigManagerClient(configmanagerclient.NewFake())
+	defer UseConfigManagerClient(nil)
+
+	storage := &memorystorage.MockMemoryStorage{}
+
+	_, err = CreateNewVPNConfig(storage)
+	if err != nil {
+		t.Fatalf("CreateNewVPNConfig error: %s", err)
+	}
+
+	now := time.Now()
+	_, err = NewClientConfig(storage, "2-2-2-2", NewClientConfigOptions{ExpiresAt: now.Add(-1 * time.Hour)})
+	if err == nil {
+		t.Fatalf("expected error when creating a connection that is already expired")
+	}
+
+	peerConfig, err := NewClientConfig(storage, "2-2-2-2", NewClientConfigOptions{NotBefore: now.Add(1 * time.Hour), ExpiresAt: now.Add(2 * time.Hour)})
+	if err != nil {
+		t.Fatalf("NewClientConfig error: %s", err)
+	}
+	if !peerConfig.Disabled || peerConfig.DisabledReason != DISABLED_REASON_NOT_YET_VALID {
+		t.Fatalf("expected connection to be disabled until it becomes valid")
+	}
+
+	// not valid yet
+	toAdd, toDelete, err := ReapClientConfigs(storage, now)
+	if err != nil {
+		t.Fatalf("ReapClientConfigs error: %s", err)
+	}
+	if len(toAdd) != 0 || len(toDelete) != 0 {
+		t.Fatalf("expected no changes: %v %v", toAdd, toDelete)
+	}
+
+	// valid
+	toAdd, toDelete, err = ReapClientConfigs(storage, now.Add(90*time.Minute))
+	if err != nil {
+		t.Fatalf("ReapClientConfigs error: %s", err)
+	}
+	if len(toAdd) != 1 || len(toDelete) != 0 {
+		t.Fatalf("expected connection to be enabled: %v %v", toAdd, toDelete)
+	}
+
+	// suspended users stay disabled
+	err = DisableAllClientConfigs(storage, users.User{ID: "2-2-2-2"})
+	if err != nil {
+		t.Fatalf("DisableAllClientConfigs error: %s", err)
+	}
+	toAdd, toDelete, err = ReapClientConfigs(storage, now.Add(90*time.Minute))
+	if err != nil {
+		t.Fatalf("ReapClientConfigs error: %s", err)
+	}
+	if len(toAdd) != 0 || len(toDelete) != 0 {
+		t.Fatalf("expected no changes for suspended user: %v %v", toAdd, toDelete)
+	}
+	err = ReactivateAllClientConfigs(storage, users.User{ID: "2-2-2-2"})
+	if err != nil {
+		t.Fatalf("ReactivateAllClientConfigs error: %s", err)
+	}
+	peerConfig, err = getPeerConfig(storage, peerConfig.ID)
+	if err != nil {
+		t.Fatalf("getPeerConfig error: %s", err)
+	}
+	if !peerConfig.Disabled || peerConfig.DisabledReason != DISABLED_REASON_NOT_YET_VALID {
+		t.Fatalf("expected reactivated connection to stay disabled until it becomes valid")
+	}
+	toAdd, _, err = ReapClientConfigs(storage, now.Add(90*time.Minute))
+	if err != nil {
+		t.Fatalf("ReapClientConfigs error: %s", err)
+	}
+	if len(toAdd) != 1 {
+		t.Fatalf("expected connection to be enabled: %v", toAdd)
+	}
+
+	// expired
+	toAdd, toDelete, err = ReapClientConfigs(storage, now.Add(3*time.Hour))
+	if err != nil {
+		t.Fatalf("ReapClientConfigs error: %s", err)
+	}
+	if len(toAdd) != 0 || len(toDelete) != 1 {
+		t.Fatalf("expected connection to be disabled: %v %v", toAdd, toDelete)
+	}
+	peerConfig, err = getPeerConfig(storage, peerConfig.ID)
+	if err != nil {
+		t.Fatalf("getPeerConfig error: %s", err)
+	}
+	if !peerConfig.Disabled || peerConfig.DisabledReason != DISABLED_REASON_EXPIRED {
+		t.Fatalf("expected connection to be disabled because it expired")
+	}
+
+	// extend expiry
+	peerConfig, err = SetClientValidity(storage, peerConfig.ID, time.Time{}, now.Add(24*time.Hour), "admin")
+	if err != nil {
+		t.Fatalf("SetClientValidity error: %s", err)
+	}
+	if peerConfig.Disabled || peerConfig.DisabledReason != "" {
+		t.Fatalf("expected connection to be enabled after extending the expiry")
+	}
+}
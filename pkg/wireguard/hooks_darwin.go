@@ -0,0 +1,12 @@
+//go:build darwin
+
+package wireguard
+
+import "fmt"
+
+func runServerConfigHooks(serverConfig []byte, hook string) error {
+	for _, command := range getServerConfigHooks(serverConfig, hook) {
+		fmt.Printf("Warning: %s is not executed on darwin: %s\n", hook, command)
+	}
+	return nil
+}
@@ -0,0 +1,20 @@
+//go:build linux
+
+package wireguard
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+func runServerConfigHooks(serverConfig []byte, hook string) error {
+	for _, command := range getServerConfigHooks(serverConfig, hook) {
+		fmt.Printf("Executing %s: %s\n", hook, command)
+		out, err := exec.Command("sh", "-c", command).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("%s command failed: %s (output: %s)", hook, err, strings.TrimSpace(string(out)))
+		}
+	}
+	return nil
+}
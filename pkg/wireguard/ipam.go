@@ -0,0 +1,674 @@
+package wireguard
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"os/user"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/in4it/go-devops-platform/storage"
+)
+
+var ipamMutex sync.Mutex
+
+// IPAM is the persistent index of client address allocations and admin defined reservations.
+// The index is stored in IP_LIST_NAME, so allocating an address doesn't need to read all client configs.
+type IPAM struct {
+	IPv4         *IPPool         `json:"ipv4"`
+	IPv6         *IPPool         `json:"ipv6,omitempty"`
+	Reservations []IPReservation `json:"reservations"`
+}
+
+// IPPool contains the allocations of one address family
+type IPPool struct {
+	AddressRange  netip.Prefix      `json:"addressRange"`
+	AddressPrefix string            `json:"addressPrefix"`
+	Next          netip.Addr        `json:"next"`
+	Allocations   map[string]string `json:"allocations"` // connection id => address
+
+	prefixLen    int
+	reservations []IPReservation
+	used         map[netip.Addr]string // every address in an allocated block => connection id
+	reserved     map[netip.Addr]int    // every address in a reserved block => index in reservations
+}
+
+// IPReservation reserves a client address for a single connection, or for the next connection of a user
+type IPReservation struct {
+	Address      string `json:"address"`
+	UserID       string `json:"userID,omitempty"`
+	ConnectionID string `json:"connectionID,omitempty"`
+}
+
+// addressScope limits where new addresses are allocated: within the address range of the profile of a connection,
+// and outside of the address ranges of other profiles. The zero value allows the whole address range.
+type addressScope struct {
+	within  netip.Prefix
+	exclude []netip.Prefix
+}
+
+type IPConflict struct {
+	Address       string   `json:"address"`
+	ConnectionIDs []string `json:"connectionIDs"`
+	Reason        string   `json:"reason"`
+}
+
+func newIPAM(vpnConfig VPNConfig, reservations []IPReservation) (*IPAM, error) {
+	ipam := &IPAM{
+		IPv4:         &IPPool{AddressRange: vpnConfig.AddressRange, AddressPrefix: vpnConfig.ClientAddressPrefix},
+		Reservations: reservations,
+	}
+	if vpnConfig.AddressRangeIPv6.IsValid() {
+		ipam.IPv6 = &IPPool{AddressRange: vpnConfig.AddressRangeIPv6, AddressPrefix: vpnConfig.ClientAddressPrefixIPv6}
+	}
+	err := ipam.init()
+	return ipam, err
+}
+
+func (i *IPAM) init() error {
+	if i.Reservations == nil {
+		i.Reservations = []IPReservation{}
+	}
+	if i.IPv4 == nil {
+		return fmt.Errorf("no ipv4 address range")
+	}
+	for _, pool := range i.pools() {
+		err := pool.init(i.Reservations)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (i *IPAM) pools() []*IPPool {
+	if i.IPv6 == nil {
+		return []*IPPool{i.IPv4}
+	}
+	return []*IPPool{i.IPv4, i.IPv6}
+}
+
+// pool returns the pool of the address family of an address
+func (i *IPAM) pool(address string) (*IPPool, error) {
+	prefix, err := netip.ParsePrefix(address)
+	if err != nil {
+		addr, err := netip.ParseAddr(address)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address: %s", err)
+		}
+		prefix = netip.PrefixFrom(addr, addr.BitLen())
+	}
+	if prefix.Addr().Is4() {
+		return i.IPv4, nil
+	}
+	if i.IPv6 == nil {
+		return nil, fmt.Errorf("no ipv6 address range configured")
+	}
+	return i.IPv6, nil
+}
+
+// matches returns true when the vpn config has the same address ranges as the index
+func (i *IPAM) matches(vpnConfig VPNConfig) bool {
+	if i.IPv4 == nil || i.IPv4.AddressRange != vpnConfig.AddressRange || i.IPv4.AddressPrefix != vpnConfig.ClientAddressPrefix {
+		return false
+	}
+	if i.IPv6 == nil {
+		return !vpnConfig.AddressRangeIPv6.IsValid()
+	}
+	return i.IPv6.AddressRange == vpnConfig.AddressRangeIPv6 && i.IPv6.AddressPrefix == vpnConfig.ClientAddressPrefixIPv6
+}
+
+// allocate returns a free address of every address family for a connection. The scope only applies to ipv4.
+func (i *IPAM) allocate(connectionID string, scope addressScope) (netip.Prefix, netip.Prefix, error) {
+	var addressIPv6 netip.Prefix
+	address, err := i.IPv4.allocate(connectionID, scope)
+	if err != nil {
+		return address, addressIPv6, err
+	}
+	if i.IPv6 != nil {
+		addressIPv6, err = i.IPv6.allocate(connectionID, addressScope{})
+		if err != nil {
+			return address, addressIPv6, fmt.Errorf("ipv6: %s", err)
+		}
+	}
+	return address, addressIPv6, nil
+}
+
+func (i *IPAM) release(connectionID string) {
+	for _, pool := range i.pools() {
+		pool.release(connectionID)
+	}
+}
+
+func (p *IPPool) init(reservations []IPReservation) error {
+	if !p.AddressRange.IsValid() {
+		return fmt.Errorf("invalid address range")
+	}
+	prefixLen := p.AddressRange.Addr().BitLen()
+	if p.AddressPrefix != "" {
+		var err error
+		prefixLen, err = strconv.Atoi(strings.TrimPrefix(p.AddressPrefix, "/"))
+		if err != nil {
+			return fmt.Errorf("cannot parse client address prefix (%s): %s", p.AddressPrefix, err)
+		}
+	}
+	if prefixLen < p.AddressRange.Bits() || prefixLen > p.AddressRange.Addr().BitLen() {
+		return fmt.Errorf("client address prefix /%d doesn't fit in address range %s", prefixLen, p.AddressRange)
+	}
+	if p.AddressRange.Addr().BitLen()-prefixLen > 16 {
+		return fmt.Errorf("client address prefix /%d is too large", prefixLen)
+	}
+	p.prefixLen = prefixLen
+	p.used = make(map[netip.Addr]string)
+	p.reserved = make(map[netip.Addr]int)
+	p.reservations = []IPReservation{}
+	if p.Allocations == nil {
+		p.Allocations = make(map[string]string)
+	}
+	for connectionID, address := range p.Allocations {
+		prefix, err := netip.ParsePrefix(address)
+		if err != nil {
+			return fmt.Errorf("cannot parse allocated address of %s: %s", connectionID, err)
+		}
+		p.markBlock(p.used, prefix, connectionID)
+	}
+	for _, reservation := range reservations {
+		prefix, err := p.parseAddress(reservation.Address)
+		if err != nil {
+			return fmt.Errorf("cannot parse reserved address: %s", err)
+		}
+		if prefix.Addr().Is4() != p.AddressRange.Addr().Is4() {
+			continue
+		}
+		p.reservations = append(p.reservations, reservation)
+		for _, addr := range blockAddresses(prefix.Masked()) {
+			p.reserved[addr] = len(p.reservations) - 1
+		}
+	}
+	return nil
+}
+
+// parseAddress parses an address, with or without prefix. The client address prefix is used when there's no prefix.
+func (p *IPPool) parseAddress(address string) (netip.Prefix, error) {
+	if !strings.Contains(address, "/") {
+		addr, err := netip.ParseAddr(address)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return addr.Prefix(p.prefixLen)
+	}
+	prefix, err := netip.ParsePrefix(address)
+	if err != nil {
+		return prefix, err
+	}
+	return prefix, nil
+}
+
+// owner returns the connection id that has an address of the block allocated
+func (p *IPPool) owner(prefix netip.Prefix) string {
+	for _, addr := range blockAddresses(netip.PrefixFrom(prefix.Addr(), p.prefixLen).Masked()) {
+		if owner, ok := p.used[addr]; ok {
+			return owner
+		}
+	}
+	return ""
+}
+
+func (p *IPPool) markBlock(m map[netip.Addr]string, prefix netip.Prefix, connectionID string) {
+	for _, addr := range blockAddresses(prefix.Masked()) {
+		m[addr] = connectionID
+	}
+}
+
+// reservationAllowed returns true when the reservation can be used by the connection
+func reservationAllowed(reservation IPReservation, connectionID string) bool {
+	if reservation.ConnectionID != "" {
+		return reservation.ConnectionID == connectionID
+	}
+	userID, _, err := getClientIDAndConfigID(connectionID)
+	return err == nil && reservation.UserID == userID
+}
+
+// checkAddress returns why the connection can't use the address. An empty string is returned when the address can be used.
+func (p *IPPool) checkAddress(prefix netip.Prefix, connectionID string) string {
+	block := netip.PrefixFrom(prefix.Addr(), p.prefixLen).Masked()
+	if !p.AddressRange.Contains(block.Addr()) || !p.AddressRange.Contains(lastAddress(block)) {
+		return "outside of address range " + p.AddressRange.String()
+	}
+	if prefix.Addr() == p.AddressRange.Addr() || prefix.Addr() == p.AddressRange.Masked().Addr() {
+		return "conflicts with the vpn server address"
+	}
+	for _, addr := range blockAddresses(block) {
+		if owner, ok := p.used[addr]; ok && owner != connectionID {
+			return "already in use by " + owner
+		}
+		if k, ok := p.reserved[addr]; ok && !reservationAllowed(p.reservations[k], connectionID) {
+			return "reserved"
+		}
+	}
+	return ""
+}
+
+// claim allocates an existing address to a connection
+func (p *IPPool) claim(connectionID string, prefix netip.Prefix) error {
+	if reason := p.checkAddress(prefix, connectionID); reason != "" {
+		return fmt.Errorf("address %s %s", prefix, reason)
+	}
+	p.release(connectionID)
+	p.Allocations[connectionID] = prefix.String()
+	p.markBlock(p.used, netip.PrefixFrom(prefix.Addr(), p.prefixLen), connectionID)
+	return nil
+}
+
+func (p *IPPool) release(connectionID string) {
+	address, ok := p.Allocations[connectionID]
+	if !ok {
+		return
+	}
+	delete(p.Allocations, connectionID)
+	prefix, err := netip.ParsePrefix(address)
+	if err != nil {
+		return
+	}
+	for _, addr := range blockAddresses(netip.PrefixFrom(prefix.Addr(), p.prefixLen).Masked()) {
+		if p.used[addr] == connectionID {
+			delete(p.used, addr)
+		}
+	}
+}
+
+// inScope returns true when the block of the address is within the scope
+func (p *IPPool) inScope(prefix netip.Prefix, scope addressScope) bool {
+	block := netip.PrefixFrom(prefix.Addr(), p.prefixLen).Masked()
+	if scope.within.IsValid() && (!scope.within.Masked().Contains(block.Addr()) || !scope.within.Masked().Contains(lastAddress(block))) {
+		return false
+	}
+	for _, exclude := range scope.exclude {
+		if exclude.Overlaps(block) {
+			return false
+		}
+	}
+	return true
+}
+
+// allocate returns a free address for a connection. Reservations of the connection or its user take precedence.
+// Other addresses are handed out next-fit within the scope, starting after the last allocated address.
+func (p *IPPool) allocate(connectionID string, scope addressScope) (netip.Prefix, error) {
+	if address, ok := p.Allocations[connectionID]; ok {
+		return netip.ParsePrefix(address)
+	}
+	// reservations of the connection first, then reservations of the user
+	for _, connectionReservation := range []bool{true, false} {
+		for _, reservation := range p.reservations {
+			if (reservation.ConnectionID != "") != connectionReservation || !reservationAllowed(reservation, connectionID) {
+				continue
+			}
+			prefix, err := p.parseAddress(reservation.Address)
+			if err != nil {
+				continue
+			}
+			if p.checkAddress(prefix, connectionID) == "" {
+				return prefix, p.claim(connectionID, prefix)
+			}
+		}
+	}
+
+	addressRange := p.AddressRange
+	if scope.within.IsValid() {
+		addressRange = scope.within
+	}
+	start := p.Next
+	if !start.IsValid() || !addressRange.Contains(start) { // start right after the server address
+		start = p.AddressRange.Addr()
+		if !addressRange.Contains(start) {
+			start = addressRange.Masked().Addr()
+		}
+	}
+	block := netip.PrefixFrom(start, p.prefixLen).Masked()
+	blockSize := uint64(1) << (block.Addr().BitLen() - p.prefixLen)
+	// every taken block is backed by at least one used or reserved address, so the loop ends before going around twice
+	attempts := len(p.used) + len(p.reserved) + 3
+	if len(scope.exclude) > 0 { // excluded blocks are not backed by used or reserved addresses
+		attempts = 1 << 16
+	}
+	if rangeBlocks := addressRange.Addr().BitLen() - addressRange.Bits() - (block.Addr().BitLen() - p.prefixLen); rangeBlocks < 32 && attempts > 1<<rangeBlocks {
+		attempts = 1 << rangeBlocks
+	}
+	for range attempts {
+		if !block.Contains(p.AddressRange.Addr()) { // don't pick a block with the server address in it
+			prefix := netip.PrefixFrom(block.Addr(), p.prefixLen)
+			if p.inScope(prefix, scope) && p.checkAddress(prefix, connectionID) == "" {
+				p.Next = addToAddr(block.Addr(), blockSize)
+				return prefix, p.claim(connectionID, prefix)
+			}
+		}
+		next := addToAddr(block.Addr(), blockSize)
+		if !addressRange.Contains(next) { // wrap around
+			next = addressRange.Masked().Addr()
+		}
+		block = netip.PrefixFrom(next, p.prefixLen)
+	}
+	return netip.Prefix{}, fmt.Errorf("no free address left: next address is not within address range (%s). Address Range might be too small", addressRange)
+}
+
+// reallocate returns the address of an existing connection. A new address is allocated when the connection
+// has no valid address in the index, when the address is out of scope (the profile of the connection changed),
+// or when it has a reservation for another address that is available.
+func (p *IPPool) reallocate(connectionID string, scope addressScope) (netip.Prefix, error) {
+	address, ok := p.Allocations[connectionID]
+	if ok {
+		if prefix, err := netip.ParsePrefix(address); err == nil && !p.inScope(prefix, scope) && !p.hasReservation(connectionID, address) {
+			p.release(connectionID)
+			return p.allocate(connectionID, scope)
+		}
+		for _, reservation := range p.reservations {
+			if reservation.ConnectionID != connectionID {
+				continue
+			}
+			prefix, err := p.parseAddress(reservation.Address)
+			if err == nil && prefix.String() != address && p.checkAddress(prefix, connectionID) == "" {
+				return prefix, p.claim(connectionID, prefix)
+			}
+		}
+		return netip.ParsePrefix(address)
+	}
+	return p.allocate(connectionID, scope)
+}
+
+// GetIPAM returns the address allocation index. The index is rebuilt from the client configs when it doesn't exist yet.
+func GetIPAM(storage storage.Iface) (IPAM, error) {
+	vpnConfig, err := GetVPNConfig(storage)
+	if err != nil {
+		return IPAM{}, fmt.Errorf("failed to get vpn config: %s", err)
+	}
+	ipamMutex.Lock()
+	defer ipamMutex.Unlock()
+	ipam, err := loadIPAM(storage, vpnConfig)
+	if err != nil {
+		return IPAM{}, err
+	}
+	return *ipam, nil
+}
+
+// loadIPAM reads the index. When the index is missing or the address ranges changed, it's rebuilt from the client configs.
+func loadIPAM(storage storage.Iface, vpnConfig VPNConfig) (*IPAM, error) {
+	reservations := []IPReservation{}
+	if storage.FileExists(storage.ConfigPath(IP_LIST_NAME)) {
+		var ipam IPAM
+		body, err := storage.ReadFile(storage.ConfigPath(IP_LIST_NAME))
+		if err != nil {
+			return nil, fmt.Errorf("cannot read ip list: %s", err)
+		}
+		err = json.Unmarshal(body, &ipam)
+		if err != nil {
+			return nil, fmt.Errorf("cannot unmarshal ip list: %s", err)
+		}
+		if ipam.matches(vpnConfig) {
+			err = ipam.init()
+			if err != nil {
+				return nil, fmt.Errorf("invalid ip list: %s", err)
+			}
+			return &ipam, nil
+		}
+		if ipam.Reservations != nil {
+			reservations = ipam.Reservations
+		}
+	}
+	ipam, _, err := rebuildIPAM(storage, vpnConfig, reservations)
+	if err != nil {
+		return nil, err
+	}
+	err = saveIPAM(storage, ipam)
+	if err != nil {
+		return nil, err
+	}
+	return ipam, nil
+}
+
+// rebuildIPAM builds the index from the client configs. Connections with an address that is outside of the address range,
+// or that conflicts with another connection or reservation are returned as conflicts and are not part of the index.
+func rebuildIPAM(storage storage.Iface, vpnConfig VPNConfig, reservations []IPReservation) (*IPAM, []IPConflict, error) {
+	conflicts := []IPConflict{}
+	ipam, err := newIPAM(vpnConfig, reservations)
+	if err != nil {
+		return nil, conflicts, err
+	}
+	peerConfigs, err := GetAllPeerConfigs(storage)
+	if err != nil {
+		return nil, conflicts, fmt.Errorf("could not get peer configs: %s", err)
+	}
+	for _, pool := range ipam.pools() {
+		isIPv4 := pool.AddressRange.Addr().Is4()
+		getAddress := func(peerConfig PeerConfig) string {
+			if isIPv4 {
+				return peerConfig.Address
+			}
+			return peerConfig.AddressIPv6
+		}
+		// connections with a reservation claim their address first, so they keep it when another connection conflicts
+		slices.SortStableFunc(peerConfigs, func(a, b PeerConfig) int {
+			return boolToInt(!pool.hasReservation(a.ID, getAddress(a))) - boolToInt(!pool.hasReservation(b.ID, getAddress(b)))
+		})
+		for _, peerConfig := range peerConfigs {
+			address := getAddress(peerConfig)
+			if address == "" && !isIPv4 { // ipv6 address is allocated when the client configs are updated
+				continue
+			}
+			prefix, err := netip.ParsePrefix(address)
+			if err != nil {
+				conflicts = append(conflicts, IPConflict{Address: address, ConnectionIDs: []string{peerConfig.ID}, Reason: "invalid address"})
+				continue
+			}
+			prefix = netip.PrefixFrom(prefix.Addr(), pool.prefixLen)
+			if reason := pool.checkAddress(prefix, peerConfig.ID); reason != "" {
+				conflict := IPConflict{Address: prefix.String(), ConnectionIDs: []string{peerConfig.ID}, Reason: reason}
+				if owner := pool.owner(prefix); owner != "" {
+					conflict.ConnectionIDs = append([]string{owner}, conflict.ConnectionIDs...)
+					conflict.Reason = "duplicate address"
+				}
+				conflicts = append(conflicts, conflict)
+				continue
+			}
+			err = pool.claim(peerConfig.ID, prefix)
+			if err != nil {
+				return nil, conflicts, err
+			}
+		}
+	}
+	return ipam, conflicts, nil
+}
+
+func (p *IPPool) hasReservation(connectionID, address string) bool {
+	prefix, err := netip.ParsePrefix(address)
+	if err != nil {
+		return false
+	}
+	k, ok := p.reserved[prefix.Addr()]
+	return ok && reservationAllowed(p.reservations[k], connectionID)
+}
+
+func saveIPAM(storage storage.Iface, ipam *IPAM) error {
+	out, err := json.Marshal(ipam)
+	if err != nil {
+		return fmt.Errorf("ip list marshal error: %s", err)
+	}
+	filename := storage.ConfigPath(IP_LIST_NAME)
+	err = storage.WriteFile(filename, out)
+	if err != nil {
+		return fmt.Errorf("ip list write error: %s", err)
+	}
+	currentUser, err := user.Current()
+	if err != nil {
+		return fmt.Errorf("could not get current user: %s", err)
+	}
+	if currentUser.Username != VPN_USER {
+		err = storage.EnsureOwnership(filename, VPN_USER)
+		if err != nil {
+			return fmt.Errorf("could not ensure ownership of %s: %s", filename, err)
+		}
+	}
+	return nil
+}
+
+// allocateIPAddress allocates the addresses for a new connection and stores them in the index.
+// The ipv6 address is only valid when an ipv6 address range is configured.
+func allocateIPAddress(storage storage.Iface, vpnConfig VPNConfig, connectionID string, scope addressScope) (netip.Prefix, netip.Prefix, error) {
+	ipamMutex.Lock()
+	defer ipamMutex.Unlock()
+	ipam, err := loadIPAM(storage, vpnConfig)
+	if err != nil {
+		return netip.Prefix{}, netip.Prefix{}, err
+	}
+	address, addressIPv6, err := ipam.allocate(connectionID, scope)
+	if err != nil {
+		return address, addressIPv6, err
+	}
+	return address, addressIPv6, saveIPAM(storage, ipam)
+}
+
+// releaseIPAddresses removes the address allocations of deleted connections from the index
+func releaseIPAddresses(storage storage.Iface, connectionIDs []string) error {
+	ipamMutex.Lock()
+	defer ipamMutex.Unlock()
+	if !storage.FileExists(storage.ConfigPath(IP_LIST_NAME)) {
+		return nil // the index is built when the next address is allocated
+	}
+	vpnConfig, err := GetVPNConfig(storage)
+	if err != nil {
+		return fmt.Errorf("failed to get vpn config: %s", err)
+	}
+	ipam, err := loadIPAM(storage, vpnConfig)
+	if err != nil {
+		return err
+	}
+	for _, connectionID := range connectionIDs {
+		ipam.release(connectionID)
+	}
+	return saveIPAM(storage, ipam)
+}
+
+// GetIPConflicts checks the addresses of all connections for duplicates, conflicts with reservations
+// and addresses outside of the address range
+func GetIPConflicts(storage storage.Iface) ([]IPConflict, error) {
+	vpnConfig, err := GetVPNConfig(storage)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get vpn config: %s", err)
+	}
+	ipamMutex.Lock()
+	defer ipamMutex.Unlock()
+	ipam, err := loadIPAM(storage, vpnConfig)
+	if err != nil {
+		return nil, err
+	}
+	_, conflicts, err := rebuildIPAM(storage, vpnConfig, ipam.Reservations)
+	return conflicts, err
+}
+
+// AddIPReservation reserves an address for a connection or a user
+func AddIPReservation(storage storage.Iface, reservation IPReservation) (IPReservation, error) {
+	if (reservation.UserID == "") == (reservation.ConnectionID == "") {
+		return reservation, fmt.Errorf("a reservation needs either a user id or a connection id")
+	}
+	vpnConfig, err := GetVPNConfig(storage)
+	if err != nil {
+		return reservation, fmt.Errorf("failed to get vpn config: %s", err)
+	}
+
+	clientConfigMutex.Lock()
+	defer clientConfigMutex.Unlock()
+	ipamMutex.Lock()
+	defer ipamMutex.Unlock()
+
+	ipam, err := loadIPAM(storage, vpnConfig)
+	if err != nil {
+		return reservation, err
+	}
+	pool, err := ipam.pool(reservation.Address)
+	if err != nil {
+		return reservation, err
+	}
+	prefix, err := pool.parseAddress(reservation.Address)
+	if err != nil {
+		return reservation, fmt.Errorf("invalid address: %s", err)
+	}
+	prefix = netip.PrefixFrom(prefix.Addr(), pool.prefixLen)
+	reservation.Address = prefix.String()
+
+	if reason := pool.checkAddress(prefix, ""); reason != "" {
+		// the address can already be in use by the connection (or a connection of the user) it's reserved for
+		if owner := pool.owner(prefix); owner == "" || !reservationAllowed(reservation, owner) {
+			return reservation, fmt.Errorf("address %s %s", prefix, reason)
+		}
+	}
+	for _, addr := range blockAddresses(prefix.Masked()) {
+		if _, ok := pool.reserved[addr]; ok {
+			return reservation, fmt.Errorf("address %s is already reserved", prefix)
+		}
+	}
+	ipam.Reservations = append(ipam.Reservations, reservation)
+	return reservation, saveIPAM(storage, ipam)
+}
+
+// DeleteIPReservation removes a reservation. Connections keep the address they already have.
+func DeleteIPReservation(storage storage.Iface, address string) error {
+	vpnConfig, err := GetVPNConfig(storage)
+	if err != nil {
+		return fmt.Errorf("failed to get vpn config: %s", err)
+	}
+	ipamMutex.Lock()
+	defer ipamMutex.Unlock()
+	ipam, err := loadIPAM(storage, vpnConfig)
+	if err != nil {
+		return err
+	}
+	addr, err := netip.ParseAddr(strings.Split(address, "/")[0])
+	if err != nil {
+		return fmt.Errorf("invalid address: %s", err)
+	}
+	for k, reservation := range ipam.Reservations {
+		reservedPrefix, err := netip.ParsePrefix(reservation.Address)
+		if err == nil && reservedPrefix.Masked().Contains(addr) {
+			ipam.Reservations = slices.Delete(ipam.Reservations, k, k+1)
+			return saveIPAM(storage, ipam)
+		}
+	}
+	return fmt.Errorf("reservation not found")
+}
+
+func blockAddresses(block netip.Prefix) []netip.Addr {
+	addresses := []netip.Addr{}
+	for addr := block.Addr(); addr.IsValid() && block.Contains(addr); addr = addr.Next() {
+		addresses = append(addresses, addr)
+	}
+	return addresses
+}
+
+func lastAddress(block netip.Prefix) netip.Addr {
+	addresses := blockAddresses(block)
+	return addresses[len(addresses)-1]
+}
+
+func addToAddr(addr netip.Addr, n uint64) netip.Addr {
+	b := addr.As16()
+	carry := n
+	for k := 15; k >= 0 && carry > 0; k-- {
+		sum := uint64(b[k]) + carry&0xff
+		b[k] = byte(sum)
+		carry = carry>>8 + sum>>8
+	}
+	out := netip.AddrFrom16(b)
+	if addr.Is4() && out.Is4In6() {
+		return out.Unmap()
+	}
+	return out
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
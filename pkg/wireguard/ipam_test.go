@@ -0,0 +1,286 @@
+package wireguard
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+	"testing"
+
+	memorystorage "github.com/in4it/go-devops-platform/storage/memory"
+	configmanagerclient "github.com/in4it/wireguard-server/pkg/configmanager/client"
+)
+
+// newIPAMWithAddresses returns a pool where the addresses are allocated, without checking for conflicts
+func newIPAMWithAddresses(t *testing.T, addressRange string, addressPrefix string, addresses []string) *IPPool {
+	prefix, err := netip.ParsePrefix(addressRange)
+	if err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	ipam := &IPPool{AddressRange: prefix, AddressPrefix: addressPrefix}
+	err = ipam.init(nil)
+	if err != nil {
+		t.Fatalf("init error: %s", err)
+	}
+	for k, address := range addresses {
+		addressParsed, err := ipam.parseAddress(address)
+		if err != nil {
+			t.Fatalf("error: %s", err)
+		}
+		connectionID := fmt.Sprintf("existing-%d", k+1)
+		ipam.Allocations[connectionID] = addressParsed.String()
+		ipam.markBlock(ipam.used, addressParsed, connectionID)
+	}
+	return ipam
+}
+
+func TestIPAMAllocateWithList(t *testing.T) {
+	ipam := newIPAMWithAddresses(t, "10.189.184.1/21", "/32", []string{"10.189.184.2"})
+	nextIP, err := ipam.allocate("2-2-2-2-1", addressScope{})
+	if err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	if nextIP.Addr().String() != "10.189.184.3" {
+		t.Fatalf("Wrong IP: %s", nextIP)
+	}
+}
+
+func TestIPAMAllocateWithList2(t *testing.T) {
+	ipam := newIPAMWithAddresses(t, "10.189.184.1/21", "/32", []string{"10.190.190.2", "10.189.184.2", "10.190.190.3"})
+	nextIP, err := ipam.allocate("2-2-2-2-1", addressScope{})
+	if err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	if nextIP.Addr().String() != "10.189.184.3" {
+		t.Fatalf("Wrong IP: %s", nextIP)
+	}
+}
+
+func TestIPAMAllocateWithRange(t *testing.T) {
+	networkPrefix := []string{
+		"/32",
+		"/32",
+		"/32",
+		"/32",
+		"/32",
+		"/32",
+		"/30",
+		"/30",
+		"/32",
+	}
+	testCases := [][]string{
+		{},
+		{"10.189.184.2"},
+		{"10.189.184.2/32"},
+		{"10.189.184.2", "10.189.184.3", "10.189.184.4/30"},
+		{"10.189.184.2", "10.189.184.3", "10.189.184.4/30", "10.189.184.8/32"},
+		{"10.189.184.1/30", "10.189.184.4/30", "10.189.184.8/30"},
+		{},
+		{"10.189.184.4/30", "10.189.184.8/30"},
+		{"10.189.189.2/32", "10.189.189.3/32", "10.189.189.4/32"},
+	}
+	expected := []string{
+		"10.189.184.2",
+		"10.189.184.3",
+		"10.189.184.3",
+		"10.189.184.8",
+		"10.189.184.9",
+		"10.189.184.12",
+		"10.189.184.4",
+		"10.189.184.12",
+		"10.189.184.2",
+	}
+
+	for k := range testCases {
+		ipam := newIPAMWithAddresses(t, "10.189.184.1/21", networkPrefix[k], testCases[k])
+		nextIP, err := ipam.allocate("2-2-2-2-1", addressScope{})
+		if err != nil {
+			t.Fatalf("error: %s", err)
+		}
+		if nextIP.Addr().String() != expected[k] {
+			t.Fatalf("Wrong IP: %s", nextIP)
+		}
+	}
+}
+
+func TestIPAMNotInRange(t *testing.T) {
+	ipam := newIPAMWithAddresses(t, "10.189.184.1/21", "/22", []string{"10.189.188.0/22"})
+	_, err := ipam.allocate("2-2-2-2-1", addressScope{})
+	if err == nil || !strings.Contains(err.Error(), "not within address range") {
+		t.Fatalf("Expected error, got: %s", err)
+	}
+}
+
+func TestIPAMNextFit(t *testing.T) {
+	ipam := newIPAMWithAddresses(t, "10.0.0.1/29", "/32", []string{})
+	for k, expected := range []string{"10.0.0.2", "10.0.0.3", "10.0.0.4"} {
+		nextIP, err := ipam.allocate(fmt.Sprintf("2-2-2-2-%d", k+1), addressScope{})
+		if err != nil {
+			t.Fatalf("error: %s", err)
+		}
+		if nextIP.Addr().String() != expected {
+			t.Fatalf("Wrong IP: %s (expected %s)", nextIP, expected)
+		}
+	}
+	// released addresses are only handed out again once the end of the range is reached
+	ipam.release("2-2-2-2-1")
+	for k, expected := range []string{"10.0.0.5", "10.0.0.6", "10.0.0.7", "10.0.0.2"} {
+		nextIP, err := ipam.allocate(fmt.Sprintf("3-3-3-3-%d", k+1), addressScope{})
+		if err != nil {
+			t.Fatalf("error: %s", err)
+		}
+		if nextIP.Addr().String() != expected {
+			t.Fatalf("Wrong IP: %s (expected %s)", nextIP, expected)
+		}
+	}
+	_, err := ipam.allocate("3-3-3-3-5", addressScope{})
+	if err == nil {
+		t.Fatalf("expected error when address range is full")
+	}
+}
+
+func TestIPAMReservations(t *testing.T) {
+	ipam := newIPAMWithAddresses(t, "10.0.0.1/24", "/32", []string{})
+	err := ipam.init([]IPReservation{
+		{Address: "10.0.0.2/32", UserID: "1-1-1-1"},
+		{Address: "10.0.0.100/32", ConnectionID: "2-2-2-2-2"},
+		{Address: "fd00::2/128", UserID: "1-1-1-1"},
+	})
+	if err != nil {
+		t.Fatalf("init error: %s", err)
+	}
+
+	// reserved addresses are skipped for other users
+	nextIP, err := ipam.allocate("2-2-2-2-1", addressScope{})
+	if err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	if nextIP.Addr().String() != "10.0.0.3" {
+		t.Fatalf("Wrong IP: %s", nextIP)
+	}
+	nextIP, err = ipam.allocate("2-2-2-2-2", addressScope{})
+	if err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	if nextIP.Addr().String() != "10.0.0.100" {
+		t.Fatalf("expected reserved address for connection. Got: %s", nextIP)
+	}
+	nextIP, err = ipam.allocate("1-1-1-1-1", addressScope{})
+	if err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	if nextIP.Addr().String() != "10.0.0.2" {
+		t.Fatalf("expected reserved address for user. Got: %s", nextIP)
+	}
+	// the user reservation is in use: the next connection of the user gets a free address
+	nextIP, err = ipam.allocate("1-1-1-1-2", addressScope{})
+	if err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	if nextIP.Addr().String() != "10.0.0.4" {
+		t.Fatalf("Wrong IP: %s", nextIP)
+	}
+	if reason := ipam.checkAddress(netip.MustParsePrefix("10.0.0.100/32"), "3-3-3-3-1"); reason != "already in use by 2-2-2-2-2" {
+		t.Fatalf("unexpected reason: %s", reason)
+	}
+}
+
+func TestIPAMWithClientConfigs(t *testing.T) {
+	var err error
+	UseConfigManagerClient(configmanagerclient.NewFake())
+	defer UseConfigManagerClient(nil)
+
+	storage := &memorystorage.MockMemoryStorage{}
+
+	_, err = CreateNewVPNConfig(storage)
+	if err != nil {
+		t.Fatalf("CreateNewVPNConfig error: %s", err)
+	}
+
+	reservation, err := AddIPReservation(storage, IPReservation{Address: "10.189.184.50", UserID: "3-3-3-3"})
+	if err != nil {
+		t.Fatalf("AddIPReservation error: %s", err)
+	}
+	if reservation.Address != "10.189.184.50/32" {
+		t.Fatalf("unexpected reservation address: %s", reservation.Address)
+	}
+	_, err = AddIPReservation(storage, IPReservation{Address: "10.189.184.50", ConnectionID: "4-4-4-4-1"})
+	if err == nil {
+		t.Fatalf("expected error when reserving an address twice")
+	}
+	_, err = AddIPReservation(storage, IPReservation{Address: "10.189.184.1", ConnectionID: "4-4-4-4-1"})
+	if err == nil {
+		t.Fatalf("expected error when reserving the server address")
+	}
+
+	peerConfig, err := NewEmptyClientConfig(storage, "3-3-3-3")
+	if err != nil {
+		t.Fatalf("NewEmptyClientConfig error: %s", err)
+	}
+	if peerConfig.Address != "10.189.184.50/32" {
+		t.Fatalf("expected reserved address. Got: %s", peerConfig.Address)
+	}
+	peerConfig1, err := NewEmptyClientConfig(storage, "2-2-2-2")
+	if err != nil {
+		t.Fatalf("NewEmptyClientConfig error: %s", err)
+	}
+	peerConfig2, err := NewEmptyClientConfig(storage, "2-2-2-2")
+	if err != nil {
+		t.Fatalf("NewEmptyClientConfig error: %s", err)
+	}
+	if peerConfig1.Address != "10.189.184.2/32" || peerConfig2.Address != "10.189.184.3/32" {
+		t.Fatalf("unexpected addresses: %s, %s", peerConfig1.Address, peerConfig2.Address)
+	}
+	if _, ok := storage.Data[storage.ConfigPath(IP_LIST_NAME)]; !ok {
+		t.Fatalf("ip list not written")
+	}
+
+	// create a duplicate address outside of the ipam
+	peerConfig2.Address = peerConfig1.Address
+	err = writePeerConfig(storage, peerConfig2)
+	if err != nil {
+		t.Fatalf("writePeerConfig error: %s", err)
+	}
+	conflicts, err := GetIPConflicts(storage)
+	if err != nil {
+		t.Fatalf("GetIPConflicts error: %s", err)
+	}
+	if len(conflicts) != 1 || conflicts[0].Reason != "duplicate address" || len(conflicts[0].ConnectionIDs) != 2 {
+		t.Fatalf("unexpected conflicts: %+v", conflicts)
+	}
+
+	// updating the client configs resolves the conflict
+	err = UpdateClientsConfig(storage)
+	if err != nil {
+		t.Fatalf("UpdateClientsConfig error: %s", err)
+	}
+	peerConfig2, err = getPeerConfig(storage, peerConfig2.ID)
+	if err != nil {
+		t.Fatalf("getPeerConfig error: %s", err)
+	}
+	if peerConfig2.Address == peerConfig1.Address || peerConfig2.Address == "10.189.184.50/32" {
+		t.Fatalf("conflicting address not reallocated: %s", peerConfig2.Address)
+	}
+	conflicts, err = GetIPConflicts(storage)
+	if err != nil {
+		t.Fatalf("GetIPConflicts error: %s", err)
+	}
+	if len(conflicts) != 0 {
+		t.Fatalf("unexpected conflicts: %+v", conflicts)
+	}
+
+	err = DeleteClientConfig(storage, peerConfig.ID, "3-3-3-3")
+	if err != nil {
+		t.Fatalf("DeleteClientConfig error: %s", err)
+	}
+	ipam, err := GetIPAM(storage)
+	if err != nil {
+		t.Fatalf("GetIPAM error: %s", err)
+	}
+	if _, ok := ipam.IPv4.Allocations[peerConfig.ID]; ok {
+		t.Fatalf("address of deleted connection not released")
+	}
+	err = DeleteIPReservation(storage, "10.189.184.50")
+	if err != nil {
+		t.Fatalf("DeleteIPReservation error: %s", err)
+	}
+}
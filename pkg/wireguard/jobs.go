@@ -0,0 +1,45 @@
+package wireguard
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/in4it/go-devops-platform/storage"
+	configmanagerclient "github.com/in4it/wireguard-server/pkg/configmanager/client"
+)
+
+const JOB_WAIT_TIMEOUT = 30 * time.Second
+
+var jobIDRegexp = regexp.MustCompile(`^[a-f0-9]{32}$`)
+
+func IsValidJobID(jobID string) bool {
+	return jobIDRegexp.MatchString(jobID)
+}
+
+// GetJob returns the status of a configmanager job
+func GetJob(ctx context.Context, storage storage.Iface, jobID string) (Job, error) {
+	if !IsValidJobID(jobID) {
+		return Job{}, fmt.Errorf("invalid job id")
+	}
+	client, err := NewConfigManagerClient(storage)
+	if err != nil {
+		return Job{}, err
+	}
+	return client.Job(ctx, jobID)
+}
+
+// WaitForJob polls the configmanager until the job is finished. An error is returned when the job failed or didn't finish in time.
+func WaitForJob(storage storage.Iface, jobID string, timeout time.Duration) (Job, error) {
+	if !IsValidJobID(jobID) {
+		return Job{}, fmt.Errorf("invalid job id")
+	}
+	client, err := NewConfigManagerClient(storage)
+	if err != nil {
+		return Job{}, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return configmanagerclient.WaitForJob(ctx, client, jobID)
+}
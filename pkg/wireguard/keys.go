@@ -37,3 +37,30 @@ func GeneratePresharedKey() (string, error) {
 	}
 	return base64.StdEncoding.EncodeToString(key), nil
 }
+
+func ValidatePublicKey(publicKey string) error {
+	key, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil {
+		return fmt.Errorf("public key is not base64 encoded: %s", err)
+	}
+	if len(key) != keyLength {
+		return fmt.Errorf("public key has wrong length: expected %d bytes, got %d bytes", keyLength, len(key))
+	}
+	return nil
+}
+
+// getPublicKey derives the public key from a private key
+func getPublicKey(privateKey string) (string, error) {
+	key, err := base64.StdEncoding.DecodeString(privateKey)
+	if err != nil {
+		return "", fmt.Errorf("private key is not base64 encoded: %s", err)
+	}
+	if len(key) != keyLength {
+		return "", fmt.Errorf("private key has wrong length: expected %d bytes, got %d bytes", keyLength, len(key))
+	}
+	publicKey, err := curve25519.X25519(key, curve25519.Basepoint)
+	if err != nil {
+		return "", fmt.Errorf("could not derive public key: %s", err)
+	}
+	return base64.StdEncoding.EncodeToString(publicKey), nil
+}
@@ -12,3 +12,18 @@ func TestGenerateKeys(t *testing.T) {
 	}
 	fmt.Printf("%s\n%s", priv, pub)
 }
+
+func TestValidatePublicKey(t *testing.T) {
+	_, pub, err := GenerateKeys()
+	if err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	if err := ValidatePublicKey(pub); err != nil {
+		t.Fatalf("expected valid public key, got error: %s", err)
+	}
+	for _, invalidKey := range []string{"", "not base64", "dGVzdA=="} {
+		if err := ValidatePublicKey(invalidKey); err == nil {
+			t.Fatalf("expected error for invalid public key %q", invalidKey)
+		}
+	}
+}
@@ -181,6 +181,26 @@ func (c *Client) Device(name string) (*Device, error) {
 	return parseDevice(msgs)
 }
 
+// ConfigureDevice applies the configuration to the device specified by name.
+// Large configurations are split into batches, unless cfg.Atomic is set.
+func (c *Client) ConfigureDevice(name string, cfg Config) error {
+	for _, b := range buildBatches(cfg) {
+		attrs, err := configAttrs(name, b)
+		if err != nil {
+			return err
+		}
+
+		// Request acknowledgement of our request from netlink, even though the
+		// output messages are unused. The netlink package checks and trims the
+		// status code value.
+		if _, err := c.execute(unix.WG_CMD_SET_DEVICE, netlink.Request|netlink.Acknowledge, attrs); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 // execute executes a single WireGuard netlink request with the specified command,
 // header flags, and attribute arguments.
 func (c *Client) execute(command uint8, flags netlink.HeaderFlags, attrb []byte) ([]genetlink.Message, error) {
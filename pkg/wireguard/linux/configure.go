@@ -2,12 +2,284 @@
 
 package wireguardlinux
 
+// from https://github.com/WireGuard/wgctrl-go/blob/master/internal/wglinux/configure_linux.go (MIT license)
 import (
 	"encoding/binary"
 	"fmt"
+	"net"
 	"unsafe"
+
+	"github.com/mdlayher/netlink"
+	"golang.org/x/sys/unix"
 )
 
+// configAttrs creates the required encoded netlink attributes to configure
+// the device specified by name using the non-nil fields in cfg.
+func configAttrs(name string, cfg Config) ([]byte, error) {
+	ae := netlink.NewAttributeEncoder()
+	ae.String(unix.WGDEVICE_A_IFNAME, name)
+
+	if cfg.PrivateKey != nil {
+		ae.Bytes(unix.WGDEVICE_A_PRIVATE_KEY, (*cfg.PrivateKey)[:])
+	}
+
+	if cfg.ListenPort != nil {
+		ae.Uint16(unix.WGDEVICE_A_LISTEN_PORT, uint16(*cfg.ListenPort))
+	}
+
+	if cfg.FirewallMark != nil {
+		ae.Uint32(unix.WGDEVICE_A_FWMARK, uint32(*cfg.FirewallMark))
+	}
+
+	if cfg.ReplacePeers {
+		ae.Uint32(unix.WGDEVICE_A_FLAGS, unix.WGDEVICE_F_REPLACE_PEERS)
+	}
+
+	// Only apply peer attributes if necessary.
+	if len(cfg.Peers) > 0 {
+		ae.Nested(unix.WGDEVICE_A_PEERS, func(nae *netlink.AttributeEncoder) error {
+			// Netlink arrays use type as an array index.
+			for i, p := range cfg.Peers {
+				nae.Nested(uint16(i), func(nnae *netlink.AttributeEncoder) error {
+					return encodePeer(nnae, p)
+				})
+			}
+
+			return nil
+		})
+	}
+
+	return ae.Encode()
+}
+
+// ipBatchChunk is a tunable allowed IP batch limit per peer.
+//
+// Because we don't necessarily know how much space a given peer will occupy,
+// we play it safe and use a reasonably small value.
+const ipBatchChunk = 256
+
+// peerBatchChunk specifies the number of peers that can appear in a
+// configuration before we start splitting it into chunks.
+const peerBatchChunk = 32
+
+// shouldBatch determines if a configuration is sufficiently complex that it
+// should be split into batches.
+func shouldBatch(cfg Config) bool {
+	if len(cfg.Peers) > peerBatchChunk {
+		return true
+	}
+
+	var ips int
+	for _, p := range cfg.Peers {
+		ips += len(p.AllowedIPs)
+	}
+
+	return ips > ipBatchChunk
+}
+
+// buildBatches produces a batch of configs from a single config, if needed.
+// Every batch is sent as a single netlink message, which the kernel applies
+// while holding the device lock.
+func buildBatches(cfg Config) []Config {
+	// Is this a small configuration; no need to batch?
+	if cfg.Atomic || !shouldBatch(cfg) {
+		return []Config{cfg}
+	}
+
+	// Use most fields of cfg for our "base" configuration, and only differ
+	// peers in each batch.
+	base := cfg
+	base.Peers = nil
+
+	// Track the known peers so that peer IPs are not replaced if a single
+	// peer has its allowed IPs split into multiple batches.
+	knownPeers := make(map[Key]struct{})
+
+	batches := make([]Config, 0)
+	for _, p := range cfg.Peers {
+		batch := base
+
+		// Iterate until no more allowed IPs.
+		var done bool
+		for !done {
+			var tmp []net.IPNet
+			if len(p.AllowedIPs) < ipBatchChunk {
+				// IPs all fit within a batch; we are done.
+				tmp = make([]net.IPNet, len(p.AllowedIPs))
+				copy(tmp, p.AllowedIPs)
+				done = true
+			} else {
+				// IPs are larger than a single batch, copy a batch out and
+				// advance the cursor.
+				tmp = make([]net.IPNet, ipBatchChunk)
+				copy(tmp, p.AllowedIPs[:ipBatchChunk])
+
+				p.AllowedIPs = p.AllowedIPs[ipBatchChunk:]
+
+				if len(p.AllowedIPs) == 0 {
+					// IPs ended on a batch boundary; no more IPs left so end
+					// iteration after this loop.
+					done = true
+				}
+			}
+
+			pcfg := PeerConfig{
+				// PublicKey denotes the peer and must be present.
+				PublicKey: p.PublicKey,
+
+				// Apply the update only flag to every chunk to ensure
+				// consistency between batches when the kernel module processes
+				// them.
+				UpdateOnly: p.UpdateOnly,
+
+				// It'd be a bit weird to have a remove peer message with many
+				// IPs, but just in case, add this to every peer's message.
+				Remove: p.Remove,
+
+				// The IPs for this chunk.
+				AllowedIPs: tmp,
+			}
+
+			// Only pass certain fields on the first occurrence of a peer, so
+			// that subsequent IPs won't be wiped out and space isn't wasted.
+			if _, ok := knownPeers[p.PublicKey]; !ok {
+				knownPeers[p.PublicKey] = struct{}{}
+
+				pcfg.PresharedKey = p.PresharedKey
+				pcfg.Endpoint = p.Endpoint
+				pcfg.PersistentKeepaliveInterval = p.PersistentKeepaliveInterval
+
+				// Important: do not move or appending peers won't work.
+				pcfg.ReplaceAllowedIPs = p.ReplaceAllowedIPs
+			}
+
+			// Add a peer configuration to this batch and keep going.
+			batch.Peers = []PeerConfig{pcfg}
+			batches = append(batches, batch)
+		}
+	}
+
+	// Do not allow peer replacement beyond the first message in a batch,
+	// so we don't overwrite our previous batch work.
+	for i := range batches {
+		if i > 0 {
+			batches[i].ReplacePeers = false
+		}
+	}
+
+	return batches
+}
+
+// encodePeer converts a PeerConfig into netlink attribute encoder bytes.
+func encodePeer(ae *netlink.AttributeEncoder, p PeerConfig) error {
+	ae.Bytes(unix.WGPEER_A_PUBLIC_KEY, p.PublicKey[:])
+
+	// Flags are stored in a single attribute.
+	var flags uint32
+	if p.Remove {
+		flags |= unix.WGPEER_F_REMOVE_ME
+	}
+	if p.ReplaceAllowedIPs {
+		flags |= unix.WGPEER_F_REPLACE_ALLOWEDIPS
+	}
+	if p.UpdateOnly {
+		flags |= unix.WGPEER_F_UPDATE_ONLY
+	}
+	if flags != 0 {
+		ae.Uint32(unix.WGPEER_A_FLAGS, flags)
+	}
+
+	if p.PresharedKey != nil {
+		ae.Bytes(unix.WGPEER_A_PRESHARED_KEY, (*p.PresharedKey)[:])
+	}
+
+	if p.Endpoint != nil {
+		ae.Do(unix.WGPEER_A_ENDPOINT, func() ([]byte, error) {
+			return encodeSockaddr(*p.Endpoint)
+		})
+	}
+
+	if p.PersistentKeepaliveInterval != nil {
+		ae.Uint16(unix.WGPEER_A_PERSISTENT_KEEPALIVE_INTERVAL, uint16(p.PersistentKeepaliveInterval.Seconds()))
+	}
+
+	// Only apply allowed IPs if necessary.
+	if len(p.AllowedIPs) > 0 {
+		ae.Nested(unix.WGPEER_A_ALLOWEDIPS, func(nae *netlink.AttributeEncoder) error {
+			return encodeAllowedIPs(nae, p.AllowedIPs)
+		})
+	}
+
+	return nil
+}
+
+// encodeSockaddr encodes a socket address into netlink attribute encoder bytes.
+func encodeSockaddr(endpoint net.UDPAddr) ([]byte, error) {
+	if v4 := endpoint.IP.To4(); v4 != nil {
+		// IPv4 address.
+		b := *(*[unix.SizeofSockaddrInet4]byte)(unsafe.Pointer(&unix.RawSockaddrInet4{
+			Family: unix.AF_INET,
+			Addr:   [4]byte(v4),
+			Port:   sockaddrPort(endpoint.Port),
+		}))
+
+		return b[:], nil
+	}
+
+	v6 := endpoint.IP.To16()
+	if v6 == nil {
+		return nil, fmt.Errorf("wglinux: invalid endpoint address: %s", endpoint.IP)
+	}
+
+	// IPv6 address, with an optional zone.
+	var zone uint32
+	if endpoint.Zone != "" {
+		iface, err := net.InterfaceByName(endpoint.Zone)
+		if err != nil {
+			return nil, err
+		}
+
+		zone = uint32(iface.Index)
+	}
+
+	b := *(*[unix.SizeofSockaddrInet6]byte)(unsafe.Pointer(&unix.RawSockaddrInet6{
+		Family:   unix.AF_INET6,
+		Addr:     [16]byte(v6),
+		Port:     sockaddrPort(endpoint.Port),
+		Scope_id: zone,
+	}))
+
+	return b[:], nil
+}
+
+// encodeAllowedIPs encodes a slice of net.IPNets into netlink attribute encoder
+// bytes.
+func encodeAllowedIPs(ae *netlink.AttributeEncoder, ipns []net.IPNet) error {
+	for i, ipn := range ipns {
+		ae.Nested(uint16(i), func(nae *netlink.AttributeEncoder) error {
+			// Allowed IPs are encoded with the IPv4 or IPv6 family, which
+			// determines the length of the address.
+			family := uint16(unix.AF_INET6)
+			ip := ipn.IP.To16()
+			if v4 := ipn.IP.To4(); v4 != nil {
+				family = unix.AF_INET
+				ip = v4
+			}
+			if ip == nil {
+				return fmt.Errorf("wglinux: invalid allowed ip: %s", ipn.String())
+			}
+
+			ones, _ := ipn.Mask.Size()
+			nae.Uint16(unix.WGALLOWEDIP_A_FAMILY, family)
+			nae.Bytes(unix.WGALLOWEDIP_A_IPADDR, ip)
+			nae.Uint8(unix.WGALLOWEDIP_A_CIDR_MASK, uint8(ones))
+			return nil
+		})
+	}
+
+	return nil
+}
+
 // sockaddrPort interprets port as a big endian uint16 for use passing sockaddr
 // structures to the kernel.
 func sockaddrPort(port int) uint16 {
@@ -0,0 +1,67 @@
+//go:build linux
+
+package wireguardlinux
+
+import (
+	"net"
+	"testing"
+
+	"github.com/mdlayher/netlink"
+	"golang.org/x/sys/unix"
+)
+
+func TestBuildBatches(t *testing.T) {
+	peers := make([]PeerConfig, peerBatchChunk+1)
+	for k := range peers {
+		peers[k] = PeerConfig{PublicKey: Key{byte(k)}, ReplaceAllowedIPs: true}
+	}
+	batches := buildBatches(Config{Peers: peers, ReplacePeers: true})
+	if len(batches) != len(peers) {
+		t.Fatalf("expected %d batches, got %d", len(peers), len(batches))
+	}
+	if !batches[0].ReplacePeers || batches[1].ReplacePeers {
+		t.Fatalf("only the first batch can replace peers")
+	}
+	batches = buildBatches(Config{Peers: peers, Atomic: true})
+	if len(batches) != 1 {
+		t.Fatalf("expected a single batch for an atomic config, got %d", len(batches))
+	}
+}
+
+func TestConfigAttrsAllowedIPs(t *testing.T) {
+	_, ipNet4, _ := net.ParseCIDR("10.189.184.2/32")
+	_, ipNet6, _ := net.ParseCIDR("fd00::2/128")
+	b, err := configAttrs("vpn", Config{Peers: []PeerConfig{{PublicKey: Key{1}, AllowedIPs: []net.IPNet{*ipNet4, *ipNet6}}}})
+	if err != nil {
+		t.Fatalf("configAttrs error: %s", err)
+	}
+	ad, err := netlink.NewAttributeDecoder(b)
+	if err != nil {
+		t.Fatalf("NewAttributeDecoder error: %s", err)
+	}
+	var peer Peer
+	for ad.Next() {
+		switch ad.Type() {
+		case unix.WGDEVICE_A_IFNAME:
+			if ad.String() != "vpn" {
+				t.Fatalf("unexpected interface name: %s", ad.String())
+			}
+		case unix.WGDEVICE_A_PEERS:
+			ad.Nested(func(nad *netlink.AttributeDecoder) error {
+				for nad.Next() {
+					nad.Nested(func(nnad *netlink.AttributeDecoder) error {
+						peer = parsePeer(nnad)
+						return nil
+					})
+				}
+				return nil
+			})
+		}
+	}
+	if err := ad.Err(); err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+	if peer.PublicKey != (Key{1}) || len(peer.AllowedIPs) != 2 || peer.AllowedIPs[0].String() != "10.189.184.2/32" || peer.AllowedIPs[1].String() != "fd00::2/128" {
+		t.Fatalf("unexpected peer: %+v", peer)
+	}
+}
@@ -1,5 +1,3 @@
-//go:build linux
-
 package wireguardlinux
 
 const VPN_INTERFACE_NAME = "vpn"
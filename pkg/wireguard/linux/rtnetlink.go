@@ -0,0 +1,327 @@
+//go:build linux
+
+package wireguardlinux
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"unsafe"
+
+	"github.com/mdlayher/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// An InterfaceError describes a failed rtnetlink operation on a network interface
+type InterfaceError struct {
+	// Op is the operation that failed, e.g. "create link" or "add address"
+	Op string
+	// Interface is the name of the interface
+	Interface string
+	// Target is the address or route of the operation, if any
+	Target string
+	Err    error
+}
+
+func (e *InterfaceError) Error() string {
+	if e.Target == "" {
+		return fmt.Sprintf("%s %s: %s", e.Op, e.Interface, e.Err)
+	}
+	return fmt.Sprintf("%s %s on %s: %s", e.Op, e.Target, e.Interface, e.Err)
+}
+
+func (e *InterfaceError) Unwrap() error {
+	return e.Err
+}
+
+// RTNLClient manages links, addresses and routes through rtnetlink
+type RTNLClient struct {
+	c *netlink.Conn
+}
+
+func NewRTNL() (*RTNLClient, error) {
+	c, err := netlink.Dial(unix.NETLINK_ROUTE, nil)
+	if err != nil {
+		return nil, fmt.Errorf("rtnetlink dial error: %s", err)
+	}
+	return &RTNLClient{c: c}, nil
+}
+
+func (r *RTNLClient) Close() error {
+	return r.c.Close()
+}
+
+// EnsureLink returns the index of the WireGuard link with the given name. The link is created when it doesn't exist yet.
+func (r *RTNLClient) EnsureLink(name string) (int, bool, error) {
+	iface, err := net.InterfaceByName(name)
+	if err == nil {
+		wgInterfaces, err := rtnlInterfaces()
+		if err != nil {
+			return 0, false, &InterfaceError{Op: "list links", Interface: name, Err: err}
+		}
+		for _, wgInterface := range wgInterfaces {
+			if wgInterface == name {
+				return iface.Index, false, nil
+			}
+		}
+		return 0, false, &InterfaceError{Op: "check link", Interface: name, Err: fmt.Errorf("link exists, but is not a WireGuard link")}
+	}
+
+	ae := netlink.NewAttributeEncoder()
+	ae.String(unix.IFLA_IFNAME, name)
+	ae.Nested(unix.IFLA_LINKINFO, func(nae *netlink.AttributeEncoder) error {
+		nae.String(unix.IFLA_INFO_KIND, wgKind)
+		return nil
+	})
+	attrs, err := ae.Encode()
+	if err != nil {
+		return 0, false, &InterfaceError{Op: "create link", Interface: name, Err: err}
+	}
+	_, err = r.execute(unix.RTM_NEWLINK, netlink.Create|netlink.Excl, structBytes(&unix.IfInfomsg{Family: unix.AF_UNSPEC}), attrs)
+	if err != nil {
+		return 0, false, &InterfaceError{Op: "create link", Interface: name, Err: err}
+	}
+	iface, err = net.InterfaceByName(name)
+	if err != nil {
+		return 0, false, &InterfaceError{Op: "get link", Interface: name, Err: err}
+	}
+	return iface.Index, true, nil
+}
+
+// DeleteLink removes the link. A link that doesn't exist is not an error.
+func (r *RTNLClient) DeleteLink(name string) error {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil
+	}
+	_, err = r.execute(unix.RTM_DELLINK, 0, structBytes(&unix.IfInfomsg{Family: unix.AF_UNSPEC, Index: int32(iface.Index)}), nil)
+	if err != nil {
+		return &InterfaceError{Op: "delete link", Interface: name, Err: err}
+	}
+	return nil
+}
+
+// SetLinkMTU changes the MTU of the link
+func (r *RTNLClient) SetLinkMTU(index int, mtu int) error {
+	ae := netlink.NewAttributeEncoder()
+	ae.Uint32(unix.IFLA_MTU, uint32(mtu))
+	attrs, err := ae.Encode()
+	if err != nil {
+		return &InterfaceError{Op: "set mtu", Interface: interfaceName(index), Err: err}
+	}
+	_, err = r.execute(unix.RTM_NEWLINK, 0, structBytes(&unix.IfInfomsg{Family: unix.AF_UNSPEC, Index: int32(index)}), attrs)
+	if err != nil {
+		return &InterfaceError{Op: "set mtu", Interface: interfaceName(index), Err: err}
+	}
+	return nil
+}
+
+// SetLinkUp brings the link up
+func (r *RTNLClient) SetLinkUp(index int) error {
+	_, err := r.execute(unix.RTM_NEWLINK, 0, structBytes(&unix.IfInfomsg{Family: unix.AF_UNSPEC, Index: int32(index), Flags: unix.IFF_UP, Change: unix.IFF_UP}), nil)
+	if err != nil {
+		return &InterfaceError{Op: "set link up", Interface: interfaceName(index), Err: err}
+	}
+	return nil
+}
+
+// Addresses returns the addresses of the link, with the prefix length of the network
+func (r *RTNLClient) Addresses(index int) ([]netip.Prefix, error) {
+	msgs, err := r.execute(unix.RTM_GETADDR, netlink.Dump, structBytes(&unix.IfAddrmsg{Family: unix.AF_UNSPEC}), nil)
+	if err != nil {
+		return nil, &InterfaceError{Op: "list addresses", Interface: interfaceName(index), Err: err}
+	}
+	addresses := []netip.Prefix{}
+	for _, m := range msgs {
+		if m.Header.Type != unix.RTM_NEWADDR || len(m.Data) < unix.SizeofIfAddrmsg {
+			continue
+		}
+		ifAddrmsg := (*unix.IfAddrmsg)(unsafe.Pointer(&m.Data[0]))
+		if int(ifAddrmsg.Index) != index {
+			continue
+		}
+		ad, err := netlink.NewAttributeDecoder(m.Data[unix.SizeofIfAddrmsg:])
+		if err != nil {
+			return nil, &InterfaceError{Op: "list addresses", Interface: interfaceName(index), Err: err}
+		}
+		var address, local netip.Addr
+		for ad.Next() {
+			switch ad.Type() {
+			case unix.IFA_ADDRESS:
+				address, _ = netip.AddrFromSlice(ad.Bytes())
+			case unix.IFA_LOCAL:
+				local, _ = netip.AddrFromSlice(ad.Bytes())
+			}
+		}
+		if err := ad.Err(); err != nil {
+			return nil, &InterfaceError{Op: "list addresses", Interface: interfaceName(index), Err: err}
+		}
+		if local.IsValid() {
+			address = local
+		}
+		if address.IsValid() {
+			addresses = append(addresses, netip.PrefixFrom(address.Unmap(), int(ifAddrmsg.Prefixlen)))
+		}
+	}
+	return addresses, nil
+}
+
+// AddAddress assigns the address to the link. The kernel adds the route to the network of the address.
+func (r *RTNLClient) AddAddress(index int, address netip.Prefix) error {
+	return r.address(unix.RTM_NEWADDR, netlink.Create|netlink.Replace, "add address", index, address)
+}
+
+// DeleteAddress removes the address from the link
+func (r *RTNLClient) DeleteAddress(index int, address netip.Prefix) error {
+	return r.address(unix.RTM_DELADDR, 0, "delete address", index, address)
+}
+
+func (r *RTNLClient) address(typ netlink.HeaderType, flags netlink.HeaderFlags, op string, index int, address netip.Prefix) error {
+	ae := netlink.NewAttributeEncoder()
+	ae.Bytes(unix.IFA_LOCAL, address.Addr().AsSlice())
+	ae.Bytes(unix.IFA_ADDRESS, address.Addr().AsSlice())
+	attrs, err := ae.Encode()
+	if err != nil {
+		return &InterfaceError{Op: op, Interface: interfaceName(index), Target: address.String(), Err: err}
+	}
+	ifAddrmsg := unix.IfAddrmsg{
+		Family:    addrFamily(address.Addr()),
+		Prefixlen: uint8(address.Bits()),
+		Index:     uint32(index),
+	}
+	_, err = r.execute(typ, flags, structBytes(&ifAddrmsg), attrs)
+	if err != nil {
+		return &InterfaceError{Op: op, Interface: interfaceName(index), Target: address.String(), Err: err}
+	}
+	return nil
+}
+
+// Routes returns the destinations of the routes in the main table that go out of the link and were installed with the given protocol
+func (r *RTNLClient) Routes(index int, protocol uint8) ([]netip.Prefix, error) {
+	routes := []netip.Prefix{}
+	for _, family := range []uint8{unix.AF_INET, unix.AF_INET6} {
+		msgs, err := r.execute(unix.RTM_GETROUTE, netlink.Dump, structBytes(&unix.RtMsg{Family: family}), nil)
+		if err != nil {
+			return nil, &InterfaceError{Op: "list routes", Interface: interfaceName(index), Err: err}
+		}
+		for _, m := range msgs {
+			if m.Header.Type != unix.RTM_NEWROUTE || len(m.Data) < unix.SizeofRtMsg {
+				continue
+			}
+			rtMsg := (*unix.RtMsg)(unsafe.Pointer(&m.Data[0]))
+			if rtMsg.Protocol != protocol || rtMsg.Table != unix.RT_TABLE_MAIN {
+				continue
+			}
+			ad, err := netlink.NewAttributeDecoder(m.Data[unix.SizeofRtMsg:])
+			if err != nil {
+				return nil, &InterfaceError{Op: "list routes", Interface: interfaceName(index), Err: err}
+			}
+			var (
+				dst netip.Addr
+				oif int
+			)
+			for ad.Next() {
+				switch ad.Type() {
+				case unix.RTA_DST:
+					dst, _ = netip.AddrFromSlice(ad.Bytes())
+				case unix.RTA_OIF:
+					oif = int(ad.Uint32())
+				}
+			}
+			if err := ad.Err(); err != nil {
+				return nil, &InterfaceError{Op: "list routes", Interface: interfaceName(index), Err: err}
+			}
+			if oif != index {
+				continue
+			}
+			if !dst.IsValid() { // default route
+				dst = netip.IPv4Unspecified()
+				if family == unix.AF_INET6 {
+					dst = netip.IPv6Unspecified()
+				}
+			}
+			routes = append(routes, netip.PrefixFrom(dst, int(rtMsg.Dst_len)))
+		}
+	}
+	return routes, nil
+}
+
+// ReplaceRoute adds or replaces the route to the destination through the link
+func (r *RTNLClient) ReplaceRoute(index int, dst netip.Prefix, protocol uint8) error {
+	return r.route(unix.RTM_NEWROUTE, netlink.Create|netlink.Replace, "replace route", index, dst, protocol)
+}
+
+// DeleteRoute removes the route to the destination through the link
+func (r *RTNLClient) DeleteRoute(index int, dst netip.Prefix, protocol uint8) error {
+	return r.route(unix.RTM_DELROUTE, 0, "delete route", index, dst, protocol)
+}
+
+func (r *RTNLClient) route(typ netlink.HeaderType, flags netlink.HeaderFlags, op string, index int, dst netip.Prefix, protocol uint8) error {
+	dst = dst.Masked()
+	ae := netlink.NewAttributeEncoder()
+	ae.Bytes(unix.RTA_DST, dst.Addr().AsSlice())
+	ae.Uint32(unix.RTA_OIF, uint32(index))
+	attrs, err := ae.Encode()
+	if err != nil {
+		return &InterfaceError{Op: op, Interface: interfaceName(index), Target: dst.String(), Err: err}
+	}
+	rtMsg := unix.RtMsg{
+		Family:   addrFamily(dst.Addr()),
+		Dst_len:  uint8(dst.Bits()),
+		Table:    unix.RT_TABLE_MAIN,
+		Protocol: protocol,
+		Scope:    unix.RT_SCOPE_LINK,
+		Type:     unix.RTN_UNICAST,
+	}
+	_, err = r.execute(typ, flags, structBytes(&rtMsg), attrs)
+	if err != nil {
+		return &InterfaceError{Op: op, Interface: interfaceName(index), Target: dst.String(), Err: err}
+	}
+	return nil
+}
+
+// execute sends a single rtnetlink request, consisting of a fixed header and the attributes
+func (r *RTNLClient) execute(typ netlink.HeaderType, flags netlink.HeaderFlags, header []byte, attrs []byte) ([]netlink.Message, error) {
+	if flags != netlink.Dump { // dumps end with a done message instead of an acknowledgement. The dump flags share their bits with the create flags.
+		flags |= netlink.Acknowledge
+	}
+	flags |= netlink.Request
+	msgs, err := r.c.Execute(netlink.Message{
+		Header: netlink.Header{
+			Type:  typ,
+			Flags: flags,
+		},
+		Data: append(header, attrs...),
+	})
+	if err != nil {
+		var opErr *netlink.OpError
+		if errors.As(err, &opErr) && errors.Is(opErr.Err, unix.ENODEV) {
+			return nil, os.ErrNotExist
+		}
+		return nil, err
+	}
+	return msgs, nil
+}
+
+// structBytes returns a copy of the memory of a fixed size rtnetlink header
+func structBytes[T unix.IfInfomsg | unix.IfAddrmsg | unix.RtMsg](v *T) []byte {
+	b := unsafe.Slice((*byte)(unsafe.Pointer(v)), unsafe.Sizeof(*v))
+	return append([]byte{}, b...)
+}
+
+func addrFamily(addr netip.Addr) uint8 {
+	if addr.Is4() {
+		return unix.AF_INET
+	}
+	return unix.AF_INET6
+}
+
+func interfaceName(index int) string {
+	iface, err := net.InterfaceByIndex(index)
+	if err != nil {
+		return fmt.Sprintf("ifindex %d", index)
+	}
+	return iface.Name
+}
@@ -1,5 +1,3 @@
-//go:build linux
-
 package stats
 
 import "time"
@@ -7,6 +5,7 @@ import "time"
 type PeerStat struct {
 	Timestamp         time.Time `json:"timestamp"`
 	PublicKey         string    `json:"publicKey"`
+	Endpoint          string    `json:"endpoint"`
 	LastHandshakeTime time.Time `json:"lastHandshakeTime"`
 	ReceiveBytes      int64     `json:"receiveBytes"`
 	TransmitBytes     int64     `json:"transmitBytes"`
@@ -1,25 +1,16 @@
-//go:build linux
-
 package stats
 
 import (
-	"fmt"
 	"time"
 
+	wireguardbackend "github.com/in4it/wireguard-server/pkg/wireguard/backend"
 	wireguardlinux "github.com/in4it/wireguard-server/pkg/wireguard/linux"
 )
 
-func GetStats() ([]PeerStat, error) {
-	c, available, err := wireguardlinux.New()
+func GetStats(backend wireguardbackend.Backend) ([]PeerStat, error) {
+	device, err := backend.Device(wireguardlinux.VPN_INTERFACE_NAME)
 	if err != nil {
-		return []PeerStat{}, fmt.Errorf("cannot start wireguardlinux client: %s", err)
-	}
-	if !available {
-		return []PeerStat{}, fmt.Errorf("wireguard linux client not available")
-	}
-	device, err := c.Device(wireguardlinux.VPN_INTERFACE_NAME)
-	if err != nil {
-		return []PeerStat{}, fmt.Errorf("wireguard linux device 'vpn' not found: %s", err)
+		return []PeerStat{}, err
 	}
 
 	peerStats := make([]PeerStat, len(device.Peers))
@@ -32,6 +23,9 @@ func GetStats() ([]PeerStat, error) {
 			ReceiveBytes:      peer.ReceiveBytes,
 			TransmitBytes:     peer.TransmitBytes,
 		}
+		if peer.Endpoint != nil {
+			peerStats[k].Endpoint = peer.Endpoint.String()
+		}
 	}
 	return peerStats, nil
 }
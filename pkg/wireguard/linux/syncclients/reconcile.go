@@ -0,0 +1,126 @@
+package processpeerconfig
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/in4it/go-devops-platform/storage"
+	"github.com/in4it/wireguard-server/pkg/wireguard"
+	wireguardbackend "github.com/in4it/wireguard-server/pkg/wireguard/backend"
+	wireguardlinux "github.com/in4it/wireguard-server/pkg/wireguard/linux"
+)
+
+// Reconcile brings the server key, peers and network peer routes of the vpn device in line with the peer configs, and returns the changes that were made.
+func Reconcile(storage storage.Iface, backend wireguardbackend.Backend, peerConfigs []wireguard.PeerConfig) (wireguard.ReconcileDrift, error) {
+	drift := wireguard.ReconcileDrift{}
+
+	device, err := backend.Device(wireguard.VPN_INTERFACE_NAME)
+	if err != nil {
+		return drift, err
+	}
+
+	// server key
+	privateKeyBytes, err := storage.ReadFile(path.Join(wireguard.VPN_SERVER_SECRETS_PATH, wireguard.VPN_PRIVATE_KEY_FILENAME))
+	if err != nil {
+		return drift, fmt.Errorf("failed to read private key: %s", err)
+	}
+	privateKey := strings.TrimSpace(string(privateKeyBytes))
+	if device.PrivateKey.String() != privateKey {
+		err := wgSetServerPrivateKey(backend, privateKey)
+		if err != nil {
+			return drift, fmt.Errorf("failed to set server private key: %s", err)
+		}
+		drift.ServerKeyUpdated++
+	}
+
+	// peers
+	desiredPeers := []wireguardlinux.PeerConfig{}
+	networks := []string{}
+	for _, peerConfig := range peerConfigs {
+		if peerConfig.Disabled {
+			continue
+		}
+		if peerConfig.PublicKey == "" {
+			log.Printf("Warning: corrupt peer config. Skipping peer (id: %s)", peerConfig.ID)
+			drift.PeerErrors++
+			continue
+		}
+		peer, err := newPeer(storage, peerConfig)
+		if err != nil { // one invalid peer config shouldn't keep the other peers from being configured
+			log.Printf("Warning: invalid peer config. Skipping peer: %s", err)
+			drift.PeerErrors++
+			continue
+		}
+		desiredPeers = append(desiredPeers, peer)
+		if peerConfig.Type == wireguard.PEER_TYPE_NETWORK {
+			networks = append(networks, peerConfig.Networks...)
+		}
+	}
+	peers := diffPeers(device.Peers, desiredPeers, &drift)
+	if len(peers) > 0 {
+		err = backend.ApplyPeers(wireguard.VPN_INTERFACE_NAME, peers)
+		if err != nil {
+			return drift, fmt.Errorf("could not configure peers: %s", err)
+		}
+	}
+
+	// routes to the networks behind network peers
+	err = reconcileNetworkPeerRoutes(backend, networks, &drift)
+	if err != nil {
+		return drift, fmt.Errorf("could not reconcile network peer routes: %s", err)
+	}
+
+	return drift, nil
+}
+
+// diffPeers returns the minimal set of peer changes to go from the current peers of the device to the desired peers
+func diffPeers(currentPeers []wireguardlinux.Peer, desiredPeers []wireguardlinux.PeerConfig, drift *wireguard.ReconcileDrift) []wireguardlinux.PeerConfig {
+	current := make(map[wireguardlinux.Key]wireguardlinux.Peer, len(currentPeers))
+	for _, peer := range currentPeers {
+		current[peer.PublicKey] = peer
+	}
+	desired := make(map[wireguardlinux.Key]bool, len(desiredPeers))
+
+	changes := []wireguardlinux.PeerConfig{}
+	for _, desiredPeer := range desiredPeers {
+		if desired[desiredPeer.PublicKey] {
+			continue // duplicate key
+		}
+		desired[desiredPeer.PublicKey] = true
+		currentPeer, found := current[desiredPeer.PublicKey]
+		if !found {
+			changes = append(changes, desiredPeer)
+			drift.PeersAdded++
+			continue
+		}
+		if currentPeer.PresharedKey != *desiredPeer.PresharedKey || !allowedIPsEqual(currentPeer.AllowedIPs, desiredPeer.AllowedIPs) {
+			changes = append(changes, desiredPeer)
+			drift.PeersUpdated++
+		}
+	}
+	for _, peer := range currentPeers {
+		if !desired[peer.PublicKey] {
+			changes = append(changes, wireguardlinux.PeerConfig{PublicKey: peer.PublicKey, Remove: true})
+			drift.PeersRemoved++
+		}
+	}
+	return changes
+}
+
+func allowedIPsEqual(allowedIPs []net.IPNet, expectedAllowedIPs []net.IPNet) bool {
+	current := make([]string, len(allowedIPs))
+	for k := range allowedIPs {
+		current[k] = allowedIPs[k].String()
+	}
+	expected := make([]string, len(expectedAllowedIPs))
+	for k := range expectedAllowedIPs {
+		expected[k] = expectedAllowedIPs[k].String()
+	}
+	slices.Sort(current)
+	slices.Sort(expected)
+	return slices.Equal(current, expected)
+}
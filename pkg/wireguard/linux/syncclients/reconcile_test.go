@@ -0,0 +1,158 @@
+package processpeerconfig
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"testing"
+
+	memorystorage "github.com/in4it/go-devops-platform/storage/memory"
+	"github.com/in4it/wireguard-server/pkg/wireguard"
+	wireguardbackend "github.com/in4it/wireguard-server/pkg/wireguard/backend"
+	wireguardlinux "github.com/in4it/wireguard-server/pkg/wireguard/linux"
+)
+
+func TestDiffPeers(t *testing.T) {
+	_, ipNet1, _ := net.ParseCIDR("10.189.184.2/32")
+	_, ipNet2, _ := net.ParseCIDR("10.189.184.3/32")
+	_, ipNet3, _ := net.ParseCIDR("10.189.184.4/32")
+	presharedKey := wireguardlinux.Key{9}
+	currentPeers := []wireguardlinux.Peer{
+		{PublicKey: wireguardlinux.Key{1}, PresharedKey: presharedKey, AllowedIPs: []net.IPNet{*ipNet1}}, // unchanged
+		{PublicKey: wireguardlinux.Key{2}, PresharedKey: presharedKey, AllowedIPs: []net.IPNet{*ipNet1}}, // allowed ips changed
+		{PublicKey: wireguardlinux.Key{3}, PresharedKey: wireguardlinux.Key{8}},                          // preshared key changed
+		{PublicKey: wireguardlinux.Key{4}, PresharedKey: presharedKey},                                   // removed
+	}
+	desiredPeers := []wireguardlinux.PeerConfig{
+		{PublicKey: wireguardlinux.Key{1}, PresharedKey: &presharedKey, AllowedIPs: []net.IPNet{*ipNet1}},
+		{PublicKey: wireguardlinux.Key{2}, PresharedKey: &presharedKey, AllowedIPs: []net.IPNet{*ipNet2}},
+		{PublicKey: wireguardlinux.Key{3}, PresharedKey: &presharedKey},
+		{PublicKey: wireguardlinux.Key{5}, PresharedKey: &presharedKey, AllowedIPs: []net.IPNet{*ipNet3}}, // added
+	}
+	drift := wireguard.ReconcileDrift{}
+	changes := diffPeers(currentPeers, desiredPeers, &drift)
+	if drift != (wireguard.ReconcileDrift{PeersAdded: 1, PeersUpdated: 2, PeersRemoved: 1}) {
+		t.Fatalf("unexpected drift: %+v", drift)
+	}
+	if len(changes) != 4 {
+		t.Fatalf("expected 4 changes, got: %d", len(changes))
+	}
+	if changes[3].PublicKey != (wireguardlinux.Key{4}) || !changes[3].Remove {
+		t.Fatalf("expected removal of peer 4, got: %+v", changes[3])
+	}
+
+	drift = wireguard.ReconcileDrift{}
+	changes = diffPeers(currentPeers[:1], desiredPeers[:1], &drift)
+	if len(changes) != 0 || drift != (wireguard.ReconcileDrift{}) {
+		t.Fatalf("expected no changes when in sync, got: %+v (drift: %+v)", changes, drift)
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	storage := &memorystorage.MockMemoryStorage{}
+	vpnConfig, err := wireguard.CreateNewVPNConfig(storage)
+	if err != nil {
+		t.Fatalf("CreateNewVPNConfig error: %s", err)
+	}
+	backend := wireguardbackend.NewMemory()
+	_, err = Reconcile(storage, backend, []wireguard.PeerConfig{})
+	if err == nil {
+		t.Fatalf("expected error when the interface doesn't exist")
+	}
+	_, err = backend.StartInterface(wireguard.VPN_INTERFACE_NAME, wireguardbackend.InterfaceConfig{ListenPort: vpnConfig.Port})
+	if err != nil {
+		t.Fatalf("StartInterface error: %s", err)
+	}
+
+	peerConfigs := make([]wireguard.PeerConfig, 3)
+	for k := range peerConfigs {
+		_, publicKey, err := wireguard.GenerateKeys()
+		if err != nil {
+			t.Fatalf("GenerateKeys error: %s", err)
+		}
+		peerConfigs[k] = wireguard.PeerConfig{
+			ID:               fmt.Sprintf("1-2-3-4-%d", k),
+			PublicKey:        publicKey,
+			ServerAllowedIPs: []string{fmt.Sprintf("10.189.184.%d/32", k+2)},
+		}
+	}
+	peerConfigs[1].Disabled = true
+	peerConfigs[2].Type = wireguard.PEER_TYPE_NETWORK
+	peerConfigs[2].Networks = []string{"192.168.1.1/24"}
+
+	drift, err := Reconcile(storage, backend, peerConfigs)
+	if err != nil {
+		t.Fatalf("Reconcile error: %s", err)
+	}
+	if drift != (wireguard.ReconcileDrift{PeersAdded: 2, RoutesAdded: 1, ServerKeyUpdated: 1}) {
+		t.Fatalf("unexpected drift: %+v", drift)
+	}
+	device, err := backend.Device(wireguard.VPN_INTERFACE_NAME)
+	if err != nil {
+		t.Fatalf("Device error: %s", err)
+	}
+	if len(device.Peers) != 2 || device.Peers[0].PublicKey.String() != peerConfigs[0].PublicKey || device.Peers[0].AllowedIPs[0].String() != "10.189.184.2/32" {
+		t.Fatalf("unexpected peers: %+v", device.Peers)
+	}
+	if device.Peers[0].PresharedKey.String() != vpnConfig.PresharedKey {
+		t.Fatalf("expected the preshared key of the server")
+	}
+	routes, err := backend.PeerRoutes(wireguard.VPN_INTERFACE_NAME)
+	if err != nil {
+		t.Fatalf("PeerRoutes error: %s", err)
+	}
+	if len(routes) != 1 || routes[0] != netip.MustParsePrefix("192.168.1.0/24") {
+		t.Fatalf("unexpected routes: %v", routes)
+	}
+
+	// in sync: handshakes and counters don't cause drift
+	err = backend.Transfer(wireguard.VPN_INTERFACE_NAME, device.Peers[0].PublicKey, 100, 100)
+	if err != nil {
+		t.Fatalf("Transfer error: %s", err)
+	}
+	drift, err = Reconcile(storage, backend, peerConfigs)
+	if err != nil {
+		t.Fatalf("Reconcile error: %s", err)
+	}
+	if drift != (wireguard.ReconcileDrift{}) {
+		t.Fatalf("expected no drift, got: %+v", drift)
+	}
+
+	// a peer removed outside of the configmanager is added back, the network peer is disabled
+	err = backend.ApplyPeers(wireguard.VPN_INTERFACE_NAME, []wireguardbackend.PeerConfig{{PublicKey: device.Peers[0].PublicKey, Remove: true}})
+	if err != nil {
+		t.Fatalf("ApplyPeers error: %s", err)
+	}
+	peerConfigs[2].Disabled = true
+	drift, err = Reconcile(storage, backend, peerConfigs)
+	if err != nil {
+		t.Fatalf("Reconcile error: %s", err)
+	}
+	if drift != (wireguard.ReconcileDrift{PeersAdded: 1, PeersRemoved: 1, RoutesRemoved: 1}) {
+		t.Fatalf("unexpected drift: %+v", drift)
+	}
+	// a corrupt peer config is skipped, the other peers are still reconciled
+	_, publicKey, err := wireguard.GenerateKeys()
+	if err != nil {
+		t.Fatalf("GenerateKeys error: %s", err)
+	}
+	peerConfigs = append(peerConfigs,
+		wireguard.PeerConfig{ID: "1-2-3-4-3", PublicKey: "invalid", ServerAllowedIPs: []string{"10.189.184.5/32"}},
+		wireguard.PeerConfig{ID: "1-2-3-4-4", PublicKey: publicKey, PresharedKey: "invalid", ServerAllowedIPs: []string{"10.189.184.6/32"}},
+		wireguard.PeerConfig{ID: "1-2-3-4-5", PublicKey: publicKey, ServerAllowedIPs: []string{"10.189.184.7/32"}},
+	)
+	drift, err = Reconcile(storage, backend, peerConfigs)
+	if err != nil {
+		t.Fatalf("Reconcile error: %s", err)
+	}
+	if drift != (wireguard.ReconcileDrift{PeersAdded: 1, PeerErrors: 2}) {
+		t.Fatalf("unexpected drift: %+v", drift)
+	}
+	device, err = backend.Device(wireguard.VPN_INTERFACE_NAME)
+	if err != nil {
+		t.Fatalf("Device error: %s", err)
+	}
+	if len(device.Peers) != 2 || device.Peers[1].PublicKey.String() != publicKey || device.Peers[1].AllowedIPs[0].String() != "10.189.184.7/32" {
+		t.Fatalf("unexpected peers: %+v", device.Peers)
+	}
+}
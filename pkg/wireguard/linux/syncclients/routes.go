@@ -0,0 +1,45 @@
+package processpeerconfig
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+
+	"github.com/in4it/wireguard-server/pkg/wireguard"
+	wireguardbackend "github.com/in4it/wireguard-server/pkg/wireguard/backend"
+)
+
+// reconcileNetworkPeerRoutes installs the routes to the networks of the enabled network peers, and removes the routes that don't belong to an enabled network peer anymore
+func reconcileNetworkPeerRoutes(backend wireguardbackend.Backend, networks []string, drift *wireguard.ReconcileDrift) error {
+	installedRoutes, err := backend.PeerRoutes(wireguard.VPN_INTERFACE_NAME)
+	if err != nil {
+		return err
+	}
+	desiredRoutes := make([]netip.Prefix, 0, len(networks))
+	for _, network := range networks {
+		prefix, err := netip.ParsePrefix(network)
+		if err != nil {
+			return fmt.Errorf("could not parse network %s: %s", network, err)
+		}
+		desiredRoutes = append(desiredRoutes, prefix.Masked())
+	}
+	for _, desiredRoute := range desiredRoutes {
+		if !slices.Contains(installedRoutes, desiredRoute) {
+			err = backend.AddPeerRoute(wireguard.VPN_INTERFACE_NAME, desiredRoute)
+			if err != nil {
+				return err
+			}
+			drift.RoutesAdded++
+		}
+	}
+	for _, installedRoute := range installedRoutes {
+		if !slices.Contains(desiredRoutes, installedRoute) {
+			err := backend.DeletePeerRoute(wireguard.VPN_INTERFACE_NAME, installedRoute)
+			if err != nil {
+				return err
+			}
+			drift.RoutesRemoved++
+		}
+	}
+	return nil
+}
@@ -1,97 +1,62 @@
-//go:build linux
-
 package processpeerconfig
 
 import (
 	"fmt"
-	"os/exec"
+	"net"
 	"path"
 	"strings"
 
 	"github.com/in4it/go-devops-platform/storage"
 	"github.com/in4it/wireguard-server/pkg/wireguard"
+	wireguardbackend "github.com/in4it/wireguard-server/pkg/wireguard/backend"
+	wireguardlinux "github.com/in4it/wireguard-server/pkg/wireguard/linux"
 )
 
-func wgAddPeer(storage storage.Iface, peerConfig wireguard.PeerConfig) error {
-	// wg set
-
-	args := []string{"set", wireguard.VPN_INTERFACE_NAME, "peer", peerConfig.PublicKey, "allowed-ips", strings.Join(peerConfig.ServerAllowedIPs, ","), "preshared-key", path.Join(storage.GetPath(), wireguard.VPN_SERVER_SECRETS_PATH, wireguard.PRESHARED_KEY_FILENAME)}
-
-	fmt.Printf("Executing cmd: wg %s\n", strings.Join(args, " "))
-
-	cmd := exec.Command("wg", args...)
-
-	if err := cmd.Start(); err != nil {
-		return fmt.Errorf("wg set error: %v", err)
+func wgSetServerPrivateKey(backend wireguardbackend.Backend, privateKey string) error {
+	key, err := wireguardlinux.ParseKey(privateKey)
+	if err != nil {
+		return fmt.Errorf("could not parse private key: %s", err)
 	}
-
-	if err := cmd.Wait(); err != nil {
-		if exiterr, ok := err.(*exec.ExitError); ok {
-			return fmt.Errorf("wg set exit Status: %d", exiterr.ExitCode())
-		} else {
-			return fmt.Errorf("error during wg set: %v", err)
-		}
+	err = backend.SetPrivateKey(wireguard.VPN_INTERFACE_NAME, key)
+	if err != nil {
+		return fmt.Errorf("could not set private key: %s", err)
 	}
-
-	// add route (needs another check first?)
-	/*for _, allowedIP := range peerConfig.AllowedIPs {
-		cmd := exec.Command("ip", "-4", "route", "add", allowedIP, "dev", wireguard.VPN_INTERFACE_NAME)
-
-		if err := cmd.Start(); err != nil {
-			return fmt.Errorf("wg set error: %v", err)
-		}
-
-		if err := cmd.Wait(); err != nil {
-			if exiterr, ok := err.(*exec.ExitError); ok {
-				return fmt.Errorf("wg set exit Status: %d", exiterr.ExitCode())
-			} else {
-				return fmt.Errorf("error during wg set: %v", err)
-			}
-		}
-	}*/
 	return nil
 }
 
-func wgDeletePeer(peerConfig wireguard.PeerConfig) error {
-	// wg set to delete a peer
-	args := []string{"set", wireguard.VPN_INTERFACE_NAME, "peer", peerConfig.PublicKey, "remove"}
-
-	fmt.Printf("Executing cmd: wg %s\n", strings.Join(args, " "))
-
-	cmd := exec.Command("wg", args...)
-
-	if err := cmd.Start(); err != nil {
-		return fmt.Errorf("wg set error: %v", err)
+// newPeer returns the device configuration of a peer. The allowed ips replace the existing allowed ips of the peer.
+func newPeer(storage storage.Iface, peerConfig wireguard.PeerConfig) (wireguardlinux.PeerConfig, error) {
+	publicKey, err := wireguardlinux.ParseKey(peerConfig.PublicKey)
+	if err != nil {
+		return wireguardlinux.PeerConfig{}, fmt.Errorf("could not parse public key of peer %s: %s", peerConfig.ID, err)
 	}
 
-	if err := cmd.Wait(); err != nil {
-		if exiterr, ok := err.(*exec.ExitError); ok {
-			return fmt.Errorf("wg set exit Status: %d", exiterr.ExitCode())
-		} else {
-			return fmt.Errorf("error during wg set: %v", err)
+	presharedKeyStr := peerConfig.PresharedKey
+	if presharedKeyStr == "" { // fall back to the preshared key of the server
+		presharedKeyBytes, err := storage.ReadFile(path.Join(wireguard.VPN_SERVER_SECRETS_PATH, wireguard.PRESHARED_KEY_FILENAME))
+		if err != nil {
+			return wireguardlinux.PeerConfig{}, fmt.Errorf("failed to read preshared key: %s", err)
 		}
+		presharedKeyStr = strings.TrimSpace(string(presharedKeyBytes))
 	}
-	return nil
-}
-
-func wgSetServerPrivateKey(privateKeyPath string) error {
-	// wg set to change private key
-	args := []string{"set", wireguard.VPN_INTERFACE_NAME, "private-key", privateKeyPath}
-
-	fmt.Printf("Executing cmd: wg %s\n", strings.Join(args, " "))
-
-	cmd := exec.Command("wg", args...)
-
-	if err := cmd.Start(); err != nil {
-		return fmt.Errorf("wg set private-key error: %v", err)
+	presharedKey, err := wireguardlinux.ParseKey(presharedKeyStr)
+	if err != nil {
+		return wireguardlinux.PeerConfig{}, fmt.Errorf("could not parse preshared key of peer %s: %s", peerConfig.ID, err)
 	}
 
-	if err := cmd.Wait(); err != nil {
-		if exiterr, ok := err.(*exec.ExitError); ok {
-			return fmt.Errorf("wg set exit Status: %d", exiterr.ExitCode())
-		} else {
-			return fmt.Errorf("error during wg set private-key: %v", err)
+	allowedIPs := make([]net.IPNet, len(peerConfig.ServerAllowedIPs))
+	for k, serverAllowedIP := range peerConfig.ServerAllowedIPs {
+		_, ipNet, err := net.ParseCIDR(serverAllowedIP)
+		if err != nil {
+			return wireguardlinux.PeerConfig{}, fmt.Errorf("could not parse allowed ip %s of peer %s: %s", serverAllowedIP, peerConfig.ID, err)
 		}
+		allowedIPs[k] = *ipNet
 	}
-	return nil
+
+	return wireguardlinux.PeerConfig{
+		PublicKey:         publicKey,
+		PresharedKey:      &presharedKey,
+		ReplaceAllowedIPs: true,
+		AllowedIPs:        allowedIPs,
+	}, nil
 }
@@ -1,5 +1,3 @@
-//go:build linux
-
 package wireguardlinux
 
 import (
@@ -126,6 +124,81 @@ type Peer struct {
 	ProtocolVersion int
 }
 
+// A Config is a WireGuard device configuration.
+//
+// Because the zero value of some Go types may be significant to WireGuard for
+// Config fields, pointer types are used for some of these fields. Only
+// pointer fields which are not nil will be applied when configuring a device.
+type Config struct {
+	// PrivateKey specifies a private key configuration, if not nil.
+	//
+	// A non-nil, zero-value Key will clear the private key.
+	PrivateKey *Key
+
+	// ListenPort specifies a device's listening port, if not nil.
+	ListenPort *int
+
+	// FirewallMark specifies a device's firewall mark, if not nil.
+	//
+	// If non-nil and set to 0, the firewall mark will be cleared.
+	FirewallMark *int
+
+	// ReplacePeers specifies if the Peers in this configuration should replace
+	// the existing peer list, instead of appending them to the existing list.
+	ReplacePeers bool
+
+	// Peers specifies a list of peer configurations to apply to a device.
+	Peers []PeerConfig
+
+	// Atomic specifies that the configuration is sent to the kernel in a
+	// single netlink message, instead of being split into batches when it
+	// contains many peers or allowed IPs. The kernel applies a single message
+	// while holding the device lock, so no partial state is observable.
+	Atomic bool
+}
+
+// A PeerConfig is a WireGuard device peer configuration.
+//
+// Because the zero value of some Go types may be significant to WireGuard for
+// PeerConfig fields, pointer types are used for some of these fields. Only
+// pointer fields which are not nil will be applied when configuring a peer.
+type PeerConfig struct {
+	// PublicKey specifies the public key of this peer.  PublicKey is a
+	// mandatory field for all PeerConfigs.
+	PublicKey Key
+
+	// Remove specifies if the peer with this public key should be removed
+	// from a device's peer list.
+	Remove bool
+
+	// UpdateOnly specifies that an operation will only occur on this peer
+	// if the peer already exists as part of the interface.
+	UpdateOnly bool
+
+	// PresharedKey specifies a peer's preshared key configuration, if not nil.
+	//
+	// A non-nil, zero-value Key will clear the preshared key.
+	PresharedKey *Key
+
+	// Endpoint specifies the endpoint of this peer entry, if not nil.
+	Endpoint *net.UDPAddr
+
+	// PersistentKeepaliveInterval specifies the persistent keepalive interval
+	// for this peer, if not nil.
+	//
+	// A non-nil value of 0 will clear the persistent keepalive interval.
+	PersistentKeepaliveInterval *time.Duration
+
+	// ReplaceAllowedIPs specifies if the allowed IPs specified in this peer
+	// configuration should replace any existing ones, instead of appending them
+	// to the allowed IPs list.
+	ReplaceAllowedIPs bool
+
+	// AllowedIPs specifies a list of allowed IP addresses in CIDR notation
+	// for this peer.
+	AllowedIPs []net.IPNet
+}
+
 // NewKey creates a Key from an existing byte slice.  The byte slice must be
 // exactly 32 bytes in length.
 func NewKey(b []byte) (Key, error) {
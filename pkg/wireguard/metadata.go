@@ -0,0 +1,104 @@
+package wireguard
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/in4it/go-devops-platform/storage"
+)
+
+var platforms = []string{PLATFORM_WINDOWS, PLATFORM_MACOS, PLATFORM_LINUX, PLATFORM_IOS, PLATFORM_ANDROID, PLATFORM_OTHER}
+
+// SetClientMetadata changes the name, description, platform and labels of a connection. The metadata ends up in
+// client configs, so control characters are not allowed.
+func SetClientMetadata(storage storage.Iface, connectionID string, metadata ConnectionMetadata, actor string) (PeerConfig, error) {
+	clientConfigMutex.Lock()
+	defer clientConfigMutex.Unlock()
+
+	peerConfig, err := getPeerConfig(storage, connectionID)
+	if err != nil {
+		return peerConfig, fmt.Errorf("could not get peer config: %s", err)
+	}
+	if metadata.Name != nil {
+		name := strings.TrimSpace(*metadata.Name)
+		if name == "" {
+			return peerConfig, fmt.Errorf("name can't be empty")
+		}
+		err = validateMetadataField("name", name, MAX_CONNECTION_NAME_LENGTH)
+		if err != nil {
+			return peerConfig, err
+		}
+		peerConfig.Name = name
+	}
+	if metadata.Description != nil {
+		description := strings.TrimSpace(*metadata.Description)
+		err = validateMetadataField("description", description, MAX_CONNECTION_DESCRIPTION_LENGTH)
+		if err != nil {
+			return peerConfig, err
+		}
+		peerConfig.Description = description
+	}
+	if metadata.Platform != nil {
+		if *metadata.Platform != "" && !slices.Contains(platforms, *metadata.Platform) {
+			return peerConfig, fmt.Errorf("platform needs to be one of: %s", strings.Join(platforms, ", "))
+		}
+		peerConfig.Platform = *metadata.Platform
+	}
+	if metadata.Labels != nil {
+		peerConfig.Labels, err = validateLabels(*metadata.Labels)
+		if err != nil {
+			return peerConfig, err
+		}
+	}
+
+	err = writePeerConfig(storage, peerConfig)
+	if err != nil {
+		return peerConfig, err
+	}
+	err = writeConnectionAuditEntry(storage, peerConfig.ID, AUDIT_ACTION_SET_METADATA, actor)
+	if err != nil {
+		return peerConfig, err
+	}
+	return peerConfig, nil
+}
+
+// validateLabels trims the labels and removes duplicates
+func validateLabels(labels []string) ([]string, error) {
+	validLabels := []string{}
+	for _, label := range labels {
+		label = strings.TrimSpace(label)
+		if label == "" {
+			return nil, fmt.Errorf("label can't be empty")
+		}
+		err := validateMetadataField("label", label, MAX_CONNECTION_LABEL_LENGTH)
+		if err != nil {
+			return nil, err
+		}
+		if strings.Contains(label, ",") {
+			return nil, fmt.Errorf("label can't contain a comma")
+		}
+		if !slices.Contains(validLabels, label) {
+			validLabels = append(validLabels, label)
+		}
+	}
+	if len(validLabels) > MAX_CONNECTION_LABELS {
+		return nil, fmt.Errorf("a connection can have at most %d labels", MAX_CONNECTION_LABELS)
+	}
+	return validLabels, nil
+}
+
+func validateMetadataField(field, value string, maxLength int) error {
+	if !utf8.ValidString(value) {
+		return fmt.Errorf("%s is not valid utf-8", field)
+	}
+	if utf8.RuneCountInString(value) > maxLength {
+		return fmt.Errorf("%s can be at most %d characters", field, maxLength)
+	}
+	if strings.ContainsFunc(value, unicode.IsControl) {
+		return fmt.Errorf("%s can't contain control characters", field)
+	}
+	return nil
+}
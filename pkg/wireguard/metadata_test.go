@@ -0,0 +1,68 @@
+package wireguard
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	memorystorage "github.com/in4it/go-devops-platform/storage/memory"
+	configmanagerclient "github.com/in4it/wireguard-server/pkg/configmanager/client"
+)
+
+func TestValidateLabels(t *testing.T) {
+	labels, err := validateLabels([]string{" work", "work", "Office"})
+	if err != nil {
+		t.Fatalf("validateLabels error: %s", err)
+	}
+	if !slices.Equal(labels, []string{"work", "Office"}) {
+		t.Fatalf("unexpected labels: %v", labels)
+	}
+	tooMany := make([]string, MAX_CONNECTION_LABELS+1)
+	for k := range tooMany {
+		tooMany[k] = strings.Repeat("a", k+1)
+	}
+	for _, invalidLabels := range [][]string{{""}, {"a,b"}, {"a\tb"}, {strings.Repeat("a", MAX_CONNECTION_LABEL_LENGTH+1)}, tooMany} {
+		_, err = validateLabels(invalidLabels)
+		if err == nil {
+			t.Fatalf("expected error for labels: %v", invalidLabels)
+		}
+	}
+}
+
+func TestSetClientMetadata(t *testing.T) {
+	storage := &memorystorage.MockMemoryStorage{}
+	UseConfigManagerClient(configmanagerclient.NewFake())
+	defer UseConfigManagerClient(nil)
+
+	_, err := CreateNewVPNConfig(storage)
+	if err != nil {
+		t.Fatalf("CreateNewVPNConfig error: %s", err)
+	}
+	err = WriteClientTemplate(storage, []byte(DEFAULT_CLIENT_TEMPLATE+"# {{ .Name }} ({{ .Platform }}): {{ .Description }} [{{StringsJoin .Labels \",\" }}]\n"))
+	if err != nil {
+		t.Fatalf("WriteClientTemplate error: %s", err)
+	}
+	peerConfig, err := NewEmptyClientConfig(storage, "2-2-2-2")
+	if err != nil {
+		t.Fatalf("NewEmptyClientConfig error: %s", err)
+	}
+	name, description, platform, labels := "phone", "personal phone", PLATFORM_IOS, []string{"personal", "mobile"}
+	_, err = SetClientMetadata(storage, peerConfig.ID, ConnectionMetadata{Name: &name, Description: &description, Platform: &platform, Labels: &labels}, "2-2-2-2")
+	if err != nil {
+		t.Fatalf("SetClientMetadata error: %s", err)
+	}
+	out, err := GenerateNewClientConfig(storage, peerConfig.ID, "2-2-2-2")
+	if err != nil {
+		t.Fatalf("GenerateNewClientConfig error: %s", err)
+	}
+	if !strings.Contains(string(out), "# phone (ios): personal phone [personal,mobile]") {
+		t.Fatalf("metadata not rendered in client config: %s", out)
+	}
+	auditLog, err := GetConnectionAuditLog(storage, peerConfig.ID)
+	if err != nil {
+		t.Fatalf("GetConnectionAuditLog error: %s", err)
+	}
+	if len(auditLog) != 1 || auditLog[0].Action != AUDIT_ACTION_SET_METADATA {
+		t.Fatalf("unexpected audit log: %+v", auditLog)
+	}
+}
@@ -0,0 +1,145 @@
+package wireguard
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+
+	"github.com/in4it/go-devops-platform/storage"
+)
+
+// NewNetworkPeerConfig creates a site-to-site peer: a router that makes the networks behind it reachable through the vpn
+func NewNetworkPeerConfig(storage storage.Iface, options NetworkPeerOptions) (PeerConfig, error) {
+	if options.Name == "" {
+		return PeerConfig{}, fmt.Errorf("a network peer needs a name")
+	}
+	if len(options.Networks) == 0 {
+		return PeerConfig{}, fmt.Errorf("a network peer needs at least one network")
+	}
+	return NewClientConfig(storage, NETWORK_PEER_USER_ID, NewClientConfigOptions{
+		PublicKey:      options.PublicKey,
+		Type:           PEER_TYPE_NETWORK,
+		Name:           options.Name,
+		Networks:       options.Networks,
+		RouteToClients: options.RouteToClients,
+	})
+}
+
+// UpdateNetworkPeerConfig changes the name and networks of a network peer. UpdateClientsConfig needs to run
+// afterwards to update the allowed ips of the peer and the other clients.
+func UpdateNetworkPeerConfig(storage storage.Iface, connectionID string, options NetworkPeerOptions) (PeerConfig, error) {
+	if options.Name == "" {
+		return PeerConfig{}, fmt.Errorf("a network peer needs a name")
+	}
+	clientConfigMutex.Lock()
+	defer clientConfigMutex.Unlock()
+
+	vpnConfig, err := GetVPNConfig(storage)
+	if err != nil {
+		return PeerConfig{}, fmt.Errorf("failed to get vpn config: %s", err)
+	}
+	peerConfig, err := getPeerConfig(storage, connectionID)
+	if err != nil {
+		return peerConfig, fmt.Errorf("could not get peer config: %s", err)
+	}
+	if peerConfig.Type != PEER_TYPE_NETWORK {
+		return peerConfig, fmt.Errorf("connection is not a network peer")
+	}
+	peerConfigs, err := GetAllPeerConfigs(storage)
+	if err != nil {
+		return peerConfig, fmt.Errorf("could not get peer configs: %s", err)
+	}
+	networks, err := validateNetworks(vpnConfig, peerConfigs, connectionID, options.Networks)
+	if err != nil {
+		return peerConfig, err
+	}
+	peerConfig.Name = options.Name
+	peerConfig.Networks = networks
+	peerConfig.RouteToClients = options.RouteToClients
+	return peerConfig, writePeerConfig(storage, peerConfig)
+}
+
+// GetNetworkPeerConfigs returns all network peers
+func GetNetworkPeerConfigs(storage storage.Iface) ([]PeerConfig, error) {
+	peerConfigs, err := GetAllPeerConfigs(storage)
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(peerConfigs, func(peerConfig PeerConfig) bool { return peerConfig.Type != PEER_TYPE_NETWORK }), nil
+}
+
+// GetNetworkPeerConfig returns a single network peer
+func GetNetworkPeerConfig(storage storage.Iface, connectionID string) (PeerConfig, error) {
+	peerConfig, err := getPeerConfig(storage, connectionID)
+	if err != nil {
+		return peerConfig, fmt.Errorf("could not get peer config: %s", err)
+	}
+	if peerConfig.Type != PEER_TYPE_NETWORK {
+		return peerConfig, fmt.Errorf("connection is not a network peer")
+	}
+	return peerConfig, nil
+}
+
+// validateNetworks returns the networks of a network peer in canonical form. The networks can't overlap with
+// the vpn address range, or with the networks of other network peers.
+func validateNetworks(vpnConfig VPNConfig, peerConfigs []PeerConfig, connectionID string, networks []string) ([]string, error) {
+	if len(networks) == 0 {
+		return nil, fmt.Errorf("a network peer needs at least one network")
+	}
+	vpnAddressRanges := []netip.Prefix{vpnConfig.AddressRange.Masked()}
+	if vpnConfig.AddressRangeIPv6.IsValid() {
+		vpnAddressRanges = append(vpnAddressRanges, vpnConfig.AddressRangeIPv6.Masked())
+	}
+	validatedNetworks := []string{}
+	for _, network := range networks {
+		prefix, err := netip.ParsePrefix(network)
+		if err != nil {
+			return nil, fmt.Errorf("network %s in wrong format: %s", network, err)
+		}
+		prefix = prefix.Masked()
+		if prefix.Bits() == 0 {
+			return nil, fmt.Errorf("network %s would route all traffic to the network peer", prefix)
+		}
+		for _, vpnAddressRange := range vpnAddressRanges {
+			if prefix.Overlaps(vpnAddressRange) {
+				return nil, fmt.Errorf("network %s overlaps with the vpn address range %s", prefix, vpnAddressRange)
+			}
+		}
+		for _, peerConfig := range peerConfigs {
+			if peerConfig.ID == connectionID {
+				continue
+			}
+			for _, existingNetwork := range peerConfig.Networks {
+				existingPrefix, err := netip.ParsePrefix(existingNetwork)
+				if err == nil && existingPrefix.Overlaps(prefix) {
+					return nil, fmt.Errorf("network %s overlaps with network %s of %s", prefix, existingPrefix, peerConfig.Name)
+				}
+			}
+		}
+		if !slices.Contains(validatedNetworks, prefix.String()) {
+			validatedNetworks = append(validatedNetworks, prefix.String())
+		}
+	}
+	return validatedNetworks, nil
+}
+
+// getRoutedNetworks returns the networks of the network peers that are routed to the clients, except the networks of the connection itself
+func getRoutedNetworks(peerConfigs []PeerConfig, connectionID string) []string {
+	routedNetworks := []string{}
+	for _, peerConfig := range peerConfigs {
+		if peerConfig.Type == PEER_TYPE_NETWORK && peerConfig.RouteToClients && peerConfig.ID != connectionID {
+			routedNetworks = append(routedNetworks, peerConfig.Networks...)
+		}
+	}
+	return routedNetworks
+}
+
+// getNetworkPeerClientAllowedIPs returns the allowed ips of a network peer: the whole vpn address range,
+// so the networks behind the peer can reach the clients, and the networks of the other network peers
+func getNetworkPeerClientAllowedIPs(vpnConfig VPNConfig, routedNetworks []string) []string {
+	clientAllowedIPs := []string{vpnConfig.AddressRange.Masked().String()}
+	if vpnConfig.AddressRangeIPv6.IsValid() {
+		clientAllowedIPs = append(clientAllowedIPs, vpnConfig.AddressRangeIPv6.Masked().String())
+	}
+	return appendUncoveredRoutes(clientAllowedIPs, routedNetworks)
+}
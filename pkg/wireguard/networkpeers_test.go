@@ -0,0 +1,170 @@
+package wireguard
+
+import (
+	"net/netip"
+	"slices"
+	"strings"
+	"testing"
+
+	memorystorage "github.com/in4it/go-devops-platform/storage/memory"
+	configmanagerclient "github.com/in4it/wireguard-server/pkg/configmanager/client"
+)
+
+func TestValidateNetworks(t *testing.T) {
+	vpnConfig := VPNConfig{
+		AddressRange:     netip.MustParsePrefix("10.189.184.1/21"),
+		AddressRangeIPv6: netip.MustParsePrefix("fd00:189::1/64"),
+	}
+	peerConfigs := []PeerConfig{
+		{ID: "network-1", Type: PEER_TYPE_NETWORK, Networks: []string{"192.168.1.0/24"}},
+	}
+	testCases := map[string]bool{
+		"192.168.2.1/24":    true,
+		"192.168.1.128/25":  false, // overlaps with network-1
+		"192.168.0.0/16":    false, // overlaps with network-1
+		"10.189.185.0/24":   false, // vpn address range
+		"fd00:189::/48":     false, // vpn ipv6 address range
+		"0.0.0.0/0":         false,
+		"192.168.2.1":       false,
+		"fd00:1234::/64":    true,
+		"172.16.0.0/12":     true,
+		"192.168.1.0/24 ":   false,
+		"192.168.3.0/24/24": false,
+	}
+	for network, valid := range testCases {
+		_, err := validateNetworks(vpnConfig, peerConfigs, "network-2", []string{network})
+		if valid && err != nil {
+			t.Fatalf("expected %s to be valid. Got: %s", network, err)
+		}
+		if !valid && err == nil {
+			t.Fatalf("expected %s to be invalid", network)
+		}
+	}
+	// the networks of the connection itself are not an overlap
+	networks, err := validateNetworks(vpnConfig, peerConfigs, "network-1", []string{"192.168.1.1/24", "192.168.1.0/24"})
+	if err != nil {
+		t.Fatalf("validateNetworks error: %s", err)
+	}
+	if !slices.Equal(networks, []string{"192.168.1.0/24"}) {
+		t.Fatalf("unexpected networks: %v", networks)
+	}
+}
+
+func TestNetworkPeers(t *testing.T) {
+	var err error
+	UseConfigManagerClient(configmanagerclient.NewFake())
+	defer UseConfigManagerClient(nil)
+
+	storage := &memorystorage.MockMemoryStorage{}
+
+	vpnConfig, err := CreateNewVPNConfig(storage)
+	if err != nil {
+		t.Fatalf("CreateNewVPNConfig error: %s", err)
+	}
+	vpnConfig.ClientRoutes = []string{"10.0.1.0/24"}
+	vpnConfig.Nameservers = []string{"1.1.1.1"}
+	err = WriteVPNConfig(storage, vpnConfig)
+	if err != nil {
+		t.Fatalf("WriteVPNConfig error: %s", err)
+	}
+	peerConfig1, err := NewEmptyClientConfig(storage, "2-2-2-2")
+	if err != nil {
+		t.Fatalf("NewEmptyClientConfig error: %s", err)
+	}
+
+	_, err = NewNetworkPeerConfig(storage, NetworkPeerOptions{Name: "office"})
+	if err == nil {
+		t.Fatalf("expected error for network peer without networks")
+	}
+	networkPeer, err := NewNetworkPeerConfig(storage, NetworkPeerOptions{Name: "office", Networks: []string{"192.168.1.1/24"}, RouteToClients: true})
+	if err != nil {
+		t.Fatalf("NewNetworkPeerConfig error: %s", err)
+	}
+	if networkPeer.Type != PEER_TYPE_NETWORK || networkPeer.Name != "office" || !strings.HasPrefix(networkPeer.ID, NETWORK_PEER_USER_ID+"-") {
+		t.Fatalf("unexpected network peer: %+v", networkPeer)
+	}
+	if !slices.Equal(networkPeer.ServerAllowedIPs, []string{networkPeer.Address, "192.168.1.0/24"}) {
+		t.Fatalf("networks not in server allowed ips: %v", networkPeer.ServerAllowedIPs)
+	}
+	if !slices.Equal(networkPeer.ClientAllowedIPs, []string{vpnConfig.AddressRange.Masked().String()}) {
+		t.Fatalf("unexpected client allowed ips of network peer: %v", networkPeer.ClientAllowedIPs)
+	}
+	out, err := GenerateNewClientConfig(storage, networkPeer.ID, NETWORK_PEER_USER_ID)
+	if err != nil {
+		t.Fatalf("GenerateNewClientConfig error: %s", err)
+	}
+	if strings.Contains(string(out), "DNS =") {
+		t.Fatalf("network peer config should not contain dns: %s", out)
+	}
+
+	// overlapping networks are refused
+	_, err = NewNetworkPeerConfig(storage, NetworkPeerOptions{Name: "branch", Networks: []string{"192.168.0.0/16"}})
+	if err == nil {
+		t.Fatalf("expected error for overlapping networks")
+	}
+
+	// the routed networks are added to existing and new clients
+	err = UpdateClientsConfig(storage)
+	if err != nil {
+		t.Fatalf("UpdateClientsConfig error: %s", err)
+	}
+	peerConfig1, err = getPeerConfig(storage, peerConfig1.ID)
+	if err != nil {
+		t.Fatalf("getPeerConfig error: %s", err)
+	}
+	if !slices.Contains(peerConfig1.ClientAllowedIPs, "192.168.1.0/24") {
+		t.Fatalf("routed network not in client allowed ips: %v", peerConfig1.ClientAllowedIPs)
+	}
+	peerConfig2, err := NewEmptyClientConfig(storage, "3-3-3-3")
+	if err != nil {
+		t.Fatalf("NewEmptyClientConfig error: %s", err)
+	}
+	if !slices.Contains(peerConfig2.ClientAllowedIPs, "192.168.1.0/24") || peerConfig2.DNS != "1.1.1.1" {
+		t.Fatalf("routed network not in client allowed ips: %+v", peerConfig2)
+	}
+
+	// a second network peer can reach the networks of the first one
+	networkPeer2, err := NewNetworkPeerConfig(storage, NetworkPeerOptions{Name: "branch", Networks: []string{"192.168.2.0/24"}})
+	if err != nil {
+		t.Fatalf("NewNetworkPeerConfig error: %s", err)
+	}
+	if !slices.Contains(networkPeer2.ClientAllowedIPs, "192.168.1.0/24") {
+		t.Fatalf("routed network not in allowed ips of network peer: %v", networkPeer2.ClientAllowedIPs)
+	}
+
+	// changing the networks updates the server allowed ips, and stopping the routing removes them from the clients
+	_, err = UpdateNetworkPeerConfig(storage, networkPeer.ID, NetworkPeerOptions{Name: "office", Networks: []string{"192.168.10.0/24"}})
+	if err != nil {
+		t.Fatalf("UpdateNetworkPeerConfig error: %s", err)
+	}
+	_, err = UpdateNetworkPeerConfig(storage, peerConfig1.ID, NetworkPeerOptions{Name: "office", Networks: []string{"192.168.11.0/24"}})
+	if err == nil {
+		t.Fatalf("expected error when updating a connection that is not a network peer")
+	}
+	err = UpdateClientsConfig(storage)
+	if err != nil {
+		t.Fatalf("UpdateClientsConfig error: %s", err)
+	}
+	networkPeer, err = getPeerConfig(storage, networkPeer.ID)
+	if err != nil {
+		t.Fatalf("getPeerConfig error: %s", err)
+	}
+	if !slices.Equal(networkPeer.ServerAllowedIPs, []string{networkPeer.Address, "192.168.10.0/24"}) {
+		t.Fatalf("server allowed ips not updated: %v", networkPeer.ServerAllowedIPs)
+	}
+	peerConfig1, err = getPeerConfig(storage, peerConfig1.ID)
+	if err != nil {
+		t.Fatalf("getPeerConfig error: %s", err)
+	}
+	if slices.Contains(peerConfig1.ClientAllowedIPs, "192.168.1.0/24") || slices.Contains(peerConfig1.ClientAllowedIPs, "192.168.10.0/24") {
+		t.Fatalf("network is not routed anymore, but still in client allowed ips: %v", peerConfig1.ClientAllowedIPs)
+	}
+
+	networkPeers, err := GetNetworkPeerConfigs(storage)
+	if err != nil {
+		t.Fatalf("GetNetworkPeerConfigs error: %s", err)
+	}
+	if len(networkPeers) != 2 {
+		t.Fatalf("expected 2 network peers, got %d", len(networkPeers))
+	}
+}
@@ -4,15 +4,14 @@ import (
 	"bufio"
 	"bytes"
 	"compress/gzip"
-	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
 	"os"
 	"path"
-	"runtime"
 	"strconv"
 	"strings"
 	"sync"
@@ -23,7 +22,7 @@ import (
 	"github.com/in4it/go-devops-platform/logging"
 	"github.com/in4it/go-devops-platform/storage"
 	dateutils "github.com/in4it/go-devops-platform/utils/date"
-	pcap "github.com/packetcap/go-pcap"
+	wireguardbackend "github.com/in4it/wireguard-server/pkg/wireguard/backend"
 	"golang.org/x/sys/unix"
 )
 
@@ -31,7 +30,7 @@ var (
 	PacketLoggerIsRunning sync.Mutex
 )
 
-func RunPacketLogger(storage storage.Iface, clientCache *ClientCache, vpnConfig *VPNConfig) {
+func RunPacketLogger(storage storage.Iface, capture wireguardbackend.PacketCapture, clientCache *ClientCache, vpnConfig *VPNConfig) {
 	if !vpnConfig.EnablePacketLogs {
 		return
 	}
@@ -66,8 +65,7 @@ func RunPacketLogger(storage storage.Iface, clientCache *ClientCache, vpnConfig
 		return
 	}
 
-	useSyscalls := runtime.GOOS == "darwin"
-	handle, err := pcap.OpenLive(context.Background(), VPN_INTERFACE_NAME, 1600, false, 0, useSyscalls)
+	handle, err := capture.OpenCapture(VPN_INTERFACE_NAME)
 	if err != nil {
 		logging.ErrorLog(fmt.Errorf("can't start packet inspector: %s", err))
 		return
@@ -77,6 +75,13 @@ func RunPacketLogger(storage storage.Iface, clientCache *ClientCache, vpnConfig
 	openFiles := make(PacketLoggerOpenFiles)
 	for {
 		err := readPacket(storage, handle, clientCache, openFiles, vpnConfig.PacketLogsTypes)
+		if errors.Is(err, io.EOF) { // the capture is closed when the interface is removed
+			logging.InfoLog("packet capture closed")
+			for _, openFile := range openFiles {
+				openFile.Close() //nolint:errcheck
+			}
+			return
+		}
 		if err != nil {
 			logging.DebugLog(fmt.Errorf("readPacket error: %s", err))
 		}
@@ -100,10 +105,10 @@ func RunPacketLogger(storage storage.Iface, clientCache *ClientCache, vpnConfig
 		i++
 	}
 }
-func readPacket(storage storage.Iface, handle *pcap.Handle, clientCache *ClientCache, openFiles PacketLoggerOpenFiles, packetLogsTypes map[string]bool) error {
+func readPacket(storage storage.Iface, handle wireguardbackend.PacketSource, clientCache *ClientCache, openFiles PacketLoggerOpenFiles, packetLogsTypes map[string]bool) error {
 	data, _, err := handle.ReadPacketData()
 	if err != nil {
-		return fmt.Errorf("read packet error: %s", err)
+		return fmt.Errorf("read packet error: %w", err)
 	}
 	return parsePacket(storage, data, clientCache, openFiles, packetLogsTypes, time.Now())
 }
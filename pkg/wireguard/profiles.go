@@ -0,0 +1,295 @@
+package wireguard
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/netip"
+	"os/user"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/in4it/go-devops-platform/storage"
+)
+
+var profilesMutex sync.Mutex
+
+var profileNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// GetProfiles returns the connection profiles and the group memberships
+func GetProfiles(storage storage.Iface) (ProfilesConfig, error) {
+	profilesConfig := ProfilesConfig{Profiles: []Profile{}, Groups: make(map[string][]string)}
+	filename := storage.ConfigPath(PROFILES_CONFIG_NAME)
+	if !storage.FileExists(filename) {
+		return profilesConfig, nil
+	}
+	body, err := storage.ReadFile(filename)
+	if err != nil {
+		return profilesConfig, fmt.Errorf("cannot read profiles: %s", err)
+	}
+	err = json.Unmarshal(body, &profilesConfig)
+	if err != nil {
+		return profilesConfig, fmt.Errorf("cannot unmarshal profiles: %s", err)
+	}
+	if profilesConfig.Profiles == nil {
+		profilesConfig.Profiles = []Profile{}
+	}
+	if profilesConfig.Groups == nil {
+		profilesConfig.Groups = make(map[string][]string)
+	}
+	return profilesConfig, nil
+}
+
+func writeProfiles(storage storage.Iface, profilesConfig ProfilesConfig) error {
+	out, err := json.Marshal(profilesConfig)
+	if err != nil {
+		return fmt.Errorf("profiles marshal error: %s", err)
+	}
+	filename := storage.ConfigPath(PROFILES_CONFIG_NAME)
+	err = storage.WriteFile(filename, out)
+	if err != nil {
+		return fmt.Errorf("profiles write error: %s", err)
+	}
+	currentUser, err := user.Current()
+	if err != nil {
+		return fmt.Errorf("could not get current user: %s", err)
+	}
+	if currentUser.Username != VPN_USER {
+		err = storage.EnsureOwnership(filename, VPN_USER)
+		if err != nil {
+			return fmt.Errorf("could not ensure ownership of %s: %s", filename, err)
+		}
+	}
+	return nil
+}
+
+// AddProfile validates and stores a new profile. UpdateClientsConfig needs to run to apply the profile to existing connections.
+func AddProfile(storage storage.Iface, profile Profile) (Profile, error) {
+	profilesMutex.Lock()
+	defer profilesMutex.Unlock()
+	profilesConfig, err := GetProfiles(storage)
+	if err != nil {
+		return profile, err
+	}
+	if slices.ContainsFunc(profilesConfig.Profiles, func(p Profile) bool { return p.Name == profile.Name }) {
+		return profile, fmt.Errorf("profile %s already exists", profile.Name)
+	}
+	profile, err = validateProfile(storage, profilesConfig, profile)
+	if err != nil {
+		return profile, err
+	}
+	profilesConfig.Profiles = append(profilesConfig.Profiles, profile)
+	return profile, writeProfiles(storage, profilesConfig)
+}
+
+// UpdateProfile replaces an existing profile. The profile keeps its position, which determines the precedence.
+func UpdateProfile(storage storage.Iface, name string, profile Profile) (Profile, error) {
+	profilesMutex.Lock()
+	defer profilesMutex.Unlock()
+	profilesConfig, err := GetProfiles(storage)
+	if err != nil {
+		return profile, err
+	}
+	k := slices.IndexFunc(profilesConfig.Profiles, func(p Profile) bool { return p.Name == name })
+	if k == -1 {
+		return profile, fmt.Errorf("profile not found")
+	}
+	if profile.Name != name && slices.ContainsFunc(profilesConfig.Profiles, func(p Profile) bool { return p.Name == profile.Name }) {
+		return profile, fmt.Errorf("profile %s already exists", profile.Name)
+	}
+	profilesConfig.Profiles = slices.Delete(profilesConfig.Profiles, k, k+1)
+	profile, err = validateProfile(storage, profilesConfig, profile)
+	if err != nil {
+		return profile, err
+	}
+	profilesConfig.Profiles = slices.Insert(profilesConfig.Profiles, k, profile)
+	return profile, writeProfiles(storage, profilesConfig)
+}
+
+// DeleteProfile removes a profile. Its connections fall back to the next matching profile, or to the vpn config.
+func DeleteProfile(storage storage.Iface, name string) error {
+	profilesMutex.Lock()
+	defer profilesMutex.Unlock()
+	profilesConfig, err := GetProfiles(storage)
+	if err != nil {
+		return err
+	}
+	k := slices.IndexFunc(profilesConfig.Profiles, func(p Profile) bool { return p.Name == name })
+	if k == -1 {
+		return fmt.Errorf("profile not found")
+	}
+	profilesConfig.Profiles = slices.Delete(profilesConfig.Profiles, k, k+1)
+	return writeProfiles(storage, profilesConfig)
+}
+
+// SetGroupMembers sets the user ids of a group. An empty list removes the group.
+func SetGroupMembers(storage storage.Iface, group string, userIDs []string) error {
+	if !profileNameRegexp.MatchString(group) {
+		return fmt.Errorf("group name can only contain letters, numbers, dashes and underscores")
+	}
+	profilesMutex.Lock()
+	defer profilesMutex.Unlock()
+	profilesConfig, err := GetProfiles(storage)
+	if err != nil {
+		return err
+	}
+	if len(userIDs) == 0 {
+		delete(profilesConfig.Groups, group)
+	} else {
+		profilesConfig.Groups[group] = slices.Compact(slices.Sorted(slices.Values(userIDs)))
+	}
+	return writeProfiles(storage, profilesConfig)
+}
+
+func validateProfile(storage storage.Iface, profilesConfig ProfilesConfig, profile Profile) (Profile, error) {
+	if !profileNameRegexp.MatchString(profile.Name) {
+		return profile, fmt.Errorf("profile name can only contain letters, numbers, dashes and underscores")
+	}
+	if profile.Users == nil {
+		profile.Users = []string{}
+	}
+	if profile.Groups == nil {
+		profile.Groups = []string{}
+	}
+	if profile.PersistentKeepalive < 0 || profile.PersistentKeepalive > 65535 {
+		return profile, fmt.Errorf("persistent keepalive needs to be between 0 and 65535 seconds")
+	}
+	for _, clientRoute := range profile.ClientRoutes {
+		_, _, err := net.ParseCIDR(clientRoute)
+		if err != nil {
+			return profile, fmt.Errorf("client route %s in wrong format: %s", clientRoute, err)
+		}
+	}
+	for _, excludedRoute := range profile.ClientExcludedRoutes {
+		_, _, err := net.ParseCIDR(excludedRoute)
+		if err != nil {
+			return profile, fmt.Errorf("excluded route %s in wrong format: %s", excludedRoute, err)
+		}
+	}
+	for _, nameserver := range profile.Nameservers {
+		_, err := netip.ParseAddr(nameserver)
+		if err != nil {
+			return profile, fmt.Errorf("nameserver %s in wrong format: %s", nameserver, err)
+		}
+	}
+	if profile.AddressRange.IsValid() {
+		vpnConfig, err := GetVPNConfig(storage)
+		if err != nil {
+			return profile, fmt.Errorf("failed to get vpn config: %s", err)
+		}
+		profile.AddressRange = profile.AddressRange.Masked()
+		if !profile.AddressRange.Addr().Is4() || profile.AddressRange.Bits() < vpnConfig.AddressRange.Bits() || !vpnConfig.AddressRange.Contains(profile.AddressRange.Addr()) {
+			return profile, fmt.Errorf("address range %s is not within the vpn address range %s", profile.AddressRange, vpnConfig.AddressRange)
+		}
+		clientPrefixLen, err := strconv.Atoi(strings.TrimPrefix(vpnConfig.ClientAddressPrefix, "/"))
+		if err == nil && profile.AddressRange.Bits() > clientPrefixLen {
+			return profile, fmt.Errorf("address range %s is smaller than the client address prefix %s", profile.AddressRange, vpnConfig.ClientAddressPrefix)
+		}
+		for _, existingProfile := range profilesConfig.Profiles {
+			if existingProfile.AddressRange.IsValid() && existingProfile.AddressRange.Overlaps(profile.AddressRange) {
+				return profile, fmt.Errorf("address range %s overlaps with the address range of profile %s", profile.AddressRange, existingProfile.Name)
+			}
+		}
+	}
+	return profile, nil
+}
+
+// getUserProfile returns the profile of a user. Profiles that contain the user take precedence over profiles of
+// the groups of the user. When multiple profiles match, the first one wins. Nil is returned when no profile matches.
+func (p ProfilesConfig) getUserProfile(userID string) *Profile {
+	for k := range p.Profiles {
+		if slices.Contains(p.Profiles[k].Users, userID) {
+			return &p.Profiles[k]
+		}
+	}
+	userGroups := p.getUserGroups(userID)
+	for k := range p.Profiles {
+		for _, group := range p.Profiles[k].Groups {
+			if slices.Contains(userGroups, group) {
+				return &p.Profiles[k]
+			}
+		}
+	}
+	return nil
+}
+
+// getUserGroups returns the groups of a user. Profiles and connection limits both resolve groups here.
+func (p ProfilesConfig) getUserGroups(userID string) []string {
+	userGroups := []string{}
+	for group, userIDs := range p.Groups {
+		if slices.Contains(userIDs, userID) {
+			userGroups = append(userGroups, group)
+		}
+	}
+	return userGroups
+}
+
+// getAddressScope returns where addresses for the connections of a profile are allocated. Addresses in the range
+// of a profile are only handed out to connections of that profile.
+func (p ProfilesConfig) getAddressScope(profile *Profile) addressScope {
+	scope := addressScope{}
+	for _, existingProfile := range p.Profiles {
+		if !existingProfile.AddressRange.IsValid() {
+			continue
+		}
+		if profile != nil && existingProfile.Name == profile.Name {
+			scope.within = existingProfile.AddressRange
+		} else {
+			scope.exclude = append(scope.exclude, existingProfile.AddressRange)
+		}
+	}
+	return scope
+}
+
+// getProfileClientSettings returns the client allowed ips, dns and keepalive of the connections of a profile.
+// Without a profile, the settings of the vpn config are used. The routed networks of network peers are always added.
+func getProfileClientSettings(vpnConfig VPNConfig, profile *Profile, routedNetworks []string) ([]string, string, int, error) {
+	clientRoutes := vpnConfig.ClientRoutes
+	excludedRoutes := vpnConfig.ClientExcludedRoutes
+	nameservers := vpnConfig.Nameservers
+	persistentKeepalive := 0
+	if profile != nil {
+		if len(profile.ClientRoutes) > 0 || len(profile.ClientExcludedRoutes) > 0 { // excluded routes of the vpn config don't apply to the routes of a profile
+			if len(profile.ClientRoutes) > 0 {
+				clientRoutes = profile.ClientRoutes
+			}
+			excludedRoutes = profile.ClientExcludedRoutes
+		}
+		if len(profile.Nameservers) > 0 {
+			nameservers = profile.Nameservers
+		}
+		persistentKeepalive = profile.PersistentKeepalive
+	}
+	clientAllowedIPs, err := getClientAllowedIPs(getClientAddressRanges(vpnConfig), clientRoutes, excludedRoutes, nameservers)
+	if err != nil {
+		return clientAllowedIPs, "", persistentKeepalive, fmt.Errorf("getClientAllowedIPs error: %s", err)
+	}
+	return appendUncoveredRoutes(clientAllowedIPs, routedNetworks), strings.Join(nameservers, ", "), persistentKeepalive, nil
+}
+
+// GetClientAllowedIPs returns the AllowedIPs of clients without a profile
+func GetClientAllowedIPs(storage storage.Iface, vpnConfig VPNConfig) ([]string, error) {
+	peerConfigs, err := GetAllPeerConfigs(storage)
+	if err != nil {
+		return nil, fmt.Errorf("could not get peer configs: %s", err)
+	}
+	clientAllowedIPs, _, _, err := getProfileClientSettings(vpnConfig, nil, getRoutedNetworks(peerConfigs, ""))
+	return clientAllowedIPs, err
+}
+
+func getProfileName(profile *Profile) string {
+	if profile == nil {
+		return ""
+	}
+	return profile.Name
+}
+
+func getPersistentKeepalive(peerConfig PeerConfig) int {
+	if peerConfig.PersistentKeepalive == 0 {
+		return DEFAULT_PERSISTENT_KEEPALIVE
+	}
+	return peerConfig.PersistentKeepalive
+}
@@ -0,0 +1,167 @@
+package wireguard
+
+import (
+	"net/netip"
+	"slices"
+	"strings"
+	"testing"
+
+	memorystorage "github.com/in4it/go-devops-platform/storage/memory"
+	configmanagerclient "github.com/in4it/wireguard-server/pkg/configmanager/client"
+)
+
+func TestGetUserProfile(t *testing.T) {
+	profilesConfig := ProfilesConfig{
+		Profiles: []Profile{
+			{Name: "engineering", Groups: []string{"engineering"}},
+			{Name: "finance", Groups: []string{"finance"}},
+			{Name: "contractor", Users: []string{"3-3-3-3"}},
+		},
+		Groups: map[string][]string{
+			"engineering": {"1-1-1-1", "3-3-3-3"},
+			"finance":     {"2-2-2-2", "1-1-1-1"},
+		},
+	}
+	testCases := map[string]string{
+		"1-1-1-1": "engineering", // first matching profile wins
+		"2-2-2-2": "finance",
+		"3-3-3-3": "contractor", // users take precedence over groups
+		"4-4-4-4": "",
+	}
+	for userID, expected := range testCases {
+		if profile := getProfileName(profilesConfig.getUserProfile(userID)); profile != expected {
+			t.Fatalf("unexpected profile for %s: %s (expected %s)", userID, profile, expected)
+		}
+	}
+}
+
+func TestProfiles(t *testing.T) {
+	var err error
+	UseConfigManagerClient(configmanagerclient.NewFake())
+	defer UseConfigManagerClient(nil)
+
+	storage := &memorystorage.MockMemoryStorage{}
+
+	vpnConfig, err := CreateNewVPNConfig(storage)
+	if err != nil {
+		t.Fatalf("CreateNewVPNConfig error: %s", err)
+	}
+	vpnConfig.Nameservers = []string{"1.1.1.1"}
+	err = WriteVPNConfig(storage, vpnConfig)
+	if err != nil {
+		t.Fatalf("WriteVPNConfig error: %s", err)
+	}
+	peerConfig1, err := NewEmptyClientConfig(storage, "2-2-2-2")
+	if err != nil {
+		t.Fatalf("NewEmptyClientConfig error: %s", err)
+	}
+
+	// invalid profiles
+	for _, profile := range []Profile{
+		{Name: "invalid name"},
+		{Name: "outside", AddressRange: netip.MustParsePrefix("10.0.0.0/24")},
+		{Name: "routes", ClientRoutes: []string{"10.0.1.0"}},
+	} {
+		_, err = AddProfile(storage, profile)
+		if err == nil {
+			t.Fatalf("expected error for profile %s", profile.Name)
+		}
+	}
+
+	profile, err := AddProfile(storage, Profile{
+		Name:                "finance",
+		AddressRange:        netip.MustParsePrefix("10.189.186.1/24"),
+		ClientRoutes:        []string{"10.0.1.0/24", "10.0.2.0/24"},
+		Nameservers:         []string{"10.0.1.53"},
+		PersistentKeepalive: 10,
+		Groups:              []string{"finance"},
+	})
+	if err != nil {
+		t.Fatalf("AddProfile error: %s", err)
+	}
+	if profile.AddressRange.String() != "10.189.186.0/24" {
+		t.Fatalf("expected masked address range. Got: %s", profile.AddressRange)
+	}
+	_, err = AddProfile(storage, Profile{Name: "overlap", AddressRange: netip.MustParsePrefix("10.189.186.128/25")})
+	if err == nil {
+		t.Fatalf("expected error for overlapping address range")
+	}
+	err = SetGroupMembers(storage, "finance", []string{"3-3-3-3"})
+	if err != nil {
+		t.Fatalf("SetGroupMembers error: %s", err)
+	}
+
+	peerConfig2, err := NewEmptyClientConfig(storage, "3-3-3-3")
+	if err != nil {
+		t.Fatalf("NewEmptyClientConfig error: %s", err)
+	}
+	if peerConfig2.Profile != "finance" || peerConfig2.DNS != "10.0.1.53" || peerConfig2.PersistentKeepalive != 10 {
+		t.Fatalf("profile not applied: %+v", peerConfig2)
+	}
+	if !profile.AddressRange.Contains(netip.MustParsePrefix(peerConfig2.Address).Addr()) {
+		t.Fatalf("address %s not in profile address range", peerConfig2.Address)
+	}
+	if strings.Join(peerConfig2.ClientAllowedIPs, ",") != "10.0.1.0/24,10.0.2.0/24,10.189.184.1/32" {
+		t.Fatalf("unexpected client allowed ips: %v", peerConfig2.ClientAllowedIPs)
+	}
+	out, err := GenerateNewClientConfig(storage, peerConfig2.ID, "3-3-3-3")
+	if err != nil {
+		t.Fatalf("GenerateNewClientConfig error: %s", err)
+	}
+	if !strings.Contains(string(out), "PersistentKeepalive = 10") {
+		t.Fatalf("keepalive of profile not in client config: %s", out)
+	}
+
+	// connections without profile don't get an address of the profile range
+	peerConfig3, err := NewEmptyClientConfig(storage, "4-4-4-4")
+	if err != nil {
+		t.Fatalf("NewEmptyClientConfig error: %s", err)
+	}
+	if peerConfig3.Profile != "" || profile.AddressRange.Contains(netip.MustParsePrefix(peerConfig3.Address).Addr()) {
+		t.Fatalf("connection without profile got profile settings: %+v", peerConfig3)
+	}
+	out, err = GenerateNewClientConfig(storage, peerConfig3.ID, "4-4-4-4")
+	if err != nil {
+		t.Fatalf("GenerateNewClientConfig error: %s", err)
+	}
+	if !strings.Contains(string(out), "PersistentKeepalive = 25") {
+		t.Fatalf("default keepalive not in client config: %s", out)
+	}
+
+	// existing connections move to the profile range when the user is added to the group
+	err = SetGroupMembers(storage, "finance", []string{"3-3-3-3", "2-2-2-2"})
+	if err != nil {
+		t.Fatalf("SetGroupMembers error: %s", err)
+	}
+	err = UpdateClientsConfig(storage)
+	if err != nil {
+		t.Fatalf("UpdateClientsConfig error: %s", err)
+	}
+	peerConfig1, err = getPeerConfig(storage, peerConfig1.ID)
+	if err != nil {
+		t.Fatalf("getPeerConfig error: %s", err)
+	}
+	if peerConfig1.Profile != "finance" || !profile.AddressRange.Contains(netip.MustParsePrefix(peerConfig1.Address).Addr()) {
+		t.Fatalf("profile not applied to existing connection: %+v", peerConfig1)
+	}
+	if !slices.Equal(peerConfig1.ServerAllowedIPs, []string{peerConfig1.Address}) {
+		t.Fatalf("server allowed ips not updated: %v", peerConfig1.ServerAllowedIPs)
+	}
+
+	// connections fall back to the vpn config when the profile is deleted
+	err = DeleteProfile(storage, "finance")
+	if err != nil {
+		t.Fatalf("DeleteProfile error: %s", err)
+	}
+	err = UpdateClientsConfig(storage)
+	if err != nil {
+		t.Fatalf("UpdateClientsConfig error: %s", err)
+	}
+	peerConfig2, err = getPeerConfig(storage, peerConfig2.ID)
+	if err != nil {
+		t.Fatalf("getPeerConfig error: %s", err)
+	}
+	if peerConfig2.Profile != "" || peerConfig2.DNS != "1.1.1.1" || peerConfig2.PersistentKeepalive != 0 {
+		t.Fatalf("profile settings not removed: %+v", peerConfig2)
+	}
+}
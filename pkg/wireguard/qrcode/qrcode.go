@@ -0,0 +1,518 @@
+// Package qrcode implements a minimal QR code encoder (byte mode, all error correction levels),
+// enough to render WireGuard client configs for mobile clients.
+package qrcode
+
+import (
+	"fmt"
+)
+
+const (
+	minVersion = 1
+	maxVersion = 40
+	quietZone  = 4
+)
+
+// Level is the error correction level of a QR code.
+type Level int
+
+const (
+	LevelL Level = iota // recovers 7% of the data
+	LevelM              // recovers 15% of the data
+	LevelQ              // recovers 25% of the data
+	LevelH              // recovers 30% of the data
+)
+
+// format bits of each error correction level
+var levelFormatBits = [4]int{LevelL: 1, LevelM: 0, LevelQ: 3, LevelH: 2}
+
+// error correction codewords per block, indexed by error correction level and version
+var eccCodewordsPerBlock = [4][maxVersion + 1]int{
+	LevelL: {-1, 7, 10, 15, 20, 26, 18, 20, 24, 30, 18, 20, 24, 26, 30, 22, 24, 28, 30, 28, 28, 28, 28, 30, 30, 26, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	LevelM: {-1, 10, 16, 26, 18, 24, 16, 18, 22, 22, 26, 30, 22, 22, 24, 24, 28, 28, 26, 26, 26, 26, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28},
+	LevelQ: {-1, 13, 22, 18, 26, 18, 24, 18, 22, 20, 24, 28, 26, 24, 20, 30, 24, 28, 28, 26, 30, 28, 30, 30, 30, 30, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	LevelH: {-1, 17, 28, 22, 16, 22, 28, 26, 26, 24, 28, 24, 28, 22, 24, 24, 30, 28, 28, 26, 28, 30, 24, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+}
+
+// number of error correction blocks, indexed by error correction level and version
+var numErrorCorrectionBlocks = [4][maxVersion + 1]int{
+	LevelL: {-1, 1, 1, 1, 1, 1, 2, 2, 2, 2, 4, 4, 4, 4, 4, 6, 6, 6, 6, 7, 8, 8, 9, 9, 10, 12, 12, 12, 13, 14, 15, 16, 17, 18, 19, 19, 20, 21, 22, 24, 25},
+	LevelM: {-1, 1, 1, 1, 2, 2, 4, 4, 4, 5, 5, 5, 8, 9, 9, 10, 10, 11, 13, 14, 16, 17, 17, 18, 20, 21, 23, 25, 26, 28, 29, 31, 33, 35, 37, 38, 40, 43, 45, 47, 49},
+	LevelQ: {-1, 1, 1, 2, 2, 4, 4, 6, 6, 8, 8, 8, 10, 12, 16, 12, 17, 16, 18, 21, 20, 23, 23, 25, 27, 29, 34, 34, 35, 38, 40, 43, 45, 48, 51, 53, 56, 59, 62, 65, 68},
+	LevelH: {-1, 1, 1, 2, 4, 4, 4, 5, 6, 8, 8, 11, 11, 16, 16, 18, 16, 19, 21, 25, 25, 25, 34, 30, 32, 35, 37, 40, 42, 45, 48, 51, 54, 57, 60, 63, 66, 70, 74, 77, 81},
+}
+
+// QRCode is a square grid of modules. true is a dark module.
+type QRCode struct {
+	Version int
+	Level   Level
+	Size    int
+	Modules [][]bool
+
+	isFunction [][]bool
+}
+
+// Encode encodes data in byte mode with error correction level M, using the smallest version that fits.
+func Encode(data []byte) (*QRCode, error) {
+	return EncodeWithLevel(data, LevelM)
+}
+
+// EncodeWithLevel encodes data in byte mode with the given error correction level, using the smallest version that fits.
+func EncodeWithLevel(data []byte, level Level) (*QRCode, error) {
+	if level < LevelL || level > LevelH {
+		return nil, fmt.Errorf("invalid error correction level: %d", level)
+	}
+	version := minVersion
+	for ; version <= maxVersion; version++ {
+		if len(data)*8+4+charCountBits(version) <= numDataCodewords(version, level)*8 {
+			break
+		}
+	}
+	if version > maxVersion {
+		return nil, fmt.Errorf("data too long to encode in a QR code (%d bytes)", len(data))
+	}
+	return encode(data, version, level, -1), nil
+}
+
+// encode encodes data that fits in the given version. A negative mask chooses the mask with the lowest penalty.
+func encode(data []byte, version int, level Level, mask int) *QRCode {
+	// mode indicator, character count, data
+	bits := bitBuffer{}
+	bits.appendBits(0x4, 4)
+	bits.appendBits(len(data), charCountBits(version))
+	for _, b := range data {
+		bits.appendBits(int(b), 8)
+	}
+	// terminator and padding
+	capacity := numDataCodewords(version, level) * 8
+	bits.appendBits(0, min(4, capacity-len(bits)))
+	bits.appendBits(0, (8-len(bits)%8)%8)
+	for padByte := 0xEC; len(bits) < capacity; padByte ^= 0xEC ^ 0x11 {
+		bits.appendBits(padByte, 8)
+	}
+	dataCodewords := make([]byte, len(bits)/8)
+	for i, bit := range bits {
+		if bit {
+			dataCodewords[i>>3] |= 1 << (7 - uint(i&7))
+		}
+	}
+
+	qr := newQRCode(version, level)
+	qr.drawFunctionPatterns()
+	qr.drawCodewords(addEccAndInterleave(version, level, dataCodewords))
+
+	if mask < 0 {
+		// choose the mask with the lowest penalty
+		minPenalty := -1
+		for i := 0; i < 8; i++ {
+			qr.applyMask(i)
+			qr.drawFormatBits(i)
+			penalty := qr.penaltyScore()
+			if minPenalty == -1 || penalty < minPenalty {
+				mask, minPenalty = i, penalty
+			}
+			qr.applyMask(i) // XOR again to undo
+		}
+	}
+	qr.applyMask(mask)
+	qr.drawFormatBits(mask)
+
+	return qr
+}
+
+func newQRCode(version int, level Level) *QRCode {
+	size := version*4 + 17
+	qr := &QRCode{
+		Version:    version,
+		Level:      level,
+		Size:       size,
+		Modules:    make([][]bool, size),
+		isFunction: make([][]bool, size),
+	}
+	for i := range size {
+		qr.Modules[i] = make([]bool, size)
+		qr.isFunction[i] = make([]bool, size)
+	}
+	return qr
+}
+
+func (qr *QRCode) setFunctionModule(x, y int, dark bool) {
+	qr.Modules[y][x] = dark
+	qr.isFunction[y][x] = true
+}
+
+func (qr *QRCode) drawFunctionPatterns() {
+	// timing patterns
+	for i := 0; i < qr.Size; i++ {
+		qr.setFunctionModule(6, i, i%2 == 0)
+		qr.setFunctionModule(i, 6, i%2 == 0)
+	}
+
+	// finder patterns
+	qr.drawFinderPattern(3, 3)
+	qr.drawFinderPattern(qr.Size-4, 3)
+	qr.drawFinderPattern(3, qr.Size-4)
+
+	// alignment patterns
+	alignmentPatternPositions := getAlignmentPatternPositions(qr.Version)
+	numAlign := len(alignmentPatternPositions)
+	for i := range numAlign {
+		for j := range numAlign {
+			// skip the three finder corners
+			if (i == 0 && j == 0) || (i == 0 && j == numAlign-1) || (i == numAlign-1 && j == 0) {
+				continue
+			}
+			qr.drawAlignmentPattern(alignmentPatternPositions[i], alignmentPatternPositions[j])
+		}
+	}
+
+	// reserve format bits (overwritten later) and draw version bits
+	qr.drawFormatBits(0)
+	qr.drawVersion()
+}
+
+func (qr *QRCode) drawFinderPattern(x, y int) {
+	for dy := -4; dy <= 4; dy++ {
+		for dx := -4; dx <= 4; dx++ {
+			dist := max(abs(dx), abs(dy))
+			xx, yy := x+dx, y+dy
+			if xx >= 0 && xx < qr.Size && yy >= 0 && yy < qr.Size {
+				qr.setFunctionModule(xx, yy, dist != 2 && dist != 4)
+			}
+		}
+	}
+}
+
+func (qr *QRCode) drawAlignmentPattern(x, y int) {
+	for dy := -2; dy <= 2; dy++ {
+		for dx := -2; dx <= 2; dx++ {
+			qr.setFunctionModule(x+dx, y+dy, max(abs(dx), abs(dy)) != 1)
+		}
+	}
+}
+
+func (qr *QRCode) drawFormatBits(mask int) {
+	data := levelFormatBits[qr.Level]<<3 | mask
+	rem := data
+	for range 10 {
+		rem = (rem << 1) ^ ((rem >> 9) * 0x537)
+	}
+	bits := (data<<10 | rem) ^ 0x5412
+
+	// first copy
+	for i := 0; i <= 5; i++ {
+		qr.setFunctionModule(8, i, getBit(bits, i))
+	}
+	qr.setFunctionModule(8, 7, getBit(bits, 6))
+	qr.setFunctionModule(8, 8, getBit(bits, 7))
+	qr.setFunctionModule(7, 8, getBit(bits, 8))
+	for i := 9; i < 15; i++ {
+		qr.setFunctionModule(14-i, 8, getBit(bits, i))
+	}
+
+	// second copy
+	for i := range 8 {
+		qr.setFunctionModule(qr.Size-1-i, 8, getBit(bits, i))
+	}
+	for i := 8; i < 15; i++ {
+		qr.setFunctionModule(8, qr.Size-15+i, getBit(bits, i))
+	}
+	qr.setFunctionModule(8, qr.Size-8, true) // always dark
+}
+
+func (qr *QRCode) drawVersion() {
+	if qr.Version < 7 {
+		return
+	}
+	rem := qr.Version
+	for range 12 {
+		rem = (rem << 1) ^ ((rem >> 11) * 0x1F25)
+	}
+	bits := qr.Version<<12 | rem
+
+	for i := range 18 {
+		bit := getBit(bits, i)
+		a := qr.Size - 11 + i%3
+		b := i / 3
+		qr.setFunctionModule(a, b, bit)
+		qr.setFunctionModule(b, a, bit)
+	}
+}
+
+func (qr *QRCode) drawCodewords(data []byte) {
+	i := 0
+	// zigzag scan, two columns at a time, from the bottom right
+	for right := qr.Size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < qr.Size; vert++ {
+			for j := range 2 {
+				x := right - j
+				upward := (right+1)&2 == 0
+				y := vert
+				if upward {
+					y = qr.Size - 1 - vert
+				}
+				if !qr.isFunction[y][x] && i < len(data)*8 {
+					qr.Modules[y][x] = getBit(int(data[i>>3]), 7-(i&7))
+					i++
+				}
+			}
+		}
+	}
+}
+
+func (qr *QRCode) applyMask(mask int) {
+	for y := 0; y < qr.Size; y++ {
+		for x := 0; x < qr.Size; x++ {
+			var invert bool
+			switch mask {
+			case 0:
+				invert = (x+y)%2 == 0
+			case 1:
+				invert = y%2 == 0
+			case 2:
+				invert = x%3 == 0
+			case 3:
+				invert = (x+y)%3 == 0
+			case 4:
+				invert = (x/3+y/2)%2 == 0
+			case 5:
+				invert = x*y%2+x*y%3 == 0
+			case 6:
+				invert = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				invert = ((x+y)%2+x*y%3)%2 == 0
+			}
+			if invert && !qr.isFunction[y][x] {
+				qr.Modules[y][x] = !qr.Modules[y][x]
+			}
+		}
+	}
+}
+
+func (qr *QRCode) penaltyScore() int {
+	const (
+		penaltyN1 = 3
+		penaltyN2 = 3
+		penaltyN3 = 40
+		penaltyN4 = 10
+	)
+	result := 0
+
+	// adjacent modules in a row or column with the same color, and finder-like patterns
+	for _, columns := range []bool{false, true} {
+		for i := 0; i < qr.Size; i++ {
+			runColor := false
+			runLength := 0
+			history := make([]bool, 0, qr.Size)
+			for j := 0; j < qr.Size; j++ {
+				module := qr.Modules[i][j]
+				if columns {
+					module = qr.Modules[j][i]
+				}
+				history = append(history, module)
+				if j > 0 && module == runColor {
+					runLength++
+					if runLength == 5 {
+						result += penaltyN1
+					} else if runLength > 5 {
+						result++
+					}
+				} else {
+					runColor = module
+					runLength = 1
+				}
+			}
+			result += countFinderLikePatterns(history) * penaltyN3
+		}
+	}
+
+	// 2x2 blocks of the same color
+	for y := 0; y < qr.Size-1; y++ {
+		for x := 0; x < qr.Size-1; x++ {
+			color := qr.Modules[y][x]
+			if color == qr.Modules[y][x+1] && color == qr.Modules[y+1][x] && color == qr.Modules[y+1][x+1] {
+				result += penaltyN2
+			}
+		}
+	}
+
+	// balance of dark and light modules
+	dark := 0
+	for _, row := range qr.Modules {
+		for _, module := range row {
+			if module {
+				dark++
+			}
+		}
+	}
+	total := qr.Size * qr.Size
+	k := (abs(dark*20-total*10)+total-1)/total - 1
+	result += max(k, 0) * penaltyN4
+
+	return result
+}
+
+// countFinderLikePatterns counts the 1:1:3:1:1 patterns with 4 light modules on one side
+func countFinderLikePatterns(line []bool) int {
+	pattern := []bool{true, false, true, true, true, false, true}
+	count := 0
+	for i := 0; i+len(pattern) <= len(line); i++ {
+		match := true
+		for j, p := range pattern {
+			if line[i+j] != p {
+				match = false
+				break
+			}
+		}
+		if !match {
+			continue
+		}
+		if isLight(line, i-4, i) || isLight(line, i+len(pattern), i+len(pattern)+4) {
+			count++
+		}
+	}
+	return count
+}
+
+// isLight returns true if all modules in [start, end) are light. Modules outside of the symbol are light.
+func isLight(line []bool, start, end int) bool {
+	for i := start; i < end; i++ {
+		if i >= 0 && i < len(line) && line[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func addEccAndInterleave(version int, level Level, data []byte) []byte {
+	numBlocks := numErrorCorrectionBlocks[level][version]
+	blockEccLen := eccCodewordsPerBlock[level][version]
+	rawCodewords := numRawDataModules(version) / 8
+	numShortBlocks := numBlocks - rawCodewords%numBlocks
+	shortBlockLen := rawCodewords / numBlocks
+
+	divisor := reedSolomonComputeDivisor(blockEccLen)
+	blocks := make([][]byte, numBlocks)
+	k := 0
+	for i := range numBlocks {
+		dataLen := shortBlockLen - blockEccLen
+		if i >= numShortBlocks {
+			dataLen++
+		}
+		block := make([]byte, 0, shortBlockLen+1)
+		block = append(block, data[k:k+dataLen]...)
+		k += dataLen
+		ecc := reedSolomonComputeRemainder(block, divisor)
+		if i < numShortBlocks {
+			block = append(block, 0) // padding, skipped when interleaving
+		}
+		blocks[i] = append(block, ecc...)
+	}
+
+	result := make([]byte, 0, rawCodewords)
+	for i := 0; i < len(blocks[0]); i++ {
+		for j, block := range blocks {
+			if i != shortBlockLen-blockEccLen || j >= numShortBlocks {
+				result = append(result, block[i])
+			}
+		}
+	}
+	return result
+}
+
+func reedSolomonComputeDivisor(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree-1] = 1
+	root := byte(1)
+	for range degree {
+		for j := range degree {
+			result[j] = reedSolomonMultiply(result[j], root)
+			if j+1 < degree {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = reedSolomonMultiply(root, 0x02)
+	}
+	return result
+}
+
+func reedSolomonComputeRemainder(data, divisor []byte) []byte {
+	result := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i, coef := range divisor {
+			result[i] ^= reedSolomonMultiply(coef, factor)
+		}
+	}
+	return result
+}
+
+// reedSolomonMultiply multiplies two elements of GF(2^8) modulo x^8 + x^4 + x^3 + x^2 + 1
+func reedSolomonMultiply(x, y byte) byte {
+	z := 0
+	for i := 7; i >= 0; i-- {
+		z = (z << 1) ^ ((z >> 7) * 0x11D)
+		z ^= int((y>>uint(i))&1) * int(x)
+	}
+	return byte(z)
+}
+
+func getAlignmentPatternPositions(version int) []int {
+	if version == 1 {
+		return []int{}
+	}
+	numAlign := version/7 + 2
+	step := (version*8 + numAlign*3 + 5) / (numAlign*4 - 4) * 2
+	result := make([]int, numAlign)
+	result[0] = 6
+	for i, pos := numAlign-1, version*4+17-7; i >= 1; i, pos = i-1, pos-step {
+		result[i] = pos
+	}
+	return result
+}
+
+func numRawDataModules(version int) int {
+	result := (16*version+128)*version + 64
+	if version >= 2 {
+		numAlign := version/7 + 2
+		result -= (25*numAlign-10)*numAlign - 55
+		if version >= 7 {
+			result -= 36
+		}
+	}
+	return result
+}
+
+func numDataCodewords(version int, level Level) int {
+	return numRawDataModules(version)/8 - eccCodewordsPerBlock[level][version]*numErrorCorrectionBlocks[level][version]
+}
+
+func charCountBits(version int) int {
+	if version <= 9 {
+		return 8
+	}
+	return 16
+}
+
+type bitBuffer []bool
+
+func (b *bitBuffer) appendBits(value, length int) {
+	for i := length - 1; i >= 0; i-- {
+		*b = append(*b, (value>>uint(i))&1 != 0)
+	}
+}
+
+func getBit(x, i int) bool {
+	return (x>>uint(i))&1 != 0
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
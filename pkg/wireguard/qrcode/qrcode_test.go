@@ -0,0 +1,220 @@
+package qrcode
+
+import (
+	"bytes"
+	"fmt"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNumDataCodewords(t *testing.T) {
+	// data codewords per error correction level (ISO/IEC 18004 table 7)
+	expected := map[Level]map[int]int{
+		LevelL: {1: 19, 2: 34, 7: 156, 10: 274, 20: 861, 40: 2956},
+		LevelM: {1: 16, 2: 28, 7: 124, 10: 216, 20: 669, 40: 2334},
+		LevelQ: {1: 13, 2: 22, 7: 88, 10: 154, 20: 485, 40: 1666},
+		LevelH: {1: 9, 2: 16, 7: 66, 10: 122, 20: 385, 40: 1276},
+	}
+	for level, versions := range expected {
+		for version, codewords := range versions {
+			if numDataCodewords(version, level) != codewords {
+				t.Errorf("version %d, level %d: expected %d data codewords, got %d", version, level, codewords, numDataCodewords(version, level))
+			}
+		}
+	}
+}
+
+// TestEncodeKnownAnswer compares the modules with the output of a reference encoder (rsc.io/qr/coding),
+// for the version, error correction level, mask and data length in the file name of every file in testdata.
+func TestEncodeKnownAnswer(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "*.txt"))
+	if err != nil {
+		t.Fatalf("glob error: %s", err)
+	}
+	if len(files) == 0 {
+		t.Fatalf("no testdata found")
+	}
+	for _, file := range files {
+		var version, mask, length int
+		var levelName rune
+		if _, err := fmt.Sscanf(filepath.Base(file), "v%d-%c-mask%d-%d.txt", &version, &levelName, &mask, &length); err != nil {
+			t.Fatalf("invalid testdata filename %s: %s", file, err)
+		}
+		level := Level(strings.IndexRune("LMQH", levelName))
+		expected, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatalf("read error: %s", err)
+		}
+		qr := encode(knownAnswerData(length), version, level, mask)
+		if string(qr.text()) != string(expected) {
+			t.Errorf("%s: modules don't match the reference encoder", filepath.Base(file))
+		}
+	}
+}
+
+func TestEncode(t *testing.T) {
+	for _, level := range []Level{LevelL, LevelM, LevelQ, LevelH} {
+		for _, length := range []int{1, 14, 15, 100, 300, 1000} {
+			testEncode(t, level, length)
+		}
+	}
+}
+
+func TestEncodeInvalidLevel(t *testing.T) {
+	if _, err := EncodeWithLevel([]byte("x"), Level(4)); err == nil {
+		t.Fatalf("expected error for invalid error correction level")
+	}
+}
+
+func testEncode(t *testing.T, level Level, length int) {
+	data := []byte(strings.Repeat("x", length))
+	qr, err := EncodeWithLevel(data, level)
+	if err != nil {
+		t.Fatalf("encode error: %s", err)
+	}
+	if qr.Size != qr.Version*4+17 || len(qr.Modules) != qr.Size {
+		t.Fatalf("wrong size: %d (version %d)", qr.Size, qr.Version)
+	}
+
+	// read back the format bits and check the mask
+	formatBits := 0
+	for i := 14; i >= 9; i-- {
+		formatBits = formatBits<<1 | boolToInt(qr.Modules[8][14-i])
+	}
+	formatBits = formatBits<<1 | boolToInt(qr.Modules[8][7])
+	formatBits = formatBits<<1 | boolToInt(qr.Modules[8][8])
+	formatBits = formatBits<<1 | boolToInt(qr.Modules[7][8])
+	for i := 5; i >= 0; i-- {
+		formatBits = formatBits<<1 | boolToInt(qr.Modules[i][8])
+	}
+	formatBits ^= 0x5412
+	if formatBits>>13 != levelFormatBits[level] {
+		t.Fatalf("wrong error correction level in format bits: %d", formatBits>>13)
+	}
+	mask := (formatBits >> 10) & 7
+
+	// read back the codewords and check the error correction of every block
+	qr.applyMask(mask)
+	codewords := qr.readCodewords()
+	qr.applyMask(mask)
+	numBlocks := numErrorCorrectionBlocks[level][qr.Version]
+	blockEccLen := eccCodewordsPerBlock[level][qr.Version]
+	rawCodewords := numRawDataModules(qr.Version) / 8
+	numShortBlocks := numBlocks - rawCodewords%numBlocks
+	shortBlockLen := rawCodewords / numBlocks
+	blocks := make([][]byte, numBlocks)
+	k := 0
+	for i := 0; i <= shortBlockLen; i++ {
+		for j := range numBlocks {
+			if i != shortBlockLen-blockEccLen || j >= numShortBlocks {
+				blocks[j] = append(blocks[j], codewords[k])
+				k++
+			}
+		}
+	}
+	decoded := []byte{}
+	divisor := reedSolomonComputeDivisor(blockEccLen)
+	for _, block := range blocks {
+		dataLen := len(block) - blockEccLen
+		ecc := reedSolomonComputeRemainder(block[:dataLen], divisor)
+		if !bytes.Equal(ecc, block[dataLen:]) {
+			t.Fatalf("version %d: error correction mismatch", qr.Version)
+		}
+		decoded = append(decoded, block[:dataLen]...)
+	}
+	headerLen := (4 + charCountBits(qr.Version)) / 8
+	if decoded[0]>>4 != 0x4 {
+		t.Fatalf("expected byte mode")
+	}
+	shift := uint(4)
+	out := make([]byte, length)
+	for i := range out {
+		out[i] = decoded[headerLen+i]<<shift | decoded[headerLen+i+1]>>(8-shift)
+	}
+	if !bytes.Equal(out, data) {
+		t.Fatalf("version %d: decoded data mismatch", qr.Version)
+	}
+}
+
+func TestRender(t *testing.T) {
+	qr, err := Encode([]byte("[Interface]"))
+	if err != nil {
+		t.Fatalf("encode error: %s", err)
+	}
+	out, err := qr.PNG(4)
+	if err != nil {
+		t.Fatalf("png error: %s", err)
+	}
+	img, err := png.Decode(bytes.NewReader(out))
+	if err != nil {
+		t.Fatalf("png decode error: %s", err)
+	}
+	if img.Bounds().Dx() != (qr.Size+quietZone*2)*4 {
+		t.Fatalf("wrong image size: %d", img.Bounds().Dx())
+	}
+	if !strings.Contains(string(qr.SVG()), "<svg") {
+		t.Fatalf("not an svg image")
+	}
+}
+
+func (qr *QRCode) readCodewords() []byte {
+	result := make([]byte, numRawDataModules(qr.Version)/8)
+	i := 0
+	for right := qr.Size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < qr.Size; vert++ {
+			for j := range 2 {
+				x := right - j
+				y := vert
+				if (right+1)&2 == 0 {
+					y = qr.Size - 1 - vert
+				}
+				if !qr.isFunction[y][x] && i < len(result)*8 {
+					if qr.Modules[y][x] {
+						result[i>>3] |= 1 << (7 - uint(i&7))
+					}
+					i++
+				}
+			}
+		}
+	}
+	return result
+}
+
+// knownAnswerData returns length bytes of a sample client config, the same data the reference encoder got
+func knownAnswerData(length int) []byte {
+	const sample = "[Interface]\nAddress = 10.189.184.2/32\nPrivateKey = yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk=\nDNS = 1.1.1.1\n\n[Peer]\nPublicKey = xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=\nAllowedIPs = 0.0.0.0/0\nEndpoint = vpn.example.com:51820\n"
+	data := make([]byte, length)
+	for i := range data {
+		data[i] = sample[i%len(sample)]
+	}
+	return data
+}
+
+// text returns the modules as lines of '#' (dark) and '.' (light)
+func (qr *QRCode) text() []byte {
+	out := []byte{}
+	for _, row := range qr.Modules {
+		for _, module := range row {
+			if module {
+				out = append(out, '#')
+			} else {
+				out = append(out, '.')
+			}
+		}
+		out = append(out, '\n')
+	}
+	return out
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,53 @@
+package qrcode
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+)
+
+// PNG renders the QR code as a PNG image, with scale pixels per module
+func (qr *QRCode) PNG(scale int) ([]byte, error) {
+	size := (qr.Size + quietZone*2) * scale
+	img := image.NewPaletted(image.Rect(0, 0, size, size), color.Palette{color.White, color.Black})
+	for y, row := range qr.Modules {
+		for x, dark := range row {
+			if !dark {
+				continue
+			}
+			for dy := range scale {
+				for dx := range scale {
+					img.SetColorIndex((x+quietZone)*scale+dx, (y+quietZone)*scale+dy, 1)
+				}
+			}
+		}
+	}
+	out := bytes.NewBuffer([]byte{})
+	err := png.Encode(out, img)
+	if err != nil {
+		return nil, fmt.Errorf("png encode error: %s", err)
+	}
+	return out.Bytes(), nil
+}
+
+// SVG renders the QR code as an SVG image
+func (qr *QRCode) SVG() []byte {
+	size := qr.Size + quietZone*2
+	path := strings.Builder{}
+	for y, row := range qr.Modules {
+		for x, dark := range row {
+			if dark {
+				fmt.Fprintf(&path, "M%d,%dh1v1h-1z", x+quietZone, y+quietZone)
+			}
+		}
+	}
+	return fmt.Appendf(nil, `<?xml version="1.0" encoding="UTF-8"?>
+<svg xmlns="http://www.w3.org/2000/svg" version="1.1" viewBox="0 0 %d %d" shape-rendering="crispEdges">
+<rect width="100%%" height="100%%" fill="#FFFFFF"/>
+<path d="%s" fill="#000000"/>
+</svg>
+`, size, size, path.String())
+}
@@ -0,0 +1,127 @@
+package wireguard
+
+import (
+	"fmt"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/in4it/go-devops-platform/storage"
+)
+
+var serverKeyMutex sync.Mutex
+
+// RotateServerKey generates a new server keypair. The new key is kept as pending until switchAt, after which
+// ActivatePendingServerKey makes it the active key. All peers are marked as needing a config refresh.
+func RotateServerKey(storage storage.Iface, switchAt time.Time) (VPNConfig, error) {
+	return rotateServerKey(storage, switchAt, false)
+}
+
+// EmergencyRotateServerKey rotates the server key right away, rotates all preshared keys
+// and disables all peers until they download a new config.
+func EmergencyRotateServerKey(storage storage.Iface) (VPNConfig, error) {
+	vpnConfig, err := rotateServerKey(storage, time.Now(), true)
+	if err != nil {
+		return vpnConfig, err
+	}
+	_, err = ActivatePendingServerKey(storage, time.Now())
+	if err != nil {
+		return vpnConfig, fmt.Errorf("could not activate new server key: %s", err)
+	}
+	return GetVPNConfig(storage)
+}
+
+func rotateServerKey(storage storage.Iface, switchAt time.Time, emergency bool) (VPNConfig, error) {
+	serverKeyMutex.Lock()
+	defer serverKeyMutex.Unlock()
+
+	vpnConfig, err := GetVPNConfig(storage)
+	if err != nil {
+		return vpnConfig, fmt.Errorf("failed to get vpn config: %s", err)
+	}
+
+	privateKey, publicKey, err := GenerateKeys()
+	if err != nil {
+		return vpnConfig, fmt.Errorf("GenerateKeys error: %s", err)
+	}
+	err = storage.WriteFile(path.Join(VPN_SERVER_SECRETS_PATH, VPN_PENDING_PRIVATE_KEY_FILENAME), []byte(privateKey))
+	if err != nil {
+		return vpnConfig, fmt.Errorf("could not write pending private key to %s: %s", path.Join(VPN_SERVER_SECRETS_PATH, VPN_PENDING_PRIVATE_KEY_FILENAME), err)
+	}
+
+	vpnConfig.PendingPublicKey = publicKey
+	vpnConfig.PendingKeySwitchAt = switchAt.UTC()
+
+	err = WriteVPNConfig(storage, vpnConfig)
+	if err != nil {
+		return vpnConfig, fmt.Errorf("WriteVPNConfig error: %s", err)
+	}
+
+	err = markPeerConfigsForRefresh(storage, emergency)
+	if err != nil {
+		return vpnConfig, fmt.Errorf("could not mark peer configs for refresh: %s", err)
+	}
+
+	return vpnConfig, nil
+}
+
+// ActivatePendingServerKey makes the pending server key the active key when its switch time has passed.
+// Returns true when the key was switched.
+func ActivatePendingServerKey(storage storage.Iface, now time.Time) (bool, error) {
+	serverKeyMutex.Lock()
+	defer serverKeyMutex.Unlock()
+
+	vpnConfig, err := GetVPNConfig(storage)
+	if err != nil {
+		return false, fmt.Errorf("failed to get vpn config: %s", err)
+	}
+	if vpnConfig.PendingPublicKey == "" || now.Before(vpnConfig.PendingKeySwitchAt) {
+		return false, nil
+	}
+
+	err = storage.Rename(path.Join(VPN_SERVER_SECRETS_PATH, VPN_PENDING_PRIVATE_KEY_FILENAME), path.Join(VPN_SERVER_SECRETS_PATH, VPN_PRIVATE_KEY_FILENAME))
+	if err != nil {
+		return false, fmt.Errorf("could not move pending private key: %s", err)
+	}
+
+	vpnConfig.PublicKey = vpnConfig.PendingPublicKey
+	vpnConfig.PendingPublicKey = ""
+	vpnConfig.PendingKeySwitchAt = time.Time{}
+
+	err = WriteVPNConfig(storage, vpnConfig)
+	if err != nil {
+		return false, fmt.Errorf("WriteVPNConfig error: %s", err)
+	}
+
+	return true, nil
+}
+
+func markPeerConfigsForRefresh(storage storage.Iface, emergency bool) error {
+	clientConfigMutex.Lock()
+	defer clientConfigMutex.Unlock()
+
+	peerConfigs, err := GetAllPeerConfigs(storage)
+	if err != nil {
+		return fmt.Errorf("could not get peer configs: %s", err)
+	}
+	for _, peerConfig := range peerConfigs {
+		peerConfig.ConfigRefreshRequired = true
+		peerConfig.ServerPublicKey = "" // the next download gets the pending key
+		if emergency {
+			peerConfig.PresharedKey, err = GeneratePresharedKey()
+			if err != nil {
+				return fmt.Errorf("GeneratePresharedKey error: %s", err)
+			}
+			peerConfig.PresharedKeyRotationRequired = false
+			if !peerConfig.Disabled {
+				peerConfig.Disabled = true
+				peerConfig.DisabledUntilConfigRefresh = true
+			}
+		}
+		err = writePeerConfig(storage, peerConfig)
+		if err != nil {
+			return fmt.Errorf("could not write peer config (%s): %s", peerConfig.ID, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,215 @@
+package wireguard
+
+import (
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	memorystorage "github.com/in4it/go-devops-platform/storage/memory"
+	configmanagerclient "github.com/in4it/wireguard-server/pkg/configmanager/client"
+)
+
+func TestRotateServerKey(t *testing.T) {
+	var err error
+	UseConfigManagerClient(configmanagerclient.NewFake())
+	defer UseConfigManagerClient(nil)
+
+	storage := &memorystorage.MockMemoryStorage{}
+
+	vpnConfig, err := CreateNewVPNConfig(storage)
+	if err != nil {
+		t.Fatalf("CreateNewVPNConfig error: %s", err)
+	}
+	oldPublicKey := vpnConfig.PublicKey
+
+	peerConfig, err := NewEmptyClientConfig(storage, "2-2-2-2")
+	if err != nil {
+		t.Fatalf("NewEmptyClientConfig error: %s", err)
+	}
+
+	switchAt := time.Now().Add(1 * time.Hour)
+	vpnConfig, err = RotateServerKey(storage, switchAt)
+	if err != nil {
+		t.Fatalf("RotateServerKey error: %s", err)
+	}
+	if vpnConfig.PendingPublicKey == "" || vpnConfig.PublicKey != oldPublicKey {
+		t.Fatalf("expected new key to be pending")
+	}
+	peerConfig, err = getPeerConfig(storage, peerConfig.ID)
+	if err != nil {
+		t.Fatalf("getPeerConfig error: %s", err)
+	}
+	if !peerConfig.ConfigRefreshRequired {
+		t.Fatalf("expected peer to need a config refresh")
+	}
+
+	activated, err := ActivatePendingServerKey(storage, time.Now())
+	if err != nil {
+		t.Fatalf("ActivatePendingServerKey error: %s", err)
+	}
+	if activated {
+		t.Fatalf("key activated before switch time")
+	}
+	activated, err = ActivatePendingServerKey(storage, switchAt.Add(1*time.Second))
+	if err != nil {
+		t.Fatalf("ActivatePendingServerKey error: %s", err)
+	}
+	if !activated {
+		t.Fatalf("key not activated after switch time")
+	}
+	newVPNConfig, err := GetVPNConfig(storage)
+	if err != nil {
+		t.Fatalf("GetVPNConfig error: %s", err)
+	}
+	if newVPNConfig.PublicKey != vpnConfig.PendingPublicKey || newVPNConfig.PendingPublicKey != "" {
+		t.Fatalf("pending key is not active")
+	}
+	if storage.FileExists(path.Join(VPN_SERVER_SECRETS_PATH, VPN_PENDING_PRIVATE_KEY_FILENAME)) {
+		t.Fatalf("pending private key still exists")
+	}
+
+	out, err := GenerateNewClientConfig(storage, peerConfig.ID, "2-2-2-2")
+	if err != nil {
+		t.Fatalf("GenerateNewClientConfig error: %s", err)
+	}
+	if !strings.Contains(string(out), "PublicKey = "+newVPNConfig.PublicKey) {
+		t.Fatalf("new server public key not found in client config: %s", out)
+	}
+	peerConfig, err = getPeerConfig(storage, peerConfig.ID)
+	if err != nil {
+		t.Fatalf("getPeerConfig error: %s", err)
+	}
+	if peerConfig.ConfigRefreshRequired {
+		t.Fatalf("expected config refresh flag to be cleared after download")
+	}
+}
+
+func TestServerKeyGracePeriod(t *testing.T) {
+	storage := &memorystorage.MockMemoryStorage{}
+	UseConfigManagerClient(configmanagerclient.NewFake())
+	defer UseConfigManagerClient(nil)
+
+	vpnConfig, err := CreateNewVPNConfig(storage)
+	if err != nil {
+		t.Fatalf("CreateNewVPNConfig error: %s", err)
+	}
+	oldPublicKey := vpnConfig.PublicKey
+	peerConfig1, err := NewEmptyClientConfig(storage, "2-2-2-2")
+	if err != nil {
+		t.Fatalf("NewEmptyClientConfig error: %s", err)
+	}
+	vpnConfig, err = RotateServerKey(storage, time.Now().Add(1*time.Hour))
+	if err != nil {
+		t.Fatalf("RotateServerKey error: %s", err)
+	}
+
+	// peers that existed during the rotation get the pending key, so they keep working after the switch
+	for range 2 { // downloading the config again during the grace period renders the same key
+		out, err := GenerateNewClientConfig(storage, peerConfig1.ID, "2-2-2-2")
+		if err != nil {
+			t.Fatalf("GenerateNewClientConfig error: %s", err)
+		}
+		if !strings.Contains(string(out), "PublicKey = "+vpnConfig.PendingPublicKey) {
+			t.Fatalf("pending server public key not found in client config: %s", out)
+		}
+	}
+	peerConfig1, err = getPeerConfig(storage, peerConfig1.ID)
+	if err != nil {
+		t.Fatalf("getPeerConfig error: %s", err)
+	}
+	if peerConfig1.ConfigRefreshRequired {
+		t.Fatalf("expected config refresh flag to be cleared after downloading the pending key")
+	}
+
+	// new peers get the current key until the switch, and need a refresh after the switch
+	peerConfig2, err := NewEmptyClientConfig(storage, "3-3-3-3")
+	if err != nil {
+		t.Fatalf("NewEmptyClientConfig error: %s", err)
+	}
+	for range 2 {
+		out, err := GenerateNewClientConfig(storage, peerConfig2.ID, "3-3-3-3")
+		if err != nil {
+			t.Fatalf("GenerateNewClientConfig error: %s", err)
+		}
+		if !strings.Contains(string(out), "PublicKey = "+oldPublicKey) {
+			t.Fatalf("current server public key not found in client config: %s", out)
+		}
+	}
+	peerConfig2, err = getPeerConfig(storage, peerConfig2.ID)
+	if err != nil {
+		t.Fatalf("getPeerConfig error: %s", err)
+	}
+	if !peerConfig2.ConfigRefreshRequired {
+		t.Fatalf("expected new peer to need a config refresh")
+	}
+
+	switched, err := ActivatePendingServerKey(storage, vpnConfig.PendingKeySwitchAt)
+	if err != nil {
+		t.Fatalf("ActivatePendingServerKey error: %s", err)
+	}
+	if !switched {
+		t.Fatalf("expected pending server key to be activated")
+	}
+	for _, peerConfig := range []PeerConfig{peerConfig1, peerConfig2} {
+		userID, _, err := getClientIDAndConfigID(peerConfig.ID)
+		if err != nil {
+			t.Fatalf("getClientIDAndConfigID error: %s", err)
+		}
+		out, err := GenerateNewClientConfig(storage, peerConfig.ID, userID)
+		if err != nil {
+			t.Fatalf("GenerateNewClientConfig error: %s", err)
+		}
+		if !strings.Contains(string(out), "PublicKey = "+vpnConfig.PendingPublicKey) {
+			t.Fatalf("new server public key not found in client config after the switch: %s", out)
+		}
+	}
+}
+
+func TestEmergencyRotateServerKey(t *testing.T) {
+	var err error
+	UseConfigManagerClient(configmanagerclient.NewFake())
+	defer UseConfigManagerClient(nil)
+
+	storage := &memorystorage.MockMemoryStorage{}
+
+	vpnConfig, err := CreateNewVPNConfig(storage)
+	if err != nil {
+		t.Fatalf("CreateNewVPNConfig error: %s", err)
+	}
+
+	peerConfig, err := NewEmptyClientConfig(storage, "2-2-2-2")
+	if err != nil {
+		t.Fatalf("NewEmptyClientConfig error: %s", err)
+	}
+
+	newVPNConfig, err := EmergencyRotateServerKey(storage)
+	if err != nil {
+		t.Fatalf("EmergencyRotateServerKey error: %s", err)
+	}
+	if newVPNConfig.PublicKey == vpnConfig.PublicKey || newVPNConfig.PendingPublicKey != "" {
+		t.Fatalf("expected server key to be rotated right away")
+	}
+	rotatedPeerConfig, err := getPeerConfig(storage, peerConfig.ID)
+	if err != nil {
+		t.Fatalf("getPeerConfig error: %s", err)
+	}
+	if !rotatedPeerConfig.Disabled || !rotatedPeerConfig.DisabledUntilConfigRefresh {
+		t.Fatalf("expected peer to be disabled until config refresh")
+	}
+	if rotatedPeerConfig.PresharedKey == peerConfig.PresharedKey {
+		t.Fatalf("expected preshared key to be rotated")
+	}
+
+	_, err = GenerateNewClientConfig(storage, peerConfig.ID, "2-2-2-2")
+	if err != nil {
+		t.Fatalf("GenerateNewClientConfig error: %s", err)
+	}
+	rotatedPeerConfig, err = getPeerConfig(storage, peerConfig.ID)
+	if err != nil {
+		t.Fatalf("getPeerConfig error: %s", err)
+	}
+	if rotatedPeerConfig.Disabled || rotatedPeerConfig.DisabledUntilConfigRefresh || rotatedPeerConfig.ConfigRefreshRequired {
+		t.Fatalf("expected peer to be enabled after config refresh")
+	}
+}
@@ -1,11 +1,101 @@
 package wireguard
 
 import (
+	"errors"
 	"fmt"
+	"net/netip"
+	"os"
 	"os/exec"
+	"path"
+	"strings"
+
+	"github.com/in4it/go-devops-platform/storage"
+	wireguardbackend "github.com/in4it/wireguard-server/pkg/wireguard/backend"
+	wireguardlinux "github.com/in4it/wireguard-server/pkg/wireguard/linux"
 )
 
-func StartVPN() error {
+// StartVPN brings the vpn interface up. Unless the legacy wg-quick mode is configured, the interface is created and
+// configured through the backend. Starting an interface that is already up only corrects the parts that differ.
+func StartVPN(storage storage.Iface, backend wireguardbackend.Backend) error {
+	vpnConfig, err := GetVPNConfig(storage)
+	if err != nil {
+		return fmt.Errorf("failed to get vpn config: %s", err)
+	}
+	if vpnConfig.InterfaceMode == INTERFACE_MODE_WG_QUICK {
+		running, err := IsVPNRunning()
+		if err != nil {
+			return err
+		}
+		if running { // wg-quick can't update a running interface
+			return nil
+		}
+		return startWGQuick()
+	}
+	return startInterface(storage, backend, vpnConfig)
+}
+
+// StopVPN removes the vpn interface
+func StopVPN(storage storage.Iface, backend wireguardbackend.Backend) error {
+	vpnConfig, err := GetVPNConfig(storage)
+	if err != nil {
+		return fmt.Errorf("failed to get vpn config: %s", err)
+	}
+	if vpnConfig.InterfaceMode == INTERFACE_MODE_WG_QUICK {
+		return stopWGQuick()
+	}
+	return stopInterface(storage, backend)
+}
+
+func startInterface(storage storage.Iface, backend wireguardbackend.Backend, vpnConfig VPNConfig) error {
+	privateKeyBytes, err := storage.ReadFile(path.Join(VPN_SERVER_SECRETS_PATH, VPN_PRIVATE_KEY_FILENAME))
+	if err != nil {
+		return fmt.Errorf("failed to read private key: %s", err)
+	}
+	privateKey, err := wireguardlinux.ParseKey(strings.TrimSpace(string(privateKeyBytes)))
+	if err != nil {
+		return fmt.Errorf("could not parse private key: %s", err)
+	}
+
+	created, err := backend.StartInterface(VPN_INTERFACE_NAME, wireguardbackend.InterfaceConfig{
+		PrivateKey: privateKey,
+		ListenPort: vpnConfig.Port,
+		MTU:        getMTU(vpnConfig),
+		Addresses:  getServerAddresses(vpnConfig),
+	})
+	if err != nil {
+		return err
+	}
+
+	if created { // the PostUp commands of the server template only run once, when the interface is created
+		serverConfig, err := generateWireGuardServerConfig(storage)
+		if err != nil {
+			return fmt.Errorf("could not generate wireguard server config: %s", err)
+		}
+		err = runServerConfigHooks(serverConfig, "PostUp")
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func stopInterface(storage storage.Iface, backend wireguardbackend.Backend) error {
+	_, err := backend.Device(VPN_INTERFACE_NAME)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	serverConfig, err := generateWireGuardServerConfig(storage)
+	if err != nil {
+		return fmt.Errorf("could not generate wireguard server config: %s", err)
+	}
+	err = runServerConfigHooks(serverConfig, "PostDown")
+	if err != nil { // still remove the interface
+		fmt.Printf("Warning: %s\n", err)
+	}
+	return backend.StopInterface(VPN_INTERFACE_NAME)
+}
+
+func startWGQuick() error {
 	cmd := exec.Command("wg-quick", "up", "vpn")
 
 	if err := cmd.Start(); err != nil {
@@ -22,7 +112,7 @@ func StartVPN() error {
 	return nil
 }
 
-func StopVPN() error {
+func stopWGQuick() error {
 	cmd := exec.Command("wg-quick", "down", "vpn")
 
 	if err := cmd.Start(); err != nil {
@@ -38,3 +128,35 @@ func StopVPN() error {
 	}
 	return nil
 }
+
+// getServerConfigHooks returns the commands of a hook (e.g. PostUp) in the server config, with %i replaced by the interface name, like wg-quick does
+func getServerConfigHooks(serverConfig []byte, hook string) []string {
+	commands := []string{}
+	for line := range strings.Lines(string(serverConfig)) {
+		key, value, found := strings.Cut(line, "=")
+		if !found || !strings.EqualFold(strings.TrimSpace(key), hook) {
+			continue
+		}
+		command := strings.TrimSpace(value)
+		if command != "" {
+			commands = append(commands, strings.ReplaceAll(command, "%i", VPN_INTERFACE_NAME))
+		}
+	}
+	return commands
+}
+
+// getServerAddresses returns the addresses of the vpn interface: the server address with the prefix length of the vpn address range
+func getServerAddresses(vpnConfig VPNConfig) []netip.Prefix {
+	addresses := []netip.Prefix{vpnConfig.AddressRange}
+	if vpnConfig.AddressRangeIPv6.IsValid() {
+		addresses = append(addresses, vpnConfig.AddressRangeIPv6)
+	}
+	return addresses
+}
+
+func getMTU(vpnConfig VPNConfig) int {
+	if vpnConfig.MTU == 0 {
+		return DEFAULT_MTU
+	}
+	return vpnConfig.MTU
+}
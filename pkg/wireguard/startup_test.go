@@ -0,0 +1,101 @@
+package wireguard
+
+import (
+	"errors"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	memorystorage "github.com/in4it/go-devops-platform/storage/memory"
+	configmanagerclient "github.com/in4it/wireguard-server/pkg/configmanager/client"
+	wireguardbackend "github.com/in4it/wireguard-server/pkg/wireguard/backend"
+	wireguardlinux "github.com/in4it/wireguard-server/pkg/wireguard/linux"
+)
+
+func TestGetServerConfigHooks(t *testing.T) {
+	serverConfig := []byte(`[Interface]
+Address = 10.189.184.1/21
+PostUp = iptables -A FORWARD -i %i -j ACCEPT; iptables -A FORWARD -o %i -j ACCEPT
+PostUp=iptables -t nat -A POSTROUTING -o eth0 -j MASQUERADE
+PostDown = iptables -D FORWARD -i %i -j ACCEPT
+PostUp =
+`)
+	postUp := getServerConfigHooks(serverConfig, "PostUp")
+	expected := []string{
+		"iptables -A FORWARD -i vpn -j ACCEPT; iptables -A FORWARD -o vpn -j ACCEPT",
+		"iptables -t nat -A POSTROUTING -o eth0 -j MASQUERADE",
+	}
+	if !slices.Equal(postUp, expected) {
+		t.Fatalf("unexpected PostUp hooks: %v", postUp)
+	}
+	postDown := getServerConfigHooks(serverConfig, "PostDown")
+	if !slices.Equal(postDown, []string{"iptables -D FORWARD -i vpn -j ACCEPT"}) {
+		t.Fatalf("unexpected PostDown hooks: %v", postDown)
+	}
+}
+
+func TestStartVPNMemoryBackend(t *testing.T) {
+	storage := &memorystorage.MockMemoryStorage{}
+	UseConfigManagerClient(configmanagerclient.NewFake())
+	defer UseConfigManagerClient(nil)
+	vpnConfig, err := CreateNewVPNConfig(storage)
+	if err != nil {
+		t.Fatalf("CreateNewVPNConfig error: %s", err)
+	}
+	backend := wireguardbackend.NewMemory()
+
+	for range 2 { // starting a running interface is not an error
+		err = StartVPN(storage, backend)
+		if err != nil {
+			t.Fatalf("StartVPN error: %s", err)
+		}
+	}
+	interfaceConfig, err := backend.InterfaceConfig(VPN_INTERFACE_NAME)
+	if err != nil {
+		t.Fatalf("InterfaceConfig error: %s", err)
+	}
+	if interfaceConfig.ListenPort != vpnConfig.Port || interfaceConfig.MTU != DEFAULT_MTU || !slices.Equal(interfaceConfig.Addresses, getServerAddresses(vpnConfig)) {
+		t.Fatalf("unexpected interface config: %+v", interfaceConfig)
+	}
+
+	// stats of the peers
+	peerConfig, err := NewEmptyClientConfig(storage, "2-2-2-2")
+	if err != nil {
+		t.Fatalf("NewEmptyClientConfig error: %s", err)
+	}
+	publicKey, err := wireguardlinux.ParseKey(peerConfig.PublicKey)
+	if err != nil {
+		t.Fatalf("ParseKey error: %s", err)
+	}
+	err = backend.ApplyPeers(VPN_INTERFACE_NAME, []wireguardbackend.PeerConfig{{PublicKey: publicKey}})
+	if err != nil {
+		t.Fatalf("ApplyPeers error: %s", err)
+	}
+	err = backend.Transfer(VPN_INTERFACE_NAME, publicKey, 1000, 2000)
+	if err != nil {
+		t.Fatalf("Transfer error: %s", err)
+	}
+	err = runStats(storage, backend)
+	if err != nil {
+		t.Fatalf("runStats error: %s", err)
+	}
+	statsFile, err := storage.ReadFile(path.Join(VPN_STATS_DIR, "user-"+time.Now().Format("2006-01-02")) + ".log")
+	if err != nil {
+		t.Fatalf("could not read stats: %s", err)
+	}
+	if !strings.Contains(string(statsFile), ",2-2-2-2,1,1000,2000,") {
+		t.Fatalf("unexpected stats: %s", statsFile)
+	}
+
+	err = StopVPN(storage, backend)
+	if err != nil {
+		t.Fatalf("StopVPN error: %s", err)
+	}
+	_, err = backend.Device(VPN_INTERFACE_NAME)
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected the interface to be removed, got: %v", err)
+	}
+}
@@ -1,5 +1,3 @@
-//go:build linux
-
 package wireguard
 
 import (
@@ -12,12 +10,13 @@ import (
 
 	"github.com/in4it/go-devops-platform/logging"
 	"github.com/in4it/go-devops-platform/storage"
+	wireguardbackend "github.com/in4it/wireguard-server/pkg/wireguard/backend"
 	"github.com/in4it/wireguard-server/pkg/wireguard/linux/stats"
 )
 
 const RUN_STATS_INTERVAL = 5
 
-func RunStats(storage storage.Iface) {
+func RunStats(storage storage.Iface, backend wireguardbackend.Backend) {
 	err := storage.EnsurePath(VPN_STATS_DIR)
 	if err != nil {
 		logging.ErrorLog(fmt.Errorf("could not create stats path: %s. Stats disabled", err))
@@ -29,7 +28,7 @@ func RunStats(storage storage.Iface) {
 		return
 	}
 	for {
-		err := runStats(storage)
+		err := runStats(storage, backend)
 		if err != nil {
 			logging.ErrorLog(fmt.Errorf("run stats error: %s", err))
 		}
@@ -37,8 +36,8 @@ func RunStats(storage storage.Iface) {
 	}
 }
 
-func runStats(storage storage.Iface) error {
-	peerStats, err := stats.GetStats()
+func runStats(storage storage.Iface, backend wireguardbackend.Backend) error {
+	peerStats, err := stats.GetStats(backend)
 	if err != nil {
 		return fmt.Errorf("could not get WireGuard stats: %s", err)
 	}
@@ -5,20 +5,35 @@ import (
 	"net"
 	"net/netip"
 	"time"
+
+	configmanagerclient "github.com/in4it/wireguard-server/pkg/configmanager/client"
 )
 
 type VPNClientData struct {
-	Address         string
-	DNS             string
-	PrivateKey      string
-	ServerPublicKey string
-	PresharedKey    string
-	Endpoint        string
-	AllowedIPs      []string
+	ID                  string    `json:"id"`
+	Name                string    `json:"name"`
+	Address             string    `json:"address"`
+	DNS                 string    `json:"dns"`
+	PrivateKey          string    `json:"privateKey"`
+	ServerPublicKey     string    `json:"serverPublicKey"`
+	PresharedKey        string    `json:"presharedKey"`
+	Endpoint            string    `json:"endpoint"`
+	AllowedIPs          []string  `json:"allowedIPs"`
+	PersistentKeepalive int       `json:"persistentKeepalive"`
+	Description         string    `json:"description"`
+	Platform            string    `json:"platform"`
+	Labels              []string  `json:"labels"`
+	ServerKeySwitchAt   time.Time `json:"serverKeySwitchAt,omitzero"` // set when the config contains a pending server key
+}
+
+type ClientConfigOptions struct {
+	Format      string // one of the CLIENT_CONFIG_FORMAT formats
+	WaitForSync bool   // wait until the configmanager applied the peer
 }
 
 type VPNServerData struct {
 	Address           string
+	AddressIPv6       string
 	PrivateKey        string
 	Port              int
 	Clients           []VPNServerClient
@@ -33,38 +48,173 @@ type VPNServerClient struct {
 }
 
 type VPNConfig struct {
-	AddressRange        netip.Prefix    `json:"addressRange"`
-	ClientAddressPrefix string          `json:"clientAddressPrefix"`
-	PublicKey           string          `json:"publicKey"`
-	PresharedKey        string          `json:"presharedKey"`
-	Endpoint            string          `json:"endpoint"`
-	Port                int             `json:"port"`
-	ExternalInterface   string          `json:"externalInterface"`
-	Nameservers         []string        `json:"nameservers"`
-	DisableNAT          bool            `json:"disableNAT"`
-	ClientRoutes        []string        `json:"clientRoutes"`
-	EnablePacketLogs    bool            `json:"enablePacketLogs"`
-	PacketLogsTypes     map[string]bool `json:"packetLogsTypes"`
-	PacketLogsRetention int             `json:"packetLogsRetention"`
+	AddressRange            netip.Prefix    `json:"addressRange"`
+	ClientAddressPrefix     string          `json:"clientAddressPrefix"`
+	AddressRangeIPv6        netip.Prefix    `json:"addressRangeIPv6"`
+	ClientAddressPrefixIPv6 string          `json:"clientAddressPrefixIPv6"`
+	PublicKey               string          `json:"publicKey"`
+	PresharedKey            string          `json:"presharedKey"`
+	Endpoint                string          `json:"endpoint"`
+	Port                    int             `json:"port"`
+	ExternalInterface       string          `json:"externalInterface"`
+	Nameservers             []string        `json:"nameservers"`
+	DisableNAT              bool            `json:"disableNAT"`
+	ClientRoutes            []string        `json:"clientRoutes"`
+	ClientExcludedRoutes    []string        `json:"clientExcludedRoutes"`
+	EnablePacketLogs        bool            `json:"enablePacketLogs"`
+	PacketLogsTypes         map[string]bool `json:"packetLogsTypes"`
+	PacketLogsRetention     int             `json:"packetLogsRetention"`
+	RequireClientPublicKey  bool            `json:"requireClientPublicKey"`
+	OneTimeConfigReveal     bool            `json:"oneTimeConfigReveal"`
+	PendingPublicKey        string          `json:"pendingPublicKey,omitempty"`
+	PendingKeySwitchAt      time.Time       `json:"pendingKeySwitchAt,omitzero"`
+	MTU                     int             `json:"mtu,omitempty"`
+	InterfaceMode           string          `json:"interfaceMode,omitempty"`
 }
 
-type PubKeyExchange struct {
-	PubKey string `json:"pubKey"`
+type PeerConfig struct {
+	ID                           string    `json:"id"`
+	DNS                          string    `json:"dns"`
+	Name                         string    `json:"name"`
+	ServerAllowedIPs             []string  `json:"serverAllowedIPs"`
+	ClientAllowedIPs             []string  `json:"clientAllowedIPs"`
+	Address                      string    `json:"address"`
+	AddressIPv6                  string    `json:"addressIPv6,omitempty"`
+	PublicKey                    string    `json:"publicKey"`
+	Disabled                     bool      `json:"disabled"`
+	ClientGeneratedKey           bool      `json:"clientGeneratedKey"`
+	PrivateKeyEncrypted          string    `json:"privateKeyEncrypted,omitempty"`
+	ConfigRevealed               bool      `json:"configRevealed"`
+	PresharedKey                 string    `json:"presharedKey"`
+	ConfigRefreshRequired        bool      `json:"configRefreshRequired"`
+	ServerPublicKey              string    `json:"serverPublicKey,omitempty"`              // server public key of the downloaded config
+	PresharedKeyRotationRequired bool      `json:"presharedKeyRotationRequired,omitempty"` // the peer still uses the former global preshared key
+	DisabledUntilConfigRefresh   bool      `json:"disabledUntilConfigRefresh"`
+	DisabledReason               string    `json:"disabledReason,omitempty"`
+	DisabledBy                   string    `json:"disabledBy,omitempty"`
+	DisabledAt                   time.Time `json:"disabledAt,omitzero"`
+	NotBefore                    time.Time `json:"notBefore,omitzero"`
+	ExpiresAt                    time.Time `json:"expiresAt,omitzero"`
+	Profile                      string    `json:"profile,omitempty"`
+	PersistentKeepalive          int       `json:"persistentKeepalive,omitempty"`
+	Type                         string    `json:"type,omitempty"`
+	Networks                     []string  `json:"networks,omitempty"`       // networks behind a network peer
+	RouteToClients               bool      `json:"routeToClients,omitempty"` // add the networks to the allowed ips of the other clients
+	Description                  string    `json:"description,omitempty"`
+	Platform                     string    `json:"platform,omitempty"` // device the key lives on, one of the PLATFORM constants
+	Labels                       []string  `json:"labels,omitempty"`
 }
 
-type PeerConfig struct {
-	ID               string   `json:"id"`
-	DNS              string   `json:"dns"`
-	Name             string   `json:"name"`
-	ServerAllowedIPs []string `json:"serverAllowedIPs"`
-	ClientAllowedIPs []string `json:"clientAllowedIPs"`
-	Address          string   `json:"address"`
-	PublicKey        string   `json:"publicKey"`
-	Disabled         bool     `json:"disabled"`
-}
-type RefreshClientRequest struct {
-	Action    string
-	Filenames []string `json:"filenames"`
+// Profile overrides the address range, routes, nameservers and keepalive of the connections of its users and groups.
+// Routes and nameservers that are not set are taken from the vpn config. Excluded routes of the vpn config
+// only apply when the profile doesn't set its own routes or excluded routes.
+type Profile struct {
+	Name                 string       `json:"name"`
+	AddressRange         netip.Prefix `json:"addressRange,omitzero"`
+	ClientRoutes         []string     `json:"clientRoutes,omitempty"`
+	ClientExcludedRoutes []string     `json:"clientExcludedRoutes,omitempty"`
+	Nameservers          []string     `json:"nameservers,omitempty"`
+	PersistentKeepalive  int          `json:"persistentKeepalive,omitempty"`
+	Users                []string     `json:"users"`
+	Groups               []string     `json:"groups"`
+}
+
+type ProfilesConfig struct {
+	Profiles []Profile           `json:"profiles"`
+	Groups   map[string][]string `json:"groups"` // group name => user ids
+}
+
+type NewClientConfigOptions struct {
+	PublicKey      string
+	NotBefore      time.Time
+	ExpiresAt      time.Time
+	CreatedByAdmin bool // allowed when the connection policy only lets admins create connections
+
+	// network peers only
+	Type           string
+	Name           string
+	Networks       []string
+	RouteToClients bool
+}
+
+// ConnectionPolicy limits the connections of users. A limit of 0 means unlimited. A user limit takes precedence over
+// the group limits, and the highest limit of the groups of a user takes precedence over the default limit.
+type ConnectionPolicy struct {
+	MaxConnectionsPerUser int            `json:"maxConnectionsPerUser"`
+	UserLimits            map[string]int `json:"userLimits"`  // user id => max connections
+	GroupLimits           map[string]int `json:"groupLimits"` // group name => max connections
+	AdminOnly             bool           `json:"adminOnly"`   // only admins can create connections
+}
+
+// ConnectionMetadata changes the metadata of a connection. Fields that are nil are left unchanged.
+type ConnectionMetadata struct {
+	Name        *string   `json:"name"`
+	Description *string   `json:"description"`
+	Platform    *string   `json:"platform"`
+	Labels      *[]string `json:"labels"`
+}
+
+type NetworkPeerOptions struct {
+	Name           string   `json:"name"`
+	Networks       []string `json:"networks"`
+	RouteToClients bool     `json:"routeToClients"`
+	PublicKey      string   `json:"publicKey,omitempty"`
+}
+
+// ImportRequest imports the peers of an existing wg-quick server config. Client configs are optional: when the client config
+// of a peer is included, its private key is kept, so users can download their config again.
+type ImportRequest struct {
+	ServerConfig  string            `json:"serverConfig"`
+	ClientConfigs []string          `json:"clientConfigs,omitempty"`
+	UserMapping   map[string]string `json:"userMapping,omitempty"` // peer public key or comment => user login. Without mapping, the comment is the login.
+	ImportServer  bool              `json:"importServer"`          // import the private key and listen port of the server
+	DryRun        bool              `json:"dryRun"`
+}
+
+type ImportReport struct {
+	DryRun   bool            `json:"dryRun"`
+	Server   *ImportedServer `json:"server,omitempty"`
+	Peers    []ImportedPeer  `json:"peers"`
+	Imported int             `json:"imported"`
+	Skipped  int             `json:"skipped"`
+	Warnings []string        `json:"warnings,omitempty"`
+}
+
+type ImportedServer struct {
+	PublicKey string `json:"publicKey"`
+	Port      int    `json:"port"`
+	Status    string `json:"status"`
+}
+
+type ImportedPeer struct {
+	PublicKey          string   `json:"publicKey"`
+	Comment            string   `json:"comment,omitempty"`
+	Login              string   `json:"login,omitempty"`
+	ConnectionID       string   `json:"connectionID,omitempty"`
+	Address            string   `json:"address,omitempty"`
+	AddressIPv6        string   `json:"addressIPv6,omitempty"`
+	PrivateKeyImported bool     `json:"privateKeyImported"`
+	Status             string   `json:"status"`
+	Reason             string   `json:"reason,omitempty"`
+	Warnings           []string `json:"warnings,omitempty"`
+}
+
+// configmanager api types
+type PubKeyExchange = configmanagerclient.PubKeyExchange
+type RefreshClientRequest = configmanagerclient.RefreshClientRequest
+type RotateServerKeyRequest = configmanagerclient.RotateServerKeyRequest
+type RotateServerKeyResponse = configmanagerclient.RotateServerKeyResponse
+type LivePeerStat = configmanagerclient.LivePeerStat
+type ReconcileDrift = configmanagerclient.ReconcileDrift
+type ReconcileStatus = configmanagerclient.ReconcileStatus
+type Job = configmanagerclient.Job
+type JobResponse = configmanagerclient.JobResponse
+
+type ConnectionAuditEntry struct {
+	Timestamp    time.Time `json:"timestamp"`
+	ConnectionID string    `json:"connectionID"`
+	Action       string    `json:"action"`
+	Actor        string    `json:"actor"`
 }
 
 // stats
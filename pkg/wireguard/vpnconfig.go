@@ -11,6 +11,7 @@ import (
 	"net/netip"
 	"os/user"
 	"path"
+	"regexp"
 	"strings"
 	"sync"
 	"time"
@@ -21,6 +22,13 @@ import (
 
 var vpnConfigMutex sync.Mutex
 
+var interfaceNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9_.-]{1,15}$`)
+
+// IsValidInterfaceName returns true when name can be used as a network interface name in the firewall rules
+func IsValidInterfaceName(name string) bool {
+	return interfaceNameRegexp.MatchString(name)
+}
+
 func GetVPNConfig(storage storage.Iface) (VPNConfig, error) {
 	var vpnConfig VPNConfig
 
@@ -147,22 +155,6 @@ func WriteVPNConfig(storage storage.Iface, vpnConfig VPNConfig) error {
 	return nil
 }
 
-func ReloadVPNServerConfig() error {
-	// notify configmanager
-	client := http.Client{
-		Timeout: 10 * time.Second,
-	}
-
-	resp, err := client.Post("http://"+CONFIGMANAGER_URI+"/refresh-server-config", "application/json", nil)
-	if err != nil {
-		return fmt.Errorf("configmanager post error: %s", err)
-	}
-	if resp.StatusCode != http.StatusAccepted {
-		return fmt.Errorf("configmanager post error: received status code %d", resp.StatusCode)
-	}
-	return nil
-}
-
 func guessHostname() string {
 	// try to get hostname from local loopback
 	hostname := ""
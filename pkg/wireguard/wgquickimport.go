@@ -0,0 +1,472 @@
+package wireguard
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"os/user"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/in4it/go-devops-platform/storage"
+	"github.com/in4it/go-devops-platform/users"
+)
+
+// wgQuickConfig is a parsed wg-quick config file
+type wgQuickConfig struct {
+	Interface wgQuickSection
+	Peers     []wgQuickSection
+}
+
+type wgQuickSection struct {
+	Comment string // first comment above or within the section
+	values  map[string][]string
+}
+
+// get returns the first value of a key. Keys are case insensitive.
+func (s wgQuickSection) get(key string) string {
+	values := s.values[strings.ToLower(key)]
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// list returns the comma separated values of a key. A key can also be repeated (e.g. multiple Address lines).
+func (s wgQuickSection) list(key string) []string {
+	list := []string{}
+	for _, value := range s.values[strings.ToLower(key)] {
+		for _, item := range strings.Split(value, ",") {
+			if strings.TrimSpace(item) != "" {
+				list = append(list, strings.TrimSpace(item))
+			}
+		}
+	}
+	return list
+}
+
+// parseWGQuickConfig parses a wg-quick config. A comment directly above a section header, or within the section,
+// is the comment of the section. Comments like "# Name = alice" are reduced to the value.
+func parseWGQuickConfig(config string) (wgQuickConfig, error) {
+	var (
+		parsed          wgQuickConfig
+		current         *wgQuickSection
+		pendingComment  string
+		interfaceParsed bool
+	)
+	for lineNumber, line := range strings.Split(config, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "#") {
+			if comment := parseWGQuickComment(line); comment != "" && pendingComment == "" {
+				pendingComment = comment
+			}
+			continue
+		}
+		if i := strings.Index(line, "#"); i != -1 { // wg-quick strips everything after a #
+			line = strings.TrimSpace(line[:i])
+		}
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section := wgQuickSection{Comment: pendingComment, values: make(map[string][]string)}
+			pendingComment = ""
+			switch strings.ToLower(strings.TrimSpace(line[1 : len(line)-1])) {
+			case "interface":
+				if interfaceParsed {
+					return parsed, fmt.Errorf("line %d: duplicate [Interface] section", lineNumber+1)
+				}
+				interfaceParsed = true
+				parsed.Interface = section
+				current = &parsed.Interface
+			case "peer":
+				parsed.Peers = append(parsed.Peers, section)
+				current = &parsed.Peers[len(parsed.Peers)-1]
+			default:
+				return parsed, fmt.Errorf("line %d: unknown section %s", lineNumber+1, line)
+			}
+			continue
+		}
+		if current == nil {
+			return parsed, fmt.Errorf("line %d: key outside of a section", lineNumber+1)
+		}
+		key, value, found := strings.Cut(line, "=")
+		if !found {
+			return parsed, fmt.Errorf("line %d: expected key = value", lineNumber+1)
+		}
+		if current.Comment == "" {
+			current.Comment = pendingComment
+		}
+		pendingComment = ""
+		key = strings.ToLower(strings.TrimSpace(key))
+		current.values[key] = append(current.values[key], strings.TrimSpace(value))
+	}
+	if current != nil && current.Comment == "" {
+		current.Comment = pendingComment
+	}
+	if !interfaceParsed {
+		return parsed, fmt.Errorf("no [Interface] section found")
+	}
+	return parsed, nil
+}
+
+func parseWGQuickComment(line string) string {
+	comment := strings.TrimSpace(strings.TrimLeft(line, "#"))
+	if key, value, found := strings.Cut(comment, "="); found {
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "name", "friendly_name":
+			return strings.TrimSpace(value)
+		}
+	}
+	return comment
+}
+
+// ImportWGQuickConfig imports the peers of a wg-quick server config as connections. Peers are mapped to a user through the
+// user mapping or their comment, and keep their address, public key and preshared key. Peers whose address is outside of
+// the address range or already in use are skipped. Nothing is changed in a dry run.
+func ImportWGQuickConfig(storage storage.Iface, request ImportRequest, userStore *users.UserStore, actor string) (ImportReport, error) {
+	report := ImportReport{DryRun: request.DryRun, Peers: []ImportedPeer{}}
+
+	serverConfig, err := parseWGQuickConfig(request.ServerConfig)
+	if err != nil {
+		return report, fmt.Errorf("could not parse server config: %s", err)
+	}
+	clientConfigs := make(map[string]wgQuickConfig) // public key => client config
+	for k, config := range request.ClientConfigs {
+		clientConfig, err := parseWGQuickConfig(config)
+		if err != nil {
+			return report, fmt.Errorf("could not parse client config %d: %s", k+1, err)
+		}
+		publicKey, err := getPublicKey(clientConfig.Interface.get("PrivateKey"))
+		if err != nil {
+			return report, fmt.Errorf("client config %d: %s", k+1, err)
+		}
+		clientConfigs[publicKey] = clientConfig
+	}
+
+	restartVPN := false
+	if request.ImportServer {
+		report.Server, err = importWGQuickServer(storage, serverConfig.Interface, request.DryRun)
+		if err != nil {
+			return report, err
+		}
+		restartVPN = report.Server.Status == IMPORT_STATUS_IMPORTED && !request.DryRun
+	}
+
+	vpnConfig, err := GetVPNConfig(storage)
+	if err != nil {
+		return report, fmt.Errorf("failed to get vpn config: %s", err)
+	}
+	for _, address := range serverConfig.Interface.list("Address") {
+		if prefix, err := netip.ParsePrefix(address); err == nil && prefix.Addr().Is4() && prefix.Masked() != vpnConfig.AddressRange.Masked() {
+			report.Warnings = append(report.Warnings, fmt.Sprintf("the server address %s differs from the vpn address range %s", prefix, vpnConfig.AddressRange))
+		}
+	}
+
+	peerConfigs, filenames, err := importWGQuickPeers(storage, vpnConfig, serverConfig.Peers, clientConfigs, request, userStore, &report)
+	if err != nil {
+		return report, err
+	}
+	for publicKey := range clientConfigs {
+		if !slices.ContainsFunc(serverConfig.Peers, func(peer wgQuickSection) bool { return peer.get("PublicKey") == publicKey }) {
+			report.Warnings = append(report.Warnings, fmt.Sprintf("client config with public key %s has no peer in the server config", publicKey))
+		}
+	}
+	if request.DryRun {
+		return report, nil
+	}
+
+	for _, peerConfig := range peerConfigs {
+		err = writeConnectionAuditEntry(storage, peerConfig.ID, AUDIT_ACTION_IMPORT, actor)
+		if err != nil {
+			return report, fmt.Errorf("could not write audit entry: %s", err)
+		}
+	}
+	// the configmanager also picks up the new peers on the next reconcile, so the import doesn't fail when it can't be reached
+	if restartVPN {
+		_, err = RestartVPN(context.Background(), storage)
+		if err != nil {
+			report.Warnings = append(report.Warnings, fmt.Sprintf("could not restart the vpn to apply the server key: %s", err))
+		}
+	} else if len(filenames) > 0 {
+		err = refreshClients(storage, ACTION_ADD, filenames)
+		if err != nil {
+			report.Warnings = append(report.Warnings, fmt.Sprintf("could not notify the configmanager: %s", err))
+		}
+	}
+	return report, nil
+}
+
+// importWGQuickServer writes the private key of the wg-quick server to secrets/, and takes over its listen port
+func importWGQuickServer(storage storage.Iface, serverInterface wgQuickSection, dryRun bool) (*ImportedServer, error) {
+	serverKeyMutex.Lock()
+	defer serverKeyMutex.Unlock()
+
+	vpnConfig, err := GetVPNConfig(storage)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get vpn config: %s", err)
+	}
+	if vpnConfig.PendingPublicKey != "" {
+		return nil, fmt.Errorf("a server key rotation is pending")
+	}
+	privateKey := serverInterface.get("PrivateKey")
+	publicKey, err := getPublicKey(privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("server config: %s", err)
+	}
+	server := &ImportedServer{PublicKey: publicKey, Port: vpnConfig.Port, Status: IMPORT_STATUS_UNCHANGED}
+	if listenPort := serverInterface.get("ListenPort"); listenPort != "" {
+		server.Port, err = strconv.Atoi(listenPort)
+		if err != nil || server.Port < 1 || server.Port > 65535 {
+			return nil, fmt.Errorf("server config: invalid listen port: %s", listenPort)
+		}
+	}
+	if publicKey == vpnConfig.PublicKey && server.Port == vpnConfig.Port {
+		return server, nil
+	}
+	server.Status = IMPORT_STATUS_IMPORTED
+	if dryRun {
+		return server, nil
+	}
+
+	err = storage.EnsurePath(VPN_SERVER_SECRETS_PATH)
+	if err != nil {
+		return nil, fmt.Errorf("could not ensure path exists %s: %s", VPN_SERVER_SECRETS_PATH, err)
+	}
+	filename := path.Join(VPN_SERVER_SECRETS_PATH, VPN_PRIVATE_KEY_FILENAME)
+	err = storage.WriteFile(filename, []byte(privateKey))
+	if err != nil {
+		return nil, fmt.Errorf("could not write private key to %s: %s", filename, err)
+	}
+	currentUser, err := user.Current()
+	if err != nil {
+		return nil, fmt.Errorf("could not get current user: %s", err)
+	}
+	if currentUser.Username != VPN_USER {
+		err = storage.EnsureOwnership(filename, VPN_USER)
+		if err != nil {
+			return nil, fmt.Errorf("could not ensure ownership of %s: %s", filename, err)
+		}
+	}
+	keyChanged := publicKey != vpnConfig.PublicKey
+	vpnConfig.PublicKey = publicKey
+	vpnConfig.Port = server.Port
+	err = WriteVPNConfig(storage, vpnConfig)
+	if err != nil {
+		return nil, fmt.Errorf("WriteVPNConfig error: %s", err)
+	}
+	if keyChanged { // existing connections still have the former server key
+		err = markPeerConfigsForRefresh(storage, false)
+		if err != nil {
+			return nil, fmt.Errorf("could not mark peer configs for refresh: %s", err)
+		}
+	}
+	return server, nil
+}
+
+// importWGQuickPeers claims the addresses of the peers and writes their peer configs. The peer configs are only
+// written when it's not a dry run. Returns the peer configs and their filenames.
+func importWGQuickPeers(storage storage.Iface, vpnConfig VPNConfig, peers []wgQuickSection, clientConfigs map[string]wgQuickConfig, request ImportRequest, userStore *users.UserStore, report *ImportReport) ([]PeerConfig, []string, error) {
+	clientConfigMutex.Lock()
+	defer clientConfigMutex.Unlock()
+	ipamMutex.Lock()
+	defer ipamMutex.Unlock()
+
+	ipam, err := loadIPAM(storage, vpnConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+	existingPeerConfigs, err := GetAllPeerConfigs(storage)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not get peer configs: %s", err)
+	}
+	profilesConfig, err := GetProfiles(storage)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not get profiles: %s", err)
+	}
+
+	peerConfigs := []PeerConfig{}
+	nextConfigNumbers := make(map[string]int) // user id => next config number
+	for _, peer := range peers {
+		importedPeer := ImportedPeer{PublicKey: peer.get("PublicKey"), Comment: peer.Comment, Status: IMPORT_STATUS_SKIPPED}
+		peerConfig, err := newImportedPeerConfig(storage, vpnConfig, ipam, profilesConfig, existingPeerConfigs, peerConfigs, peer, clientConfigs, request, userStore, nextConfigNumbers, &importedPeer)
+		if err != nil {
+			importedPeer.Reason = err.Error()
+			report.Skipped++
+		} else {
+			importedPeer.Status = IMPORT_STATUS_IMPORTED
+			peerConfigs = append(peerConfigs, peerConfig)
+			report.Imported++
+		}
+		report.Peers = append(report.Peers, importedPeer)
+	}
+	if request.DryRun || len(peerConfigs) == 0 {
+		return peerConfigs, nil, nil
+	}
+
+	err = saveIPAM(storage, ipam)
+	if err != nil {
+		return nil, nil, err
+	}
+	filenames := make([]string, len(peerConfigs))
+	for k, peerConfig := range peerConfigs {
+		err = writePeerConfig(storage, peerConfig)
+		if err != nil {
+			return nil, nil, err
+		}
+		filenames[k] = fmt.Sprintf("%s.json", peerConfig.ID)
+	}
+	return peerConfigs, filenames, nil
+}
+
+// newImportedPeerConfig returns the peer config of a wg-quick peer, and claims its addresses in the index.
+// The returned error is the reason the peer is skipped.
+func newImportedPeerConfig(storage storage.Iface, vpnConfig VPNConfig, ipam *IPAM, profilesConfig ProfilesConfig, existingPeerConfigs, importedPeerConfigs []PeerConfig, peer wgQuickSection, clientConfigs map[string]wgQuickConfig, request ImportRequest, userStore *users.UserStore, nextConfigNumbers map[string]int, importedPeer *ImportedPeer) (PeerConfig, error) {
+	err := ValidatePublicKey(importedPeer.PublicKey)
+	if err != nil {
+		return PeerConfig{}, fmt.Errorf("invalid public key: %s", err)
+	}
+	err = checkPublicKeyInUse(vpnConfig, slices.Concat(existingPeerConfigs, importedPeerConfigs), importedPeer.PublicKey, "")
+	if err != nil {
+		return PeerConfig{}, err
+	}
+	presharedKey := peer.get("PresharedKey")
+	if presharedKey == "" {
+		presharedKey = IMPORT_EMPTY_PRESHARED_KEY
+	} else if err := ValidatePublicKey(presharedKey); err != nil {
+		return PeerConfig{}, fmt.Errorf("invalid preshared key")
+	}
+
+	// user
+	importedPeer.Login = request.UserMapping[importedPeer.PublicKey]
+	if importedPeer.Login == "" && importedPeer.Comment != "" {
+		importedPeer.Login = request.UserMapping[importedPeer.Comment]
+		if importedPeer.Login == "" {
+			importedPeer.Login = importedPeer.Comment
+		}
+	}
+	if importedPeer.Login == "" {
+		return PeerConfig{}, fmt.Errorf("no user mapping or comment found")
+	}
+	user, err := userStore.GetUserByLogin(importedPeer.Login)
+	if err != nil {
+		return PeerConfig{}, fmt.Errorf("user %s not found", importedPeer.Login)
+	}
+	if user.Suspended {
+		return PeerConfig{}, fmt.Errorf("user %s is suspended", importedPeer.Login)
+	}
+	userID := user.ID
+	if _, ok := nextConfigNumbers[userID]; !ok {
+		configNumbers, err := GetConfigNumbers(storage, userID)
+		if err != nil {
+			return PeerConfig{}, fmt.Errorf("GetConfigNumbers error: %s", err)
+		}
+		nextConfigNumbers[userID] = 1
+		if len(configNumbers) > 0 {
+			nextConfigNumbers[userID] = slices.Max(configNumbers) + 1
+		}
+	}
+	configNumber := nextConfigNumbers[userID]
+	connectionID := fmt.Sprintf("%s-%d", userID, configNumber)
+
+	// addresses: the first host address of every address family. Other allowed ips are not imported.
+	var address, addressIPv6 netip.Prefix
+	for _, allowedIP := range peer.list("AllowedIPs") {
+		prefix, err := netip.ParsePrefix(allowedIP)
+		switch {
+		case err != nil:
+			return PeerConfig{}, fmt.Errorf("invalid allowed ip %s", allowedIP)
+		case prefix.Addr().Is4() && prefix.IsSingleIP() && !address.IsValid():
+			address = prefix
+		case prefix.Addr().Is6() && prefix.IsSingleIP() && !addressIPv6.IsValid() && ipam.IPv6 != nil:
+			addressIPv6 = prefix
+		default:
+			importedPeer.Warnings = append(importedPeer.Warnings, fmt.Sprintf("allowed ip %s is not imported", allowedIP))
+		}
+	}
+	if !address.IsValid() {
+		return PeerConfig{}, fmt.Errorf("no ipv4 address in the allowed ips")
+	}
+	address = netip.PrefixFrom(address.Addr(), ipam.IPv4.prefixLen)
+	if reason := ipam.IPv4.checkAddress(address, connectionID); reason != "" {
+		return PeerConfig{}, fmt.Errorf("address %s %s", address, reason)
+	}
+	if addressIPv6.IsValid() {
+		addressIPv6 = netip.PrefixFrom(addressIPv6.Addr(), ipam.IPv6.prefixLen)
+		if reason := ipam.IPv6.checkAddress(addressIPv6, connectionID); reason != "" {
+			return PeerConfig{}, fmt.Errorf("address %s %s", addressIPv6, reason)
+		}
+	}
+
+	profile := profilesConfig.getUserProfile(userID)
+	clientAllowedIPs, dns, persistentKeepalive, err := getProfileClientSettings(vpnConfig, profile, getRoutedNetworks(existingPeerConfigs, connectionID))
+	if err != nil {
+		return PeerConfig{}, err
+	}
+	peerConfig := PeerConfig{
+		ID:                  connectionID,
+		DNS:                 dns,
+		Name:                fmt.Sprintf("connection-%d", configNumber),
+		Address:             address.String(),
+		ServerAllowedIPs:    []string{address.String()},
+		ClientAllowedIPs:    clientAllowedIPs,
+		PublicKey:           importedPeer.PublicKey,
+		PresharedKey:        presharedKey,
+		ClientGeneratedKey:  true, // the private key stays on the device, unless the client config is imported
+		Profile:             getProfileName(profile),
+		PersistentKeepalive: persistentKeepalive,
+	}
+	if importedPeer.Comment != "" {
+		peerConfig.Name = importedPeer.Comment
+	}
+	if addressIPv6.IsValid() {
+		peerConfig.AddressIPv6 = addressIPv6.String()
+		peerConfig.ServerAllowedIPs = append(peerConfig.ServerAllowedIPs, netip.PrefixFrom(addressIPv6.Addr(), 128).String())
+	}
+
+	// the client config keeps the settings the device already has
+	if clientConfig, ok := clientConfigs[importedPeer.PublicKey]; ok {
+		if !slices.Contains(clientConfig.Interface.list("Address"), address.String()) {
+			importedPeer.Warnings = append(importedPeer.Warnings, fmt.Sprintf("client config addresses %s don't match the server config", strings.Join(clientConfig.Interface.list("Address"), ", ")))
+		}
+		if len(clientConfig.Interface.list("DNS")) > 0 {
+			peerConfig.DNS = strings.Join(clientConfig.Interface.list("DNS"), ", ")
+		}
+		if len(clientConfig.Peers) > 0 {
+			if clientAllowedIPs := clientConfig.Peers[0].list("AllowedIPs"); len(clientAllowedIPs) > 0 {
+				peerConfig.ClientAllowedIPs = clientAllowedIPs
+			}
+			if keepalive, err := strconv.Atoi(clientConfig.Peers[0].get("PersistentKeepalive")); err == nil {
+				peerConfig.PersistentKeepalive = keepalive
+			}
+		}
+		peerConfig.ClientGeneratedKey = false
+		importedPeer.PrivateKeyImported = true
+		if !request.DryRun {
+			peerConfig.PrivateKeyEncrypted, err = encryptClientPrivateKey(storage, clientConfig.Interface.get("PrivateKey"))
+			if err != nil {
+				return PeerConfig{}, fmt.Errorf("could not encrypt private key: %s", err)
+			}
+		}
+	}
+
+	// claim the addresses, so the next peers of the import can't use them
+	err = ipam.IPv4.claim(connectionID, address)
+	if err != nil {
+		return PeerConfig{}, err
+	}
+	if addressIPv6.IsValid() {
+		err = ipam.IPv6.claim(connectionID, addressIPv6)
+		if err != nil {
+			ipam.IPv4.release(connectionID)
+			return PeerConfig{}, err
+		}
+	}
+	nextConfigNumbers[userID]++
+	importedPeer.ConnectionID = connectionID
+	importedPeer.Address = peerConfig.Address
+	importedPeer.AddressIPv6 = peerConfig.AddressIPv6
+	return peerConfig, nil
+}
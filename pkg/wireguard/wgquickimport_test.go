@@ -0,0 +1,230 @@
+package wireguard
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+	"testing"
+
+	memorystorage "github.com/in4it/go-devops-platform/storage/memory"
+	"github.com/in4it/go-devops-platform/users"
+	configmanagerclient "github.com/in4it/wireguard-server/pkg/configmanager/client"
+)
+
+func TestParseWGQuickConfig(t *testing.T) {
+	config, err := parseWGQuickConfig(`[Interface]
+Address = 10.0.0.1/24, fd00::1/64
+Address = 10.0.1.1/24
+ListenPort = 51820 # inline comment
+
+# alice
+[Peer]
+PublicKey = a=
+AllowedIPs = 10.0.0.2/32
+
+[Peer]
+# Name = bob
+publickey = b=
+`)
+	if err != nil {
+		t.Fatalf("parseWGQuickConfig error: %s", err)
+	}
+	if !slices.Equal(config.Interface.list("Address"), []string{"10.0.0.1/24", "fd00::1/64", "10.0.1.1/24"}) || config.Interface.get("ListenPort") != "51820" {
+		t.Fatalf("unexpected interface: %+v", config.Interface)
+	}
+	if len(config.Peers) != 2 || config.Peers[0].Comment != "alice" || config.Peers[1].Comment != "bob" || config.Peers[1].get("PublicKey") != "b=" {
+		t.Fatalf("unexpected peers: %+v", config.Peers)
+	}
+
+	for _, invalidConfig := range []string{
+		"PrivateKey = a=\n[Interface]",
+		"[Interface]\n[Interface]",
+		"[Interface]\n[Peers]",
+		"[Interface]\nPrivateKey",
+		"[Peer]\nPublicKey = a=",
+	} {
+		_, err = parseWGQuickConfig(invalidConfig)
+		if err == nil {
+			t.Fatalf("expected error for config: %s", invalidConfig)
+		}
+	}
+}
+
+func TestImportWGQuickConfig(t *testing.T) {
+	storage := &memorystorage.MockMemoryStorage{}
+	fake := configmanagerclient.NewFake()
+	UseConfigManagerClient(fake)
+	defer UseConfigManagerClient(nil)
+
+	_, err := CreateNewVPNConfig(storage)
+	if err != nil {
+		t.Fatalf("CreateNewVPNConfig error: %s", err)
+	}
+	serverPrivateKey, serverPublicKey, _ := GenerateKeys()
+	alicePrivateKey, alicePublicKey, _ := GenerateKeys()
+	_, bobPublicKey, _ := GenerateKeys()
+	_, otherPublicKey, _ := GenerateKeys()
+	_, carolPublicKey, _ := GenerateKeys()
+	_, outsidePublicKey, _ := GenerateKeys()
+	_, davePublicKey, _ := GenerateKeys()
+	presharedKey, _ := GeneratePresharedKey()
+
+	serverConfig := fmt.Sprintf(`[Interface]
+PrivateKey = %s
+Address = 10.189.184.1/21
+ListenPort = 51821
+
+# alice
+[Peer]
+PublicKey = %s
+PresharedKey = %s
+AllowedIPs = 10.189.184.2/32, 192.168.50.0/24
+
+[Peer]
+# Name = bob
+PublicKey = %s
+AllowedIPs = 10.189.184.3/32
+
+[Peer]
+PublicKey = %s
+AllowedIPs = 10.189.184.2/32
+
+# carol
+[Peer]
+PublicKey = %s
+AllowedIPs = 10.189.184.4/32
+
+# bob
+[Peer]
+PublicKey = %s
+AllowedIPs = 10.0.0.5/32
+
+# dave
+[Peer]
+PublicKey = %s
+AllowedIPs = 10.189.184.5/32
+`, serverPrivateKey, alicePublicKey, presharedKey, bobPublicKey, otherPublicKey, carolPublicKey, outsidePublicKey, davePublicKey)
+	clientConfig := fmt.Sprintf(`[Interface]
+PrivateKey = %s
+Address = 10.189.184.2/32
+DNS = 1.1.1.1
+
+[Peer]
+PublicKey = %s
+Endpoint = vpn.example.com:51821
+AllowedIPs = 10.0.0.0/8
+PersistentKeepalive = 25
+`, alicePrivateKey, serverPublicKey)
+
+	userStore, err := users.NewUserStore(storage, -1)
+	if err != nil {
+		t.Fatalf("NewUserStore error: %s", err)
+	}
+	for _, user := range []users.User{{ID: "1-1-1-1", Login: "alice"}, {ID: "2-2-2-2", Login: "bob"}, {ID: "3-3-3-3", Login: "dave", Suspended: true}} {
+		_, err = userStore.AddUser(user)
+		if err != nil {
+			t.Fatalf("AddUser error: %s", err)
+		}
+	}
+	request := ImportRequest{
+		ServerConfig:  serverConfig,
+		ClientConfigs: []string{clientConfig},
+		UserMapping:   map[string]string{otherPublicKey: "alice"},
+		ImportServer:  true,
+		DryRun:        true,
+	}
+
+	report, err := ImportWGQuickConfig(storage, request, userStore, "admin")
+	if err != nil {
+		t.Fatalf("ImportWGQuickConfig error: %s", err)
+	}
+	if report.Imported != 2 || report.Skipped != 4 || report.Server == nil || report.Server.Status != IMPORT_STATUS_IMPORTED || report.Server.Port != 51821 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	reasons := []string{"", "", "already in use by 1-1-1-1-1", "user carol not found", "outside of address range", "user dave is suspended"}
+	for k, reason := range reasons {
+		if !strings.Contains(report.Peers[k].Reason, reason) || (reason == "") != (report.Peers[k].Status == IMPORT_STATUS_IMPORTED) {
+			t.Fatalf("unexpected peer %d: %+v", k, report.Peers[k])
+		}
+	}
+	if !report.Peers[0].PrivateKeyImported || len(report.Peers[0].Warnings) != 1 || report.Peers[1].ConnectionID != "2-2-2-2-1" {
+		t.Fatalf("unexpected peers: %+v", report.Peers)
+	}
+	peerConfigs, err := GetAllPeerConfigs(storage)
+	if err != nil {
+		t.Fatalf("GetAllPeerConfigs error: %s", err)
+	}
+	vpnConfig, err := GetVPNConfig(storage)
+	if err != nil {
+		t.Fatalf("GetVPNConfig error: %s", err)
+	}
+	if len(peerConfigs) != 0 || vpnConfig.PublicKey == serverPublicKey {
+		t.Fatalf("dry run made changes")
+	}
+
+	request.DryRun = false
+	_, err = ImportWGQuickConfig(storage, request, userStore, "admin")
+	if err != nil {
+		t.Fatalf("ImportWGQuickConfig error: %s", err)
+	}
+	vpnConfig, err = GetVPNConfig(storage)
+	if err != nil {
+		t.Fatalf("GetVPNConfig error: %s", err)
+	}
+	privateKey, err := storage.ReadFile(path.Join(VPN_SERVER_SECRETS_PATH, VPN_PRIVATE_KEY_FILENAME))
+	if err != nil {
+		t.Fatalf("could not read private key: %s", err)
+	}
+	if vpnConfig.PublicKey != serverPublicKey || vpnConfig.Port != 51821 || string(privateKey) != serverPrivateKey {
+		t.Fatalf("server key not imported: %+v", vpnConfig)
+	}
+	if fake.CallCount("POST /restart-vpn") != 1 {
+		t.Fatalf("expected vpn restart")
+	}
+
+	alice, err := getPeerConfig(storage, "1-1-1-1-1")
+	if err != nil {
+		t.Fatalf("getPeerConfig error: %s", err)
+	}
+	if alice.PublicKey != alicePublicKey || alice.PresharedKey != presharedKey || alice.Address != "10.189.184.2/32" || alice.DNS != "1.1.1.1" || alice.PersistentKeepalive != 25 || alice.ClientGeneratedKey {
+		t.Fatalf("unexpected peer config: %+v", alice)
+	}
+	if !slices.Equal(alice.ServerAllowedIPs, []string{"10.189.184.2/32"}) || !slices.Equal(alice.ClientAllowedIPs, []string{"10.0.0.0/8"}) {
+		t.Fatalf("unexpected allowed ips: %+v", alice)
+	}
+	decryptedKey, err := decryptClientPrivateKey(storage, alice.PrivateKeyEncrypted)
+	if err != nil || decryptedKey != alicePrivateKey {
+		t.Fatalf("private key not imported (error: %v)", err)
+	}
+	bob, err := getPeerConfig(storage, "2-2-2-2-1")
+	if err != nil {
+		t.Fatalf("getPeerConfig error: %s", err)
+	}
+	if bob.Name != "bob" || bob.PresharedKey != IMPORT_EMPTY_PRESHARED_KEY || !bob.ClientGeneratedKey || bob.PrivateKeyEncrypted != "" {
+		t.Fatalf("unexpected peer config: %+v", bob)
+	}
+	ipam, err := GetIPAM(storage)
+	if err != nil {
+		t.Fatalf("GetIPAM error: %s", err)
+	}
+	if ipam.IPv4.Allocations["1-1-1-1-1"] != "10.189.184.2/32" || ipam.IPv4.Allocations["2-2-2-2-1"] != "10.189.184.3/32" {
+		t.Fatalf("unexpected allocations: %+v", ipam.IPv4.Allocations)
+	}
+	auditLog, err := GetConnectionAuditLog(storage, "")
+	if err != nil {
+		t.Fatalf("GetConnectionAuditLog error: %s", err)
+	}
+	if len(auditLog) != 2 || auditLog[0].Action != AUDIT_ACTION_IMPORT || auditLog[0].Actor != "admin" {
+		t.Fatalf("unexpected audit log: %+v", auditLog)
+	}
+
+	// importing again doesn't create duplicate connections
+	report, err = ImportWGQuickConfig(storage, request, userStore, "admin")
+	if err != nil {
+		t.Fatalf("ImportWGQuickConfig error: %s", err)
+	}
+	if report.Imported != 0 || report.Server.Status != IMPORT_STATUS_UNCHANGED || !strings.Contains(report.Peers[0].Reason, "already used by connection 1-1-1-1-1") {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+}
@@ -1,18 +1,16 @@
 package wireguard
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
 	"net"
-	"net/http"
 	"net/netip"
+	"os/user"
 	"path"
 	"slices"
 	"strconv"
 	"strings"
 	"sync"
-	"text/template"
 	"time"
 
 	"github.com/in4it/go-devops-platform/storage"
@@ -42,6 +40,34 @@ func GetConfigNumbers(storage storage.Iface, userID string) ([]int, error) {
 }
 
 func NewEmptyClientConfig(storage storage.Iface, userID string) (PeerConfig, error) {
+	return NewClientConfig(storage, userID, NewClientConfigOptions{})
+}
+
+// NewClientConfigWithPublicKey creates a new connection for a public key that was generated on the client device.
+// The private key never leaves the device, so the configmanager can add the peer straight away.
+func NewClientConfigWithPublicKey(storage storage.Iface, userID, publicKey string) (PeerConfig, error) {
+	return NewClientConfig(storage, userID, NewClientConfigOptions{PublicKey: publicKey})
+}
+
+// NewClientConfig creates a new connection. The public key and validity window are optional.
+func NewClientConfig(storage storage.Iface, userID string, options NewClientConfigOptions) (PeerConfig, error) {
+	if options.PublicKey != "" {
+		err := ValidatePublicKey(options.PublicKey)
+		if err != nil {
+			return PeerConfig{}, fmt.Errorf("invalid public key: %s", err)
+		}
+	}
+	err := validateClientValidity(options.NotBefore, options.ExpiresAt)
+	if err != nil {
+		return PeerConfig{}, err
+	}
+	if !options.ExpiresAt.IsZero() && !options.ExpiresAt.After(time.Now()) {
+		return PeerConfig{}, fmt.Errorf("expiry needs to be in the future")
+	}
+	return newClientConfig(storage, userID, options)
+}
+
+func newClientConfig(storage storage.Iface, userID string, options NewClientConfigOptions) (PeerConfig, error) {
 	clientConfigMutex.Lock()
 	defer clientConfigMutex.Unlock()
 
@@ -50,58 +76,125 @@ func NewEmptyClientConfig(storage storage.Iface, userID string) (PeerConfig, err
 		return PeerConfig{}, fmt.Errorf("failed to get vpn config: %s", err)
 	}
 
-	// get next IP address, write in client file
-	nextFreeIP, err := getNextFreeIP(storage, vpnConfig.AddressRange, vpnConfig.ClientAddressPrefix)
-	if err != nil {
-		return PeerConfig{}, fmt.Errorf("getNextFreeIP error: %s", err)
-	}
-
 	// determine config number
 	configNumbers, err := GetConfigNumbers(storage, userID)
 	if err != nil {
 		return PeerConfig{}, fmt.Errorf("GetConfigNumbers error: %s", err)
 	}
+	if options.Type != PEER_TYPE_NETWORK {
+		err = checkConnectionPolicy(storage, userID, len(configNumbers), options.CreatedByAdmin)
+		if err != nil {
+			return PeerConfig{}, err
+		}
+	}
 	newConfigNumber := 1
 	if len(configNumbers) > 0 {
 		newConfigNumber = slices.Max(configNumbers) + 1
 	}
+	connectionID := fmt.Sprintf("%s-%d", userID, newConfigNumber)
 
-	clientAllowedIPs, err := getClientAllowedIPs(vpnConfig.AddressRange.Addr().String()+vpnConfig.ClientAddressPrefix, vpnConfig.ClientRoutes, vpnConfig.Nameservers)
+	profilesConfig, err := GetProfiles(storage)
 	if err != nil {
-		return PeerConfig{}, fmt.Errorf("getClientAllowedIPs error: %s", err)
+		return PeerConfig{}, fmt.Errorf("could not get profiles: %s", err)
+	}
+	peerConfigs, err := GetAllPeerConfigs(storage)
+	if err != nil {
+		return PeerConfig{}, fmt.Errorf("could not get peer configs: %s", err)
+	}
+	if options.PublicKey != "" {
+		err = checkPublicKeyInUse(vpnConfig, peerConfigs, options.PublicKey, "")
+		if err != nil {
+			return PeerConfig{}, err
+		}
+	}
+	var (
+		profile             *Profile
+		clientAllowedIPs    []string
+		dns                 string
+		persistentKeepalive int
+		networks            []string
+	)
+	if options.Type == PEER_TYPE_NETWORK {
+		networks, err = validateNetworks(vpnConfig, peerConfigs, connectionID, options.Networks)
+		if err != nil {
+			return PeerConfig{}, err
+		}
+		clientAllowedIPs = getNetworkPeerClientAllowedIPs(vpnConfig, getRoutedNetworks(peerConfigs, connectionID))
+	} else {
+		profile = profilesConfig.getUserProfile(userID)
+		clientAllowedIPs, dns, persistentKeepalive, err = getProfileClientSettings(vpnConfig, profile, getRoutedNetworks(peerConfigs, connectionID))
+		if err != nil {
+			return PeerConfig{}, err
+		}
 	}
 
-	// validate address
-	address := nextFreeIP.String() + vpnConfig.ClientAddressPrefix
-	_, _, err = net.ParseCIDR(address)
+	// get next IP address, write in client file
+	address, addressIPv6, err := allocateIPAddress(storage, vpnConfig, connectionID, profilesConfig.getAddressScope(profile))
 	if err != nil {
-		return PeerConfig{}, fmt.Errorf("cannot parse client address: %s", err)
+		return PeerConfig{}, fmt.Errorf("could not allocate ip address: %s", err)
 	}
 
 	peerConfig := PeerConfig{
-		ID:               fmt.Sprintf("%s-%d", userID, newConfigNumber),
-		DNS:              strings.Join(vpnConfig.Nameservers, ", "),
-		Name:             fmt.Sprintf("connection-%d", newConfigNumber),
-		Address:          address,
-		ServerAllowedIPs: []string{address},
-		ClientAllowedIPs: clientAllowedIPs,
+		ID:                  connectionID,
+		DNS:                 dns,
+		Name:                fmt.Sprintf("connection-%d", newConfigNumber),
+		Address:             address.String(),
+		ServerAllowedIPs:    []string{address.String()},
+		ClientAllowedIPs:    clientAllowedIPs,
+		NotBefore:           options.NotBefore,
+		ExpiresAt:           options.ExpiresAt,
+		Profile:             getProfileName(profile),
+		PersistentKeepalive: persistentKeepalive,
+		Type:                options.Type,
+		Networks:            networks,
+		RouteToClients:      options.RouteToClients,
+	}
+	if options.Name != "" {
+		peerConfig.Name = options.Name
+	}
+	if addressIPv6.IsValid() {
+		peerConfig.AddressIPv6 = addressIPv6.String()
+		peerConfig.ServerAllowedIPs = append(peerConfig.ServerAllowedIPs, netip.PrefixFrom(addressIPv6.Addr(), 128).String())
+	}
+	peerConfig.ServerAllowedIPs = append(peerConfig.ServerAllowedIPs, networks...) // the vpn server routes the networks to the network peer
+	updateClientValidity(&peerConfig, time.Now())                                  // the reaper enables the peer once it becomes valid
+	// created during the grace period of a key rotation: the config keeps the current key until the switch
+	if vpnConfig.PendingPublicKey != "" {
+		peerConfig.ServerPublicKey = vpnConfig.PublicKey
+	}
+	peerConfig.PresharedKey, err = GeneratePresharedKey()
+	if err != nil {
+		return peerConfig, fmt.Errorf("GeneratePresharedKey error: %s", err)
+	}
+	if options.PublicKey != "" {
+		peerConfig.PublicKey = options.PublicKey
+		peerConfig.ClientGeneratedKey = true
+	} else if !vpnConfig.RequireClientPublicKey { // the key is generated once, when the connection is created
+		err = setNewClientKey(storage, &peerConfig)
+		if err != nil {
+			return peerConfig, fmt.Errorf("could not set new client key: %s", err)
+		}
 	}
 
 	// write peerconfig
-
-	peerConfigOut, err := json.Marshal(peerConfig)
+	err = writePeerConfig(storage, peerConfig)
 	if err != nil {
-		return peerConfig, fmt.Errorf("peerConfig marshal error: %s", err)
+		return peerConfig, err
 	}
-	userConfigFilename := storage.ConfigPath(path.Join(VPN_CLIENTS_DIR, fmt.Sprintf("%s.json", peerConfig.ID)))
-	err = storage.WriteFile(userConfigFilename, peerConfigOut)
-	if err != nil {
-		return peerConfig, fmt.Errorf("could not save vpn client info to file: %s", err)
+
+	// notify configmanager
+	if peerConfig.PublicKey != "" && !peerConfig.Disabled {
+		err = refreshClients(storage, ACTION_ADD, []string{fmt.Sprintf("%s.json", peerConfig.ID)})
+		if err != nil {
+			return peerConfig, err
+		}
 	}
 
 	return peerConfig, nil
 }
 
+// UpdateClientsConfig updates the connections after a change of the vpn config or the profiles. The address index is rebuilt:
+// connections with an address outside of the address range (of their profile), or a conflicting address, get a new address.
 func UpdateClientsConfig(storage storage.Iface) error {
 	clientConfigMutex.Lock()
 	defer clientConfigMutex.Unlock()
@@ -109,19 +202,55 @@ func UpdateClientsConfig(storage storage.Iface) error {
 	if err != nil {
 		return fmt.Errorf("failed to get vpn config: %s", err)
 	}
+	profilesConfig, err := GetProfiles(storage)
+	if err != nil {
+		return fmt.Errorf("could not get profiles: %s", err)
+	}
+	peerConfigs, err := GetAllPeerConfigs(storage)
+	if err != nil {
+		return fmt.Errorf("could not get peer configs: %s", err)
+	}
+
+	ipamMutex.Lock()
+	defer ipamMutex.Unlock()
+	existingIPAM, err := loadIPAM(storage, vpnConfig)
+	if err != nil {
+		return fmt.Errorf("could not load ip list: %s", err)
+	}
+	ipam, _, err := rebuildIPAM(storage, vpnConfig, existingIPAM.Reservations)
+	if err != nil {
+		return fmt.Errorf("could not rebuild ip list: %s", err)
+	}
+
 	clients, err := storage.ReadDir(storage.ConfigPath(VPN_CLIENTS_DIR))
 	if err != nil {
 		return fmt.Errorf("cannot list client connections: %s", err)
 	}
+	refreshFilenames := []string{}
 	for _, clientFilename := range clients {
 		peerConfig, err := getPeerConfig(storage, strings.TrimSuffix(clientFilename, ".json"))
 		if err != nil {
 			return fmt.Errorf("cannot get peer config (%s): %s", clientFilename, err)
 		}
 		// update attributes
-		clientAllowedIPs, err := getClientAllowedIPs(vpnConfig.AddressRange.Addr().String()+vpnConfig.ClientAddressPrefix, vpnConfig.ClientRoutes, vpnConfig.Nameservers)
+		userID, _, err := getClientIDAndConfigID(peerConfig.ID)
 		if err != nil {
-			return fmt.Errorf("getClientAllowedIPs error: %s", err)
+			return fmt.Errorf("cannot determine user of connection %s: %s", peerConfig.ID, err)
+		}
+		var (
+			profile             *Profile
+			clientAllowedIPs    []string
+			dns                 string
+			persistentKeepalive int
+		)
+		if peerConfig.Type == PEER_TYPE_NETWORK {
+			clientAllowedIPs = getNetworkPeerClientAllowedIPs(vpnConfig, getRoutedNetworks(peerConfigs, peerConfig.ID))
+		} else {
+			profile = profilesConfig.getUserProfile(userID)
+			clientAllowedIPs, dns, persistentKeepalive, err = getProfileClientSettings(vpnConfig, profile, getRoutedNetworks(peerConfigs, peerConfig.ID))
+			if err != nil {
+				return err
+			}
 		}
 
 		rewriteFile := false
@@ -129,28 +258,60 @@ func UpdateClientsConfig(storage storage.Iface) error {
 			rewriteFile = true
 			peerConfig.ClientAllowedIPs = clientAllowedIPs
 		}
-		if peerConfig.DNS != strings.Join(vpnConfig.Nameservers, ", ") {
+		if peerConfig.DNS != dns {
 			rewriteFile = true
-			peerConfig.DNS = strings.Join(vpnConfig.Nameservers, ", ")
+			peerConfig.DNS = dns
 		}
+		if peerConfig.Profile != getProfileName(profile) || peerConfig.PersistentKeepalive != persistentKeepalive {
+			rewriteFile = true
+			peerConfig.Profile = getProfileName(profile)
+			peerConfig.PersistentKeepalive = persistentKeepalive
+		}
+		serverAllowedIPsBefore := slices.Clone(peerConfig.ServerAllowedIPs)
 
 		addressParsed, err := netip.ParsePrefix(peerConfig.Address)
 		if err != nil {
 			return fmt.Errorf("couldn't parse existing address of vpn config %s", clientFilename)
 		}
-		if !vpnConfig.AddressRange.Contains(addressParsed.Addr()) { // client IP address is not in address range (address range might have changed)
-			nextFreeIP, err := getNextFreeIP(storage, vpnConfig.AddressRange, vpnConfig.ClientAddressPrefix)
-			if err != nil {
-				return fmt.Errorf("getNextFreeIP error: %s", err)
-			}
-			peerConfig.Address = nextFreeIP.String() + vpnConfig.ClientAddressPrefix
-			peerConfig.ServerAllowedIPs = []string{nextFreeIP.String() + "/32"}
+		// client IP address is not in address range (address range might have changed), conflicts, or the connection has a reservation
+		address, err := ipam.IPv4.reallocate(peerConfig.ID, profilesConfig.getAddressScope(profile))
+		if err != nil {
+			return fmt.Errorf("could not allocate ip address for %s: %s", peerConfig.ID, err)
+		}
+		if address.Addr() != addressParsed.Addr() {
+			peerConfig.Address = address.String()
+			peerConfig.ServerAllowedIPs = []string{address.Addr().String() + "/32"}
 			rewriteFile = true
 		}
 
-		if !strings.HasSuffix(peerConfig.Address, vpnConfig.ClientAddressPrefix) {
+		if peerConfig.Address != address.String() {
+			rewriteFile = true
+			peerConfig.Address = address.String()
+		}
+
+		// ipv6 address is added when an ipv6 address range is configured, and removed when it's not
+		addressIPv6 := netip.Prefix{}
+		if ipam.IPv6 != nil {
+			addressIPv6, err = ipam.IPv6.reallocate(peerConfig.ID, addressScope{})
+			if err != nil {
+				return fmt.Errorf("could not allocate ipv6 address for %s: %s", peerConfig.ID, err)
+			}
+		}
+		serverAllowedIPs := []string{address.Addr().String() + "/32"}
+		if len(peerConfig.ServerAllowedIPs) > 0 {
+			serverAllowedIPs[0] = peerConfig.ServerAllowedIPs[0]
+		}
+		if addressIPv6.IsValid() {
+			serverAllowedIPs = append(serverAllowedIPs, netip.PrefixFrom(addressIPv6.Addr(), 128).String())
+		}
+		serverAllowedIPs = append(serverAllowedIPs, peerConfig.Networks...)
+		if peerConfig.AddressIPv6 != addressIPv6.String() || !slices.Equal(peerConfig.ServerAllowedIPs, serverAllowedIPs) {
+			peerConfig.AddressIPv6 = ""
+			if addressIPv6.IsValid() {
+				peerConfig.AddressIPv6 = addressIPv6.String()
+			}
+			peerConfig.ServerAllowedIPs = serverAllowedIPs
 			rewriteFile = true
-			peerConfig.Address = addressParsed.Addr().String() + vpnConfig.ClientAddressPrefix
 		}
 
 		if rewriteFile {
@@ -164,6 +325,20 @@ func UpdateClientsConfig(storage storage.Iface) error {
 				return fmt.Errorf("could not save vpn client info to file (%s): %s", clientFilename, err)
 			}
 		}
+		if !slices.Equal(serverAllowedIPsBefore, peerConfig.ServerAllowedIPs) && peerConfig.PublicKey != "" && !peerConfig.Disabled {
+			refreshFilenames = append(refreshFilenames, clientFilename)
+		}
+	}
+	err = saveIPAM(storage, ipam)
+	if err != nil {
+		return err
+	}
+	// the address of the peer changed on the vpn server
+	if len(refreshFilenames) > 0 {
+		err = refreshClients(storage, ACTION_ADD, refreshFilenames)
+		if err != nil {
+			return fmt.Errorf("could not refresh clients: %s", err)
+		}
 	}
 	return nil
 }
@@ -232,94 +407,360 @@ func WriteClientTemplate(storage storage.Iface, body []byte) error {
 	return nil
 }
 
+// GenerateNewClientConfig renders the client config of a connection. The key is generated when the connection is created
+// and stored encrypted, so the config can be downloaded again without breaking devices that already use it.
 func GenerateNewClientConfig(storage storage.Iface, connectionID, userID string) ([]byte, error) {
-	clientConfigMutex.Lock()
-	defer clientConfigMutex.Unlock()
+	return GenerateNewClientConfigWithFormat(storage, connectionID, userID, CLIENT_CONFIG_FORMAT_CONF)
+}
 
-	// parse template
-	privateKey, publicKey, err := GenerateKeys()
+// GenerateNewClientConfigWithFormat renders the client config in one of the CLIENT_CONFIG_FORMAT formats
+func GenerateNewClientConfigWithFormat(storage storage.Iface, connectionID, userID, format string) ([]byte, error) {
+	return GenerateNewClientConfigWithOptions(storage, connectionID, userID, ClientConfigOptions{Format: format})
+}
+
+// GenerateNewClientConfigWithOptions renders the client config. With WaitForSync, it only returns when the peer changes
+// that were needed to render the config are applied on the vpn interface.
+func GenerateNewClientConfigWithOptions(storage storage.Iface, connectionID, userID string, options ClientConfigOptions) ([]byte, error) {
+	out, jobID, err := generateNewClientConfig(storage, connectionID, userID, options.Format)
 	if err != nil {
-		return nil, fmt.Errorf("GenerateKeys error: %s", err)
+		return nil, err
+	}
+	if options.WaitForSync && jobID != "" {
+		_, err = WaitForJob(storage, jobID, JOB_WAIT_TIMEOUT)
+		if err != nil {
+			return nil, fmt.Errorf("peer not applied on the vpn server: %s", err)
+		}
+	}
+	return out, nil
+}
+
+func generateNewClientConfig(storage storage.Iface, connectionID, userID, format string) ([]byte, string, error) {
+	if _, ok := clientConfigFormats[format]; !ok {
+		return nil, "", fmt.Errorf("unsupported client config format: %s", format)
 	}
+
+	clientConfigMutex.Lock()
+	defer clientConfigMutex.Unlock()
+
 	vpnConfig, err := GetVPNConfig(storage)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get vpn config: %s", err)
+		return nil, "", fmt.Errorf("failed to get vpn config: %s", err)
 	}
 
 	peerConfig, err := getPeerConfig(storage, connectionID)
 	if err != nil {
-		return nil, fmt.Errorf("could not get peer config: %s", err)
+		return nil, "", fmt.Errorf("could not get peer config: %s", err)
 	}
 
-	// set public key
-	peerConfig.PublicKey = publicKey
+	rewriteFile := !peerConfig.ConfigRevealed
+	refreshPeer := false
 
-	peerConfigOut, err := json.Marshal(peerConfig)
-	if err != nil {
-		return nil, fmt.Errorf("peerConfig marshal error: %s", err)
+	// the private key of a client generated key is unknown to the server: render a placeholder instead
+	privateKey := CLIENT_PRIVATE_KEY_PLACEHOLDER
+	if !peerConfig.ClientGeneratedKey {
+		if vpnConfig.RequireClientPublicKey {
+			return nil, "", fmt.Errorf("a public key generated on the client device needs to be uploaded before the config can be downloaded")
+		}
+		if peerConfig.PrivateKeyEncrypted == "" {
+			if peerConfig.ConfigRevealed && peerConfig.PublicKey != "" && !peerConfig.ConfigRefreshRequired {
+				return nil, "", fmt.Errorf("config has already been downloaded and the private key is not stored anymore: rotate the key to download a new config")
+			}
+			// connection was created before keys were generated at creation time
+			err = setNewClientKey(storage, &peerConfig)
+			if err != nil {
+				return nil, "", fmt.Errorf("could not set new client key: %s", err)
+			}
+			refreshPeer = true
+			rewriteFile = true
+		}
+		privateKey, err = decryptClientPrivateKey(storage, peerConfig.PrivateKeyEncrypted)
+		if err != nil {
+			return nil, "", fmt.Errorf("could not decrypt client private key: %s", err)
+		}
+		if vpnConfig.OneTimeConfigReveal { // discard the private key after it has been revealed once
+			peerConfig.PrivateKeyEncrypted = ""
+			rewriteFile = true
+		}
+	}
+	peerConfig.ConfigRevealed = true
+	// during the grace period of a key rotation, peers that existed at the rotation get a config with the pending server key,
+	// which works from the switch time on. Peers created after the rotation get the current key, and need a refresh after the switch.
+	// The key is kept in the peer config, so that downloading the config again during the grace period renders the same key.
+	serverPublicKey := peerConfig.ServerPublicKey
+	if serverPublicKey == "" || (serverPublicKey != vpnConfig.PublicKey && serverPublicKey != vpnConfig.PendingPublicKey) {
+		serverPublicKey = vpnConfig.PublicKey
+		if vpnConfig.PendingPublicKey != "" && peerConfig.ConfigRefreshRequired {
+			serverPublicKey = vpnConfig.PendingPublicKey
+		}
+	}
+	serverKeySwitchAt := time.Time{}
+	if serverPublicKey == vpnConfig.PendingPublicKey {
+		serverKeySwitchAt = vpnConfig.PendingKeySwitchAt
+	}
+	configRefreshRequired := vpnConfig.PendingPublicKey != "" && serverPublicKey != vpnConfig.PendingPublicKey
+	if peerConfig.ServerPublicKey != serverPublicKey || peerConfig.ConfigRefreshRequired != configRefreshRequired {
+		peerConfig.ServerPublicKey = serverPublicKey
+		peerConfig.ConfigRefreshRequired = configRefreshRequired
+		rewriteFile = true
+	}
+	if peerConfig.PresharedKeyRotationRequired { // peer still uses the former global preshared key
+		peerConfig.PresharedKey, err = GeneratePresharedKey()
+		if err != nil {
+			return nil, "", fmt.Errorf("GeneratePresharedKey error: %s", err)
+		}
+		peerConfig.PresharedKeyRotationRequired = false
+		refreshPeer = true
+		rewriteFile = true
+	}
+	if peerConfig.DisabledUntilConfigRefresh { // peer was disabled by an emergency key rotation
+		peerConfig.DisabledUntilConfigRefresh = false
+		if peerConfig.DisabledReason == "" {
+			peerConfig.Disabled = false
+			refreshPeer = true
+		}
+		rewriteFile = true
 	}
 
-	// config template
 	vpnClientData := VPNClientData{
-		Address:         peerConfig.Address,
+		ID:              peerConfig.ID,
+		Name:            peerConfig.Name,
+		Address:         strings.Join(getPeerConfigAddresses(peerConfig), ", "),
 		DNS:             peerConfig.DNS,
 		PrivateKey:      privateKey,
-		ServerPublicKey: vpnConfig.PublicKey,
-		PresharedKey:    vpnConfig.PresharedKey,
-		Endpoint:        vpnConfig.Endpoint + ":" + fmt.Sprintf("%d", vpnConfig.Port),
+		ServerPublicKey: serverPublicKey,
+		PresharedKey:    peerConfig.PresharedKey,
+		Endpoint:        getEndpoint(vpnConfig),
 		AllowedIPs:      peerConfig.ClientAllowedIPs,
+		Description:     peerConfig.Description,
+		Platform:        peerConfig.Platform,
+		Labels:          peerConfig.Labels,
+
+		PersistentKeepalive: getPersistentKeepalive(peerConfig),
+		ServerKeySwitchAt:   serverKeySwitchAt,
+	}
+
+	out, err := renderClientConfig(storage, vpnClientData, format)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if rewriteFile {
+		err = writePeerConfig(storage, peerConfig)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	// notify configmanager
+	jobID := ""
+	if refreshPeer {
+		jobID, err = refreshClientsWithJob(storage, ACTION_ADD, []string{fmt.Sprintf("%s.json", peerConfig.ID)})
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	return out, jobID, nil
+}
+
+// RotateClientKey generates a new key for an existing connection. Devices using the previous config stop working.
+func RotateClientKey(storage storage.Iface, connectionID string) (PeerConfig, error) {
+	clientConfigMutex.Lock()
+	defer clientConfigMutex.Unlock()
+
+	vpnConfig, err := GetVPNConfig(storage)
+	if err != nil {
+		return PeerConfig{}, fmt.Errorf("failed to get vpn config: %s", err)
+	}
+	if vpnConfig.RequireClientPublicKey {
+		return PeerConfig{}, fmt.Errorf("keys need to be generated on the client device: upload a new public key instead")
+	}
+
+	peerConfig, err := getPeerConfig(storage, connectionID)
+	if err != nil {
+		return peerConfig, fmt.Errorf("could not get peer config: %s", err)
+	}
+
+	err = setNewClientKey(storage, &peerConfig)
+	if err != nil {
+		return peerConfig, fmt.Errorf("could not set new client key: %s", err)
+	}
+
+	err = writePeerConfig(storage, peerConfig)
+	if err != nil {
+		return peerConfig, err
 	}
 
-	templatefileContents, err := GetClientTemplate(storage)
+	// notify configmanager (the old key is removed during cleanup)
+	err = refreshClients(storage, ACTION_ADD, []string{fmt.Sprintf("%s.json", peerConfig.ID)})
 	if err != nil {
-		return nil, fmt.Errorf("could not get client template: %s", err)
+		return peerConfig, err
 	}
 
-	tmpl, err := template.New("client.tmpl").Funcs(template.FuncMap{"StringsJoin": strings.Join}).Parse(string(templatefileContents))
+	return peerConfig, nil
+}
+
+func setNewClientKey(storage storage.Iface, peerConfig *PeerConfig) error {
+	privateKey, publicKey, err := GenerateKeys()
 	if err != nil {
-		return nil, fmt.Errorf("could not parse client template: %s", err)
+		return fmt.Errorf("GenerateKeys error: %s", err)
 	}
-	out := bytes.NewBuffer([]byte{})
-	err = tmpl.Execute(out, vpnClientData)
+	privateKeyEncrypted, err := encryptClientPrivateKey(storage, privateKey)
 	if err != nil {
-		return nil, fmt.Errorf("could not parse client template (execute parsing): %s", err)
+		return fmt.Errorf("could not encrypt private key: %s", err)
 	}
+	presharedKey, err := GeneratePresharedKey()
+	if err != nil {
+		return fmt.Errorf("GeneratePresharedKey error: %s", err)
+	}
+	peerConfig.PresharedKey = presharedKey
+	peerConfig.PresharedKeyRotationRequired = false
+	peerConfig.PublicKey = publicKey
+	peerConfig.PrivateKeyEncrypted = privateKeyEncrypted
+	peerConfig.ClientGeneratedKey = false
+	peerConfig.ConfigRevealed = false
+	return nil
+}
 
-	// save new public key for configmanager server to pick up
+func writePeerConfig(storage storage.Iface, peerConfig PeerConfig) error {
+	peerConfigOut, err := json.Marshal(peerConfig)
+	if err != nil {
+		return fmt.Errorf("peerConfig marshal error: %s", err)
+	}
 	userConfigFilename := storage.ConfigPath(path.Join(VPN_CLIENTS_DIR, fmt.Sprintf("%s.json", peerConfig.ID)))
 	err = storage.WriteFile(userConfigFilename, peerConfigOut)
 	if err != nil {
-		return nil, fmt.Errorf("could not save vpn client info to file: %s", err)
+		return fmt.Errorf("could not save vpn client info to file: %s", err)
+	}
+	currentUser, err := user.Current()
+	if err != nil {
+		return fmt.Errorf("could not get current user: %s", err)
 	}
+	if currentUser.Username != VPN_USER { // configmanager writes need to stay writable for the rest-server
+		err = storage.EnsureOwnership(userConfigFilename, VPN_USER)
+		if err != nil {
+			return fmt.Errorf("could not ensure ownership of %s: %s", userConfigFilename, err)
+		}
+	}
+	return nil
+}
 
-	// notify configmanager
-	client := http.Client{
-		Timeout: 10 * time.Second,
+// MigratePeerConfigs gives every peer its own preshared key. Peers that already have a config on a device
+// keep the former global preshared key, so they keep working, but they need a config refresh: the next
+// download generates a unique preshared key.
+func MigratePeerConfigs(storage storage.Iface) error {
+	clientConfigMutex.Lock()
+	defer clientConfigMutex.Unlock()
+
+	vpnConfig, err := GetVPNConfig(storage)
+	if err != nil {
+		return fmt.Errorf("failed to get vpn config: %s", err)
+	}
+	peerConfigs, err := GetAllPeerConfigs(storage)
+	if err != nil {
+		return fmt.Errorf("could not get peer configs: %s", err)
+	}
+	for _, peerConfig := range peerConfigs {
+		switch {
+		case peerConfig.PresharedKey == "" && peerConfig.PublicKey != "" && vpnConfig.PresharedKey != "":
+			peerConfig.PresharedKey = vpnConfig.PresharedKey
+		case peerConfig.PresharedKey == "":
+			peerConfig.PresharedKey, err = GeneratePresharedKey()
+			if err != nil {
+				return fmt.Errorf("GeneratePresharedKey error: %s", err)
+			}
+			err = writePeerConfig(storage, peerConfig)
+			if err != nil {
+				return fmt.Errorf("could not write peer config (%s): %s", peerConfig.ID, err)
+			}
+			continue
+		case peerConfig.PresharedKey != vpnConfig.PresharedKey || peerConfig.PresharedKeyRotationRequired:
+			continue // unique preshared key, or already migrated
+		}
+		peerConfig.PresharedKeyRotationRequired = true
+		peerConfig.ConfigRefreshRequired = true
+		err = writePeerConfig(storage, peerConfig)
+		if err != nil {
+			return fmt.Errorf("could not write peer config (%s): %s", peerConfig.ID, err)
+		}
 	}
-	refreshClientRequest := RefreshClientRequest{
-		Action:    ACTION_ADD,
-		Filenames: []string{path.Base(userConfigFilename)},
+	return nil
+}
+
+// SetClientPublicKey replaces the key of an existing connection with a public key that was generated on the client device
+func SetClientPublicKey(storage storage.Iface, connectionID, publicKey string) (PeerConfig, error) {
+	clientConfigMutex.Lock()
+	defer clientConfigMutex.Unlock()
+
+	err := ValidatePublicKey(publicKey)
+	if err != nil {
+		return PeerConfig{}, fmt.Errorf("invalid public key: %s", err)
 	}
-	payload, err := json.Marshal(refreshClientRequest)
+
+	peerConfig, err := getPeerConfig(storage, connectionID)
 	if err != nil {
-		return nil, fmt.Errorf("could not marshal refresh client request: %s", err)
+		return peerConfig, fmt.Errorf("could not get peer config: %s", err)
 	}
-	resp, err := client.Post("http://"+CONFIGMANAGER_URI+"/refresh-clients", "application/json", bytes.NewReader(payload))
+	vpnConfig, err := GetVPNConfig(storage)
 	if err != nil {
-		return nil, fmt.Errorf("configmanager post error: %s", err)
+		return peerConfig, fmt.Errorf("failed to get vpn config: %s", err)
 	}
-	if resp.StatusCode != http.StatusAccepted {
-		return nil, fmt.Errorf("configmanager post error: received status code %d", resp.StatusCode)
+	peerConfigs, err := GetAllPeerConfigs(storage)
+	if err != nil {
+		return peerConfig, fmt.Errorf("could not get peer configs: %s", err)
+	}
+	err = checkPublicKeyInUse(vpnConfig, peerConfigs, publicKey, peerConfig.ID)
+	if err != nil {
+		return peerConfig, err
 	}
 
-	return out.Bytes(), nil
+	peerConfig.PresharedKey, err = GeneratePresharedKey()
+	if err != nil {
+		return peerConfig, fmt.Errorf("GeneratePresharedKey error: %s", err)
+	}
+	peerConfig.PresharedKeyRotationRequired = false
+	peerConfig.PublicKey = publicKey
+	peerConfig.ClientGeneratedKey = true
+	peerConfig.PrivateKeyEncrypted = ""
+	peerConfig.ConfigRevealed = false
+
+	err = writePeerConfig(storage, peerConfig)
+	if err != nil {
+		return peerConfig, err
+	}
+
+	// notify configmanager (the old key, if any, is removed during cleanup)
+	err = refreshClients(storage, ACTION_ADD, []string{fmt.Sprintf("%s.json", peerConfig.ID)})
+	if err != nil {
+		return peerConfig, err
+	}
+
+	return peerConfig, nil
 }
+
+// checkPublicKeyInUse returns an error when the public key belongs to the server or to another connection. The vpn
+// interface can only have one peer per public key, so a duplicate key would take over the other connection.
+func checkPublicKeyInUse(vpnConfig VPNConfig, peerConfigs []PeerConfig, publicKey, connectionID string) error {
+	if publicKey == vpnConfig.PublicKey || publicKey == vpnConfig.PendingPublicKey {
+		return fmt.Errorf("public key is already used by the vpn server")
+	}
+	for _, peerConfig := range peerConfigs {
+		if peerConfig.ID != connectionID && peerConfig.PublicKey == publicKey {
+			return fmt.Errorf("public key is already used by connection %s", peerConfig.ID)
+		}
+	}
+	return nil
+}
+
 func DeleteAllClientConfigs(storage storage.Iface, user users.User) error {
+	clientConfigMutex.Lock()
+	defer clientConfigMutex.Unlock()
 	clients, err := storage.ReadDir(storage.ConfigPath(VPN_CLIENTS_DIR))
 	if err != nil {
 		return fmt.Errorf("cannot list files in users clients directory: %s", err)
 	}
 
+	deleted := []string{}
 	for _, clientFilename := range clients {
 		if HasClientUserID(clientFilename, user.ID) {
 			filename := storage.ConfigPath(path.Join(VPN_CLIENTS_DIR, clientFilename))
@@ -327,57 +768,120 @@ func DeleteAllClientConfigs(storage storage.Iface, user users.User) error {
 			if err != nil {
 				return fmt.Errorf("removal of file %s failed: %s", filename, err)
 			}
+			deleted = append(deleted, strings.TrimSuffix(clientFilename, ".json"))
 		}
 	}
-	// notify configmanager
-	client := http.Client{
-		Timeout: 10 * time.Second,
-	}
-	refreshClientRequest := RefreshClientRequest{
-		Action:    ACTION_CLEANUP,
-		Filenames: []string{},
-	}
-	payload, err := json.Marshal(refreshClientRequest)
-	if err != nil {
-		return fmt.Errorf("could not marshal refresh client request: %s", err)
-	}
-	resp, err := client.Post("http://"+CONFIGMANAGER_URI+"/refresh-clients", "application/json", bytes.NewReader(payload))
+	err = releaseIPAddresses(storage, deleted)
 	if err != nil {
-		return fmt.Errorf("configmanager post error: %s", err)
+		return fmt.Errorf("could not release ip addresses: %s", err)
 	}
-	if resp.StatusCode != http.StatusAccepted {
-		return fmt.Errorf("configmanager post error: received status code %d", resp.StatusCode)
-	}
-	return nil
+	// notify configmanager
+	return refreshClients(storage, ACTION_CLEANUP, []string{})
 }
+
 func DeleteClientConfig(storage storage.Iface, connectionID, userID string) error {
+	clientConfigMutex.Lock()
+	defer clientConfigMutex.Unlock()
 	toDeleteFilename := fmt.Sprintf("%s.json", connectionID)
 	filename := storage.ConfigPath(path.Join(VPN_CLIENTS_DIR, toDeleteFilename))
 	err := storage.Remove(filename)
 	if err != nil {
 		return fmt.Errorf("removal of file %s failed: %s", filename, err)
 	}
+	err = releaseIPAddresses(storage, []string{connectionID})
+	if err != nil {
+		return fmt.Errorf("could not release ip address: %s", err)
+	}
 	// notify configmanager
-	client := http.Client{
-		Timeout: 10 * time.Second,
+	return refreshClients(storage, ACTION_CLEANUP, []string{})
+}
+
+// DisableClientConfig disables a single connection, e.g. when a device is lost
+func DisableClientConfig(storage storage.Iface, connectionID, actor string) (PeerConfig, error) {
+	clientConfigMutex.Lock()
+	defer clientConfigMutex.Unlock()
+
+	peerConfig, err := getPeerConfig(storage, connectionID)
+	if err != nil {
+		return peerConfig, fmt.Errorf("could not get peer config: %s", err)
+	}
+	wasDisabled := peerConfig.Disabled
+	peerConfig.Disabled = true
+	peerConfig.DisabledReason = DISABLED_REASON_ADMIN
+	peerConfig.DisabledBy = actor
+	peerConfig.DisabledAt = time.Now().UTC()
+
+	err = writePeerConfig(storage, peerConfig)
+	if err != nil {
+		return peerConfig, err
+	}
+
+	// notify configmanager
+	if !wasDisabled && peerConfig.PublicKey != "" {
+		err = refreshClients(storage, ACTION_DELETE, []string{fmt.Sprintf("%s.json", peerConfig.ID)})
+		if err != nil {
+			return peerConfig, err
+		}
+	}
+	// audited once the change is applied, so a failed audit write doesn't leave the peer config and the vpn interface out of sync
+	return peerConfig, writeConnectionAuditEntry(storage, peerConfig.ID, AUDIT_ACTION_DISABLE, actor)
+}
+
+// EnableClientConfig enables a connection that was disabled with DisableClientConfig
+func EnableClientConfig(storage storage.Iface, connectionID, actor string) (PeerConfig, error) {
+	clientConfigMutex.Lock()
+	defer clientConfigMutex.Unlock()
+
+	peerConfig, err := getPeerConfig(storage, connectionID)
+	if err != nil {
+		return peerConfig, fmt.Errorf("could not get peer config: %s", err)
+	}
+	if !peerConfig.Disabled {
+		return peerConfig, nil
 	}
-	refreshClientRequest := RefreshClientRequest{
-		Action:    ACTION_CLEANUP,
-		Filenames: []string{},
+	if peerConfig.DisabledReason != DISABLED_REASON_ADMIN {
+		if peerConfig.DisabledReason == "" {
+			return peerConfig, fmt.Errorf("connection is disabled and can't be enabled by an admin")
+		}
+		return peerConfig, fmt.Errorf("connection is disabled and can't be enabled by an admin (reason: %s)", peerConfig.DisabledReason)
 	}
-	payload, err := json.Marshal(refreshClientRequest)
+	peerConfig.DisabledReason = ""
+	peerConfig.DisabledBy = ""
+	peerConfig.DisabledAt = time.Time{}
+	peerConfig.Disabled = peerConfig.DisabledUntilConfigRefresh
+	updateClientValidity(&peerConfig, time.Now())
+
+	err = writePeerConfig(storage, peerConfig)
 	if err != nil {
-		return fmt.Errorf("could not marshal refresh client request: %s", err)
+		return peerConfig, err
+	}
+
+	// notify configmanager
+	if !peerConfig.Disabled && peerConfig.PublicKey != "" {
+		err = refreshClients(storage, ACTION_ADD, []string{fmt.Sprintf("%s.json", peerConfig.ID)})
+		if err != nil {
+			return peerConfig, err
+		}
+	}
+	return peerConfig, writeConnectionAuditEntry(storage, peerConfig.ID, AUDIT_ACTION_ENABLE, actor)
+}
+
+// RevokeClientConfig deletes a single connection on behalf of an admin
+func RevokeClientConfig(storage storage.Iface, connectionID, actor string) error {
+	if !storage.FileExists(storage.ConfigPath(path.Join(VPN_CLIENTS_DIR, fmt.Sprintf("%s.json", connectionID)))) {
+		return fmt.Errorf("connection not found")
 	}
-	resp, err := client.Post("http://"+CONFIGMANAGER_URI+"/refresh-clients", "application/json", bytes.NewReader(payload))
+	userID, _, err := getClientIDAndConfigID(connectionID)
 	if err != nil {
-		return fmt.Errorf("configmanager post error: %s", err)
+		return fmt.Errorf("invalid connection id: %s", err)
 	}
-	if resp.StatusCode != http.StatusAccepted {
-		return fmt.Errorf("configmanager post error: received status code %d", resp.StatusCode)
+	err = DeleteClientConfig(storage, connectionID, userID)
+	if err != nil {
+		return err
 	}
-	return nil
+	return writeConnectionAuditEntry(storage, connectionID, AUDIT_ACTION_DELETE, actor)
 }
+
 func DisableAllClientConfigs(storage storage.Iface, user users.User) error {
 	clientConfigMutex.Lock()
 	defer clientConfigMutex.Unlock()
@@ -406,6 +910,9 @@ func DisableAllClientConfigs(storage storage.Iface, user users.User) error {
 			return fmt.Errorf("can't unmarshal file %s: %s", filename, err)
 		}
 		peerConfig.Disabled = true
+		if peerConfig.DisabledReason != DISABLED_REASON_ADMIN { // keep connections disabled by an admin disabled after reactivation
+			peerConfig.DisabledReason = DISABLED_REASON_SUSPENDED
+		}
 		toDeleteToWrite, err := json.Marshal(peerConfig)
 		if err != nil {
 			return fmt.Errorf("can't marshal peer config file %s: %s", filename, err)
@@ -418,24 +925,7 @@ func DisableAllClientConfigs(storage storage.Iface, user users.User) error {
 
 	// notify configmanager
 	if len(toDelete) > 0 {
-		client := http.Client{
-			Timeout: 10 * time.Second,
-		}
-		refreshClientRequest := RefreshClientRequest{
-			Action:    ACTION_DELETE,
-			Filenames: toDelete,
-		}
-		payload, err := json.Marshal(refreshClientRequest)
-		if err != nil {
-			return fmt.Errorf("could not marshal refresh client request: %s", err)
-		}
-		resp, err := client.Post("http://"+CONFIGMANAGER_URI+"/refresh-clients", "application/json", bytes.NewReader(payload))
-		if err != nil {
-			return fmt.Errorf("configmanager post error: %s", err)
-		}
-		if resp.StatusCode != http.StatusAccepted {
-			return fmt.Errorf("configmanager post error: received status code %d", resp.StatusCode)
-		}
+		return refreshClients(storage, ACTION_DELETE, toDelete)
 	}
 	return nil
 }
@@ -455,6 +945,7 @@ func ReactivateAllClientConfigs(storage storage.Iface, user users.User) error {
 	}
 
 	// set the disabled flag on each file
+	enabled := []string{}
 	for _, toAddFilename := range toAdd {
 		var peerConfig PeerConfig
 		filename := storage.ConfigPath(path.Join(VPN_CLIENTS_DIR, toAddFilename))
@@ -466,7 +957,11 @@ func ReactivateAllClientConfigs(storage storage.Iface, user users.User) error {
 		if err != nil {
 			return fmt.Errorf("can't unmarshal file %s: %s", filename, err)
 		}
-		peerConfig.Disabled = false
+		if peerConfig.DisabledReason == DISABLED_REASON_SUSPENDED || peerConfig.DisabledReason == "" {
+			peerConfig.DisabledReason = ""
+			peerConfig.Disabled = peerConfig.DisabledUntilConfigRefresh
+			updateClientValidity(&peerConfig, time.Now()) // peers outside of their validity window stay disabled
+		}
 		toAddToWrite, err := json.Marshal(peerConfig)
 		if err != nil {
 			return fmt.Errorf("can't marshal peer config file %s: %s", filename, err)
@@ -475,28 +970,14 @@ func ReactivateAllClientConfigs(storage storage.Iface, user users.User) error {
 		if err != nil {
 			return fmt.Errorf("can't write peer config file %s: %s", filename, err)
 		}
+		if !peerConfig.Disabled {
+			enabled = append(enabled, toAddFilename)
+		}
 	}
 
 	// notify configmanager
-	if len(toAdd) > 0 {
-		client := http.Client{
-			Timeout: 10 * time.Second,
-		}
-		refreshClientRequest := RefreshClientRequest{
-			Action:    ACTION_ADD,
-			Filenames: toAdd,
-		}
-		payload, err := json.Marshal(refreshClientRequest)
-		if err != nil {
-			return fmt.Errorf("could not marshal refresh client request: %s", err)
-		}
-		resp, err := client.Post("http://"+CONFIGMANAGER_URI+"/refresh-clients", "application/json", bytes.NewReader(payload))
-		if err != nil {
-			return fmt.Errorf("configmanager post error: %s", err)
-		}
-		if resp.StatusCode != http.StatusAccepted {
-			return fmt.Errorf("configmanager post error: received status code %d", resp.StatusCode)
-		}
+	if len(enabled) > 0 {
+		return refreshClients(storage, ACTION_ADD, enabled)
 	}
 	return nil
 }
@@ -510,6 +991,12 @@ func getConfigNumberFromConnectionFile(filename string) (int, error) {
 	_, configNumber, err := getClientIDAndConfigID(strings.TrimSuffix(filename, ".json"))
 	return configNumber, err
 }
+
+// GetUserIDFromConnectionID returns the user id part of a connection id (<user id>-<config number>)
+func GetUserIDFromConnectionID(connectionID string) (string, error) {
+	userID, _, err := getClientIDAndConfigID(connectionID)
+	return userID, err
+}
 func getClientIDAndConfigID(name string) (string, int, error) {
 	nameSplit := strings.Split(name, "-")
 	if len(nameSplit) < 2 {
@@ -523,6 +1010,19 @@ func getClientIDAndConfigID(name string) (string, int, error) {
 	return clientID, i, nil
 }
 
+// getPeerConfigAddresses returns the ipv4 and (when configured) the ipv6 address of a connection
+func getPeerConfigAddresses(peerConfig PeerConfig) []string {
+	if peerConfig.AddressIPv6 == "" {
+		return []string{peerConfig.Address}
+	}
+	return []string{peerConfig.Address, peerConfig.AddressIPv6}
+}
+
+// getEndpoint returns host:port of the vpn server. IPv6 literals are put between brackets.
+func getEndpoint(vpnConfig VPNConfig) string {
+	return net.JoinHostPort(strings.TrimSuffix(strings.TrimPrefix(vpnConfig.Endpoint, "["), "]"), strconv.Itoa(vpnConfig.Port))
+}
+
 func networkIntersects(network1, network2 *net.IPNet) bool {
 	return network2.Contains(network1.IP) || network1.Contains(network2.IP)
 }
@@ -4,29 +4,24 @@ import (
 	"bufio"
 	"bytes"
 	"encoding/json"
-	"net"
-	"net/http"
-	"net/http/httptest"
 	"net/netip"
+	"path"
+	"slices"
 	"strings"
 	"testing"
-	"time"
 
 	memorystorage "github.com/in4it/go-devops-platform/storage/memory"
 	"github.com/in4it/go-devops-platform/users"
+	configmanagerclient "github.com/in4it/wireguard-server/pkg/configmanager/client"
 )
 
 func TestGetNextFreeIPFromList(t *testing.T) {
-	startIP, addressRange, err := net.ParseCIDR("10.0.0.1/21")
-	if err != nil {
-		t.Fatalf("error: %s", err)
-	}
-	ipList := []string{"10.0.0.2", "10.0.0.3"}
-	nextIP, err := getNextFreeIPFromList(startIP, addressRange, ipList, "/32")
+	ipam := newIPAMWithAddresses(t, "10.0.0.1/21", "/32", []string{"10.0.0.2", "10.0.0.3"})
+	nextIP, err := ipam.allocate("2-2-2-2-1", addressScope{})
 	if err != nil {
 		t.Errorf("next IP error: %s", err)
 	}
-	if nextIP.String() != "10.0.0.4" {
+	if nextIP.Addr().String() != "10.0.0.4" {
 		t.Errorf("wrong ip outputted: %s", nextIP)
 	}
 }
@@ -57,52 +52,9 @@ func TestGetConfigNumberFromConnectionFile(t *testing.T) {
 }
 
 func TestWriteConfig(t *testing.T) {
-	var (
-		l   net.Listener
-		err error
-	)
-	for {
-		l, err = net.Listen("tcp", CONFIGMANAGER_URI)
-		if err != nil {
-			if !strings.HasSuffix(err.Error(), "address already in use") {
-				t.Fatal(err)
-			}
-			time.Sleep(1 * time.Second)
-		} else {
-			break
-		}
-	}
-
-	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		switch r.Method {
-		case http.MethodPost:
-			if r.RequestURI == "/refresh-clients" {
-				w.WriteHeader(http.StatusAccepted)
-				_, err := w.Write([]byte("OK"))
-				if err != nil {
-					t.Fatalf("write error: %s", err)
-				}
-				return
-			}
-			if r.RequestURI == "/refresh-server-config" {
-				w.WriteHeader(http.StatusAccepted)
-				_, err := w.Write([]byte("OK"))
-				if err != nil {
-					t.Fatalf("write error: %s", err)
-				}
-				return
-			}
-			w.WriteHeader(http.StatusBadRequest)
-		default:
-			w.WriteHeader(http.StatusBadRequest)
-		}
-	}))
-
-	ts.Listener.Close() //nolint:errcheck
-	ts.Listener = l
-	ts.Start()
-	defer ts.Close() //nolint:errcheck
-	defer l.Close()  //nolint:errcheck
+	var err error
+	UseConfigManagerClient(configmanagerclient.NewFake())
+	defer UseConfigManagerClient(nil)
 
 	storage := &memorystorage.MockMemoryStorage{}
 
@@ -140,52 +92,9 @@ func TestWriteConfig(t *testing.T) {
 }
 
 func TestWriteConfigMultipleClients(t *testing.T) {
-	var (
-		l   net.Listener
-		err error
-	)
-	for {
-		l, err = net.Listen("tcp", CONFIGMANAGER_URI)
-		if err != nil {
-			if !strings.HasSuffix(err.Error(), "address already in use") {
-				t.Fatal(err)
-			}
-			time.Sleep(1 * time.Second)
-		} else {
-			break
-		}
-	}
-
-	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		switch r.Method {
-		case http.MethodPost:
-			if r.RequestURI == "/refresh-clients" {
-				w.WriteHeader(http.StatusAccepted)
-				_, err = w.Write([]byte("OK"))
-				if err != nil {
-					t.Fatalf("error: %s", err)
-				}
-				return
-			}
-			if r.RequestURI == "/refresh-server-config" {
-				w.WriteHeader(http.StatusAccepted)
-				_, err = w.Write([]byte("OK"))
-				if err != nil {
-					t.Fatalf("error: %s", err)
-				}
-				return
-			}
-			w.WriteHeader(http.StatusBadRequest)
-		default:
-			w.WriteHeader(http.StatusBadRequest)
-		}
-	}))
-
-	ts.Listener.Close() //nolint:errcheck
-	ts.Listener = l
-	ts.Start()
-	defer ts.Close() //nolint:errcheck
-	defer l.Close()  //nolint:errcheck
+	var err error
+	UseConfigManagerClient(configmanagerclient.NewFake())
+	defer UseConfigManagerClient(nil)
 
 	storage := &memorystorage.MockMemoryStorage{}
 
@@ -225,52 +134,9 @@ func TestWriteConfigMultipleClients(t *testing.T) {
 }
 
 func TestCreateAndDeleteAllClientConfig(t *testing.T) {
-	var (
-		l   net.Listener
-		err error
-	)
-	for {
-		l, err = net.Listen("tcp", CONFIGMANAGER_URI)
-		if err != nil {
-			if !strings.HasSuffix(err.Error(), "address already in use") {
-				t.Fatal(err)
-			}
-			time.Sleep(1 * time.Second)
-		} else {
-			break
-		}
-	}
-
-	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		switch r.Method {
-		case http.MethodPost:
-			if r.RequestURI == "/refresh-clients" {
-				w.WriteHeader(http.StatusAccepted)
-				_, err = w.Write([]byte("OK"))
-				if err != nil {
-					t.Fatalf("error: %s", err)
-				}
-				return
-			}
-			if r.RequestURI == "/refresh-server-config" {
-				w.WriteHeader(http.StatusAccepted)
-				_, err = w.Write([]byte("OK"))
-				if err != nil {
-					t.Fatalf("error: %s", err)
-				}
-				return
-			}
-			w.WriteHeader(http.StatusBadRequest)
-		default:
-			w.WriteHeader(http.StatusBadRequest)
-		}
-	}))
-
-	ts.Listener.Close() //nolint:errcheck
-	ts.Listener = l
-	ts.Start()
-	defer ts.Close() //nolint:errcheck
-	defer l.Close()  //nolint:errcheck
+	var err error
+	UseConfigManagerClient(configmanagerclient.NewFake())
+	defer UseConfigManagerClient(nil)
 
 	storage := &memorystorage.MockMemoryStorage{}
 
@@ -292,8 +158,8 @@ func TestCreateAndDeleteAllClientConfig(t *testing.T) {
 		t.Fatalf("NewEmptyClientConfig error: %s", err)
 	}
 
-	if peerConfig.PublicKey != "" {
-		t.Fatalf("public key already found in peerconfig")
+	if peerConfig.PublicKey == "" {
+		t.Fatalf("public key not generated when creating the peerconfig")
 	}
 
 	_, err = GenerateNewClientConfig(storage, peerConfig.ID, "2-2-2-2")
@@ -324,52 +190,9 @@ func TestCreateAndDeleteAllClientConfig(t *testing.T) {
 	}
 }
 func TestCreateAndDeleteClientConfig(t *testing.T) {
-	var (
-		l   net.Listener
-		err error
-	)
-	for {
-		l, err = net.Listen("tcp", CONFIGMANAGER_URI)
-		if err != nil {
-			if !strings.HasSuffix(err.Error(), "address already in use") {
-				t.Fatal(err)
-			}
-			time.Sleep(1 * time.Second)
-		} else {
-			break
-		}
-	}
-
-	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		switch r.Method {
-		case http.MethodPost:
-			if r.RequestURI == "/refresh-clients" {
-				w.WriteHeader(http.StatusAccepted)
-				_, err := w.Write([]byte("OK"))
-				if err != nil {
-					t.Fatalf("write error: %s", err)
-				}
-				return
-			}
-			if r.RequestURI == "/refresh-server-config" {
-				w.WriteHeader(http.StatusAccepted)
-				_, err := w.Write([]byte("OK"))
-				if err != nil {
-					t.Fatalf("write error: %s", err)
-				}
-				return
-			}
-			w.WriteHeader(http.StatusBadRequest)
-		default:
-			w.WriteHeader(http.StatusBadRequest)
-		}
-	}))
-
-	ts.Listener.Close() //nolint:errcheck
-	ts.Listener = l
-	ts.Start()
-	defer ts.Close() //nolint:errcheck
-	defer l.Close()  //nolint:errcheck
+	var err error
+	UseConfigManagerClient(configmanagerclient.NewFake())
+	defer UseConfigManagerClient(nil)
 
 	storage := &memorystorage.MockMemoryStorage{}
 
@@ -391,8 +214,8 @@ func TestCreateAndDeleteClientConfig(t *testing.T) {
 		t.Fatalf("NewEmptyClientConfig error: %s", err)
 	}
 
-	if peerConfig.PublicKey != "" {
-		t.Fatalf("public key already found in peerconfig")
+	if peerConfig.PublicKey == "" {
+		t.Fatalf("public key not generated when creating the peerconfig")
 	}
 
 	_, err = GenerateNewClientConfig(storage, peerConfig.ID, "2-2-2-2")
@@ -424,52 +247,9 @@ func TestCreateAndDeleteClientConfig(t *testing.T) {
 }
 
 func TestCreateAndDisableAllClientConfig(t *testing.T) {
-	var (
-		l   net.Listener
-		err error
-	)
-	for {
-		l, err = net.Listen("tcp", CONFIGMANAGER_URI)
-		if err != nil {
-			if !strings.HasSuffix(err.Error(), "address already in use") {
-				t.Fatal(err)
-			}
-			time.Sleep(1 * time.Second)
-		} else {
-			break
-		}
-	}
-
-	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		switch r.Method {
-		case http.MethodPost:
-			if r.RequestURI == "/refresh-clients" {
-				w.WriteHeader(http.StatusAccepted)
-				_, err := w.Write([]byte("OK"))
-				if err != nil {
-					t.Fatalf("write error: %s", err)
-				}
-				return
-			}
-			if r.RequestURI == "/refresh-server-config" {
-				w.WriteHeader(http.StatusAccepted)
-				_, err := w.Write([]byte("OK"))
-				if err != nil {
-					t.Fatalf("write error: %s", err)
-				}
-				return
-			}
-			w.WriteHeader(http.StatusBadRequest)
-		default:
-			w.WriteHeader(http.StatusBadRequest)
-		}
-	}))
-
-	ts.Listener.Close() //nolint:errcheck
-	ts.Listener = l
-	ts.Start()
-	defer ts.Close() //nolint:errcheck
-	defer l.Close()  //nolint:errcheck
+	var err error
+	UseConfigManagerClient(configmanagerclient.NewFake())
+	defer UseConfigManagerClient(nil)
 
 	storage := &memorystorage.MockMemoryStorage{}
 
@@ -491,8 +271,8 @@ func TestCreateAndDisableAllClientConfig(t *testing.T) {
 		t.Fatalf("NewEmptyClientConfig error: %s", err)
 	}
 
-	if peerConfig.PublicKey != "" {
-		t.Fatalf("public key already found in peerconfig")
+	if peerConfig.PublicKey == "" {
+		t.Fatalf("public key not generated when creating the peerconfig")
 	}
 
 	_, err = GenerateNewClientConfig(storage, peerConfig.ID, "2-2-2-2")
@@ -559,52 +339,9 @@ func TestCreateAndDisableAllClientConfig(t *testing.T) {
 }
 
 func TestUpdateClientConfig(t *testing.T) {
-	var (
-		l   net.Listener
-		err error
-	)
-	for {
-		l, err = net.Listen("tcp", CONFIGMANAGER_URI)
-		if err != nil {
-			if !strings.HasSuffix(err.Error(), "address already in use") {
-				t.Fatal(err)
-			}
-			time.Sleep(1 * time.Second)
-		} else {
-			break
-		}
-	}
-
-	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		switch r.Method {
-		case http.MethodPost:
-			if r.RequestURI == "/refresh-clients" {
-				w.WriteHeader(http.StatusAccepted)
-				_, err := w.Write([]byte("OK"))
-				if err != nil {
-					t.Fatalf("write error: %s", err)
-				}
-				return
-			}
-			if r.RequestURI == "/refresh-server-config" {
-				w.WriteHeader(http.StatusAccepted)
-				_, err := w.Write([]byte("OK"))
-				if err != nil {
-					t.Fatalf("write error: %s", err)
-				}
-				return
-			}
-			w.WriteHeader(http.StatusBadRequest)
-		default:
-			w.WriteHeader(http.StatusBadRequest)
-		}
-	}))
-
-	ts.Listener.Close() //nolint:errcheck
-	ts.Listener = l
-	ts.Start()
-	defer ts.Close() //nolint:errcheck
-	defer l.Close()  //nolint:errcheck
+	var err error
+	UseConfigManagerClient(configmanagerclient.NewFake())
+	defer UseConfigManagerClient(nil)
 
 	storage := &memorystorage.MockMemoryStorage{}
 
@@ -653,52 +390,9 @@ func TestUpdateClientConfig(t *testing.T) {
 }
 
 func TestUpdateClientConfigNewAddressRange(t *testing.T) {
-	var (
-		l   net.Listener
-		err error
-	)
-	for {
-		l, err = net.Listen("tcp", CONFIGMANAGER_URI)
-		if err != nil {
-			if !strings.HasSuffix(err.Error(), "address already in use") {
-				t.Fatal(err)
-			}
-			time.Sleep(1 * time.Second)
-		} else {
-			break
-		}
-	}
-
-	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		switch r.Method {
-		case http.MethodPost:
-			if r.RequestURI == "/refresh-clients" {
-				w.WriteHeader(http.StatusAccepted)
-				_, err := w.Write([]byte("OK"))
-				if err != nil {
-					t.Fatalf("write error: %s", err)
-				}
-				return
-			}
-			if r.RequestURI == "/refresh-server-config" {
-				w.WriteHeader(http.StatusAccepted)
-				_, err := w.Write([]byte("OK"))
-				if err != nil {
-					t.Fatalf("write error: %s", err)
-				}
-				return
-			}
-			w.WriteHeader(http.StatusBadRequest)
-		default:
-			w.WriteHeader(http.StatusBadRequest)
-		}
-	}))
-
-	ts.Listener.Close() //nolint:errcheck
-	ts.Listener = l
-	ts.Start()
-	defer ts.Close() //nolint:errcheck
-	defer l.Close()  //nolint:errcheck
+	var err error
+	UseConfigManagerClient(configmanagerclient.NewFake())
+	defer UseConfigManagerClient(nil)
 
 	storage := &memorystorage.MockMemoryStorage{}
 
@@ -776,52 +470,9 @@ func TestUpdateClientConfigNewAddressRange(t *testing.T) {
 }
 
 func TestUpdateClientConfigNewClientAddressPrefix(t *testing.T) {
-	var (
-		l   net.Listener
-		err error
-	)
-	for {
-		l, err = net.Listen("tcp", CONFIGMANAGER_URI)
-		if err != nil {
-			if !strings.HasSuffix(err.Error(), "address already in use") {
-				t.Fatal(err)
-			}
-			time.Sleep(1 * time.Second)
-		} else {
-			break
-		}
-	}
-
-	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		switch r.Method {
-		case http.MethodPost:
-			if r.RequestURI == "/refresh-clients" {
-				w.WriteHeader(http.StatusAccepted)
-				_, err := w.Write([]byte("OK"))
-				if err != nil {
-					t.Fatalf("write error: %s", err)
-				}
-				return
-			}
-			if r.RequestURI == "/refresh-server-config" {
-				w.WriteHeader(http.StatusAccepted)
-				_, err := w.Write([]byte("OK"))
-				if err != nil {
-					t.Fatalf("write error: %s", err)
-				}
-				return
-			}
-			w.WriteHeader(http.StatusBadRequest)
-		default:
-			w.WriteHeader(http.StatusBadRequest)
-		}
-	}))
-
-	ts.Listener.Close() //nolint:errcheck
-	ts.Listener = l
-	ts.Start()
-	defer ts.Close() //nolint:errcheck
-	defer l.Close()  //nolint:errcheck
+	var err error
+	UseConfigManagerClient(configmanagerclient.NewFake())
+	defer UseConfigManagerClient(nil)
 
 	storage := &memorystorage.MockMemoryStorage{}
 
@@ -933,3 +584,532 @@ func TestGetClientIDAndConfigID(t *testing.T) {
 	}
 
 }
+
+func TestClientGeneratedPublicKey(t *testing.T) {
+	var err error
+	UseConfigManagerClient(configmanagerclient.NewFake())
+	defer UseConfigManagerClient(nil)
+
+	storage := &memorystorage.MockMemoryStorage{}
+
+	vpnConfig, err := CreateNewVPNConfig(storage)
+	if err != nil {
+		t.Fatalf("CreateNewVPNConfig error: %s", err)
+	}
+	vpnConfig.RequireClientPublicKey = true
+	err = WriteVPNConfig(storage, vpnConfig)
+	if err != nil {
+		t.Fatalf("WriteVPNConfig error: %s", err)
+	}
+
+	_, publicKey, err := GenerateKeys()
+	if err != nil {
+		t.Fatalf("GenerateKeys error: %s", err)
+	}
+
+	_, err = NewClientConfigWithPublicKey(storage, "2-2-2-2", "invalid")
+	if err == nil {
+		t.Fatalf("expected error when supplying an invalid public key")
+	}
+
+	peerConfig, err := NewClientConfigWithPublicKey(storage, "2-2-2-2", publicKey)
+	if err != nil {
+		t.Fatalf("NewClientConfigWithPublicKey error: %s", err)
+	}
+	if peerConfig.PublicKey != publicKey || !peerConfig.ClientGeneratedKey {
+		t.Fatalf("client generated public key not stored in peer config")
+	}
+
+	out, err := GenerateNewClientConfig(storage, peerConfig.ID, "2-2-2-2")
+	if err != nil {
+		t.Fatalf("GenerateNewClientConfig error: %s", err)
+	}
+	if !strings.Contains(string(out), CLIENT_PRIVATE_KEY_PLACEHOLDER) {
+		t.Fatalf("expected private key placeholder in client config. Got: %s", out)
+	}
+	peerConfig, err = getPeerConfig(storage, peerConfig.ID)
+	if err != nil {
+		t.Fatalf("getPeerConfig error: %s", err)
+	}
+	if peerConfig.PublicKey != publicKey {
+		t.Fatalf("public key changed after downloading the config")
+	}
+
+	// connections without an uploaded key can't be downloaded when client keys are mandatory
+	peerConfig2, err := NewEmptyClientConfig(storage, "2-2-2-2")
+	if err != nil {
+		t.Fatalf("NewEmptyClientConfig error: %s", err)
+	}
+	_, err = GenerateNewClientConfig(storage, peerConfig2.ID, "2-2-2-2")
+	if err == nil {
+		t.Fatalf("expected error when downloading a config without client generated key")
+	}
+	// a key can only be used by one connection
+	_, err = NewClientConfigWithPublicKey(storage, "3-3-3-3", publicKey)
+	if err == nil || !strings.Contains(err.Error(), "already used by connection "+peerConfig.ID) {
+		t.Fatalf("expected error when reusing the public key of another connection, got: %v", err)
+	}
+	_, err = SetClientPublicKey(storage, peerConfig2.ID, publicKey)
+	if err == nil {
+		t.Fatalf("expected error when uploading the public key of another connection")
+	}
+	_, err = SetClientPublicKey(storage, peerConfig2.ID, vpnConfig.PublicKey)
+	if err == nil {
+		t.Fatalf("expected error when uploading the public key of the server")
+	}
+	_, err = SetClientPublicKey(storage, peerConfig.ID, publicKey) // uploading the same key again is allowed
+	if err != nil {
+		t.Fatalf("SetClientPublicKey error: %s", err)
+	}
+	_, publicKey2, err := GenerateKeys()
+	if err != nil {
+		t.Fatalf("GenerateKeys error: %s", err)
+	}
+	peerConfig2, err = SetClientPublicKey(storage, peerConfig2.ID, publicKey2)
+	if err != nil {
+		t.Fatalf("SetClientPublicKey error: %s", err)
+	}
+	if !peerConfig2.ClientGeneratedKey {
+		t.Fatalf("expected peer config to have a client generated key")
+	}
+	_, err = GenerateNewClientConfig(storage, peerConfig2.ID, "2-2-2-2")
+	if err != nil {
+		t.Fatalf("GenerateNewClientConfig error: %s", err)
+	}
+}
+
+func TestStableClientConfig(t *testing.T) {
+	var err error
+	UseConfigManagerClient(configmanagerclient.NewFake())
+	defer UseConfigManagerClient(nil)
+
+	storage := &memorystorage.MockMemoryStorage{}
+
+	vpnConfig, err := CreateNewVPNConfig(storage)
+	if err != nil {
+		t.Fatalf("CreateNewVPNConfig error: %s", err)
+	}
+
+	peerConfig, err := NewEmptyClientConfig(storage, "2-2-2-2")
+	if err != nil {
+		t.Fatalf("NewEmptyClientConfig error: %s", err)
+	}
+	if peerConfig.PrivateKeyEncrypted == "" || peerConfig.ConfigRevealed {
+		t.Fatalf("expected an encrypted private key and a config that is not revealed yet")
+	}
+
+	out1, err := GenerateNewClientConfig(storage, peerConfig.ID, "2-2-2-2")
+	if err != nil {
+		t.Fatalf("GenerateNewClientConfig error: %s", err)
+	}
+	out2, err := GenerateNewClientConfig(storage, peerConfig.ID, "2-2-2-2")
+	if err != nil {
+		t.Fatalf("GenerateNewClientConfig error: %s", err)
+	}
+	if string(out1) != string(out2) {
+		t.Fatalf("client config changed between downloads")
+	}
+	peerConfigDownloaded, err := getPeerConfig(storage, peerConfig.ID)
+	if err != nil {
+		t.Fatalf("getPeerConfig error: %s", err)
+	}
+	if peerConfigDownloaded.PublicKey != peerConfig.PublicKey {
+		t.Fatalf("public key changed after download")
+	}
+	if !peerConfigDownloaded.ConfigRevealed {
+		t.Fatalf("expected config to be marked as revealed")
+	}
+	peerConfigBytes, err := storage.ReadFile(storage.ConfigPath("clients/2-2-2-2-1.json"))
+	if err != nil {
+		t.Fatalf("could not read peer config: %s", err)
+	}
+	scanner := bufio.NewScanner(bytes.NewBuffer(out1))
+	for scanner.Scan() {
+		if strings.HasPrefix(scanner.Text(), "PrivateKey = ") && strings.Contains(string(peerConfigBytes), strings.TrimPrefix(scanner.Text(), "PrivateKey = ")) {
+			t.Fatalf("found private key in plain text in peer config")
+		}
+	}
+
+	// rotate key
+	peerConfigRotated, err := RotateClientKey(storage, peerConfig.ID)
+	if err != nil {
+		t.Fatalf("RotateClientKey error: %s", err)
+	}
+	if peerConfigRotated.PublicKey == peerConfig.PublicKey || peerConfigRotated.ConfigRevealed {
+		t.Fatalf("expected new public key and config not revealed after rotation")
+	}
+	out3, err := GenerateNewClientConfig(storage, peerConfig.ID, "2-2-2-2")
+	if err != nil {
+		t.Fatalf("GenerateNewClientConfig error: %s", err)
+	}
+	if string(out1) == string(out3) {
+		t.Fatalf("client config didn't change after key rotation")
+	}
+
+	// one time reveal
+	vpnConfig.OneTimeConfigReveal = true
+	err = WriteVPNConfig(storage, vpnConfig)
+	if err != nil {
+		t.Fatalf("WriteVPNConfig error: %s", err)
+	}
+	_, err = RotateClientKey(storage, peerConfig.ID)
+	if err != nil {
+		t.Fatalf("RotateClientKey error: %s", err)
+	}
+	_, err = GenerateNewClientConfig(storage, peerConfig.ID, "2-2-2-2")
+	if err != nil {
+		t.Fatalf("GenerateNewClientConfig error: %s", err)
+	}
+	_, err = GenerateNewClientConfig(storage, peerConfig.ID, "2-2-2-2")
+	if err == nil {
+		t.Fatalf("expected error when downloading a config that was already revealed")
+	}
+}
+
+func TestPresharedKeyPerPeer(t *testing.T) {
+	var err error
+	UseConfigManagerClient(configmanagerclient.NewFake())
+	defer UseConfigManagerClient(nil)
+
+	storage := &memorystorage.MockMemoryStorage{}
+
+	vpnConfig, err := CreateNewVPNConfig(storage)
+	if err != nil {
+		t.Fatalf("CreateNewVPNConfig error: %s", err)
+	}
+
+	peerConfig1, err := NewEmptyClientConfig(storage, "2-2-2-2")
+	if err != nil {
+		t.Fatalf("NewEmptyClientConfig error: %s", err)
+	}
+	peerConfig2, err := NewEmptyClientConfig(storage, "2-2-2-2")
+	if err != nil {
+		t.Fatalf("NewEmptyClientConfig error: %s", err)
+	}
+	if peerConfig1.PresharedKey == "" || peerConfig1.PresharedKey == peerConfig2.PresharedKey || peerConfig1.PresharedKey == vpnConfig.PresharedKey {
+		t.Fatalf("expected unique preshared key per peer")
+	}
+	out, err := GenerateNewClientConfig(storage, peerConfig1.ID, "2-2-2-2")
+	if err != nil {
+		t.Fatalf("GenerateNewClientConfig error: %s", err)
+	}
+	if !strings.Contains(string(out), "PresharedKey = "+peerConfig1.PresharedKey) {
+		t.Fatalf("peer preshared key not found in client config: %s", out)
+	}
+
+	// existing peers without preshared key
+	peerConfig1.PresharedKey = ""
+	peerConfig2.PresharedKey = ""
+	peerConfig2.PublicKey = ""
+	for _, peerConfig := range []PeerConfig{peerConfig1, peerConfig2} {
+		err = writePeerConfig(storage, peerConfig)
+		if err != nil {
+			t.Fatalf("writePeerConfig error: %s", err)
+		}
+	}
+	err = MigratePeerConfigs(storage)
+	if err != nil {
+		t.Fatalf("MigratePeerConfigs error: %s", err)
+	}
+	peerConfig1, err = getPeerConfig(storage, peerConfig1.ID)
+	if err != nil {
+		t.Fatalf("getPeerConfig error: %s", err)
+	}
+	peerConfig2, err = getPeerConfig(storage, peerConfig2.ID)
+	if err != nil {
+		t.Fatalf("getPeerConfig error: %s", err)
+	}
+	if peerConfig1.PresharedKey != vpnConfig.PresharedKey || !peerConfig1.PresharedKeyRotationRequired || !peerConfig1.ConfigRefreshRequired {
+		t.Fatalf("expected peer already in use to keep the global preshared key until its config is refreshed: %+v", peerConfig1)
+	}
+	if peerConfig2.PresharedKey == "" || peerConfig2.PresharedKey == vpnConfig.PresharedKey || peerConfig2.PresharedKeyRotationRequired {
+		t.Fatalf("expected peer without key to get a unique preshared key")
+	}
+	err = MigratePeerConfigs(storage) // running the migration again doesn't change anything
+	if err != nil {
+		t.Fatalf("MigratePeerConfigs error: %s", err)
+	}
+
+	// the next download generates a unique preshared key
+	out, err = GenerateNewClientConfig(storage, peerConfig1.ID, "2-2-2-2")
+	if err != nil {
+		t.Fatalf("GenerateNewClientConfig error: %s", err)
+	}
+	peerConfig1, err = getPeerConfig(storage, peerConfig1.ID)
+	if err != nil {
+		t.Fatalf("getPeerConfig error: %s", err)
+	}
+	if peerConfig1.PresharedKey == vpnConfig.PresharedKey || peerConfig1.PresharedKeyRotationRequired || peerConfig1.ConfigRefreshRequired {
+		t.Fatalf("expected unique preshared key after download: %+v", peerConfig1)
+	}
+	if !strings.Contains(string(out), "PresharedKey = "+peerConfig1.PresharedKey) {
+		t.Fatalf("new preshared key not found in client config: %s", out)
+	}
+
+	// peers that kept the global preshared key in an earlier migration are also migrated
+	peerConfig1.PresharedKey = vpnConfig.PresharedKey
+	err = writePeerConfig(storage, peerConfig1)
+	if err != nil {
+		t.Fatalf("writePeerConfig error: %s", err)
+	}
+	err = MigratePeerConfigs(storage)
+	if err != nil {
+		t.Fatalf("MigratePeerConfigs error: %s", err)
+	}
+	peerConfig1, err = getPeerConfig(storage, peerConfig1.ID)
+	if err != nil {
+		t.Fatalf("getPeerConfig error: %s", err)
+	}
+	if !peerConfig1.PresharedKeyRotationRequired {
+		t.Fatalf("expected peer with the global preshared key to need a preshared key rotation")
+	}
+
+	peerConfig1, err = RotateClientKey(storage, peerConfig1.ID)
+	if err != nil {
+		t.Fatalf("RotateClientKey error: %s", err)
+	}
+	if peerConfig1.PresharedKey == vpnConfig.PresharedKey {
+		t.Fatalf("expected unique preshared key after key rotation")
+	}
+}
+
+func TestDisableClientConfig(t *testing.T) {
+	var err error
+	fake := configmanagerclient.NewFake()
+	UseConfigManagerClient(fake)
+	defer UseConfigManagerClient(nil)
+
+	storage := &memorystorage.MockMemoryStorage{}
+
+	_, err = CreateNewVPNConfig(storage)
+	if err != nil {
+		t.Fatalf("CreateNewVPNConfig error: %s", err)
+	}
+
+	peerConfig, err := NewEmptyClientConfig(storage, "2-2-2-2")
+	if err != nil {
+		t.Fatalf("NewEmptyClientConfig error: %s", err)
+	}
+	peerConfig, err = DisableClientConfig(storage, peerConfig.ID, "admin")
+	if err != nil {
+		t.Fatalf("DisableClientConfig error: %s", err)
+	}
+	if !peerConfig.Disabled || peerConfig.DisabledBy != "admin" || peerConfig.DisabledAt.IsZero() {
+		t.Fatalf("expected connection to be disabled by admin")
+	}
+
+	// a suspended and reactivated user doesn't reactivate a connection disabled by an admin
+	err = DisableAllClientConfigs(storage, users.User{ID: "2-2-2-2"})
+	if err != nil {
+		t.Fatalf("DisableAllClientConfigs error: %s", err)
+	}
+	err = ReactivateAllClientConfigs(storage, users.User{ID: "2-2-2-2"})
+	if err != nil {
+		t.Fatalf("ReactivateAllClientConfigs error: %s", err)
+	}
+	peerConfig, err = getPeerConfig(storage, peerConfig.ID)
+	if err != nil {
+		t.Fatalf("getPeerConfig error: %s", err)
+	}
+	if !peerConfig.Disabled || peerConfig.DisabledReason != DISABLED_REASON_ADMIN {
+		t.Fatalf("expected connection to stay disabled after user reactivation")
+	}
+
+	peerConfig, err = EnableClientConfig(storage, peerConfig.ID, "admin")
+	if err != nil {
+		t.Fatalf("EnableClientConfig error: %s", err)
+	}
+	if peerConfig.Disabled {
+		t.Fatalf("expected connection to be enabled")
+	}
+
+	err = RevokeClientConfig(storage, peerConfig.ID, "admin")
+	if err != nil {
+		t.Fatalf("RevokeClientConfig error: %s", err)
+	}
+	if storage.FileExists(storage.ConfigPath(path.Join(VPN_CLIENTS_DIR, peerConfig.ID+".json"))) {
+		t.Fatalf("expected connection to be deleted")
+	}
+
+	expectedActions := []string{ACTION_ADD, ACTION_DELETE, ACTION_DELETE, ACTION_ADD, ACTION_CLEANUP}
+	refreshActions := []string{}
+	for _, refreshClientRequest := range fake.RefreshClientRequests() {
+		refreshActions = append(refreshActions, refreshClientRequest.Action)
+	}
+	if strings.Join(refreshActions, ",") != strings.Join(expectedActions, ",") {
+		t.Fatalf("unexpected refresh actions: %v", refreshActions)
+	}
+
+	auditLog, err := GetConnectionAuditLog(storage, peerConfig.ID)
+	if err != nil {
+		t.Fatalf("GetConnectionAuditLog error: %s", err)
+	}
+	if len(auditLog) != 3 || auditLog[0].Action != AUDIT_ACTION_DISABLE || auditLog[2].Action != AUDIT_ACTION_DELETE || auditLog[2].Actor != "admin" {
+		t.Fatalf("unexpected audit log: %+v", auditLog)
+	}
+}
+
+func TestGetClientAllowedIPsDualStack(t *testing.T) {
+	clientAllowedIPs, err := getClientAllowedIPs([]string{"10.189.184.1/32", "fd00::1/128"}, []string{}, []string{}, []string{"1.1.1.1", "2606:4700:4700::1111"})
+	if err != nil {
+		t.Fatalf("getClientAllowedIPs error: %s", err)
+	}
+	expected := []string{"10.189.184.1/32", "fd00::1/128", "1.1.1.1/32", "2606:4700:4700::1111/128"}
+	if strings.Join(clientAllowedIPs, ",") != strings.Join(expected, ",") {
+		t.Fatalf("unexpected client allowed ips: %v", clientAllowedIPs)
+	}
+	// only the ipv4 range is covered by the routes
+	clientAllowedIPs, err = getClientAllowedIPs([]string{"10.189.184.1/32", "fd00::1/128"}, []string{"0.0.0.0/0"}, []string{}, []string{"2606:4700:4700::1111"})
+	if err != nil {
+		t.Fatalf("getClientAllowedIPs error: %s", err)
+	}
+	expected = []string{"0.0.0.0/0", "fd00::1/128", "2606:4700:4700::1111/128"}
+	if strings.Join(clientAllowedIPs, ",") != strings.Join(expected, ",") {
+		t.Fatalf("unexpected client allowed ips: %v", clientAllowedIPs)
+	}
+}
+
+func TestGetEndpoint(t *testing.T) {
+	testCases := map[string]string{
+		"vpn.example.com": "vpn.example.com:51820",
+		"1.2.3.4":         "1.2.3.4:51820",
+		"2001:db8::1":     "[2001:db8::1]:51820",
+		"[2001:db8::1]":   "[2001:db8::1]:51820",
+	}
+	for endpoint, expected := range testCases {
+		if got := getEndpoint(VPNConfig{Endpoint: endpoint, Port: 51820}); got != expected {
+			t.Fatalf("unexpected endpoint for %s: %s (expected %s)", endpoint, got, expected)
+		}
+	}
+}
+
+func TestDualStackClientConfig(t *testing.T) {
+	var err error
+	UseConfigManagerClient(configmanagerclient.NewFake())
+	defer UseConfigManagerClient(nil)
+
+	storage := &memorystorage.MockMemoryStorage{}
+
+	vpnConfig, err := CreateNewVPNConfig(storage)
+	if err != nil {
+		t.Fatalf("CreateNewVPNConfig error: %s", err)
+	}
+	peerConfigIPv4, err := NewEmptyClientConfig(storage, "2-2-2-2")
+	if err != nil {
+		t.Fatalf("NewEmptyClientConfig error: %s", err)
+	}
+	if peerConfigIPv4.AddressIPv6 != "" {
+		t.Fatalf("didn't expect an ipv6 address: %s", peerConfigIPv4.AddressIPv6)
+	}
+
+	// enable ipv6
+	vpnConfig.AddressRangeIPv6 = netip.MustParsePrefix("fd00:189::1/64")
+	vpnConfig.ClientAddressPrefixIPv6 = DEFAULT_CLIENT_ADDRESS_PREFIX_IPV6
+	vpnConfig.Endpoint = "2001:db8::1"
+	err = WriteVPNConfig(storage, vpnConfig)
+	if err != nil {
+		t.Fatalf("WriteVPNConfig error: %s", err)
+	}
+	err = UpdateClientsConfig(storage)
+	if err != nil {
+		t.Fatalf("UpdateClientsConfig error: %s", err)
+	}
+	peerConfigIPv4, err = getPeerConfig(storage, peerConfigIPv4.ID)
+	if err != nil {
+		t.Fatalf("getPeerConfig error: %s", err)
+	}
+	if peerConfigIPv4.AddressIPv6 != "fd00:189::2/128" {
+		t.Fatalf("expected ipv6 address for existing connection. Got: %s", peerConfigIPv4.AddressIPv6)
+	}
+	if strings.Join(peerConfigIPv4.ServerAllowedIPs, ",") != "10.189.184.2/32,fd00:189::2/128" {
+		t.Fatalf("unexpected server allowed ips: %v", peerConfigIPv4.ServerAllowedIPs)
+	}
+
+	peerConfig, err := NewEmptyClientConfig(storage, "2-2-2-2")
+	if err != nil {
+		t.Fatalf("NewEmptyClientConfig error: %s", err)
+	}
+	if peerConfig.Address != "10.189.184.3/32" || peerConfig.AddressIPv6 != "fd00:189::3/128" {
+		t.Fatalf("unexpected addresses: %s, %s", peerConfig.Address, peerConfig.AddressIPv6)
+	}
+	if strings.Join(peerConfig.ServerAllowedIPs, ",") != "10.189.184.3/32,fd00:189::3/128" {
+		t.Fatalf("unexpected server allowed ips: %v", peerConfig.ServerAllowedIPs)
+	}
+	out, err := GenerateNewClientConfig(storage, peerConfig.ID, "2-2-2-2")
+	if err != nil {
+		t.Fatalf("GenerateNewClientConfig error: %s", err)
+	}
+	for _, expected := range []string{"Address = 10.189.184.3/32, fd00:189::3/128", "Endpoint = [2001:db8::1]:51820"} {
+		if !strings.Contains(string(out), expected) {
+			t.Fatalf("expected %s in client config. Got: %s", expected, out)
+		}
+	}
+
+	// disable ipv6
+	vpnConfig.AddressRangeIPv6 = netip.Prefix{}
+	err = WriteVPNConfig(storage, vpnConfig)
+	if err != nil {
+		t.Fatalf("WriteVPNConfig error: %s", err)
+	}
+	err = UpdateClientsConfig(storage)
+	if err != nil {
+		t.Fatalf("UpdateClientsConfig error: %s", err)
+	}
+	peerConfig, err = getPeerConfig(storage, peerConfig.ID)
+	if err != nil {
+		t.Fatalf("getPeerConfig error: %s", err)
+	}
+	if peerConfig.AddressIPv6 != "" || strings.Join(peerConfig.ServerAllowedIPs, ",") != "10.189.184.3/32" {
+		t.Fatalf("ipv6 address not removed: %s, %v", peerConfig.AddressIPv6, peerConfig.ServerAllowedIPs)
+	}
+}
+
+func TestExcludeRoutes(t *testing.T) {
+	testCases := []struct {
+		routes         []string
+		excludedRoutes []string
+		expected       []string
+	}{
+		{
+			routes:         []string{"0.0.0.0/0"},
+			excludedRoutes: []string{"192.168.0.0/16"},
+			expected:       []string{"0.0.0.0/1", "128.0.0.0/2", "192.0.0.0/9", "192.128.0.0/11", "192.160.0.0/13", "192.169.0.0/16", "192.170.0.0/15", "192.172.0.0/14", "192.176.0.0/12", "192.192.0.0/10", "193.0.0.0/8", "194.0.0.0/7", "196.0.0.0/6", "200.0.0.0/5", "208.0.0.0/4", "224.0.0.0/3"},
+		},
+		{
+			routes:         []string{"10.0.0.0/24", "::/0"},
+			excludedRoutes: []string{"10.0.0.0/25", "8000::/1"},
+			expected:       []string{"10.0.0.128/25", "::/1"},
+		},
+		{
+			routes:         []string{"10.0.0.128/25", "10.0.0.0/25", "10.0.0.64/26"},
+			excludedRoutes: []string{"192.168.0.0/16"},
+			expected:       []string{"10.0.0.0/24"},
+		},
+		{
+			routes:         []string{"10.0.1.0/24"},
+			excludedRoutes: []string{"10.0.0.0/16"},
+			expected:       []string{},
+		},
+	}
+	for _, testCase := range testCases {
+		out, err := excludeRoutes(testCase.routes, testCase.excludedRoutes)
+		if err != nil {
+			t.Fatalf("excludeRoutes error: %s", err)
+		}
+		if strings.Join(out, ",") != strings.Join(testCase.expected, ",") {
+			t.Fatalf("unexpected result for %v minus %v: %v", testCase.routes, testCase.excludedRoutes, out)
+		}
+	}
+	_, err := excludeRoutes([]string{"0.0.0.0/0"}, []string{"192.168.0.0"})
+	if err == nil {
+		t.Fatalf("expected error for invalid excluded route")
+	}
+	// the vpn address range stays routed when the routes don't cover it anymore
+	clientAllowedIPs, err := getClientAllowedIPs([]string{"10.189.184.1/32"}, []string{"10.0.0.0/8"}, []string{"10.189.0.0/16"}, []string{})
+	if err != nil {
+		t.Fatalf("getClientAllowedIPs error: %s", err)
+	}
+	if !slices.Contains(clientAllowedIPs, "10.189.184.1/32") || slices.Contains(clientAllowedIPs, "10.0.0.0/8") {
+		t.Fatalf("unexpected client allowed ips: %v", clientAllowedIPs)
+	}
+}
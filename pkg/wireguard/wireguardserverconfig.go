@@ -2,20 +2,32 @@ package wireguard
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"os"
 	"path"
+	"strings"
 	"text/template"
 
 	"github.com/in4it/go-devops-platform/storage"
 )
 
+// WriteWireGuardServerConfig writes the server config, including the enabled peers, so wg-quick can restore the interface
+// without the configmanager. The file is replaced atomically, and only written when it changed.
 func WriteWireGuardServerConfig(storage storage.Iface) error {
+	return writeWireGuardServerConfig(storage, WIREGUARD_CONFIG)
+}
+
+func writeWireGuardServerConfig(storage storage.Iface, filename string) error {
 	configfileBytes, err := generateWireGuardServerConfig(storage)
 	if err != nil {
 		return fmt.Errorf("could not generate wireguard server config: %s", err)
 	}
-	err = os.WriteFile(WIREGUARD_CONFIG, configfileBytes, 0600)
+	currentConfigfileBytes, err := os.ReadFile(filename)
+	if err == nil && bytes.Equal(currentConfigfileBytes, configfileBytes) {
+		return nil
+	}
+	err = writeFileAtomic(filename, configfileBytes, 0600)
 	if err != nil {
 		return fmt.Errorf("could not write wireguard config file vpn.conf: %s", err)
 	}
@@ -23,6 +35,33 @@ func WriteWireGuardServerConfig(storage storage.Iface) error {
 	return nil
 }
 
+// writeFileAtomic writes to a temporary file in the same directory, and renames it when it is fully written
+func writeFileAtomic(filename string, data []byte, perm os.FileMode) error {
+	f, err := os.CreateTemp(path.Dir(filename), "."+path.Base(filename)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("could not create temporary file: %s", err)
+	}
+	defer os.Remove(f.Name()) //nolint:errcheck // the file is already renamed when the write succeeded
+	err = f.Chmod(perm)
+	if err == nil {
+		_, err = f.Write(data)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("could not write temporary file: %s", err)
+	}
+	err = os.Rename(f.Name(), filename)
+	if err != nil {
+		return fmt.Errorf("could not rename temporary file: %s", err)
+	}
+	return nil
+}
+
 func GetServerTemplate(storage storage.Iface) ([]byte, error) {
 	templatefile := storage.ConfigPath(path.Join(WIREGUARD_TEMPLATE_DIR, WIREGUARD_TEMPLATE_SERVER))
 	err := storage.EnsurePath(storage.ConfigPath(WIREGUARD_TEMPLATE_DIR))
@@ -49,6 +88,16 @@ func GetServerTemplate(storage storage.Iface) ([]byte, error) {
 	if err != nil {
 		return nil, fmt.Errorf("cannot read template file (%s): %s", templatefile, err)
 	}
+
+	// unmodified templates from older versions are replaced, as the firewall now handles nat, and the peers are part of the server config
+	switch string(templateContents) {
+	case LEGACY_SERVER_TEMPLATE_IPTABLES, LEGACY_SERVER_TEMPLATE_IPTABLES_IPV6, LEGACY_SERVER_TEMPLATE_WITHOUT_PEERS:
+		templateContents = []byte(DEFAULT_SERVER_TEMPLATE)
+		err = storage.WriteFile(templatefile, templateContents)
+		if err != nil {
+			return nil, fmt.Errorf("could not update template (%s): %s", templatefile, err)
+		}
+	}
 	return templateContents, nil
 }
 
@@ -72,13 +121,22 @@ func generateWireGuardServerConfig(storage storage.Iface) ([]byte, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to read private key: %s", err)
 	}
+	clients, err := getServerClients(storage)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get peers: %s", err)
+	}
 	vpnServerData := VPNServerData{
 		Address:           vpnConfig.AddressRange.String(),
-		PrivateKey:        string(privateKey),
+		PrivateKey:        strings.TrimSpace(string(privateKey)),
 		Port:              vpnConfig.Port,
+		Clients:           clients,
 		DisableNAT:        vpnConfig.DisableNAT,
 		ExternalInterface: vpnConfig.ExternalInterface,
 	}
+	if vpnConfig.AddressRangeIPv6.IsValid() {
+		vpnServerData.Address += ", " + vpnConfig.AddressRangeIPv6.String()
+		vpnServerData.AddressIPv6 = vpnConfig.AddressRangeIPv6.String()
+	}
 
 	templateContents, err := GetServerTemplate(storage)
 	if err != nil {
@@ -96,3 +154,38 @@ func generateWireGuardServerConfig(storage storage.Iface) ([]byte, error) {
 	}
 	return out.Bytes(), nil
 }
+
+// getServerClients returns the peers of the server config: the enabled peers, with the allowed ips and preshared key the configmanager configures
+func getServerClients(storage storage.Iface) ([]VPNServerClient, error) {
+	clients := []VPNServerClient{}
+	if _, err := storage.ReadDir(storage.ConfigPath(VPN_CLIENTS_DIR)); errors.Is(err, os.ErrNotExist) {
+		return clients, nil // no peers yet
+	}
+	peerConfigs, err := GetAllPeerConfigs(storage)
+	if err != nil {
+		return clients, err
+	}
+	serverPresharedKey := ""
+	for _, peerConfig := range peerConfigs {
+		if peerConfig.Disabled || peerConfig.PublicKey == "" {
+			continue
+		}
+		presharedKey := peerConfig.PresharedKey
+		if presharedKey == "" { // fall back to the preshared key of the server
+			if serverPresharedKey == "" {
+				presharedKeyBytes, err := storage.ReadFile(path.Join(VPN_SERVER_SECRETS_PATH, PRESHARED_KEY_FILENAME))
+				if err != nil {
+					return clients, fmt.Errorf("failed to read preshared key: %s", err)
+				}
+				serverPresharedKey = strings.TrimSpace(string(presharedKeyBytes))
+			}
+			presharedKey = serverPresharedKey
+		}
+		clients = append(clients, VPNServerClient{
+			PublicKey:    peerConfig.PublicKey,
+			AllowedIPs:   strings.Join(peerConfig.ServerAllowedIPs, ", "),
+			PresharedKey: presharedKey,
+		})
+	}
+	return clients, nil
+}
@@ -1,63 +1,20 @@
 package wireguard
 
 import (
-	"net"
-	"net/http"
-	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path"
 	"strings"
 	"testing"
-	"time"
 
 	memorystorage "github.com/in4it/go-devops-platform/storage/memory"
+	configmanagerclient "github.com/in4it/wireguard-server/pkg/configmanager/client"
 )
 
 func TestWriteWireGuardServerConfig(t *testing.T) {
-	var (
-		l   net.Listener
-		err error
-	)
-	for {
-		l, err = net.Listen("tcp", CONFIGMANAGER_URI)
-		if err != nil {
-			if !strings.HasSuffix(err.Error(), "address already in use") {
-				t.Fatal(err)
-			}
-			time.Sleep(1 * time.Second)
-		} else {
-			break
-		}
-	}
-
-	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		switch r.Method {
-		case http.MethodPost:
-			if r.RequestURI == "/refresh-clients" {
-				w.WriteHeader(http.StatusAccepted)
-				_, err := w.Write([]byte("OK"))
-				if err != nil {
-					t.Fatalf("write error: %s", err)
-				}
-				return
-			}
-			if r.RequestURI == "/refresh-server-config" {
-				w.WriteHeader(http.StatusAccepted)
-				_, err := w.Write([]byte("OK"))
-				if err != nil {
-					t.Fatalf("write error: %s", err)
-				}
-				return
-			}
-			w.WriteHeader(http.StatusBadRequest)
-		default:
-			w.WriteHeader(http.StatusBadRequest)
-		}
-	}))
-
-	ts.Listener.Close() //nolint:errcheck
-	ts.Listener = l
-	ts.Start()
-	defer ts.Close() //nolint:errcheck
-	defer l.Close()  //nolint:errcheck
+	var err error
+	UseConfigManagerClient(configmanagerclient.NewFake())
+	defer UseConfigManagerClient(nil)
 
 	storage := &memorystorage.MockMemoryStorage{}
 
@@ -75,3 +32,143 @@ func TestWriteWireGuardServerConfig(t *testing.T) {
 		t.Fatalf("couldn't find address range in vpn config file: %s", vpnconfig.AddressRange.String())
 	}
 }
+
+func TestWireGuardServerConfigIPv6(t *testing.T) {
+	storage := &memorystorage.MockMemoryStorage{}
+
+	vpnConfig, err := CreateNewVPNConfig(storage)
+	if err != nil {
+		t.Fatalf("CreateNewVPNConfig error: %s", err)
+	}
+	vpnConfigFile, err := generateWireGuardServerConfig(storage)
+	if err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	if strings.Contains(string(vpnConfigFile), "ip6tables") {
+		t.Fatalf("didn't expect ip6tables rules in vpn config file: %s", vpnConfigFile)
+	}
+
+	vpnConfig.AddressRangeIPv6 = netip.MustParsePrefix("fd00:189::1/64")
+	vpnConfig.ClientAddressPrefixIPv6 = DEFAULT_CLIENT_ADDRESS_PREFIX_IPV6
+	err = WriteVPNConfig(storage, vpnConfig)
+	if err != nil {
+		t.Fatalf("WriteVPNConfig error: %s", err)
+	}
+	vpnConfigFile, err = generateWireGuardServerConfig(storage)
+	if err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	if !strings.Contains(string(vpnConfigFile), "Address = "+vpnConfig.AddressRange.String()+", fd00:189::1/64") {
+		t.Fatalf("couldn't find dual stack address in vpn config file: %s", vpnConfigFile)
+	}
+}
+
+func TestGetServerTemplateLegacy(t *testing.T) {
+	storage := &memorystorage.MockMemoryStorage{}
+
+	err := WriteServerTemplate(storage, []byte(LEGACY_SERVER_TEMPLATE_IPTABLES_IPV6))
+	if err != nil {
+		t.Fatalf("WriteServerTemplate error: %s", err)
+	}
+	templateContents, err := GetServerTemplate(storage)
+	if err != nil {
+		t.Fatalf("GetServerTemplate error: %s", err)
+	}
+	if string(templateContents) != DEFAULT_SERVER_TEMPLATE {
+		t.Fatalf("legacy template not replaced: %s", templateContents)
+	}
+
+	err = WriteServerTemplate(storage, []byte(LEGACY_SERVER_TEMPLATE_WITHOUT_PEERS))
+	if err != nil {
+		t.Fatalf("WriteServerTemplate error: %s", err)
+	}
+	templateContents, err = GetServerTemplate(storage)
+	if err != nil {
+		t.Fatalf("GetServerTemplate error: %s", err)
+	}
+	if string(templateContents) != DEFAULT_SERVER_TEMPLATE {
+		t.Fatalf("template without peers not replaced: %s", templateContents)
+	}
+
+	customTemplate := LEGACY_SERVER_TEMPLATE_IPTABLES + "MTU = 1280\n"
+	err = WriteServerTemplate(storage, []byte(customTemplate))
+	if err != nil {
+		t.Fatalf("WriteServerTemplate error: %s", err)
+	}
+	templateContents, err = GetServerTemplate(storage)
+	if err != nil {
+		t.Fatalf("GetServerTemplate error: %s", err)
+	}
+	if string(templateContents) != customTemplate {
+		t.Fatalf("custom template was modified: %s", templateContents)
+	}
+}
+
+func TestWireGuardServerConfigPeers(t *testing.T) {
+	storage := &memorystorage.MockMemoryStorage{}
+	UseConfigManagerClient(configmanagerclient.NewFake())
+	defer UseConfigManagerClient(nil)
+	vpnConfig, err := CreateNewVPNConfig(storage)
+	if err != nil {
+		t.Fatalf("CreateNewVPNConfig error: %s", err)
+	}
+
+	peerConfigs := make([]PeerConfig, 3)
+	for k := range peerConfigs {
+		peerConfigs[k], err = NewEmptyClientConfig(storage, "2-2-2-2")
+		if err != nil {
+			t.Fatalf("NewEmptyClientConfig error: %s", err)
+		}
+	}
+	peerConfigs[1].PresharedKey = "" // peer configs of older versions use the preshared key of the server
+	err = writePeerConfig(storage, peerConfigs[1])
+	if err != nil {
+		t.Fatalf("writePeerConfig error: %s", err)
+	}
+	_, err = DisableClientConfig(storage, peerConfigs[2].ID, "admin")
+	if err != nil {
+		t.Fatalf("DisableClientConfig error: %s", err)
+	}
+
+	wireguardConfig := path.Join(t.TempDir(), "vpn.conf")
+	for range 2 { // the second write doesn't change the file
+		err = writeWireGuardServerConfig(storage, wireguardConfig)
+		if err != nil {
+			t.Fatalf("WriteWireGuardServerConfig error: %s", err)
+		}
+	}
+	fileInfo, err := os.Stat(wireguardConfig)
+	if err != nil {
+		t.Fatalf("stat error: %s", err)
+	}
+	if fileInfo.Mode().Perm() != 0600 {
+		t.Fatalf("unexpected permissions: %s", fileInfo.Mode().Perm())
+	}
+	entries, err := os.ReadDir(path.Dir(wireguardConfig))
+	if err != nil {
+		t.Fatalf("readdir error: %s", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected only vpn.conf, got %d files", len(entries))
+	}
+	serverConfig, err := os.ReadFile(wireguardConfig)
+	if err != nil {
+		t.Fatalf("read error: %s", err)
+	}
+
+	if strings.Count(string(serverConfig), "[Peer]") != 2 {
+		t.Fatalf("expected 2 peers: %s", serverConfig)
+	}
+	if strings.Contains(string(serverConfig), peerConfigs[2].PublicKey) {
+		t.Fatalf("disabled peer found in server config: %s", serverConfig)
+	}
+	expectedPeers := []string{
+		"PublicKey = " + peerConfigs[0].PublicKey + "\nPresharedKey = " + peerConfigs[0].PresharedKey + "\nAllowedIPs = " + strings.Join(peerConfigs[0].ServerAllowedIPs, ", ") + "\n",
+		"PublicKey = " + peerConfigs[1].PublicKey + "\nPresharedKey = " + vpnConfig.PresharedKey + "\nAllowedIPs = " + strings.Join(peerConfigs[1].ServerAllowedIPs, ", ") + "\n",
+	}
+	for _, expectedPeer := range expectedPeers {
+		if !strings.Contains(string(serverConfig), expectedPeer) {
+			t.Fatalf("couldn't find peer %q in server config: %s", expectedPeer, serverConfig)
+		}
+	}
+}
@@ -4,6 +4,8 @@ aws s3 cp ../restserver-linux-amd64 s3://in4it-vpn-server/assets/binaries/${LATE
 aws s3 cp ../restserver-linux-amd64.sha256 s3://in4it-vpn-server/assets/binaries/${LATEST}/restserver-linux-amd64.sha256
 aws s3 cp ../reset-admin-password-linux-amd64 s3://in4it-vpn-server/assets/binaries/${LATEST}/reset-admin-password-linux-amd64
 aws s3 cp ../reset-admin-password-linux-amd64.sha256 s3://in4it-vpn-server/assets/binaries/${LATEST}/reset-admin-password-linux-amd64.sha256
+aws s3 cp ../import-wg-quick-linux-amd64 s3://in4it-vpn-server/assets/binaries/${LATEST}/import-wg-quick-linux-amd64
+aws s3 cp ../import-wg-quick-linux-amd64.sha256 s3://in4it-vpn-server/assets/binaries/${LATEST}/import-wg-quick-linux-amd64.sha256
 aws s3 cp ../configmanager-linux-amd64 s3://in4it-vpn-server/assets/binaries/${LATEST}/configmanager-linux-amd64
 aws s3 cp ../configmanager-linux-amd64.sha256 s3://in4it-vpn-server/assets/binaries/${LATEST}/configmanager-linux-amd64.sha256
 if [ "$1" == "--release" ] ; then
@@ -11,6 +11,8 @@ apt-get -y -o Dpkg::Options::='--force-confdef' -o Dpkg::Options::='--force-conf
 apt-get install -y -o Dpkg::Options::='--force-confdef' -o Dpkg::Options::='--force-confold' wireguard
 echo 'net.ipv4.ip_forward=1' >> /etc/sysctl.conf
 sysctl net.ipv4.ip_forward=1
+echo 'net.ipv6.conf.all.forwarding=1' >> /etc/sysctl.conf
+sysctl net.ipv6.conf.all.forwarding=1
 
 mkdir -p /vpn
 groupadd vpn